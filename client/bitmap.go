@@ -0,0 +1,65 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+)
+
+func (self *Conn) setBit(key []byte, offset int64, value, sync bool) (old bool, err error) {
+	b := common.Bit{
+		Key:    key,
+		Offset: offset,
+		Value:  value,
+		Sync:   sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.SetBit", b, &old); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.setBit(key, offset, value, sync)
+	}
+	return
+}
+
+// SSetBit will set, or clear, the bit at offset in the byte value under key, and return the old bit, like SetBit.
+func (self *Conn) SSetBit(key []byte, offset int64, value bool) (old bool, err error) {
+	return self.setBit(key, offset, value, true)
+}
+
+// SetBit will set the bit at offset in the byte value under key if value is true, or clear it otherwise, and return the old bit.
+// Offsets count from the most significant bit of the first byte, and values too short for offset are padded with zero bytes.
+func (self *Conn) SetBit(key []byte, offset int64, value bool) (old bool, err error) {
+	return self.setBit(key, offset, value, false)
+}
+
+// GetBit will return the bit at offset in the byte value under key. Bits past the end of the value, and in missing values, are 0.
+func (self *Conn) GetBit(key []byte, offset int64) (bit bool, err error) {
+	data := common.Item{
+		Key:   key,
+		Value: common.EncodeInt64(offset),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.GetBit", data, &bit); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.GetBit(key, offset)
+	}
+	return
+}
+
+// BitCount will return the number of set bits in the byte value under key, counted by the node owning key.
+func (self *Conn) BitCount(key []byte) (count int64) {
+	data := common.Item{
+		Key: key,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.BitCount", data, &count); err != nil {
+		self.removeNode(*successor)
+		return self.BitCount(key)
+	}
+	return
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// chunkMagic starts the byte values that describe a value put in chunks, followed by the id and the number of chunks.
+	chunkMagic = "\x00god:chunked\x00"
+	// chunkPrefix followed by the hex encoded id of a chunked value, a slash and the index of a chunk is the key of the chunk.
+	chunkPrefix  = "__chunk__:"
+	chunkIDSize  = 16
+	manifestSize = len(chunkMagic) + chunkIDSize + 4
+	// chunkRetries is how many times Get rereads a chunked value whose chunks went missing, since it was likely replaced while being read.
+	chunkRetries = 3
+)
+
+// SetChunkSize will make Put, SPut and SPutDurable split values larger than size bytes in chunks of size bytes, put under internal keys
+// spread over the cluster, so that huge values don't have to be sent, logged and replicated as one item. A size of 0, the default,
+// turns chunking off.
+//
+// The value under the key itself becomes a small manifest naming the chunks, which Get reassembles. Other operations, like GetPut,
+// scans and sub tree functions, see the manifest. While chunking is on, Put replaces the value atomically with GetPut and deletes
+// the chunks of the value it replaced, and Del reads the value before deleting it to do the same. SPutDurable only reports whether
+// the chunks were logged, since GetPut doesn't tell.
+// Values put in chunks by a Conn with chunking on should be deleted by a Conn with chunking on, to not leave the chunks behind.
+func (self *Conn) SetChunkSize(size int) {
+	atomic.StoreInt64(&self.chunkSize, int64(size))
+}
+
+func isChunked(value []byte) bool {
+	return len(value) == manifestSize && bytes.HasPrefix(value, []byte(chunkMagic))
+}
+
+func chunkKey(id []byte, index int) []byte {
+	return []byte(fmt.Sprintf("%v%x/%v", chunkPrefix, id, index))
+}
+
+// parseManifest returns the id and number of chunks in manifest.
+func parseManifest(manifest []byte) (id []byte, count int) {
+	id = manifest[len(chunkMagic) : len(chunkMagic)+chunkIDSize]
+	count = int(binary.BigEndian.Uint32(manifest[len(chunkMagic)+chunkIDSize:]))
+	return
+}
+
+func (self *Conn) putChunked(key, value []byte, chunkSize int, sync bool) (durable bool) {
+	manifest := value
+	durable = true
+	if len(value) > chunkSize {
+		id := make([]byte, chunkIDSize)
+		if _, err := rand.Read(id); err != nil {
+			panic(err)
+		}
+		count := 0
+		for offset := 0; offset < len(value); offset += chunkSize {
+			end := offset + chunkSize
+			if end > len(value) {
+				end = len(value)
+			}
+			chunk := chunkKey(id, count)
+			_, _, successor := self.ring.Remotes(chunk)
+			durable = self.putVia(successor, chunk, value[offset:end], sync) && durable
+			count++
+		}
+		manifest = make([]byte, manifestSize)
+		copy(manifest, chunkMagic)
+		copy(manifest[len(chunkMagic):], id)
+		binary.BigEndian.PutUint32(manifest[len(chunkMagic)+chunkIDSize:], uint32(count))
+	}
+	// The chunks are in place before the manifest naming them is, so Get never finds a manifest without its chunks.
+	if old, existed := self.getPut(key, manifest, sync); existed && isChunked(old) {
+		self.delChunks(old, sync)
+	}
+	return
+}
+
+func (self *Conn) delChunked(key []byte, sync bool) {
+	old, existed := self.get(key)
+	self.delKey(key, sync)
+	if existed && isChunked(old) {
+		self.delChunks(old, sync)
+	}
+}
+
+func (self *Conn) delChunks(manifest []byte, sync bool) {
+	id, count := parseManifest(manifest)
+	for index := 0; index < count; index++ {
+		self.delKey(chunkKey(id, index), sync)
+	}
+}
+
+// takeChunks will reassemble and delete the chunks named by a manifest that was deleted atomically, so no one else will.
+func (self *Conn) takeChunks(manifest []byte, sync bool) (value []byte, existed bool) {
+	id, count := parseManifest(manifest)
+	var buffer bytes.Buffer
+	for index := 0; index < count; index++ {
+		chunk, found := self.get(chunkKey(id, index))
+		if !found {
+			return nil, false
+		}
+		buffer.Write(chunk)
+	}
+	self.delChunks(manifest, sync)
+	return buffer.Bytes(), true
+}
+
+func (self *Conn) getChunked(key, manifest []byte) (value []byte, existed bool) {
+	for attempt := 0; attempt < chunkRetries; attempt++ {
+		id, count := parseManifest(manifest)
+		var buffer bytes.Buffer
+		complete := true
+		for index := 0; index < count; index++ {
+			chunk, found := self.get(chunkKey(id, index))
+			if !found {
+				complete = false
+				break
+			}
+			buffer.Write(chunk)
+		}
+		if complete {
+			return buffer.Bytes(), true
+		}
+		if manifest, existed = self.get(key); !existed {
+			return nil, false
+		}
+		if !isChunked(manifest) {
+			return manifest, true
+		}
+	}
+	return nil, false
+}
@@ -2,10 +2,17 @@ package client
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/zond/god/common"
+	"github.com/zond/god/rdb"
 	"github.com/zond/setop"
+	"io"
+	"math/rand"
 	"net/rpc"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,6 +24,11 @@ const (
 	stopped
 )
 
+const (
+	bulkFetchSize = 1024
+	scanRetries   = 8
+)
+
 func findKeys(op *setop.SetOp) (result map[string]bool) {
 	result = make(map[string]bool)
 	for _, source := range op.Sources {
@@ -58,8 +70,9 @@ func findKeys(op *setop.SetOp) (result map[string]bool) {
 //
 // Usage: https://github.com/zond/god/blob/master/client/client_test.go
 type Conn struct {
-	ring  *common.Ring
-	state int32
+	ring      *common.Ring
+	state     int32
+	chunkSize int64
 }
 
 // NewConnRing creates a new Conn from a given set of known nodes. For internal usage.
@@ -95,6 +108,19 @@ func (self *Conn) removeNode(node common.Remote) {
 	self.Reconnect()
 }
 
+// knownError returns the common error, like common.ErrWrongType, that err is if a node returned it, so that it can be compared with ==,
+// and err otherwise.
+func knownError(err error) error {
+	if serverError, ok := err.(rpc.ServerError); ok {
+		for _, known := range []error{common.ErrWrongType, common.ErrSnapshotTooOld} {
+			if string(serverError) == known.Error() {
+				return known
+			}
+		}
+	}
+	return err
+}
+
 // Nodes returns the set of known nodes for this Conn.
 func (self *Conn) Nodes() common.Remotes {
 	return self.ring.Nodes()
@@ -115,6 +141,12 @@ func (self *Conn) update() {
 		}
 		self.ring.SetNodes(newNodes)
 	}
+	var setting common.RedundancySetting
+	if err := node.Call("Discord.Redundancy", 0, &setting); err != nil {
+		self.removeNode(node)
+		return
+	}
+	self.ring.SetRedundancy(setting)
 }
 func (self *Conn) updateRegularly() {
 	for self.hasState(started) {
@@ -160,6 +192,21 @@ func (self *Conn) subClear(key []byte, sync bool) {
 		self.subClear(key, sync)
 	}
 }
+func (self *Conn) drainPrefix(prefix []byte, sync bool) (result []common.Item) {
+	data := common.Item{
+		Key:  prefix,
+		Sync: sync,
+	}
+	for _, node := range self.ring.Nodes() {
+		var items []common.Item
+		if err := node.Call("DHash.DrainPrefix", data, &items); err != nil {
+			self.removeNode(node)
+			return append(result, self.drainPrefix(prefix, sync)...)
+		}
+		result = append(result, items...)
+	}
+	return
+}
 func (self *Conn) subDel(key, subKey []byte, sync bool) {
 	data := common.Item{
 		Key:    key,
@@ -182,6 +229,9 @@ func (self *Conn) subPutVia(succ *common.Remote, key, subKey, value []byte, sync
 	}
 	var x int
 	if err := succ.Call("DHash.SubPut", data, &x); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
 		self.removeNode(*succ)
 		_, _, newSuccessor := self.ring.Remotes(key)
 		*succ = *newSuccessor
@@ -192,7 +242,39 @@ func (self *Conn) subPut(key, subKey, value []byte, sync bool) {
 	_, _, successor := self.ring.Remotes(key)
 	self.subPutVia(successor, key, subKey, value, sync)
 }
+func (self *Conn) subPutChanged(key []byte, subKeys, values [][]byte, sync bool) (changed int, err error) {
+	if len(subKeys) != len(values) {
+		err = fmt.Errorf("%v sub keys but %v values", len(subKeys), len(values))
+		return
+	}
+	data := common.Batch{
+		Key:  key,
+		Sync: sync,
+	}
+	for index, subKey := range subKeys {
+		data.Items = append(data.Items, common.Item{
+			SubKey: subKey,
+			Value:  values[index],
+		})
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.SubPutChanged", data, &changed); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.subPutChanged(key, subKeys, values, sync)
+	}
+	return
+}
 func (self *Conn) del(key []byte, sync bool) {
+	if atomic.LoadInt64(&self.chunkSize) > 0 {
+		self.delChunked(key, sync)
+		return
+	}
+	self.delKey(key, sync)
+}
+func (self *Conn) delKey(key []byte, sync bool) {
 	data := common.Item{
 		Key:  key,
 		Sync: sync,
@@ -201,26 +283,45 @@ func (self *Conn) del(key []byte, sync bool) {
 	var x int
 	if err := successor.Call("DHash.Del", data, &x); err != nil {
 		self.removeNode(*successor)
-		self.del(key, sync)
+		self.delKey(key, sync)
+	}
+}
+func (self *Conn) delOlderThan(key []byte, than int64, sync bool) (deleted bool) {
+	data := common.Item{
+		Key:   key,
+		Value: common.EncodeInt64(than),
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.DelOlderThan", data, &deleted); err != nil {
+		self.removeNode(*successor)
+		return self.delOlderThan(key, than, sync)
 	}
+	return
 }
-func (self *Conn) putVia(succ *common.Remote, key, value []byte, sync bool) {
+func (self *Conn) putVia(succ *common.Remote, key, value []byte, sync bool) (durable bool) {
 	data := common.Item{
 		Key:   key,
 		Value: value,
 		Sync:  sync,
 	}
-	var x int
-	if err := succ.Call("DHash.Put", data, &x); err != nil {
+	if err := succ.Call("DHash.Put", data, &durable); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
 		self.removeNode(*succ)
 		_, _, newSuccessor := self.ring.Remotes(key)
 		*succ = *newSuccessor
-		self.putVia(succ, key, value, sync)
+		return self.putVia(succ, key, value, sync)
 	}
+	return
 }
-func (self *Conn) put(key, value []byte, sync bool) {
+func (self *Conn) put(key, value []byte, sync bool) (durable bool) {
+	if chunkSize := atomic.LoadInt64(&self.chunkSize); chunkSize > 0 {
+		return self.putChunked(key, value, int(chunkSize), sync)
+	}
 	_, _, successor := self.ring.Remotes(key)
-	self.putVia(successor, key, value, sync)
+	return self.putVia(successor, key, value, sync)
 }
 func (self *Conn) mergeRecent(operation string, r common.Range, up bool) (result []common.Item) {
 	currentRedundancy := self.ring.Redundancy()
@@ -272,8 +373,25 @@ func (self *Conn) findRecent(operation string, data common.Item) (result *common
 			result = results[index]
 		}
 	}
+	if operation == "DHash.Get" {
+		for index, found := range results {
+			if found.Timestamp < result.Timestamp {
+				go self.repair(nodes[index], *result)
+			}
+		}
+	}
 	return
 }
+
+// repair will send the most recent version of a byte value, found among its replicas, to the replica that had an older one.
+func (self *Conn) repair(replica common.Remote, recent common.Item) {
+	var repaired bool
+	if err := replica.Call("DHash.Repair", recent, &repaired); err != nil {
+		if _, ok := err.(rpc.ServerError); !ok {
+			self.removeNode(replica)
+		}
+	}
+}
 func (self *Conn) consume(c chan [2][]byte, wait *sync.WaitGroup, successor *common.Remote) {
 	for pair := range c {
 		self.putVia(successor, pair[0], pair[1], false)
@@ -318,24 +436,573 @@ func (self *Conn) Clear() {
 	}
 }
 
+// SDrainPrefix will delete all values under keys starting with prefix, and return them ordered by key within each node.
+// Each node deletes the values it owns atomically, so concurrent drains will never return the same value.
+func (self *Conn) SDrainPrefix(prefix []byte) (result []common.Item) {
+	return self.drainPrefix(prefix, true)
+}
+
+// DrainPrefix will delete all values under keys starting with prefix, and return them ordered by key within each node.
+// Each node deletes the values it owns atomically, so concurrent drains will never return the same value.
+func (self *Conn) DrainPrefix(prefix []byte) (result []common.Item) {
+	return self.drainPrefix(prefix, false)
+}
+
 // SSubPut will put value under subKey in the sub tree defined by key.
 func (self *Conn) SSubPut(key, subKey, value []byte) {
 	self.subPut(key, subKey, value, true)
 }
 
-// SubPut will put value under subKey in the sub tree defined by key.
-func (self *Conn) SubPut(key, subKey, value []byte) {
-	self.subPut(key, subKey, value, false)
+// SubPut will put value under subKey in the sub tree defined by key.
+func (self *Conn) SubPut(key, subKey, value []byte) {
+	self.subPut(key, subKey, value, false)
+}
+
+// SSubPutChanged will put each of values under the corresponding subKeys in the sub tree defined by key, skipping those already containing the same value.
+// It returns the number of values that actually changed, or an error if subKeys and values have different lengths. No operation will be logged if none of them did.
+func (self *Conn) SSubPutChanged(key []byte, subKeys, values [][]byte) (changed int, err error) {
+	return self.subPutChanged(key, subKeys, values, true)
+}
+
+// SubPutChanged will put each of values under the corresponding subKeys in the sub tree defined by key, skipping those already containing the same value.
+// It returns the number of values that actually changed, or an error if subKeys and values have different lengths. No operation will be logged if none of them did.
+func (self *Conn) SubPutChanged(key []byte, subKeys, values [][]byte) (changed int, err error) {
+	return self.subPutChanged(key, subKeys, values, false)
+}
+
+// SPut will put value under key.
+func (self *Conn) SPut(key, value []byte) {
+	self.put(key, value, true)
+}
+
+// Put will put value under key.
+func (self *Conn) Put(key, value []byte) {
+	self.put(key, value, false)
+}
+
+func (self *Conn) putExpire(key, value []byte, lifetime time.Duration, sync bool) (err error) {
+	e := common.Expiry{
+		Key:      key,
+		Value:    value,
+		Lifetime: lifetime,
+		Sync:     sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var durable bool
+	if err = successor.Call("DHash.PutExpire", e, &durable); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.putExpire(key, value, lifetime, sync)
+	}
+	return
+}
+
+// SPutExpire will put value under key like SPut, and make it missing once lifetime has passed, unless it is put again before then.
+// It returns an error if lifetime is not positive.
+func (self *Conn) SPutExpire(key, value []byte, lifetime time.Duration) error {
+	return self.putExpire(key, value, lifetime, true)
+}
+
+// PutExpire will put value under key like Put, and make it missing once lifetime has passed, unless it is put again before then.
+// It returns an error if lifetime is not positive.
+func (self *Conn) PutExpire(key, value []byte, lifetime time.Duration) error {
+	return self.putExpire(key, value, lifetime, false)
+}
+
+// TTL will return what is left of the lifetime given to PutExpire for the value under key, common.NoTTL if it has none, or common.MissingTTL if there is no value there.
+func (self *Conn) TTL(key []byte) (ttl time.Duration) {
+	data := common.Item{
+		Key: key,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.TTL", data, &ttl); err != nil {
+		self.removeNode(*successor)
+		return self.TTL(key)
+	}
+	return
+}
+
+func (self *Conn) rename(key, dest []byte, nx, sync bool) (renamed bool) {
+	r := common.Rename{
+		Key:  key,
+		Dest: dest,
+		NX:   nx,
+		Sync: sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.Rename", r, &renamed); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.rename(key, dest, nx, sync)
+	}
+	return
+}
+
+// SRename will move the value under key, and its expiry, to dest, replacing what is there, and return whether there was a value to move.
+// It is atomic when the same node owns both keys, otherwise readers may briefly find neither.
+func (self *Conn) SRename(key, dest []byte) (renamed bool) {
+	return self.rename(key, dest, false, true)
+}
+
+// Rename will move the value under key, and its expiry, to dest, replacing what is there, and return whether there was a value to move.
+// It is atomic when the same node owns both keys, otherwise readers may briefly find neither.
+func (self *Conn) Rename(key, dest []byte) (renamed bool) {
+	return self.rename(key, dest, false, false)
+}
+
+// SRenameNX will move the value under key, and its expiry, to dest like SRename, unless there is a value under dest already, and return whether it was moved.
+func (self *Conn) SRenameNX(key, dest []byte) (renamed bool) {
+	return self.rename(key, dest, true, true)
+}
+
+// RenameNX will move the value under key, and its expiry, to dest like Rename, unless there is a value under dest already, and return whether it was moved.
+func (self *Conn) RenameNX(key, dest []byte) (renamed bool) {
+	return self.rename(key, dest, true, false)
+}
+
+func (self *Conn) copy(key, dest []byte, keepTTL, sync bool) (copied bool) {
+	c := common.Copy{
+		Key:     key,
+		Dest:    dest,
+		KeepTTL: keepTTL,
+		Sync:    sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.Copy", c, &copied); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.copy(key, dest, keepTTL, sync)
+	}
+	return
+}
+
+// SCopy will put the value under key under dest too, replacing what is there, and return whether there was a value to copy.
+// The copy gets the expiry of the value if keepTTL is set, and no expiry otherwise. The value is copied by the nodes, without being sent here.
+func (self *Conn) SCopy(key, dest []byte, keepTTL bool) (copied bool) {
+	return self.copy(key, dest, keepTTL, true)
+}
+
+// Copy will put the value under key under dest too, replacing what is there, and return whether there was a value to copy.
+// The copy gets the expiry of the value if keepTTL is set, and no expiry otherwise. The value is copied by the nodes, without being sent here.
+func (self *Conn) Copy(key, dest []byte, keepTTL bool) (copied bool) {
+	return self.copy(key, dest, keepTTL, false)
+}
+
+// Persist will remove the expiry given to the value under key by PutExpire, unless it has expired already, and return whether it had one.
+func (self *Conn) Persist(key []byte) (persisted bool) {
+	data := common.Item{
+		Key: key,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.Persist", data, &persisted); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.Persist(key)
+	}
+	return
+}
+func (self *Conn) putNotify(key, value []byte, channel string, message []byte, sync bool) {
+	n := common.Notification{
+		Key:     key,
+		Value:   value,
+		Channel: channel,
+		Message: message,
+		Sync:    sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var durable bool
+	if err := successor.Call("DHash.PutNotify", n, &durable); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		self.putNotify(key, value, channel, message, sync)
+	}
+}
+
+// SPutNotify will put value under key like SPut, and then publish message to the dhash.ChannelListeners of channel in all nodes.
+func (self *Conn) SPutNotify(key, value []byte, channel string, message []byte) {
+	self.putNotify(key, value, channel, message, true)
+}
+
+// PutNotify will put value under key like Put, and then publish message to the dhash.ChannelListeners of channel in all nodes.
+func (self *Conn) PutNotify(key, value []byte, channel string, message []byte) {
+	self.putNotify(key, value, channel, message, false)
+}
+
+// SPutDurable will put value under key like SPut, and return whether the owner of key managed to log it to disk.
+// It will be false while the owner is degraded and only keeps new data in memory.
+func (self *Conn) SPutDurable(key, value []byte) (durable bool) {
+	return self.put(key, value, true)
+}
+
+func (self *Conn) nextID(key []byte, count int64) (id int64, err error) {
+	data := common.Item{
+		Key:   key,
+		Value: common.EncodeInt64(count),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.NextID", data, &id); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.nextID(key, count)
+	}
+	return
+}
+
+// NextID will treat the byte value under key as a common.EncodeInt64 encoded counter, increment it and return the new value.
+// A missing counter will start at 0, so the first id is 1. It returns an error if the value under key is not an encoded int64.
+func (self *Conn) NextID(key []byte) (id int64, err error) {
+	return self.nextID(key, 1)
+}
+
+// IncrBy will treat the byte value under key as a common.EncodeInt64 encoded counter, add delta to it and return the new value.
+// A missing counter will start at 0. It returns an error if the value under key is not an encoded int64.
+func (self *Conn) IncrBy(key []byte, delta int64) (value int64, err error) {
+	data := common.Item{
+		Key:   key,
+		Value: common.EncodeInt64(delta),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.AddInt64", data, &value); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.IncrBy(key, delta)
+	}
+	return
+}
+
+// Incr will add 1 to the counter under key like IncrBy, and return the new value.
+func (self *Conn) Incr(key []byte) (value int64, err error) {
+	return self.IncrBy(key, 1)
+}
+
+// Decr will subtract 1 from the counter under key like IncrBy, and return the new value.
+func (self *Conn) Decr(key []byte) (value int64, err error) {
+	return self.IncrBy(key, -1)
+}
+
+// NextIDs will reserve count consecutive ids from the counter under key, and return the first and last of them.
+func (self *Conn) NextIDs(key []byte, count int64) (first, last int64, err error) {
+	if last, err = self.nextID(key, count); err == nil {
+		first = last - count + 1
+	}
+	return
+}
+
+func (self *Conn) replace(key []byte, pattern string, replacement []byte, sync bool) (value []byte, existed bool, err error) {
+	r := common.Replacement{
+		Key:         key,
+		Pattern:     pattern,
+		Replacement: replacement,
+		Sync:        sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Item
+	if err = successor.Call("DHash.Replace", r, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.replace(key, pattern, replacement, sync)
+	}
+	value, existed = result.Value, result.Exists
+	return
+}
+
+// SReplace will atomically replace all matches of the regular expression pattern in the byte value under key with replacement, and return the new value.
+// Replacement can refer to submatches like regexp.Regexp#Expand. It returns an error if pattern is invalid, and existed false if there was no value under key.
+func (self *Conn) SReplace(key []byte, pattern string, replacement []byte) (value []byte, existed bool, err error) {
+	return self.replace(key, pattern, replacement, true)
+}
+
+// Replace will atomically replace all matches of the regular expression pattern in the byte value under key with replacement, and return the new value.
+// Replacement can refer to submatches like regexp.Regexp#Expand. It returns an error if pattern is invalid, and existed false if there was no value under key.
+func (self *Conn) Replace(key []byte, pattern string, replacement []byte) (value []byte, existed bool, err error) {
+	return self.replace(key, pattern, replacement, false)
+}
+
+func (self *Conn) getPut(key, value []byte, sync bool) (old []byte, existed bool) {
+	data := common.Item{
+		Key:   key,
+		Value: value,
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Item
+	if err := successor.Call("DHash.GetPut", data, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.getPut(key, value, sync)
+	}
+	return result.Value, result.Exists
+}
+
+// SGetPut will put value under key, and return the byte value it replaced, atomically.
+func (self *Conn) SGetPut(key, value []byte) (old []byte, existed bool) {
+	return self.getPut(key, value, true)
+}
+
+// GetPut will put value under key, and return the byte value it replaced, atomically.
+func (self *Conn) GetPut(key, value []byte) (old []byte, existed bool) {
+	return self.getPut(key, value, false)
+}
+func (self *Conn) getDel(key []byte, sync bool) (old []byte, existed bool) {
+	data := common.Item{
+		Key:  key,
+		Sync: sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Item
+	if err := successor.Call("DHash.GetDel", data, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.getDel(key, sync)
+	}
+	if result.Exists && isChunked(result.Value) {
+		return self.takeChunks(result.Value, sync)
+	}
+	return result.Value, result.Exists
+}
+
+// SGetDel will delete the value under key, and return the byte value it deleted, atomically.
+// Of many concurrent calls for the same key, only one will get the value.
+func (self *Conn) SGetDel(key []byte) (old []byte, existed bool) {
+	return self.getDel(key, true)
+}
+
+// GetDel will delete the value under key, and return the byte value it deleted, atomically.
+// Of many concurrent calls for the same key, only one will get the value.
+func (self *Conn) GetDel(key []byte) (old []byte, existed bool) {
+	return self.getDel(key, false)
+}
+func (self *Conn) putIfMissing(key, value []byte, sync bool) (put bool) {
+	data := common.Item{
+		Key:   key,
+		Value: value,
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.PutIfMissing", data, &put); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.putIfMissing(key, value, sync)
+	}
+	return
+}
+
+// SPutIfMissing will put value under key, if there is no byte value under key, and return whether it did.
+func (self *Conn) SPutIfMissing(key, value []byte) (put bool) {
+	return self.putIfMissing(key, value, true)
+}
+
+// PutIfMissing will put value under key, if there is no byte value under key, and return whether it did.
+func (self *Conn) PutIfMissing(key, value []byte) (put bool) {
+	return self.putIfMissing(key, value, false)
+}
+func (self *Conn) compareAndSwap(key, expected, value []byte, sync bool) (swapped bool) {
+	s := common.Swap{
+		Key:      key,
+		Expected: expected,
+		Value:    value,
+		Sync:     sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.CompareAndSwap", s, &swapped); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.compareAndSwap(key, expected, value, sync)
+	}
+	return
+}
+
+// SCompareAndSwap will put value under key, if the byte value already under key is equal to expected, and return whether it did.
+func (self *Conn) SCompareAndSwap(key, expected, value []byte) (swapped bool) {
+	return self.compareAndSwap(key, expected, value, true)
+}
+
+// CompareAndSwap will put value under key, if the byte value already under key is equal to expected, and return whether it did.
+func (self *Conn) CompareAndSwap(key, expected, value []byte) (swapped bool) {
+	return self.compareAndSwap(key, expected, value, false)
+}
+func (self *Conn) compareAndDelete(key, expected []byte, sync bool) (deleted bool) {
+	data := common.Item{
+		Key:   key,
+		Value: expected,
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.CompareAndDelete", data, &deleted); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.compareAndDelete(key, expected, sync)
+	}
+	return
+}
+
+// SCompareAndDelete will delete the value under key, if the byte value under key is equal to expected, and return whether it did.
+// Use it to release a lock put with a value only its holder knows, without releasing a lock someone else took after it expired.
+func (self *Conn) SCompareAndDelete(key, expected []byte) (deleted bool) {
+	return self.compareAndDelete(key, expected, true)
+}
+
+// CompareAndDelete will delete the value under key, if the byte value under key is equal to expected, and return whether it did.
+// Use it to release a lock put with a value only its holder knows, without releasing a lock someone else took after it expired.
+func (self *Conn) CompareAndDelete(key, expected []byte) (deleted bool) {
+	return self.compareAndDelete(key, expected, false)
+}
+func (self *Conn) appendValue(key, value []byte, sync bool) (length int) {
+	data := common.Item{
+		Key:   key,
+		Value: value,
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.Append", data, &length); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.appendValue(key, value, sync)
+	}
+	return
+}
+
+// SAppend will append value to the byte value under key, or put it there if there is none, and return the length of the result.
+func (self *Conn) SAppend(key, value []byte) (length int) {
+	return self.appendValue(key, value, true)
+}
+
+// Append will append value to the byte value under key, or put it there if there is none, and return the length of the result.
+func (self *Conn) Append(key, value []byte) (length int) {
+	return self.appendValue(key, value, false)
+}
+func (self *Conn) appendIf(condKey, key, value []byte, sync bool) (appended bool, err error) {
+	a := common.Append{
+		Condition: condKey,
+		Key:       key,
+		Value:     value,
+		Sync:      sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.AppendIf", a, &appended); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.appendIf(condKey, key, value, sync)
+	}
+	return
+}
+
+// SAppendIf will append value to the byte value under key, or put it there if there is none, but only if there is a byte value under condKey.
+// It returns whether value was appended.
+func (self *Conn) SAppendIf(condKey, key, value []byte) (appended bool, err error) {
+	return self.appendIf(condKey, key, value, true)
+}
+
+// AppendIf will append value to the byte value under key, or put it there if there is none, but only if there is a byte value under condKey.
+// It returns whether value was appended.
+func (self *Conn) AppendIf(condKey, key, value []byte) (appended bool, err error) {
+	return self.appendIf(condKey, key, value, false)
+}
+
+func (self *Conn) pfAdd(key, element []byte, sync bool) (changed bool, err error) {
+	data := common.Item{
+		Key:   key,
+		Value: element,
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.PFAdd", data, &changed); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.pfAdd(key, element, sync)
+	}
+	return
+}
+
+// SPFAdd will count element in the HyperLogLog sketch under key, creating it if missing, and return whether the sketch changed.
+// It returns an error if there is a value under key that is not a sketch.
+func (self *Conn) SPFAdd(key, element []byte) (changed bool, err error) {
+	return self.pfAdd(key, element, true)
+}
+
+// PFAdd will count element in the HyperLogLog sketch under key, creating it if missing, and return whether the sketch changed.
+// It returns an error if there is a value under key that is not a sketch.
+func (self *Conn) PFAdd(key, element []byte) (changed bool, err error) {
+	return self.pfAdd(key, element, false)
+}
+
+// PFCount will return the approximate number of distinct elements counted in the HyperLogLog sketch under key, within a standard error of about 1.6%.
+func (self *Conn) PFCount(key []byte) (count int64, err error) {
+	data := common.Item{
+		Key: key,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.PFCount", data, &count); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.PFCount(key)
+	}
+	return
+}
+
+func (self *Conn) pfMerge(dst []byte, sources [][]byte, sync bool) (count int64, err error) {
+	data := common.Batch{
+		Key:  dst,
+		Sync: sync,
+	}
+	for _, source := range sources {
+		data.Items = append(data.Items, common.Item{Key: source})
+	}
+	_, _, successor := self.ring.Remotes(dst)
+	if err = successor.Call("DHash.PFMerge", data, &count); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.pfMerge(dst, sources, sync)
+	}
+	return
 }
 
-// SPut will put value under key.
-func (self *Conn) SPut(key, value []byte) {
-	self.put(key, value, true)
+// SPFMerge will merge the HyperLogLog sketches under sources into the one under dst, and return the approximate number of distinct elements in the result, like PFMerge.
+func (self *Conn) SPFMerge(dst []byte, sources ...[]byte) (count int64, err error) {
+	return self.pfMerge(dst, sources, true)
 }
 
-// Put will put value under key.
-func (self *Conn) Put(key, value []byte) {
-	self.put(key, value, false)
+// PFMerge will merge the HyperLogLog sketches under sources into the one under dst, creating it if missing,
+// and return the approximate number of distinct elements in the result. Missing sources are treated as empty sketches.
+// It returns an error if there is a value under dst or any of the sources that is not a sketch.
+func (self *Conn) PFMerge(dst []byte, sources ...[]byte) (count int64, err error) {
+	return self.pfMerge(dst, sources, false)
 }
 
 // Dump will return a channel to send multiple key/value pairs through. When finished, close the channel and #Wait for the *sync.WaitGroup.
@@ -386,6 +1053,16 @@ func (self *Conn) Del(key []byte) {
 	self.del(key, false)
 }
 
+// SDelOlderThan will remove the byte value under key if it was last written before the timestamp than, in nanoseconds of cluster time, and return whether it did.
+func (self *Conn) SDelOlderThan(key []byte, than int64) (deleted bool) {
+	return self.delOlderThan(key, than, true)
+}
+
+// DelOlderThan will remove the byte value under key if it was last written before the timestamp than, in nanoseconds of cluster time, and return whether it did.
+func (self *Conn) DelOlderThan(key []byte, than int64) (deleted bool) {
+	return self.delOlderThan(key, than, false)
+}
+
 // MirrorReverseIndexOf will return the the distance from the end for subKey, looking at the mirror tree of the sub tree defined by key.
 func (self *Conn) MirrorReverseIndexOf(key, subKey []byte) (index int, existed bool) {
 	data := common.Item{
@@ -893,8 +1570,15 @@ func (self *Conn) SubGet(key, subKey []byte) (value []byte, existed bool) {
 	return
 }
 
-// Get will return the value under key.
+// Get will return the value under key, reassembled from its chunks if it was put in chunks. See SetChunkSize.
+// The value is read from all replicas of key, and the most recent version is sent in the background to the replicas that had older ones.
 func (self *Conn) Get(key []byte) (value []byte, existed bool) {
+	if value, existed = self.get(key); existed && isChunked(value) {
+		return self.getChunked(key, value)
+	}
+	return
+}
+func (self *Conn) get(key []byte) (value []byte, existed bool) {
 	data := common.Item{
 		Key: key,
 	}
@@ -907,6 +1591,295 @@ func (self *Conn) Get(key []byte) (value []byte, existed bool) {
 	return
 }
 
+// GetVersion will return the value under key, and its version, which changes every time the value is written or deleted.
+// The version of a missing value is 0. Use it with Transaction#Watch to make changes depending on the value.
+func (self *Conn) GetVersion(key []byte) (value []byte, version int64, existed bool) {
+	data := common.Item{
+		Key: key,
+	}
+	result := self.findRecent("DHash.Get", data)
+	if result.Exists {
+		value, version, existed = result.Value, result.Timestamp, true
+	}
+	return
+}
+
+// GetMeta will return the metadata kept with the byte value under key, its write timestamp, also used as its version, and its size,
+// as known by the owner of key, without fetching the value.
+// The timestamp is the version GetVersion returns, and the Timestamp of the operations writing the value in the logs.
+func (self *Conn) GetMeta(key []byte) (meta common.Meta) {
+	data := common.Item{
+		Key: key,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.GetMeta", data, &meta); err != nil {
+		self.removeNode(*successor)
+		return self.GetMeta(key)
+	}
+	return
+}
+
+// StrLen will return the length of the value under key, or 0 if there is none, without fetching the value.
+// For values put in chunks it returns the length of the reassembled value, found by asking the owners of the chunks for their sizes. See SetChunkSize.
+func (self *Conn) StrLen(key []byte) (length int) {
+	meta := self.GetMeta(key)
+	if !meta.Exists {
+		return 0
+	}
+	if meta.Size == manifestSize {
+		// Only values of the size of a manifest can be manifests, and they are small enough to fetch.
+		if manifest, existed := self.get(key); existed && isChunked(manifest) {
+			id, count := parseManifest(manifest)
+			for index := 0; index < count; index++ {
+				length += self.GetMeta(chunkKey(id, index)).Size
+			}
+			return
+		}
+	}
+	return meta.Size
+}
+
+// MExists will return whether each of keys has a byte value, and whether all of them do, without fetching any values.
+// The keys are checked with one call to the owner of each of them.
+func (self *Conn) MExists(keys [][]byte) (present []bool, all bool) {
+	present = make([]bool, len(keys))
+	owners, batches, indices := self.byOwner(keys)
+	for addr, batch := range batches {
+		var found []bool
+		if err := owners[addr].Call("DHash.MExists", *batch, &found); err != nil {
+			self.removeNode(*owners[addr])
+			return self.MExists(keys)
+		}
+		for index, existed := range found {
+			present[indices[addr][index]] = existed
+		}
+	}
+	all = true
+	for _, existed := range present {
+		all = all && existed
+	}
+	return
+}
+
+// Scan will return up to count keys with byte values after cursor, in order, and a cursor to continue after them, or nil if there are no more keys.
+// Start a scan with a nil cursor. Nothing is kept in the nodes between calls, so keys put or deleted during the scan may or may not show up.
+// If the nodes keep failing, or disagreeing with this Conn about where their keys are, it gives up after a few retries and returns the keys found so far.
+func (self *Conn) Scan(cursor []byte, count int) (keys [][]byte, next []byte) {
+	keys, next, _ = self.scan(cursor, cursor == nil, count, 0)
+	return
+}
+
+// scan will return up to count keys after start like Scan. If the nodes fail, or don't move the scan forward, scanRetries times it returns
+// the keys found so far, a cursor after them, and an error.
+func (self *Conn) scan(start []byte, startInc bool, count int, at int64) (keys [][]byte, next []byte, err error) {
+	from, inc := start, startInc
+	for retries := 0; len(keys) < count; {
+		_, _, successor := self.ring.Remotes(from)
+		var page common.ScanPage
+		if err = successor.Call("DHash.ScanKeys", common.Range{Min: from, MinInc: inc, Len: count - len(keys), At: at}, &page); err != nil {
+			if _, ok := err.(rpc.ServerError); ok {
+				err = knownError(err)
+				return
+			}
+			if retries++; retries > scanRetries {
+				break
+			}
+			self.removeNode(*successor)
+			continue
+		}
+		keys = append(keys, page.Keys...)
+		if page.Done {
+			return
+		}
+		if bytes.Compare(page.Next, from) < 1 {
+			// The node disagrees with our ring about where it is, probably since it is migrating, so we refresh the ring before going on.
+			if len(page.Keys) > 0 {
+				from, inc = page.Keys[len(page.Keys)-1], false
+			} else if retries++; retries > scanRetries {
+				err = fmt.Errorf("%v didn't move the scan past %v", successor, from)
+				break
+			}
+			self.Reconnect()
+			continue
+		}
+		from, inc = page.Next, true
+	}
+	if len(keys) > 0 {
+		next = keys[len(keys)-1]
+	} else if err != nil {
+		next = start
+	}
+	return
+}
+
+// ScanPrefix will return all keys with byte values starting with prefix, and their values, in key order.
+func (self *Conn) ScanPrefix(prefix []byte) (result []common.Item) {
+	data := common.Item{
+		Key: prefix,
+	}
+	var results []*[]common.Item
+	for _, node := range self.ring.Nodes() {
+		var items []common.Item
+		if err := node.Call("DHash.ScanPrefix", data, &items); err != nil {
+			self.removeNode(node)
+			return self.ScanPrefix(prefix)
+		}
+		results = append(results, &items)
+	}
+	return common.MergeItems(results, true)
+}
+
+// KeyCursor pages through the keys snapshotted by every node when it was opened.
+type KeyCursor struct {
+	pageSize int
+	nodes    common.Remotes
+	ids      []int64
+}
+
+// OpenKeys will make every node snapshot its keys with byte values starting with prefix, and return a KeyCursor fetching them pageSize at a time.
+// The snapshots are forgotten by the nodes when the cursor runs out of keys, or after a minute without fetching a page.
+// All nodes snapshot their keys as they were at the same cluster time, using a new ReadView, so writes made while the nodes are
+// snapshotting are either seen by all of them or by none, and can't make the cursor return a key twice or miss it.
+func (self *Conn) OpenKeys(prefix []byte, pageSize int) (cursor *KeyCursor, err error) {
+	return self.ReadView().OpenKeys(prefix, pageSize)
+}
+
+// OpenMatchingKeys will open a KeyCursor like OpenKeys, but only for the keys matching pattern as defined by path.Match, like "user:*".
+// The nodes filter the keys while snapshotting them, starting at the part of pattern before the first special character.
+// It returns an error if pattern is malformed.
+func (self *Conn) OpenMatchingKeys(pattern string, pageSize int) (cursor *KeyCursor, err error) {
+	return self.ReadView().OpenMatchingKeys(pattern, pageSize)
+}
+
+// Next will return the next page of keys, or no keys when there are no more.
+func (self *KeyCursor) Next() (keys [][]byte, err error) {
+	for len(self.nodes) > 0 {
+		if err = self.nodes[0].Call("DHash.CursorPage", common.Cursor{ID: self.ids[0], Len: self.pageSize}, &keys); err != nil {
+			return
+		}
+		if len(keys) > 0 {
+			return
+		}
+		self.nodes, self.ids = self.nodes[1:], self.ids[1:]
+	}
+	return
+}
+func (self *Conn) mPut(keys, values [][]byte, sync bool) (old [][]byte, existed []bool, err error) {
+	if len(keys) != len(values) {
+		err = fmt.Errorf("%v keys but %v values", len(keys), len(values))
+		return
+	}
+	old, existed = make([][]byte, len(keys)), make([]bool, len(keys))
+	owners, batches, indices := self.byOwner(keys)
+	for addr, batch := range batches {
+		for index, _ := range batch.Items {
+			batch.Items[index].Value = values[indices[addr][index]]
+		}
+		batch.Sync = sync
+		var items []common.Item
+		if err = owners[addr].Call("DHash.MPut", *batch, &items); err != nil {
+			if _, ok := err.(rpc.ServerError); ok {
+				return
+			}
+			self.removeNode(*owners[addr])
+			return self.mPut(keys, values, sync)
+		}
+		for index, item := range items {
+			old[indices[addr][index]], existed[indices[addr][index]] = item.Value, item.Exists
+		}
+	}
+	return
+}
+
+// SMPut will put values under keys, with one call to, and one logged operation in, the owner of each of them.
+// It returns the values that were replaced, and whether each of them existed, or an error if there are not as many keys as values.
+func (self *Conn) SMPut(keys, values [][]byte) (old [][]byte, existed []bool, err error) {
+	return self.mPut(keys, values, true)
+}
+
+// MPut will put values under keys, with one call to, and one logged operation in, the owner of each of them.
+// It returns the values that were replaced, and whether each of them existed, or an error if there are not as many keys as values.
+func (self *Conn) MPut(keys, values [][]byte) (old [][]byte, existed []bool, err error) {
+	return self.mPut(keys, values, false)
+}
+
+// MGet will return the byte values of keys, and whether each of them existed, with one call to the owner of each of them.
+func (self *Conn) MGet(keys [][]byte) (values [][]byte, existed []bool) {
+	values, existed = make([][]byte, len(keys)), make([]bool, len(keys))
+	owners, batches, indices := self.byOwner(keys)
+	for addr, batch := range batches {
+		var items []common.Item
+		if err := owners[addr].Call("DHash.MGet", *batch, &items); err != nil {
+			self.removeNode(*owners[addr])
+			return self.MGet(keys)
+		}
+		for index, item := range items {
+			values[indices[addr][index]], existed[indices[addr][index]] = item.Value, item.Exists
+		}
+	}
+	return
+}
+
+func (self *Conn) performAll(ops []common.Operation, sync bool) (responses []common.Item, err error) {
+	keys := make([][]byte, len(ops))
+	for index, op := range ops {
+		keys[index] = op.Key
+	}
+	responses = make([]common.Item, len(ops))
+	owners, batches, indices := self.byOwner(keys)
+	for addr, _ := range batches {
+		pipeline := common.Pipeline{Sync: sync}
+		for _, index := range indices[addr] {
+			pipeline.Operations = append(pipeline.Operations, ops[index])
+		}
+		var items []common.Item
+		if err = owners[addr].Call("DHash.PerformAll", pipeline, &items); err != nil {
+			if _, ok := err.(rpc.ServerError); ok {
+				return
+			}
+			self.removeNode(*owners[addr])
+			return self.performAll(ops, sync)
+		}
+		for index, item := range items {
+			responses[indices[addr][index]] = item
+		}
+	}
+	return
+}
+
+// SPerformAll will perform ops, with one call to the owner of the keys of each of them, and return what each of them found:
+// the value read by the Gets and SubGets, and the value replaced by the Puts, Dels, SubPuts and SubDels.
+// Each owner performs its operations in the order they have in ops, so the reads see the writes before them, and applies their writes
+// as one transaction. Values are not put in chunks, see SetChunkSize.
+func (self *Conn) SPerformAll(ops []common.Operation) (responses []common.Item, err error) {
+	return self.performAll(ops, true)
+}
+
+// PerformAll will perform ops, with one call to the owner of the keys of each of them, and return what each of them found:
+// the value read by the Gets and SubGets, and the value replaced by the Puts, Dels, SubPuts and SubDels.
+// Each owner performs its operations in the order they have in ops, so the reads see the writes before them, and applies their writes
+// as one transaction. Values are not put in chunks, see SetChunkSize.
+func (self *Conn) PerformAll(ops []common.Operation) (responses []common.Item, err error) {
+	return self.performAll(ops, false)
+}
+
+// byOwner groups keys into one batch per owner, and returns the indices in keys of the items in each batch.
+func (self *Conn) byOwner(keys [][]byte) (owners map[string]*common.Remote, batches map[string]*common.Batch, indices map[string][]int) {
+	owners = make(map[string]*common.Remote)
+	batches = make(map[string]*common.Batch)
+	indices = make(map[string][]int)
+	for index, key := range keys {
+		_, _, successor := self.ring.Remotes(key)
+		if _, ok := batches[successor.Addr]; !ok {
+			owners[successor.Addr] = successor
+			batches[successor.Addr] = &common.Batch{}
+		}
+		batches[successor.Addr].Items = append(batches[successor.Addr].Items, common.Item{Key: key})
+		indices[successor.Addr] = append(indices[successor.Addr], index)
+	}
+	return
+}
+
 // DescribeTree will return a string representation of the complete tree in the node at pos.
 // Used for debug purposes, don't do it on big databases!
 func (self *Conn) DescribeTree(pos []byte) (result string, err error) {
@@ -975,6 +1948,63 @@ func (self *Conn) Size() (result int) {
 	return
 }
 
+// DBSize will return the number of keys with a byte value or a non empty sub tree in the cluster.
+// Each node counts the keys it owns using the counts its tree keeps up to date, so no keys are iterated over.
+func (self *Conn) DBSize() (result int) {
+	var tmp int
+	for _, node := range self.ring.Nodes() {
+		if err := node.Call("DHash.KeySize", 0, &tmp); err != nil {
+			self.removeNode(node)
+			return self.DBSize()
+		}
+		result += tmp
+	}
+	return
+}
+
+// RandomKey will return a random key with a byte value or a non empty sub tree, by asking randomly chosen nodes for a random key they own
+// until one has any. Keys owned by nodes owning few keys are more likely to be returned than others.
+func (self *Conn) RandomKey() (key []byte, existed bool) {
+	nodes := self.ring.Nodes()
+	for _, index := range rand.Perm(len(nodes)) {
+		var result common.Item
+		if err := nodes[index].Call("DHash.RandomKey", 0, &result); err != nil {
+			self.removeNode(nodes[index])
+			return self.RandomKey()
+		}
+		if result.Exists {
+			return result.Key, true
+		}
+	}
+	return
+}
+
+func (self *Conn) edgeKey(operation string, last bool) (key, value []byte, existed bool) {
+	for _, node := range self.ring.Nodes() {
+		var edge common.Item
+		if err := node.Call(operation, 0, &edge); err != nil {
+			self.removeNode(node)
+			return self.edgeKey(operation, last)
+		}
+		if edge.Exists {
+			if cmp := bytes.Compare(edge.Key, key); !existed || (last && cmp > 0) || (!last && cmp < 0) {
+				key, value, existed = edge.Key, edge.Value, true
+			}
+		}
+	}
+	return
+}
+
+// MinKey will return the lexically first key, and its value, in the cluster.
+func (self *Conn) MinKey() (key, value []byte, existed bool) {
+	return self.edgeKey("DHash.MinKey", false)
+}
+
+// MaxKey will return the lexically last key, and its value, in the cluster.
+func (self *Conn) MaxKey() (key, value []byte, existed bool) {
+	return self.edgeKey("DHash.MaxKey", true)
+}
+
 // Describe will return a string representation of the known cluster of nodes.
 func (self *Conn) Describe() string {
 	return self.ring.Describe()
@@ -1018,6 +2048,245 @@ func (self *Conn) SetExpression(expr setop.SetExpression) (result []setop.SetOpR
 	return results
 }
 
+func (self *Conn) setStore(typ setop.SetOpType, dst, key1, key2 []byte) (size int, err error) {
+	expr := setop.SetExpression{
+		Dest: dst,
+		Op: &setop.SetOp{
+			Sources: []setop.SetOpSource{
+				{Key: key1},
+				{Key: key2},
+			},
+			Type:  typ,
+			Merge: setop.First,
+		},
+	}
+	_, _, successor := self.ring.Remotes(dst)
+	if err = successor.Call("DHash.SetStore", expr, &size); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.setStore(typ, dst, key1, key2)
+	}
+	return
+}
+
+// UnionStore will replace the sub tree defined by dst with the union of the sub trees defined by key1 and key2, and return its new size.
+// Sub keys present in both sub trees will get the value from key1. Missing sub trees are treated as empty.
+func (self *Conn) UnionStore(dst, key1, key2 []byte) (size int, err error) {
+	return self.setStore(setop.Union, dst, key1, key2)
+}
+
+// InterStore will replace the sub tree defined by dst with the intersection of the sub trees defined by key1 and key2, and return its new size.
+// The values will be the ones from key1. If either sub tree is missing, dst will be empty.
+func (self *Conn) InterStore(dst, key1, key2 []byte) (size int, err error) {
+	return self.setStore(setop.Intersection, dst, key1, key2)
+}
+
+// Snapshot will make every node replace its logfiles with a snapshot of its current state, to free disk space and speed up restarts.
+func (self *Conn) Snapshot() (err error) {
+	for _, node := range self.ring.Nodes() {
+		var x int
+		if err = node.Call("DHash.Snapshot", 0, &x); err != nil {
+			if _, ok := err.(rpc.ServerError); ok {
+				return
+			}
+			self.removeNode(node)
+			return self.Snapshot()
+		}
+	}
+	return
+}
+
+// Backup will make every node copy its logfiles to a directory under dst on its own machine, named after its address, to be restored with dhash.Node#RestoreFrom.
+func (self *Conn) Backup(dst string) (err error) {
+	for _, node := range self.ring.Nodes() {
+		var x int
+		if err = node.Call("DHash.Backup", filepath.Join(dst, strings.Replace(node.Addr, ":", "_", -1)), &x); err != nil {
+			if _, ok := err.(rpc.ServerError); ok {
+				return
+			}
+			self.removeNode(node)
+			return self.Backup(dst)
+		}
+	}
+	return
+}
+
+// Usage will return statistics about the sizes of all keys and values in the cluster, not counting replicas.
+// Every node will read all data it owns to compute it, so use it sparingly on large clusters.
+func (self *Conn) Usage() (result common.Usage) {
+	for _, node := range self.ring.Nodes() {
+		var usage common.Usage
+		if err := node.Call("DHash.Usage", 0, &usage); err != nil {
+			self.removeNode(node)
+			return self.Usage()
+		}
+		result.Merge(usage)
+	}
+	return
+}
+
+// Stats will return the statistics of every node in the cluster.
+func (self *Conn) Stats() (result []common.Stats) {
+	for _, node := range self.ring.Nodes() {
+		var stats common.Stats
+		if err := node.Call("DHash.Stats", 0, &stats); err != nil {
+			self.removeNode(node)
+			return self.Stats()
+		}
+		result = append(result, stats)
+	}
+	return
+}
+
+// Metrics will return the Metrics of every node in the cluster.
+func (self *Conn) Metrics() (result []common.Metrics) {
+	for _, node := range self.ring.Nodes() {
+		var metrics common.Metrics
+		if err := node.Call("DHash.Metrics", 0, &metrics); err != nil {
+			self.removeNode(node)
+			return self.Metrics()
+		}
+		result = append(result, metrics)
+	}
+	return
+}
+
+// SlowLog will return the slow calls remembered by every node in the cluster, slowest first.
+func (self *Conn) SlowLog() (result []common.SlowOp) {
+	for _, node := range self.ring.Nodes() {
+		var ops []common.SlowOp
+		if err := node.Call("DHash.SlowLog", 0, &ops); err != nil {
+			self.removeNode(node)
+			return self.SlowLog()
+		}
+		result = append(result, ops...)
+	}
+	sort.Sort(common.SlowOps(result))
+	return
+}
+
+// ResetSlowLog will make every node in the cluster forget the slow calls it remembers.
+func (self *Conn) ResetSlowLog() {
+	var x int
+	for _, node := range self.ring.Nodes() {
+		if err := node.Call("DHash.ResetSlowLog", 0, &x); err != nil {
+			self.removeNode(node)
+		}
+	}
+}
+
+// ExportedItem is a value as written by ExportJSON and read by ImportJSON. SubKey is only set for values in sub trees.
+type ExportedItem struct {
+	Key       []byte
+	SubKey    []byte `json:",omitempty"`
+	Value     []byte
+	Timestamp int64
+}
+
+// ownedRanges returns the ranges of keys node is responsible for, which are two when they wrap around the end of the key space.
+func (self *Conn) ownedRanges(node common.Remote) []common.Range {
+	pred := self.ring.Predecessor(node)
+	if bytes.Compare(pred.Pos, node.Pos) < 0 {
+		return []common.Range{common.Range{Min: pred.Pos, Max: node.Pos, MinInc: true, Len: bulkFetchSize}}
+	}
+	return []common.Range{
+		common.Range{Min: pred.Pos, MinInc: true, Len: bulkFetchSize},
+		common.Range{Max: node.Pos, Len: bulkFetchSize},
+	}
+}
+
+// ExportJSON will write all values in the cluster, including the values in sub trees but not counting replicas, to w as newline delimited JSON encoded ExportedItems.
+// The values are fetched in pages from the node owning them, so if a node fails during the export the error is returned, and w contains the values written so far.
+func (self *Conn) ExportJSON(w io.Writer) (exported int, err error) {
+	encoder := json.NewEncoder(w)
+	for _, node := range self.ring.Nodes() {
+		for _, r := range self.ownedRanges(node) {
+			for {
+				var items []common.Item
+				if err = node.Call("DHash.BulkFetch", r, &items); err != nil {
+					self.removeNode(node)
+					return
+				}
+				if len(items) == 0 {
+					break
+				}
+				for _, item := range items {
+					if err = encoder.Encode(ExportedItem{
+						Key:       item.Key,
+						SubKey:    item.SubKey,
+						Value:     item.Value,
+						Timestamp: item.Timestamp,
+					}); err != nil {
+						return
+					}
+					exported++
+				}
+				r.Min, r.MinInc = items[len(items)-1].Key, false
+			}
+		}
+	}
+	return
+}
+
+// ImportJSON will put all ExportedItems read as newline delimited JSON from r, as written by ExportJSON, and return the number of values it put.
+// The values get new timestamps, so the imported values replace any values already under the same keys.
+func (self *Conn) ImportJSON(r io.Reader) (imported int, err error) {
+	decoder := json.NewDecoder(r)
+	c, wait := self.Dump()
+	defer wait.Wait()
+	defer close(c)
+	for {
+		var item ExportedItem
+		if err = decoder.Decode(&item); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if item.SubKey == nil {
+			c <- [2][]byte{item.Key, item.Value}
+		} else {
+			self.subPut(item.Key, item.SubKey, item.Value, false)
+		}
+		imported++
+	}
+}
+
+// ImportRDB will put the string values, from all databases, of the Redis RDB dump read from r, and return the number of values it put and the number of values it skipped.
+// Values of other types are skipped, values with an expiry are put using PutExpire with the lifetime they have left, and values that have already expired are skipped.
+func (self *Conn) ImportRDB(r io.Reader) (imported, skipped int, err error) {
+	reader, err := rdb.NewReader(r)
+	if err != nil {
+		return
+	}
+	c, wait := self.Dump()
+	defer wait.Wait()
+	defer close(c)
+	for {
+		var entry *rdb.Entry
+		if entry, err = reader.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			skipped += reader.Skipped()
+			return
+		}
+		if entry.ExpireAt == 0 {
+			c <- [2][]byte{entry.Key, entry.Value}
+		} else if lifetime := time.Unix(0, entry.ExpireAt).Sub(time.Now()); lifetime > 0 {
+			if err = self.putExpire(entry.Key, entry.Value, lifetime, false); err != nil {
+				return
+			}
+		} else {
+			skipped++
+			continue
+		}
+		imported++
+	}
+}
+
 // Configuration will return the configuration for the entire cluster.
 // Not internally used for anything right now.
 func (self *Conn) Configuration() (conf map[string]string) {
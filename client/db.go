@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/zond/god/common"
+	"time"
+)
+
+// namespaceMarker starts the keys of every DB, so that they are easy to tell apart from other keys in the logs and snapshots of the nodes.
+const namespaceMarker = "\x00db"
+
+// DB is a namespace in the cluster, with keys isolated from those of other DBs.
+//
+// The keys of a DB are stored in the cluster with a prefix made of the length and name of the DB, so they are logged, snapshotted and
+// replicated like any other keys. Keys put directly through a Conn never collide with them unless they start with a NUL byte followed by "db".
+type DB struct {
+	conn   *Conn
+	name   string
+	prefix []byte
+}
+
+// Select will return the DB named name. DBs need not be created before they are used.
+func (self *Conn) Select(name string) *DB {
+	prefix := make([]byte, len(namespaceMarker)+binary.MaxVarintLen64+len(name))
+	copy(prefix, namespaceMarker)
+	n := len(namespaceMarker) + binary.PutUvarint(prefix[len(namespaceMarker):], uint64(len(name)))
+	n += copy(prefix[n:], name)
+	return &DB{
+		conn:   self,
+		name:   name,
+		prefix: prefix[:n],
+	}
+}
+
+// Name returns the name of this DB.
+func (self *DB) Name() string {
+	return self.name
+}
+
+// Key returns the key that key in this DB is stored under in the cluster.
+func (self *DB) Key(key []byte) []byte {
+	return append(append(make([]byte, 0, len(self.prefix)+len(key)), self.prefix...), key...)
+}
+
+// end returns the first key after all the keys of this DB.
+func (self *DB) end() []byte {
+	end := append([]byte{}, self.prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i]++; end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Get will return the value under key in this DB.
+func (self *DB) Get(key []byte) (value []byte, existed bool) {
+	return self.conn.Get(self.Key(key))
+}
+
+// SPut will put value under key in this DB, like Conn#SPut.
+func (self *DB) SPut(key, value []byte) {
+	self.conn.SPut(self.Key(key), value)
+}
+
+// Put will put value under key in this DB.
+func (self *DB) Put(key, value []byte) {
+	self.conn.Put(self.Key(key), value)
+}
+
+// PutExpire will put value under key in this DB, and make it missing once lifetime has passed, like Conn#PutExpire.
+func (self *DB) PutExpire(key, value []byte, lifetime time.Duration) error {
+	return self.conn.PutExpire(self.Key(key), value, lifetime)
+}
+
+// SDel will delete the value under key in this DB, like Conn#SDel.
+func (self *DB) SDel(key []byte) {
+	self.conn.SDel(self.Key(key))
+}
+
+// Del will delete the value under key in this DB.
+func (self *DB) Del(key []byte) {
+	self.conn.Del(self.Key(key))
+}
+
+// SubGet will return the value under subKey in the sub tree defined by key in this DB.
+func (self *DB) SubGet(key, subKey []byte) (value []byte, existed bool) {
+	return self.conn.SubGet(self.Key(key), subKey)
+}
+
+// SubPut will put value under subKey in the sub tree defined by key in this DB.
+func (self *DB) SubPut(key, subKey, value []byte) {
+	self.conn.SubPut(self.Key(key), subKey, value)
+}
+
+// SubDel will delete the value under subKey in the sub tree defined by key in this DB.
+func (self *DB) SubDel(key, subKey []byte) {
+	self.conn.SubDel(self.Key(key), subKey)
+}
+
+// SubClear will delete all values in the sub tree defined by key in this DB.
+func (self *DB) SubClear(key []byte) {
+	self.conn.SubClear(self.Key(key))
+}
+
+// SubSize will return the number of values in the sub tree defined by key in this DB.
+func (self *DB) SubSize(key []byte) int {
+	return self.conn.SubSize(self.Key(key))
+}
+
+// Scan will return up to count keys with byte values in this DB after cursor, in order, and a cursor to continue after them, or nil if there are no more keys.
+// Start a scan with a nil cursor. Like Conn#Scan nothing is kept in the nodes between calls.
+func (self *DB) Scan(cursor []byte, count int) (keys [][]byte, next []byte) {
+	from, inc := self.prefix, true
+	if cursor != nil {
+		from, inc = self.Key(cursor), false
+	}
+	found, _, _ := self.conn.scan(from, inc, count, 0)
+	for _, key := range found {
+		if !bytes.HasPrefix(key, self.prefix) {
+			return
+		}
+		keys = append(keys, key[len(self.prefix):])
+	}
+	if len(keys) == count {
+		next = keys[len(keys)-1]
+	}
+	return
+}
+
+// ScanPrefix will return all keys in this DB with byte values starting with prefix, and their values, in key order.
+func (self *DB) ScanPrefix(prefix []byte) (result []common.Item) {
+	result = self.conn.ScanPrefix(self.Key(prefix))
+	for index := range result {
+		result[index].Key = result[index].Key[len(self.prefix):]
+	}
+	return
+}
+
+// Flush will delete all byte values and sub trees in this DB, leaving other DBs alone, and return the number of values deleted.
+func (self *DB) Flush() (deleted int) {
+	deleted = len(self.conn.DrainPrefix(self.prefix))
+	end := self.end()
+	for _, node := range self.conn.ring.Nodes() {
+		for _, r := range self.conn.ownedRanges(node) {
+			if r.Min == nil || bytes.Compare(r.Min, self.prefix) < 0 {
+				r.Min, r.MinInc = self.prefix, true
+			}
+			if r.Max == nil || bytes.Compare(r.Max, end) > 0 {
+				r.Max, r.MaxInc = end, false
+			}
+			if bytes.Compare(r.Min, r.Max) >= 0 {
+				continue
+			}
+			var last []byte
+			for {
+				var items []common.Item
+				if err := node.Call("DHash.BulkFetch", r, &items); err != nil {
+					self.conn.removeNode(node)
+					return deleted + self.Flush()
+				}
+				if len(items) == 0 {
+					break
+				}
+				for _, item := range items {
+					if item.SubKey != nil && !bytes.Equal(item.Key, last) {
+						deleted += self.conn.SubSize(item.Key)
+						self.conn.SSubClear(item.Key)
+						last = item.Key
+					}
+				}
+				r.Min, r.MinInc = items[len(items)-1].Key, false
+			}
+		}
+	}
+	return
+}
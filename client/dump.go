@@ -0,0 +1,50 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+)
+
+// DumpKey will return an opaque blob containing the byte value, sub tree and sub tree configuration under key, to restore with RestoreKey,
+// or nil if there is nothing under key.
+func (self *Conn) DumpKey(key []byte) (dump []byte) {
+	data := common.Item{
+		Key: key,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.DumpKey", data, &dump); err != nil {
+		self.removeNode(*successor)
+		return self.DumpKey(key)
+	}
+	return
+}
+
+func (self *Conn) restoreKey(key, dump []byte, replace, sync bool) (err error) {
+	r := common.Restoration{
+		Key:     key,
+		Dump:    dump,
+		Replace: replace,
+		Sync:    sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err = successor.Call("DHash.RestoreKey", r, &x); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.restoreKey(key, dump, replace, sync)
+	}
+	return
+}
+
+// SRestoreKey will put what dump contains under key, like RestoreKey, and wait until the replicas have it as well.
+func (self *Conn) SRestoreKey(key, dump []byte, replace bool) error {
+	return self.restoreKey(key, dump, replace, true)
+}
+
+// RestoreKey will put what dump, as returned by DumpKey, contains under key, which doesn't have to be the key it was dumped from.
+// Unless replace is true it returns an error if there already is something under key, otherwise what is there is replaced.
+func (self *Conn) RestoreKey(key, dump []byte, replace bool) error {
+	return self.restoreKey(key, dump, replace, false)
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+)
+
+func (self *Conn) hSet(key, field, value []byte, sync bool) (err error) {
+	data := common.Item{
+		Key:    key,
+		SubKey: field,
+		Value:  value,
+		Sync:   sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err = successor.Call("DHash.HSet", data, &x); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return knownError(err)
+		}
+		self.removeNode(*successor)
+		return self.hSet(key, field, value, sync)
+	}
+	return
+}
+
+// SHSet will put value under field in the hash defined by key, like HSet.
+func (self *Conn) SHSet(key, field, value []byte) error {
+	return self.hSet(key, field, value, true)
+}
+
+// HSet will put value under field in the hash defined by key, or return common.ErrWrongType if key holds another kind of value.
+//
+// A hash is a sub tree with the fields as sub keys, so every field is written on its own and the sub tree functions work on hashes as well.
+func (self *Conn) HSet(key, field, value []byte) error {
+	return self.hSet(key, field, value, false)
+}
+
+// HGet will return the value under field in the hash defined by key.
+func (self *Conn) HGet(key, field []byte) (value []byte, existed bool) {
+	return self.SubGet(key, field)
+}
+
+// HDel will delete field from the hash defined by key.
+func (self *Conn) HDel(key, field []byte) {
+	self.SubDel(key, field)
+}
+
+// HLen will return the number of fields in the hash defined by key.
+func (self *Conn) HLen(key []byte) int {
+	return self.SubSize(key)
+}
+
+// HGetAll will return all fields and values in the hash defined by key.
+func (self *Conn) HGetAll(key []byte) (result map[string][]byte) {
+	result = make(map[string][]byte)
+	for _, item := range self.Slice(key, nil, nil, true, true) {
+		result[string(item.Key)] = item.Value
+	}
+	return
+}
+
+// HIncrBy will treat the value under field in the hash defined by key as a common.EncodeInt64 encoded counter, add delta to it and return the new value.
+// A missing counter will start at 0. It returns an error if the value under field is not an encoded int64, and common.ErrWrongType
+// if key holds another kind of value.
+func (self *Conn) HIncrBy(key, field []byte, delta int64) (value int64, err error) {
+	data := common.Item{
+		Key:    key,
+		SubKey: field,
+		Value:  common.EncodeInt64(delta),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.HIncrBy", data, &value); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			err = knownError(err)
+			return
+		}
+		self.removeNode(*successor)
+		return self.HIncrBy(key, field, delta)
+	}
+	return
+}
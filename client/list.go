@@ -0,0 +1,119 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+	"time"
+)
+
+func (self *Conn) push(operation string, key, value []byte, sync bool) (length int, err error) {
+	data := common.Item{
+		Key:   key,
+		Value: value,
+		Sync:  sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call(operation, data, &length); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			err = knownError(err)
+			return
+		}
+		self.removeNode(*successor)
+		return self.push(operation, key, value, sync)
+	}
+	return
+}
+
+// SLPush will put value first in the list defined by key, and return the new length of the list, like LPush.
+func (self *Conn) SLPush(key, value []byte) (length int, err error) {
+	return self.push("DHash.LPush", key, value, true)
+}
+
+// LPush will put value first in the list defined by key, and return the new length of the list.
+//
+// A list is a sub tree with the positions of the elements as sub keys, and pushes and pops are atomic in the node owning the list.
+// It returns common.ErrWrongType if key holds another kind of value.
+// The sub tree functions work on lists as well, for example SubSize to count the elements and SubClear to empty the list.
+func (self *Conn) LPush(key, value []byte) (length int, err error) {
+	return self.push("DHash.LPush", key, value, false)
+}
+
+// SRPush will put value last in the list defined by key, and return the new length of the list, like RPush.
+func (self *Conn) SRPush(key, value []byte) (length int, err error) {
+	return self.push("DHash.RPush", key, value, true)
+}
+
+// RPush will put value last in the list defined by key, and return the new length of the list.
+func (self *Conn) RPush(key, value []byte) (length int, err error) {
+	return self.push("DHash.RPush", key, value, false)
+}
+
+func (self *Conn) pop(operation string, key []byte) (value []byte, existed bool) {
+	data := common.Item{
+		Key:  key,
+		Sync: true,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Item
+	if err := successor.Call(operation, data, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.pop(operation, key)
+	}
+	return result.Value, result.Exists
+}
+
+// LPop will remove and return the first element of the list defined by key.
+// Nothing is removed from keys holding other kinds of values.
+func (self *Conn) LPop(key []byte) (value []byte, existed bool) {
+	return self.pop("DHash.LPop", key)
+}
+
+// RPop will remove and return the last element of the list defined by key.
+func (self *Conn) RPop(key []byte) (value []byte, existed bool) {
+	return self.pop("DHash.RPop", key)
+}
+
+func (self *Conn) blockingPop(operation string, key []byte, timeout time.Duration) (value []byte, existed bool) {
+	b := common.BlockingPop{
+		Key:     key,
+		Timeout: timeout,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Item
+	if err := successor.Call(operation, b, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.blockingPop(operation, key, timeout)
+	}
+	return result.Value, result.Exists
+}
+
+// BLPop will remove and return the first element of the list defined by key, like LPop, but if the list is empty it waits up to timeout
+// for an element to be pushed. A timeout of 0 waits until an element is pushed. If the owner of key fails the wait starts over with the new owner.
+func (self *Conn) BLPop(key []byte, timeout time.Duration) (value []byte, existed bool) {
+	return self.blockingPop("DHash.BLPop", key, timeout)
+}
+
+// BRPop will remove and return the last element of the list defined by key, like RPop, but if the list is empty it waits up to timeout
+// for an element to be pushed, like BLPop.
+func (self *Conn) BRPop(key []byte, timeout time.Duration) (value []byte, existed bool) {
+	return self.blockingPop("DHash.BRPop", key, timeout)
+}
+
+// LLen will return the number of elements in the list defined by key.
+func (self *Conn) LLen(key []byte) int {
+	return self.SubSize(key)
+}
+
+// LRange will return the elements of the list defined by key with indices between start and stop, both included, in order.
+func (self *Conn) LRange(key []byte, start, stop int) (result [][]byte) {
+	for _, item := range self.SliceIndex(key, &start, &stop) {
+		result = append(result, item.Value)
+	}
+	return
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+	"path"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often Watch looks for nodes it hasn't subscribed in.
+const watchInterval = time.Second
+
+func (self *Conn) publish(channel string, message []byte, sync bool) (reached int) {
+	n := common.Notification{
+		Channel: channel,
+		Message: message,
+		Sync:    sync,
+	}
+	node := self.ring.Random()
+	if err := node.Call("DHash.PublishAll", n, &reached); err != nil {
+		self.removeNode(node)
+		return self.publish(channel, message, sync)
+	}
+	return
+}
+
+// SPublish will publish message to the subscribers, and dhash.ChannelListeners, of channel in all nodes, like Publish,
+// and return the number of them it reached once every node has delivered it.
+func (self *Conn) SPublish(channel string, message []byte) (reached int) {
+	return self.publish(channel, message, true)
+}
+
+// Publish will publish message to the subscribers, and dhash.ChannelListeners, of channel in all nodes.
+// Messages are not stored, so they only reach the subscribers present when they are published.
+func (self *Conn) Publish(channel string, message []byte) {
+	self.publish(channel, message, false)
+}
+
+// Subscription delivers the messages published to its channels, from when it was created until it is closed.
+type Subscription struct {
+	// C receives the messages, with the channel they were published to in Channel and the message in Message.
+	// It is closed when the Subscription is.
+	C        <-chan common.Notification
+	conn     *Conn
+	node     *common.Remote
+	channels []string
+	pattern  string
+	messages chan common.Notification
+	stop     chan struct{}
+}
+
+func (self *Conn) subscribe(node *common.Remote, pattern string, channels []string) *Subscription {
+	result := &Subscription{
+		conn:     self,
+		node:     node,
+		channels: channels,
+		pattern:  pattern,
+		messages: make(chan common.Notification),
+		stop:     make(chan struct{}),
+	}
+	result.C = result.messages
+	go result.receive()
+	return result
+}
+
+// Subscribe will return a Subscription delivering the messages published to channel, and channels, to its C.
+//
+// The Subscription polls a single node for messages, and subscribes in another node if that one fails.
+// Messages published while it does, or while C is not read fast enough for the node to buffer them, are lost.
+func (self *Conn) Subscribe(channel string, channels ...string) *Subscription {
+	return self.subscribe(nil, "", append([]string{channel}, channels...))
+}
+
+// Close will stop the Subscription and close its C. It must only be called once.
+func (self *Subscription) Close() {
+	close(self.stop)
+}
+
+// subscribe will subscribe in the node of the Subscription, or in any node if it has none, and return whether it managed to.
+func (self *Subscription) subscribe() (node common.Remote, id int64, ok bool) {
+	s := common.Subscription{
+		Channels: self.channels,
+		Pattern:  self.pattern,
+	}
+	for {
+		if self.node == nil {
+			node = self.conn.ring.Random()
+		} else {
+			node = *self.node
+		}
+		err := node.Call("DHash.Subscribe", s, &id)
+		if err == nil {
+			return node, id, true
+		}
+		if _, isServerError := err.(rpc.ServerError); isServerError || self.node != nil {
+			return
+		}
+		self.conn.removeNode(node)
+	}
+}
+func (self *Subscription) receive() {
+	defer close(self.messages)
+	node, id, ok := self.subscribe()
+	if !ok {
+		return
+	}
+	defer func() {
+		node.Go("DHash.Unsubscribe", common.Subscription{ID: id}, new(int))
+	}()
+	for {
+		var messages []common.Notification
+		call := node.Go("DHash.Receive", common.Subscription{ID: id}, &messages)
+		select {
+		case <-self.stop:
+			return
+		case <-call.Done:
+		}
+		if call.Error != nil {
+			// Either the node failed, or it forgot the subscription when restarting, so subscribe again.
+			if _, isServerError := call.Error.(rpc.ServerError); !isServerError && self.node == nil {
+				self.conn.removeNode(node)
+			}
+			if node, id, ok = self.subscribe(); !ok {
+				return
+			}
+			continue
+		}
+		for _, message := range messages {
+			select {
+			case self.messages <- message:
+			case <-self.stop:
+				return
+			}
+		}
+	}
+}
+
+// Watch will return a channel receiving an Event each time the byte value under a key matching pattern, as a path.Match pattern, is put, deleted or expires,
+// and a function that stops watching and closes the channel.
+//
+// Since the owner of a key publishes its changes, Watch subscribes in every node. Nodes joining the cluster are subscribed in within watchInterval,
+// but only if the Conn is started. Changes made while a node is being subscribed in, or while the channel is not read fast enough, are lost.
+// If pattern is malformed the channel is closed at once.
+func (self *Conn) Watch(pattern string) (events <-chan common.Event, stop func()) {
+	result := make(chan common.Event)
+	stopper := make(chan struct{})
+	go self.watch(pattern, result, stopper)
+	return result, func() {
+		close(stopper)
+	}
+}
+func (self *Conn) watch(pattern string, events chan common.Event, stopper chan struct{}) {
+	defer close(events)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return
+	}
+	channels := []string{
+		common.KeyspaceChannel + common.PutEvent,
+		common.KeyspaceChannel + common.DelEvent,
+		common.KeyspaceChannel + common.ExpireEvent,
+	}
+	subscriptions := make(map[string]*Subscription)
+	defer func() {
+		for _, subscription := range subscriptions {
+			subscription.Close()
+		}
+	}()
+	merged := make(chan common.Notification)
+	gone := make(chan string)
+	forward := func(addr string, subscription *Subscription) {
+		for message := range subscription.C {
+			select {
+			case merged <- message:
+			case <-stopper:
+				return
+			}
+		}
+		select {
+		case gone <- addr:
+		case <-stopper:
+		}
+	}
+	subscribeAll := func() {
+		for _, node := range self.ring.Nodes() {
+			if _, found := subscriptions[node.Addr]; !found {
+				node := node
+				subscriptions[node.Addr] = self.subscribe(&node, pattern, channels)
+				go forward(node.Addr, subscriptions[node.Addr])
+			}
+		}
+	}
+	subscribeAll()
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message := <-merged:
+			event := common.Event{
+				Type: strings.TrimPrefix(message.Channel, common.KeyspaceChannel),
+				Key:  message.Message,
+			}
+			select {
+			case events <- event:
+			case <-stopper:
+				return
+			}
+		case addr := <-gone:
+			// The subscription closed itself, since the node failed.
+			delete(subscriptions, addr)
+		case <-ticker.C:
+			subscribeAll()
+		case <-stopper:
+			return
+		}
+	}
+}
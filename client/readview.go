@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bytes"
+	"github.com/zond/god/common"
+	"net/rpc"
+	"path"
+	"strings"
+	"time"
+)
+
+// ReadView reads the byte values of the cluster as they were at one point in cluster time, so that long scans and exports through it
+// see a consistent view of the database instead of a mix of values from before and after the writes made while they run.
+type ReadView struct {
+	conn *Conn
+	// At is the cluster time, in nanoseconds, that the values are read as they were at.
+	At int64
+}
+
+// ReadView will return a ReadView of the byte values as they are now, at the latest cluster time of the nodes, so that it sees the writes
+// made before it on nodes whose clocks are ahead of the others.
+//
+// The nodes remember the values replaced in the last minute, but only the last few of them for each key, so reads through ReadViews
+// older than that, or of keys written more often than that since the ReadView was created, fail with common.ErrSnapshotTooOld.
+// The values are read as of the cluster time they were written with, so writes on their way to their owners when the ReadView
+// is created may or may not be seen. Sub trees are not part of the view.
+func (self *Conn) ReadView() (view *ReadView) {
+	view = &ReadView{
+		conn: self,
+	}
+	for _, node := range self.ring.Nodes() {
+		var now time.Time
+		if err := node.Call("DHash.Time", 0, &now); err != nil {
+			self.removeNode(node)
+			return self.ReadView()
+		}
+		if now.UnixNano() > view.At {
+			view.At = now.UnixNano()
+		}
+	}
+	return
+}
+
+func (self *ReadView) get(key []byte) (value []byte, existed bool, err error) {
+	data := common.Item{
+		Key:       key,
+		Timestamp: self.At,
+	}
+	_, _, successor := self.conn.ring.Remotes(key)
+	var result common.Item
+	if err = successor.Call("DHash.GetAt", data, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			err = knownError(err)
+			return
+		}
+		self.conn.removeNode(*successor)
+		return self.get(key)
+	}
+	return result.Value, result.Exists, nil
+}
+
+// Get will return the value under key as it was when the ReadView was created, reassembled from its chunks if it was put in chunks.
+func (self *ReadView) Get(key []byte) (value []byte, existed bool, err error) {
+	if value, existed, err = self.get(key); err != nil || !existed || !isChunked(value) {
+		return
+	}
+	id, count := parseManifest(value)
+	var buffer bytes.Buffer
+	for index := 0; index < count; index++ {
+		var chunk []byte
+		if chunk, existed, err = self.get(chunkKey(id, index)); err != nil || !existed {
+			return nil, false, err
+		}
+		buffer.Write(chunk)
+	}
+	return buffer.Bytes(), true, nil
+}
+
+// Scan will return up to count keys that had byte values when the ReadView was created, after cursor, in order, like Conn#Scan.
+func (self *ReadView) Scan(cursor []byte, count int) (keys [][]byte, next []byte, err error) {
+	return self.conn.scan(cursor, cursor == nil, count, self.At)
+}
+
+// OpenKeys will open a KeyCursor like Conn#OpenKeys, of the keys that had byte values when the ReadView was created.
+func (self *ReadView) OpenKeys(prefix []byte, pageSize int) (cursor *KeyCursor, err error) {
+	return self.openKeys(prefix, "", pageSize)
+}
+
+// OpenMatchingKeys will open a KeyCursor like Conn#OpenMatchingKeys, of the keys that had byte values when the ReadView was created.
+func (self *ReadView) OpenMatchingKeys(pattern string, pageSize int) (cursor *KeyCursor, err error) {
+	if _, err = path.Match(pattern, ""); err != nil {
+		return
+	}
+	prefix := pattern
+	if index := strings.IndexAny(pattern, "*?[\\"); index != -1 {
+		prefix = pattern[:index]
+	}
+	return self.openKeys([]byte(prefix), pattern, pageSize)
+}
+func (self *ReadView) openKeys(prefix []byte, pattern string, pageSize int) (cursor *KeyCursor, err error) {
+	data := common.Item{
+		Key:       prefix,
+		Value:     []byte(pattern),
+		Timestamp: self.At,
+	}
+	cursor = &KeyCursor{
+		pageSize: pageSize,
+		nodes:    self.conn.ring.Nodes(),
+	}
+	cursor.ids = make([]int64, len(cursor.nodes))
+	for index, node := range cursor.nodes {
+		if err = node.Call("DHash.OpenCursor", data, &cursor.ids[index]); err != nil {
+			err = knownError(err)
+			return
+		}
+	}
+	return
+}
+
+// ScanPrefix will return all keys starting with prefix that had byte values when the ReadView was created, and those values, in key order.
+func (self *ReadView) ScanPrefix(prefix []byte) (result []common.Item, err error) {
+	data := common.Item{
+		Key:       prefix,
+		Timestamp: self.At,
+	}
+	var results []*[]common.Item
+	for _, node := range self.conn.ring.Nodes() {
+		var items []common.Item
+		if err = node.Call("DHash.ScanPrefixAt", data, &items); err != nil {
+			if _, ok := err.(rpc.ServerError); ok {
+				err = knownError(err)
+				return
+			}
+			self.conn.removeNode(node)
+			return self.ScanPrefix(prefix)
+		}
+		results = append(results, &items)
+	}
+	return common.MergeItems(results, true), nil
+}
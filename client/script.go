@@ -0,0 +1,35 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+)
+
+func (self *Conn) eval(script string, key []byte, args [][]byte, sync bool) (result []byte, err error) {
+	e := common.Eval{
+		Script: script,
+		Key:    key,
+		Args:   args,
+		Sync:   sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.Eval", e, &result); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
+		self.removeNode(*successor)
+		return self.eval(script, key, args, sync)
+	}
+	return
+}
+
+// SEval will run the script registered as script with args on the owner of key, like Eval, and wait until the replicas have its writes.
+func (self *Conn) SEval(script string, key []byte, args ...[]byte) (result []byte, err error) {
+	return self.eval(script, key, args, true)
+}
+
+// Eval will run the script registered as script, with dhash.Node#AddScript, with args on the owner of key, and return its result.
+// The reads and writes of the script are atomic, and it can only use keys owned by the same node as key.
+func (self *Conn) Eval(script string, key []byte, args ...[]byte) (result []byte, err error) {
+	return self.eval(script, key, args, false)
+}
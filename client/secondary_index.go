@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"github.com/zond/god/common"
+)
+
+// AddIndex will declare an index named name, extracting field from the JSON object byte values, where field is a dot separated path like "address.city".
+// From then on, the owners of the keys put or deleted keep the keys of the values in the index, and the values already in the cluster are indexed before AddIndex returns.
+// An empty field removes the index.
+func (self *Conn) AddIndex(name, field string) {
+	conf := common.ConfItem{
+		Key:   common.IndexConfPrefix + name,
+		Value: field,
+	}
+	// Every node is told at once, since the configuration would otherwise take a while to sync around the ring.
+	for _, node := range self.ring.Nodes() {
+		var x int
+		if err := node.Call("DHash.AddConfiguration", conf, &x); err != nil {
+			self.removeNode(node)
+			self.AddIndex(name, field)
+			return
+		}
+	}
+	if field == "" {
+		return
+	}
+	for _, node := range self.ring.Nodes() {
+		for _, r := range self.ownedRanges(node) {
+			for {
+				var items []common.Item
+				if err := node.Call("DHash.BulkFetch", r, &items); err != nil {
+					self.removeNode(node)
+					self.AddIndex(name, field)
+					return
+				}
+				if len(items) == 0 {
+					break
+				}
+				for _, item := range items {
+					if item.SubKey == nil && !common.IsIndexKey(item.Key) {
+						if value, ok := common.ExtractField(item.Value, field); ok {
+							self.SSubPut(common.IndexKey(name, value), item.Key, nil)
+						}
+					}
+				}
+				r.Min, r.MinInc = items[len(items)-1].Key, false
+			}
+		}
+	}
+}
+
+// Query will return the keys of the values with value in the field extracted by the index named name, in key order.
+// The values are read to make sure they still match, since the index is updated after the values, and keys no longer matching are removed from the index.
+func (self *Conn) Query(name string, value []byte) (keys [][]byte) {
+	field := self.Configuration()[common.IndexConfPrefix+name]
+	if field == "" {
+		return
+	}
+	indexKey := common.IndexKey(name, value)
+	for _, item := range self.Slice(indexKey, nil, nil, true, true) {
+		if current, existed := self.Get(item.Key); existed {
+			if extracted, ok := common.ExtractField(current, field); ok && bytes.Equal(extracted, value) {
+				keys = append(keys, item.Key)
+				continue
+			}
+		}
+		self.SubDel(indexKey, item.Key)
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"github.com/zond/setop"
+	"net/rpc"
+)
+
+func (self *Conn) sAdd(key, member []byte, sync bool) (err error) {
+	data := common.Item{
+		Key:    key,
+		SubKey: member,
+		Value:  member,
+		Sync:   sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err = successor.Call("DHash.SAdd", data, &x); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return knownError(err)
+		}
+		self.removeNode(*successor)
+		return self.sAdd(key, member, sync)
+	}
+	return
+}
+
+// SSAdd will add member to the set defined by key, like SAdd.
+func (self *Conn) SSAdd(key, member []byte) error {
+	return self.sAdd(key, member, true)
+}
+
+// SAdd will add member to the set defined by key, or return common.ErrWrongType if key holds another kind of value.
+//
+// A set is a sub tree with the members as both sub keys and values, so the sub tree functions and set expressions work on sets as well.
+func (self *Conn) SAdd(key, member []byte) error {
+	return self.sAdd(key, member, false)
+}
+
+// SRem will remove member from the set defined by key.
+func (self *Conn) SRem(key, member []byte) {
+	self.SubDel(key, member)
+}
+
+// SIsMember will return whether member is in the set defined by key.
+func (self *Conn) SIsMember(key, member []byte) (existed bool) {
+	_, existed = self.SubGet(key, member)
+	return
+}
+
+// SCard will return the number of members in the set defined by key.
+func (self *Conn) SCard(key []byte) int {
+	return self.SubSize(key)
+}
+
+// SMembers will return the members of the set defined by key, in order.
+func (self *Conn) SMembers(key []byte) (members [][]byte) {
+	for _, item := range self.Slice(key, nil, nil, true, true) {
+		members = append(members, item.Key)
+	}
+	return
+}
+
+// setOp will return the members of the result of typ applied to the sets defined by keys, computed by the node owning the biggest of them.
+func (self *Conn) setOp(typ setop.SetOpType, keys [][]byte) (members [][]byte) {
+	if len(keys) == 0 {
+		return
+	}
+	op := &setop.SetOp{
+		Type:  typ,
+		Merge: setop.First,
+	}
+	for _, key := range keys {
+		op.Sources = append(op.Sources, setop.SetOpSource{Key: key})
+	}
+	for _, res := range self.SetExpression(setop.SetExpression{Op: op}) {
+		members = append(members, res.Key)
+	}
+	return
+}
+
+// SUnion will return the members that are in any of the sets defined by keys, in order.
+func (self *Conn) SUnion(keys ...[]byte) [][]byte {
+	return self.setOp(setop.Union, keys)
+}
+
+// SInter will return the members that are in all the sets defined by keys, in order.
+func (self *Conn) SInter(keys ...[]byte) [][]byte {
+	return self.setOp(setop.Intersection, keys)
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"fmt"
+	"github.com/zond/god/common"
+	"math"
+	"net/rpc"
+)
+
+// ScoredMember is a member of a sorted set and its score.
+type ScoredMember struct {
+	Member []byte
+	Score  float64
+}
+
+// decodeScore returns the score in a value of a sorted set, which is the encoded score followed by the member.
+func decodeScore(value []byte) (score float64, err error) {
+	if len(value) < 8 {
+		err = fmt.Errorf("%v is not a sorted set value", value)
+		return
+	}
+	return common.DecodeScore(value[:8])
+}
+
+func scoredMembers(items []common.Item) (result []ScoredMember) {
+	for _, item := range items {
+		if score, err := decodeScore(item.Key); err == nil {
+			result = append(result, ScoredMember{
+				Member: item.Value,
+				Score:  score,
+			})
+		}
+	}
+	return
+}
+
+func (self *Conn) zAdd(key, member []byte, score float64, sync bool) (err error) {
+	data := common.Item{
+		Key:    key,
+		SubKey: member,
+		Value:  common.EncodeScore(score),
+		Sync:   sync,
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err = successor.Call("DHash.ZAdd", data, &x); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return knownError(err)
+		}
+		self.removeNode(*successor)
+		return self.zAdd(key, member, score, sync)
+	}
+	return
+}
+
+// SZAdd will add member with score to the sorted set defined by key, like ZAdd.
+func (self *Conn) SZAdd(key, member []byte, score float64) error {
+	return self.zAdd(key, member, score, true)
+}
+
+// ZAdd will add member with score to the sorted set defined by key, or change the score of member if it already is in the set.
+// It returns common.ErrWrongType if key holds another kind of value.
+//
+// A sorted set is a sub tree with members as sub keys and common.EncodeScore encoded scores followed by the members as values,
+// mirrored so that it can be read in score order.
+// The sub tree functions work on sorted sets as well, for example SubDel to remove a member and SubSize to count them.
+func (self *Conn) ZAdd(key, member []byte, score float64) error {
+	return self.zAdd(key, member, score, false)
+}
+
+// ZRem will remove member from the sorted set defined by key.
+func (self *Conn) ZRem(key, member []byte) {
+	self.SubDel(key, member)
+}
+
+// ZScore will return the score of member in the sorted set defined by key.
+func (self *Conn) ZScore(key, member []byte) (score float64, existed bool) {
+	value, existed := self.SubGet(key, member)
+	if existed {
+		var err error
+		if score, err = decodeScore(value); err != nil {
+			existed = false
+		}
+	}
+	return
+}
+
+// ZRank will return the number of members with lower scores than member in the sorted set defined by key.
+// Members with the same score are ordered by member.
+func (self *Conn) ZRank(key, member []byte) (rank int, existed bool) {
+	value, existed := self.SubGet(key, member)
+	if !existed {
+		return
+	}
+	rank, existed = self.MirrorIndexOf(key, value)
+	return
+}
+
+// ZRange will return the members, and their scores, of the sorted set defined by key with ranks between start and stop, both included, in score order.
+func (self *Conn) ZRange(key []byte, start, stop int) []ScoredMember {
+	return scoredMembers(self.MirrorSliceIndex(key, &start, &stop))
+}
+
+// ZRangeByScore will return the members, and their scores, of the sorted set defined by key with scores between min and max, both included, in score order.
+func (self *Conn) ZRangeByScore(key []byte, min, max float64) []ScoredMember {
+	return scoredMembers(self.MirrorSlice(key, common.EncodeScore(min), common.EncodeScore(math.Nextafter(max, math.Inf(1))), true, false))
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"net/rpc"
+)
+
+// Transaction is a queue of puts and deletes to apply together with Exec.
+// The operations are only sent to the cluster when Exec is called.
+type Transaction struct {
+	conn    *Conn
+	items   []common.Item
+	watches []common.Item
+}
+
+// Multi will return an empty Transaction.
+func (self *Conn) Multi() *Transaction {
+	return &Transaction{conn: self}
+}
+
+// Watch will make Exec fail with common.ErrWatchChanged, without applying anything, if the byte value under key no longer has version,
+// as returned by GetVersion, and return the Transaction. A version of 0 watches for key to remain missing.
+func (self *Transaction) Watch(key []byte, version int64) *Transaction {
+	self.watches = append(self.watches, common.Item{Key: key, Timestamp: version})
+	return self
+}
+
+// Put will queue putting value under key, and return the Transaction.
+func (self *Transaction) Put(key, value []byte) *Transaction {
+	self.items = append(self.items, common.Item{Key: key, Value: value, Exists: true})
+	return self
+}
+
+// Del will queue deleting the value under key, and return the Transaction.
+func (self *Transaction) Del(key []byte) *Transaction {
+	self.items = append(self.items, common.Item{Key: key})
+	return self
+}
+
+// SubPut will queue putting value under subKey in the sub tree defined by key, and return the Transaction.
+func (self *Transaction) SubPut(key, subKey, value []byte) *Transaction {
+	self.items = append(self.items, common.Item{Key: key, SubKey: subKey, Value: value, Exists: true})
+	return self
+}
+
+// SubDel will queue deleting the value under subKey in the sub tree defined by key, and return the Transaction.
+func (self *Transaction) SubDel(key, subKey []byte) *Transaction {
+	self.items = append(self.items, common.Item{Key: key, SubKey: subKey})
+	return self
+}
+
+// Len returns the number of queued operations.
+func (self *Transaction) Len() int {
+	return len(self.items)
+}
+
+// batches groups the queued operations and watches by the owner of their keys.
+func (self *Transaction) batches(sync bool) (owners map[string]*common.Remote, batches map[string]*common.Batch) {
+	owners = make(map[string]*common.Remote)
+	batches = make(map[string]*common.Batch)
+	batchFor := func(key []byte) *common.Batch {
+		_, _, successor := self.conn.ring.Remotes(key)
+		if _, ok := batches[successor.Addr]; !ok {
+			owners[successor.Addr] = successor
+			batches[successor.Addr] = &common.Batch{Sync: sync}
+		}
+		return batches[successor.Addr]
+	}
+	for _, watch := range self.watches {
+		batch := batchFor(watch.Key)
+		batch.Watches = append(batch.Watches, watch)
+	}
+	for _, item := range self.items {
+		batch := batchFor(item.Key)
+		batch.Items = append(batch.Items, item)
+	}
+	return
+}
+
+func (self *Transaction) call(owner *common.Remote, batch common.Batch) (err error) {
+	var old []common.Item
+	if err = owner.Call("DHash.Exec", batch, &old); err != nil {
+		if serverError, ok := err.(rpc.ServerError); ok && string(serverError) == common.ErrWatchChanged.Error() {
+			err = common.ErrWatchChanged
+		}
+	}
+	return
+}
+
+func (self *Transaction) exec(sync bool) (err error) {
+	owners, batches := self.batches(sync)
+	if len(batches) > 1 && len(self.watches) > 0 {
+		// Check all watches before changing anything, to avoid applying the operations on some nodes only to have the watches fail on others.
+		for addr, batch := range batches {
+			if len(batch.Watches) > 0 {
+				if err = self.call(owners[addr], common.Batch{Watches: batch.Watches}); err != nil {
+					if _, ok := err.(rpc.ServerError); ok || err == common.ErrWatchChanged {
+						return
+					}
+					self.conn.removeNode(*owners[addr])
+					return self.exec(sync)
+				}
+			}
+		}
+	}
+	for addr, batch := range batches {
+		if err = self.call(owners[addr], *batch); err != nil {
+			if _, ok := err.(rpc.ServerError); ok || err == common.ErrWatchChanged {
+				return
+			}
+			self.conn.removeNode(*owners[addr])
+			// Only retry the failed part, since the watches of the rest would fail on the values this transaction already changed.
+			retry := &Transaction{
+				conn:    self.conn,
+				items:   batch.Items,
+				watches: batch.Watches,
+			}
+			if err = retry.exec(sync); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// SExec will apply the queued operations, like Exec, and wait until the replicas have them as well.
+func (self *Transaction) SExec() error {
+	return self.exec(true)
+}
+
+// Exec will apply the queued operations, in the order they were queued, unless a watched value has changed, in which case it returns common.ErrWatchChanged.
+//
+// The operations on keys owned by the same node are applied atomically, and logged as one operation, so neither other writers nor a restarted
+// node will ever see only some of them. The watches of keys owned by a node are checked atomically with applying the operations on that node.
+//
+// Operations on keys owned by different nodes are applied one node at a time, and the operations sent to a node that fails are sent to the new owners
+// of their keys. If the watches are owned by several nodes they are all checked before anything is applied,
+// but a value changed after that check can still make Exec return common.ErrWatchChanged after the operations on other nodes were applied.
+func (self *Transaction) Exec() error {
+	return self.exec(false)
+}
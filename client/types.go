@@ -0,0 +1,17 @@
+package client
+
+// Type will return the kind of value under key, one of common.NoneType, common.StringType, common.ListType, common.SetType,
+// common.HashType, common.ZSetType and common.TreeType.
+//
+// A key with a sub tree is of the kind of the sub tree, even if it also has a byte value.
+// Sub trees remember their kind when written with the operations of that kind, like LPush or SAdd, which fail with common.ErrWrongType
+// for keys of other kinds, or with byte values. Sub trees only written with the plain sub tree functions are of kind common.TreeType
+// until written with a typed operation.
+func (self *Conn) Type(key []byte) (typ string) {
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.Type", key, &typ); err != nil {
+		self.removeNode(*successor)
+		return self.Type(key)
+	}
+	return
+}
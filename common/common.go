@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/big"
 	"runtime"
 	"sort"
@@ -91,6 +92,32 @@ func DecodeFloat64(b []byte) (result float64, err error) {
 	return
 }
 
+// EncodeScore encodes f so that the encoded scores sort like the floats, which EncodeFloat64 doesn't do for negative numbers.
+func EncodeScore(f float64) []byte {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, bits)
+	return result
+}
+func DecodeScore(b []byte) (result float64, err error) {
+	if len(b) != 8 {
+		err = fmt.Errorf("%v is not an encoded score", b)
+		return
+	}
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
 func Max64(i ...int64) (result int64) {
 	for _, x := range i {
 		if x > result {
@@ -228,9 +255,86 @@ type DHashDescription struct {
 	OwnedEntries int
 	HeldEntries  int
 	Load         float64
+	Degraded     bool
+	NonDurable   int
 	Nodes        Remotes
 }
 
+// Stats contains cheap to compute statistics about a dhash node.
+// Bytes is an approximation of the memory used by the keys and values held by the node, and LogBacklog is the number of
+// operations that are either waiting to be logged or kept in memory because the log failed to write them.
+// Evicted is the number of values deleted to keep the node below its maximum size since it started.
+type Stats struct {
+	Addr         string
+	OwnedEntries int
+	HeldEntries  int
+	Bytes        int
+	LogBacklog   int
+	Degraded     bool
+	Evicted      int64
+}
+
+// Metrics counts what a dhash node at Addr has done since it started.
+// Hits and Misses count the Get and SubGet calls that found, and didn't find, a value. Calls counts the DHash calls the node served by method,
+// and Errors the ones among them that returned an error.
+type Metrics struct {
+	Addr   string
+	Hits   int64
+	Misses int64
+	Calls  map[string]int64
+	Errors map[string]int64
+}
+
+// Merge will add the counts of other to this Metrics.
+func (self *Metrics) Merge(other Metrics) {
+	self.Hits += other.Hits
+	self.Misses += other.Misses
+	if self.Calls == nil {
+		self.Calls = make(map[string]int64)
+	}
+	if self.Errors == nil {
+		self.Errors = make(map[string]int64)
+	}
+	for method, count := range other.Calls {
+		self.Calls[method] += count
+	}
+	for method, count := range other.Errors {
+		self.Errors[method] += count
+	}
+}
+
+// SlowOp is a call to Method, about Key if it had one, that a dhash node at Addr started At and spent Took handling.
+type SlowOp struct {
+	Addr   string
+	Method string
+	Key    []byte
+	At     time.Time
+	Took   time.Duration
+}
+
+// Describe will return a humanly readable string describing the slow call.
+func (self SlowOp) Describe() string {
+	return fmt.Sprintf("%v: %v %q at %v took %v", self.Addr, self.Method, self.Key, self.At.Format(time.RFC3339Nano), self.Took)
+}
+
+// SlowOps sort slowest first.
+type SlowOps []SlowOp
+
+func (self SlowOps) Len() int {
+	return len(self)
+}
+func (self SlowOps) Less(i, j int) bool {
+	return self[i].Took > self[j].Took
+}
+func (self SlowOps) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+// Describe will return a humanly readable string describing the dhash node statistics.
+func (self Stats) Describe() string {
+	return fmt.Sprintf("%v: owned entries: %v, held entries: %v, bytes: %v, log backlog: %v, degraded: %v, evicted: %v", self.Addr, self.OwnedEntries, self.HeldEntries, self.Bytes, self.LogBacklog, self.Degraded, self.Evicted)
+}
+
 // Describe will return a humanly readable string description of the dhash node.
 func (self DHashDescription) Describe() string {
 	return fmt.Sprintf("%+v", struct {
@@ -243,6 +347,8 @@ func (self DHashDescription) Describe() string {
 		OwnedEntries int
 		HeldEntries  int
 		Load         float64
+		Degraded     bool
+		NonDurable   int
 		Nodes        string
 	}{
 		Addr:         self.Addr,
@@ -254,6 +360,8 @@ func (self DHashDescription) Describe() string {
 		OwnedEntries: self.OwnedEntries,
 		HeldEntries:  self.HeldEntries,
 		Load:         self.Load,
+		Degraded:     self.Degraded,
+		NonDurable:   self.NonDurable,
 		Nodes:        fmt.Sprintf("\n%v", self.Nodes.Describe()),
 	})
 }
@@ -0,0 +1,23 @@
+package common
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(-1), -1e10, -2.5, -1, 0, 0.5, 1, 2.5, 1e10, math.Inf(1)}
+	var encoded [][]byte
+	for _, score := range scores {
+		encoded = append(encoded, EncodeScore(score))
+		if decoded, err := DecodeScore(EncodeScore(score)); err != nil || decoded != score {
+			t.Errorf("wanted %v, got %v, %v", score, decoded, err)
+		}
+	}
+	for index := 1; index < len(encoded); index++ {
+		if bytes.Compare(encoded[index-1], encoded[index]) >= 0 {
+			t.Errorf("wanted %v to sort before %v", scores[index-1], scores[index])
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"bufio"
+	"compress/flate"
+	"io"
+)
+
+// CompressionMagic is the first byte sent over connections that want all their traffic flate compressed.
+// No gob stream starts with it, since as a length prefix it would denote a count of 128 bytes.
+const CompressionMagic = 0x80
+
+// Capabilities describes the optional protocol features a Node supports.
+type Capabilities struct {
+	Compression bool
+}
+
+type readWriteCloser struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+type compressedConn struct {
+	reader io.ReadCloser
+	writer *flate.Writer
+	conn   io.ReadWriteCloser
+}
+
+// NewCompressedConn returns a connection that flate compresses everything written to conn, and decompresses everything read from r.
+// Each write is flushed, so that peers waiting for a complete message get it.
+func NewCompressedConn(r io.Reader, conn io.ReadWriteCloser) io.ReadWriteCloser {
+	writer, _ := flate.NewWriter(conn, flate.BestSpeed)
+	return &compressedConn{
+		reader: flate.NewReader(r),
+		writer: writer,
+		conn:   conn,
+	}
+}
+func (self *compressedConn) Read(b []byte) (int, error) {
+	return self.reader.Read(b)
+}
+func (self *compressedConn) Write(b []byte) (n int, err error) {
+	if n, err = self.writer.Write(b); err != nil {
+		return
+	}
+	err = self.writer.Flush()
+	return
+}
+func (self *compressedConn) Close() error {
+	self.reader.Close()
+	return self.conn.Close()
+}
+
+// AcceptConn will wait for the first byte from a newly accepted conn, and return a compressed connection if it is CompressionMagic.
+// Otherwise it returns a connection replaying the first byte before the rest of conn.
+func AcceptConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	reader := bufio.NewReader(conn)
+	if first, err := reader.Peek(1); err == nil && first[0] == CompressionMagic {
+		reader.ReadByte()
+		return NewCompressedConn(reader, conn)
+	}
+	return &readWriteCloser{reader, conn, conn}
+}
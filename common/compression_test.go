@@ -0,0 +1,40 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+type countingWriter struct {
+	io.Writer
+	count int
+}
+
+func (self *countingWriter) Write(b []byte) (n int, err error) {
+	n, err = self.Writer.Write(b)
+	self.count += n
+	return
+}
+
+func TestCompressedConn(t *testing.T) {
+	buf := new(bytes.Buffer)
+	counter := &countingWriter{Writer: buf}
+	conn := NewCompressedConn(buf, &readWriteCloser{buf, counter, nil})
+	payload := bytes.Repeat([]byte("key-"), 10000)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if counter.count >= len(payload)/10 {
+		t.Errorf("wanted %v bytes to compress well, got %v", len(payload), counter.count)
+	}
+	read := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, read); err != nil || !bytes.Equal(read, payload) {
+		t.Errorf("wanted the payload back, got %v bytes, %v", len(read), err)
+	}
+	accepted := AcceptConn(&readWriteCloser{bytes.NewBuffer([]byte{1, 2}), nil, nil})
+	if b, err := ioutil.ReadAll(accepted); err != nil || !bytes.Equal(b, []byte{1, 2}) {
+		t.Errorf("wanted an uncompressed conn to replay its first byte, got %v, %v", b, err)
+	}
+}
@@ -1,5 +1,32 @@
 package common
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrWatchChanged is the error of transactions that were not applied since a value they were watching had changed.
+var ErrWatchChanged = errors.New("A watched value has changed")
+
+// ErrSnapshotTooOld is the error of reads at a point in time the nodes no longer remember the values from.
+var ErrSnapshotTooOld = errors.New("The values from that time are no longer kept")
+
+// ErrWrongType is the error of operations on a kind of value, like pushing to a list, when the key holds another kind of value.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// The kinds of value a key can hold, as returned by Type.
+// Lists, sets, hashes and sorted sets are sub trees that remember their kind when written with the operations of that kind,
+// while sub trees only written with the plain sub tree functions are of kind TreeType.
+const (
+	NoneType   = "none"
+	StringType = "string"
+	ListType   = "list"
+	SetType    = "set"
+	HashType   = "hash"
+	ZSetType   = "zset"
+	TreeType   = "tree"
+)
+
 type Item struct {
 	Key       []byte
 	SubKey    []byte
@@ -10,3 +37,162 @@ type Item struct {
 	Index     int
 	Sync      bool
 }
+
+// Meta describes the byte value under Key without containing it.
+// Timestamp is the cluster time, in nanoseconds, of the last write of the value. It is what the value is replicated, synchronized
+// and logged with, and doubles as its version, since it changes every time the value is written. Size is the length of the value.
+type Meta struct {
+	Key       []byte
+	Exists    bool
+	Timestamp int64
+	Size      int
+}
+
+// Batch is a group of Items written to the same key in one operation.
+// When a Batch is a transaction, it is only applied if the values under the keys of the Watches still have the Timestamps of the Watches.
+type Batch struct {
+	Key       []byte
+	Items     []Item
+	Watches   []Item
+	TTL       int
+	Timestamp int64
+	Sync      bool
+}
+
+// Append is a value to append to the value under Key, if there is a value under Condition.
+type Append struct {
+	Condition []byte
+	Key       []byte
+	Value     []byte
+	Sync      bool
+}
+
+// Operation is a Get, Put, Del, SubGet, SubPut or SubDel, named by Method, of the value under Key, or under SubKey in the sub tree defined by Key.
+type Operation struct {
+	Method string
+	Key    []byte
+	SubKey []byte
+	Value  []byte
+}
+
+// Pipeline is a list of Operations for the owner of their keys to perform in order, in one call.
+type Pipeline struct {
+	Operations []Operation
+	Sync       bool
+}
+
+// Cursor asks for the next Len keys of a cursor opened by a dhash.Node.
+type Cursor struct {
+	ID  int64
+	Len int
+}
+
+// Expiry is a value to put under Key, that expires Lifetime after it was put.
+type Expiry struct {
+	Key      []byte
+	Value    []byte
+	Lifetime time.Duration
+	Sync     bool
+}
+
+// What TTL returns for values without an expiry, and for missing values.
+const (
+	NoTTL      time.Duration = -1
+	MissingTTL time.Duration = -2
+)
+
+// Rename is a value to move from under Key to under Dest, unless NX is set and there is a value under Dest already.
+type Rename struct {
+	Key  []byte
+	Dest []byte
+	NX   bool
+	Sync bool
+}
+
+// Copy is a value to copy from under Key to under Dest, replacing what is there, along with its expiry if KeepTTL is set.
+type Copy struct {
+	Key     []byte
+	Dest    []byte
+	KeepTTL bool
+	Sync    bool
+}
+
+// BlockingPop is a list to pop an element from, waiting up to Timeout for one to be pushed if the list is empty.
+// A Timeout of 0 waits until an element is pushed.
+type BlockingPop struct {
+	Key     []byte
+	Timeout time.Duration
+}
+
+// Restoration is a dump, as returned by DHash.DumpKey, to restore under Key, replacing what is already there if Replace is set.
+type Restoration struct {
+	Key     []byte
+	Dump    []byte
+	Replace bool
+	Sync    bool
+}
+
+// Notification is a value to put under Key, and a Message to publish to Channel once it is put.
+type Notification struct {
+	Key     []byte
+	Value   []byte
+	Channel string
+	Message []byte
+	Sync    bool
+}
+
+// Subscription is a set of Channels to subscribe to on a node, or the ID the node returned for such a set when polling for its messages.
+// If Pattern is not empty, only messages matching it as a path.Match pattern are delivered.
+type Subscription struct {
+	ID       int64
+	Channels []string
+	Pattern  string
+}
+
+// The types of Event.
+const (
+	PutEvent    = "put"
+	DelEvent    = "del"
+	ExpireEvent = "expire"
+)
+
+// KeyspaceChannel followed by the type of an Event is the channel the owner of a key publishes the key to, in that node only, when its byte value changes.
+const KeyspaceChannel = "__keyspace__:"
+
+// Event is a change of the byte value under Key, which was put, deleted or expired depending on Type.
+type Event struct {
+	Type string
+	Key  []byte
+}
+
+// Swap is a value to put under Key, if the value already there is Expected.
+type Swap struct {
+	Key      []byte
+	Expected []byte
+	Value    []byte
+	Sync     bool
+}
+
+// Replacement is a regular expression replacement to apply to the value under Key.
+type Replacement struct {
+	Key         []byte
+	Pattern     string
+	Replacement []byte
+	Sync        bool
+}
+
+// Bit is the bit at Offset in the value under Key, counted from the most significant bit of the first byte, to set if Value is true and clear otherwise.
+type Bit struct {
+	Key    []byte
+	Offset int64
+	Value  bool
+	Sync   bool
+}
+
+// Eval asks for the script registered as Script to be run with Args, by the node owning Key.
+type Eval struct {
+	Script string
+	Key    []byte
+	Args   [][]byte
+	Sync   bool
+}
@@ -1,5 +1,13 @@
 package common
 
+// ScanPage is a page of keys from a node, and where the scan continues after it.
+// Done means the keys after the page are all past the end of the key space.
+type ScanPage struct {
+	Keys [][]byte
+	Next []byte
+	Done bool
+}
+
 type Range struct {
 	Key      []byte
 	Min      []byte
@@ -9,4 +17,6 @@ type Range struct {
 	MinIndex int
 	MaxIndex int
 	Len      int
+	// At, if not 0, is the cluster time to read the values as they were at.
+	At int64
 }
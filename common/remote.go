@@ -4,8 +4,20 @@ import (
 	"bytes"
 	"fmt"
 	"net/rpc"
+	"strings"
 )
 
+const unixPrefix = "unix:"
+
+// SplitAddr returns the network and address to use when dialing or listening at addr.
+// Addresses prefixed with "unix:" are paths to Unix domain sockets, all other addresses are TCP addresses.
+func SplitAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", addr[len(unixPrefix):]
+	}
+	return "tcp", addr
+}
+
 type Remotes []Remote
 
 func (self Remotes) Equal(other []Remote) bool {
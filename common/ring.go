@@ -18,10 +18,18 @@ type RingChangeListener func(ring *Ring) (keep bool)
 // a defined orded even between nodes with the same position).
 type Ring struct {
 	nodes           Remotes
+	redundancy      RedundancySetting
 	lock            *sync.RWMutex
 	changeListeners []RingChangeListener
 }
 
+// RedundancySetting is the number of Nodes each value is stored on, and when, in nanoseconds, it was chosen,
+// so that the Nodes of a Ring can agree on the latest choice.
+type RedundancySetting struct {
+	Redundancy int
+	Set        int64
+}
+
 func NewRing() *Ring {
 	return &Ring{
 		lock: new(sync.RWMutex),
@@ -118,7 +126,9 @@ func (self *Ring) Nodes() Remotes {
 
 // Clone returns a copy of this Ring and its contents.
 func (self *Ring) Clone() *Ring {
-	return NewRingNodes(self.Nodes())
+	result := NewRingNodes(self.Nodes())
+	result.redundancy = self.RedundancySetting()
+	return result
 }
 func (self *Ring) Size() int {
 	self.lock.RLock()
@@ -182,14 +192,35 @@ func (self *Ring) Add(r Remote) {
 	self.sendChanges(oldHash)
 }
 
-// Redundancy returns the minimum of the number of nodes present and the Redundancy const.
+// Redundancy returns the minimum of the number of nodes present and the redundancy set with SetRedundancy, or the Redundancy var if none is.
 func (self *Ring) Redundancy() int {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
-	if len(self.nodes) < Redundancy {
+	redundancy := Redundancy
+	if self.redundancy.Redundancy > 0 {
+		redundancy = self.redundancy.Redundancy
+	}
+	if len(self.nodes) < redundancy {
 		return len(self.nodes)
 	}
-	return Redundancy
+	return redundancy
+}
+
+// SetRedundancy will make this Ring use setting, unless it uses one chosen later already, and return whether it did.
+func (self *Ring) SetRedundancy(setting RedundancySetting) (adopted bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if setting.Set > self.redundancy.Set {
+		self.redundancy, adopted = setting, true
+	}
+	return
+}
+
+// RedundancySetting returns the setting this Ring uses, which is empty if it uses the Redundancy var.
+func (self *Ring) RedundancySetting() RedundancySetting {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.redundancy
 }
 
 // Remotes returns the predecessor of pos, any Remote at pos and the successor of pos.
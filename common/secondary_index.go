@@ -0,0 +1,68 @@
+package common
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// IndexConfPrefix starts the configuration keys declaring indexes. The configuration value is the field the index extracts.
+	IndexConfPrefix = "index:"
+	indexMarker     = "\x00index"
+)
+
+// IndexKey returns the key of the sub tree containing the keys of all values with value in the field extracted by the index named name.
+// The sub tree is stored, and replicated, by the node owning the key like any other sub tree.
+func IndexKey(name string, value []byte) []byte {
+	result := make([]byte, len(indexMarker)+binary.MaxVarintLen64+len(name)+len(value))
+	copy(result, indexMarker)
+	n := len(indexMarker) + binary.PutUvarint(result[len(indexMarker):], uint64(len(name)))
+	n += copy(result[n:], name)
+	n += copy(result[n:], value)
+	return result[:n]
+}
+
+// IsIndexKey returns whether key is the key of an index sub tree.
+func IsIndexKey(key []byte) bool {
+	return strings.HasPrefix(string(key), indexMarker)
+}
+
+// Indexes returns the names of the indexes declared in conf, and the fields they extract.
+func Indexes(conf map[string]string) (result map[string]string) {
+	for key, field := range conf {
+		if strings.HasPrefix(key, IndexConfPrefix) && field != "" {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[key[len(IndexConfPrefix):]] = field
+		}
+	}
+	return
+}
+
+// ExtractField returns the field of the JSON object in value, where field is a dot separated path like "address.city".
+// Strings are returned as they are, and other values as JSON. ok is false if value is not a JSON object or the field is missing or null.
+func ExtractField(value []byte, field string) (result []byte, ok bool) {
+	var decoded interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return
+	}
+	for _, part := range strings.Split(field, ".") {
+		object, isObject := decoded.(map[string]interface{})
+		if !isObject {
+			return nil, false
+		}
+		if decoded, ok = object[part]; !ok {
+			return
+		}
+	}
+	switch v := decoded.(type) {
+	case nil:
+		return nil, false
+	case string:
+		return []byte(v), true
+	}
+	result, err := json.Marshal(decoded)
+	return result, err == nil
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestExtractField(t *testing.T) {
+	value := []byte(`{"name": "apple", "size": 3, "address": {"city": "Stockholm"}, "tags": ["a"], "none": null}`)
+	for field, expected := range map[string]string{
+		"name":         "apple",
+		"size":         "3",
+		"address.city": "Stockholm",
+		"tags":         `["a"]`,
+	} {
+		if found, ok := ExtractField(value, field); !ok || string(found) != expected {
+			t.Errorf("wanted %v => %v, got %s, %v", field, expected, found, ok)
+		}
+	}
+	for _, field := range []string{"none", "missing", "name.first", "address.street"} {
+		if found, ok := ExtractField(value, field); ok {
+			t.Errorf("wanted %v to be missing, got %s", field, found)
+		}
+	}
+	if found, ok := ExtractField([]byte("not json"), "name"); ok {
+		t.Errorf("wanted nothing from a value that is not JSON, got %s", found)
+	}
+}
+
+func TestIndexKey(t *testing.T) {
+	if !IsIndexKey(IndexKey("a", []byte("b"))) || IsIndexKey([]byte("a")) {
+		t.Errorf("wanted only index keys to be index keys")
+	}
+	if string(IndexKey("a", []byte("bc"))) == string(IndexKey("ab", []byte("c"))) {
+		t.Errorf("wanted index keys of different indexes to differ")
+	}
+}
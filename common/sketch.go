@@ -0,0 +1,76 @@
+package common
+
+import (
+	"fmt"
+	"github.com/zond/god/murmur"
+	"math"
+	"math/bits"
+)
+
+const sketchBits = 12
+
+// SketchSize is the number of one byte registers in a Sketch, giving it a standard error of about 1.04 / sqrt(SketchSize), or 1.6%.
+const SketchSize = 1 << sketchBits
+
+// Sketch is a HyperLogLog cardinality estimator, stored as its plain registers to be usable as a byte value.
+type Sketch []byte
+
+func NewSketch() Sketch {
+	return make(Sketch, SketchSize)
+}
+
+// DecodeSketch returns a copy of b as a Sketch, or an error if b is not the size of one.
+func DecodeSketch(b []byte) (result Sketch, err error) {
+	if len(b) != SketchSize {
+		err = fmt.Errorf("%v bytes is not a sketch of %v registers", len(b), SketchSize)
+		return
+	}
+	result = make(Sketch, SketchSize)
+	copy(result, b)
+	return
+}
+
+// Add will count element in this Sketch, and return whether that changed it.
+func (self Sketch) Add(element []byte) (changed bool) {
+	hash := murmur.HashBytes(element)
+	var h uint64
+	for _, b := range hash[:8] {
+		h = h<<8 | uint64(b)
+	}
+	index := h >> (64 - sketchBits)
+	rank := byte(bits.LeadingZeros64(h<<sketchBits|1<<(sketchBits-1)) + 1)
+	if rank > self[index] {
+		self[index] = rank
+		changed = true
+	}
+	return
+}
+
+// Merge will make this Sketch count the elements counted by other as well, and return whether that changed it.
+func (self Sketch) Merge(other Sketch) (changed bool) {
+	for index, register := range other {
+		if register > self[index] {
+			self[index] = register
+			changed = true
+		}
+	}
+	return
+}
+
+// Count returns the estimated number of distinct elements added to this Sketch.
+func (self Sketch) Count() int64 {
+	m := float64(len(self))
+	sum := 0.0
+	zeros := 0
+	for _, register := range self {
+		sum += math.Pow(2, -float64(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	sketch := NewSketch()
+	if count := sketch.Count(); count != 0 {
+		t.Errorf("wanted 0, got %v", count)
+	}
+	for _, n := range []int{100, 10000, 100000} {
+		sketch := NewSketch()
+		for i := 0; i < n; i++ {
+			sketch.Add([]byte(fmt.Sprint(i)))
+			sketch.Add([]byte(fmt.Sprint(i)))
+		}
+		if count := sketch.Count(); math.Abs(float64(count-int64(n))) > float64(n)*0.05 {
+			t.Errorf("wanted %v within 5%%, got %v", n, count)
+		}
+	}
+	if sketch.Add([]byte("a")); sketch.Add([]byte("a")) {
+		t.Errorf("wanted adding the same element twice to leave the sketch alone")
+	}
+	if _, err := DecodeSketch([]byte("a")); err == nil {
+		t.Errorf("wanted an error decoding a too short sketch")
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	s1, s2 := NewSketch(), NewSketch()
+	for i := 0; i < 10000; i++ {
+		s1.Add([]byte(fmt.Sprint(i)))
+		s2.Add([]byte(fmt.Sprint(i + 5000)))
+	}
+	if !s1.Merge(s2) {
+		t.Errorf("wanted merging overlapping sketches to change the first")
+	}
+	if count := s1.Count(); math.Abs(float64(count-15000)) > 15000*0.05 {
+		t.Errorf("wanted 15000 within 5%%, got %v", count)
+	}
+	if s1.Merge(s2) {
+		t.Errorf("wanted merging the same sketch twice to leave the sketch alone")
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"net"
 	"net/rpc"
 	"sync"
 )
@@ -10,19 +11,56 @@ var Switch = newSwitchboard()
 
 // Switchboard is a simple map of net/rpc.Clients, to avoid having to set up new connections for each remote call.
 type Switchboard struct {
-	lock    *sync.RWMutex
-	clients map[string]*rpc.Client
+	lock     *sync.RWMutex
+	clients  map[string]*rpc.Client
+	compress bool
 }
 
 func newSwitchboard() *Switchboard {
-	return &Switchboard{new(sync.RWMutex), make(map[string]*rpc.Client)}
+	return &Switchboard{new(sync.RWMutex), make(map[string]*rpc.Client), false}
+}
+
+// SetCompression will make this Switchboard compress the traffic of connections it sets up from now on, to Nodes whose Discord.Capabilities allow it.
+// Compression is off by default.
+func (self *Switchboard) SetCompression(compress bool) *Switchboard {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.compress = compress
+	return self
+}
+func (self *Switchboard) dial(addr string) (client *rpc.Client, err error) {
+	network, address := SplitAddr(addr)
+	if client, err = rpc.Dial(network, address); err != nil {
+		return
+	}
+	self.lock.RLock()
+	compress := self.compress
+	self.lock.RUnlock()
+	if !compress {
+		return
+	}
+	var capabilities Capabilities
+	if client.Call("Discord.Capabilities", 0, &capabilities) != nil || !capabilities.Compression {
+		return
+	}
+	var conn net.Conn
+	client.Close()
+	if conn, err = net.Dial(network, address); err != nil {
+		return
+	}
+	if _, err = conn.Write([]byte{CompressionMagic}); err != nil {
+		conn.Close()
+		return
+	}
+	client = rpc.NewClient(NewCompressedConn(conn, conn))
+	return
 }
 func (self *Switchboard) client(addr string) (client *rpc.Client, err error) {
 	self.lock.RLock()
 	client, ok := self.clients[addr]
 	self.lock.RUnlock()
 	if !ok {
-		if client, err = rpc.Dial("tcp", addr); err != nil {
+		if client, err = self.dial(addr); err != nil {
 			return
 		}
 		self.lock.Lock()
@@ -52,6 +90,9 @@ func (self *Switchboard) Call(addr, service string, args, reply interface{}) (er
 		return
 	}
 	if err = client.Call(service, args, reply); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			return
+		}
 		if err.Error() == "connection is shut down" {
 			self.lock.Lock()
 			delete(self.clients, addr)
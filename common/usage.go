@@ -0,0 +1,75 @@
+package common
+
+// UsageLargest is the number of largest values a Usage keeps track of.
+const UsageLargest = 10
+
+// KeySize is the size of the value under Key, or SubKey in the sub tree defined by Key.
+type KeySize struct {
+	Key    []byte
+	SubKey []byte
+	Size   int
+}
+
+// Usage describes how much of some data is keys and how much is values.
+//
+// ValueSizes[n] is the number of values with a size of at least 2^(n-1) and less than 2^n bytes, so ValueSizes[0] is the number of empty values.
+//
+// Largest contains the keys of the UsageLargest largest values, largest first.
+type Usage struct {
+	Values     int
+	KeyBytes   int64
+	ValueBytes int64
+	ValueSizes []int
+	Largest    []KeySize
+}
+
+// Add will add the sizes of key, subKey and value to this Usage.
+func (self *Usage) Add(key, subKey, value []byte) {
+	self.Values++
+	self.KeyBytes += int64(len(key) + len(subKey))
+	self.ValueBytes += int64(len(value))
+	bucket := 0
+	for size := len(value); size > 0; size >>= 1 {
+		bucket++
+	}
+	for len(self.ValueSizes) <= bucket {
+		self.ValueSizes = append(self.ValueSizes, 0)
+	}
+	self.ValueSizes[bucket]++
+	self.addLargest(KeySize{
+		Key:    key,
+		SubKey: subKey,
+		Size:   len(value),
+	})
+}
+func (self *Usage) addLargest(size KeySize) {
+	index := len(self.Largest)
+	for index > 0 && self.Largest[index-1].Size < size.Size {
+		index--
+	}
+	if index >= UsageLargest {
+		return
+	}
+	self.Largest = append(self.Largest, KeySize{})
+	copy(self.Largest[index+1:], self.Largest[index:])
+	self.Largest[index] = size
+	if len(self.Largest) > UsageLargest {
+		self.Largest = self.Largest[:UsageLargest]
+	}
+}
+
+// Merge will add the content of other to this Usage.
+func (self *Usage) Merge(other Usage) {
+	self.Values += other.Values
+	self.KeyBytes += other.KeyBytes
+	self.ValueBytes += other.ValueBytes
+	for len(self.ValueSizes) < len(other.ValueSizes) {
+		self.ValueSizes = append(self.ValueSizes, 0)
+	}
+	for bucket, count := range other.ValueSizes {
+		self.ValueSizes[bucket] += count
+	}
+	for _, size := range other.Largest {
+		self.addLargest(size)
+	}
+}
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	var u1, u2 Usage
+	u1.Add([]byte("a"), nil, nil)
+	u1.Add([]byte("b"), nil, []byte("123"))
+	u2.Add([]byte("c"), []byte("d"), []byte("1234"))
+	u1.Merge(u2)
+	if u1.Values != 3 || u1.KeyBytes != 4 || u1.ValueBytes != 7 {
+		t.Errorf("wanted 3 values, 4 key bytes and 7 value bytes, got %+v", u1)
+	}
+	if !reflect.DeepEqual(u1.ValueSizes, []int{1, 0, 1, 1}) {
+		t.Errorf("wanted value sizes %v, got %v", []int{1, 0, 1, 1}, u1.ValueSizes)
+	}
+	if len(u1.Largest) != 3 || string(u1.Largest[0].SubKey) != "d" || u1.Largest[2].Size != 0 {
+		t.Errorf("wanted c/d to be the largest and a the smallest, got %+v", u1.Largest)
+	}
+	for i := 0; i < UsageLargest*2; i++ {
+		u1.Add([]byte("e"), nil, make([]byte, i))
+	}
+	if len(u1.Largest) != UsageLargest || u1.Largest[0].Size != UsageLargest*2-1 {
+		t.Errorf("wanted the %v largest values, got %+v", UsageLargest, u1.Largest)
+	}
+}
@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"github.com/zond/god/client"
 	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
 	"github.com/zond/setop"
+	"math/rand"
+	"regexp"
 	"sync/atomic"
 	"time"
 )
 
 // Description will return a current description of the node.
 func (self *Node) Description() common.DHashDescription {
+	logStats := self.tree.LogStats()
 	return common.DHashDescription{
 		Addr:         self.GetBroadcastAddr(),
 		Pos:          self.node.GetPosition(),
@@ -22,6 +26,8 @@ func (self *Node) Description() common.DHashDescription {
 		OwnedEntries: self.Owned(),
 		HeldEntries:  self.tree.RealSize(),
 		Load:         self.tree.Load(),
+		Degraded:     logStats.Degraded,
+		NonDurable:   logStats.NonDurable,
 		Nodes:        self.node.GetNodes(),
 	}
 }
@@ -41,6 +47,13 @@ func (self *Node) client() *client.Conn {
 func (self *Node) Get(data common.Item, result *common.Item) error {
 	*result = data
 	result.Value, result.Timestamp, result.Exists = self.tree.Get(data.Key)
+	if result.Exists {
+		self.touch(data.Key)
+	}
+	if result.Exists && self.expired(data.Key, result.Timestamp) {
+		result.Value, result.Exists = nil, false
+	}
+	self.countGet(result.Exists)
 	return nil
 }
 func (self *Node) Prev(data common.Item, result *common.Item) error {
@@ -53,6 +66,58 @@ func (self *Node) Next(data common.Item, result *common.Item) error {
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.Next(data.Key)
 	return nil
 }
+
+// GetMeta will return the timestamp and size of the byte value under data.Key in this node, without the value.
+// Like Get, it treats expired values as missing.
+func (self *Node) GetMeta(data common.Item, meta *common.Meta) error {
+	value, timestamp, existed := self.tree.Get(data.Key)
+	*meta = common.Meta{Key: data.Key}
+	if existed && !self.expired(data.Key, timestamp) {
+		meta.Exists, meta.Timestamp, meta.Size = true, timestamp, len(value)
+	}
+	return nil
+}
+
+// MExists will return whether each of the keys of data.Items has a byte value in this node, leaving out expired values like Get.
+func (self *Node) MExists(data common.Batch, present *[]bool) error {
+	*present = make([]bool, len(data.Items))
+	for index, item := range data.Items {
+		_, timestamp, existed := self.tree.Get(item.Key)
+		(*present)[index] = existed && !self.expired(item.Key, timestamp)
+	}
+	return nil
+}
+
+// MPut will put the values of data.Items under their keys in one logged operation, and return the values they replaced.
+func (self *Node) MPut(data common.Batch, old *[]common.Item) error {
+	for _, item := range data.Items {
+		if err := self.full(item.Key); err != nil {
+			return err
+		}
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	*old = self.tree.PutAll(data.Items, data.Timestamp)
+	for _, item := range data.Items {
+		self.notify(common.PutEvent, item.Key)
+	}
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardBatch(data, "DHash.SlavePutAll")
+		} else {
+			go self.forwardBatch(data, "DHash.SlavePutAll")
+		}
+	}
+	return nil
+}
+
+// MGet will return the byte values of the keys of data.Items in this node.
+func (self *Node) MGet(data common.Batch, items *[]common.Item) error {
+	*items = make([]common.Item, len(data.Items))
+	for index, item := range data.Items {
+		self.Get(item, &(*items)[index])
+	}
+	return nil
+}
 func (self *Node) RingHash(x int, ringHash *[]byte) error {
 	*ringHash = self.node.RingHash()
 	return nil
@@ -300,6 +365,10 @@ func (self *Node) IndexOf(data common.Item, result *common.Index) error {
 func (self *Node) SubGet(data common.Item, result *common.Item) error {
 	*result = data
 	result.Value, result.Timestamp, result.Exists = self.tree.SubGet(data.Key, data.SubKey)
+	if result.Exists {
+		self.touch(data.Key)
+	}
+	self.countGet(result.Exists)
 	return nil
 }
 func (self *Node) SubClear(data common.Item) error {
@@ -311,16 +380,678 @@ func (self *Node) SubDel(data common.Item) error {
 	return self.subDel(data)
 }
 func (self *Node) SubPut(data common.Item) error {
+	if err := self.full(data.Key); err != nil {
+		return err
+	}
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
 	return self.subPut(data)
 }
+
+// ZAdd will put data.Value, a common.EncodeScore encoded score, followed by data.SubKey under data.SubKey in the sorted set defined by data.Key.
+// A sorted set is a sub tree mirrored by score, which is turned on if it isn't already. The sub key is appended to the score to keep
+// the values of the mirror tree unique, since members with the same score would otherwise be merged when read from several replicas.
+func (self *Node) ZAdd(data common.Item) (err error) {
+	if _, err = common.DecodeScore(data.Value); err != nil {
+		return
+	}
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	if err = self.checkType(data.Key, common.ZSetType, true); err != nil {
+		return
+	}
+	data.Value = append(data.Value, data.SubKey...)
+	if conf, _ := self.tree.SubConfiguration(data.Key); conf[mirroredConf] != "yes" {
+		self.SubAddConfiguration(common.ConfItem{
+			TreeKey: data.Key,
+			Key:     mirroredConf,
+			Value:   "yes",
+		})
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	return self.subPut(data)
+}
+func (self *Node) SubPutChanged(data common.Batch, changed *int) error {
+	if err := self.full(data.Key); err != nil {
+		return err
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	return self.subPutChanged(data, changed)
+}
 func (self *Node) Del(data common.Item) error {
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.del(data)
+	_, _, existed := self.tree.Get(data.Key)
+	if err := self.del(data); err != nil {
+		return err
+	}
+	if existed {
+		self.notify(common.DelEvent, data.Key)
+	}
+	return nil
+}
+
+// ScanPrefix will return all values this node owns under keys starting with data.Key, in key order.
+func (self *Node) ScanPrefix(data common.Item, items *[]common.Item) error {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	self.tree.EachBetween(data.Key, nil, true, false, func(key, value []byte, timestamp int64) bool {
+		if !bytes.HasPrefix(key, data.Key) {
+			return false
+		}
+		if common.BetweenIE(key, pred.Pos, me.Pos) && !self.expired(key, timestamp) {
+			*items = append(*items, common.Item{
+				Key:       key,
+				Value:     value,
+				Timestamp: timestamp,
+			})
+		}
+		return true
+	})
+	return nil
 }
-func (self *Node) Put(data common.Item) error {
+
+// DrainPrefix will delete all values this node owns under keys starting with data.Key, and return them.
+// The values are deleted in one logged operation, so concurrent drains will never return the same value.
+func (self *Node) DrainPrefix(data common.Item, items *[]common.Item) error {
+	var keys [][]byte
+	me := self.node.GetBroadcastAddr()
+	self.tree.EachBetween(data.Key, nil, true, false, func(key, value []byte, timestamp int64) bool {
+		if !bytes.HasPrefix(key, data.Key) {
+			return false
+		}
+		if self.node.GetSuccessorFor(key).Addr == me {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	batch := common.Batch{
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+		Sync:      data.Sync,
+	}
+	*items = self.tree.FakeDelAll(keys, batch.Timestamp)
+	for _, item := range *items {
+		self.notify(common.DelEvent, item.Key)
+	}
+	if len(*items) > 0 && batch.TTL > 1 {
+		for _, item := range *items {
+			batch.Items = append(batch.Items, common.Item{
+				Key: item.Key,
+			})
+		}
+		if batch.Sync {
+			self.forwardBatch(batch, "DHash.SlaveDelAll")
+		} else {
+			go self.forwardBatch(batch, "DHash.SlaveDelAll")
+		}
+	}
+	return nil
+}
+
+// Put will put data.Value under data.Key, and return whether this node managed to log it to disk.
+func (self *Node) Put(data common.Item) (durable bool, err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.put(data)
+	if durable, err = self.put(data); err != nil {
+		return
+	}
+	self.notify(common.PutEvent, data.Key)
+	return
+}
+
+// PutExpire will put e.Value under e.Key, and make it expire after e.Lifetime.
+// The deadline is kept in the sub configuration of e.Key, so it is logged and replicated like other configuration. Expired values are missing to Get,
+// and get deleted by their owner, unless they were written again after the deadline.
+func (self *Node) PutExpire(e common.Expiry, durable *bool) (err error) {
+	if e.Lifetime <= 0 {
+		return fmt.Errorf("Can't expire values after %v", e.Lifetime)
+	}
+	deadline := self.timer.ContinuousTime() + int64(e.Lifetime)
+	if *durable, err = self.Put(common.Item{Key: e.Key, Value: e.Value, Sync: e.Sync}); err != nil {
+		return
+	}
+	self.SubAddConfiguration(common.ConfItem{
+		TreeKey: e.Key,
+		Key:     expiresConf,
+		Value:   fmt.Sprint(deadline),
+	})
+	self.touch(e.Key)
+	return
+}
+
+// TTL will return what is left of the lifetime given to PutExpire for the value under data.Key, common.NoTTL if it has none, or common.MissingTTL if there is no value there.
+// The value is not used, so it doesn't count as used to the EvictionPolicy.
+func (self *Node) TTL(data common.Item, ttl *time.Duration) error {
+	_, timestamp, existed := self.tree.Get(data.Key)
+	if !existed || self.expired(data.Key, timestamp) {
+		*ttl = common.MissingTTL
+	} else if deadline, ok := self.expiry(data.Key); !ok || timestamp >= deadline {
+		*ttl = common.NoTTL
+	} else {
+		*ttl = time.Duration(deadline - self.timer.ContinuousTime())
+	}
+	return nil
+}
+
+// Persist will remove the expiry given to the value under data.Key by PutExpire, unless it has expired already, and return whether it had one.
+// The removal is logged and replicated like other configuration.
+func (self *Node) Persist(data common.Item, persisted *bool) error {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	*persisted = false
+	_, timestamp, existed := self.tree.Get(data.Key)
+	if !existed || self.expired(data.Key, timestamp) {
+		return nil
+	}
+	if deadline, ok := self.expiry(data.Key); ok && timestamp < deadline {
+		self.SubAddConfiguration(common.ConfItem{
+			TreeKey: data.Key,
+			Key:     expiresConf,
+		})
+		*persisted = true
+	}
+	return nil
+}
+
+// PutNotify will put n.Value under n.Key, and then publish n.Message to the ChannelListeners of n.Channel in all nodes.
+// Only the put is logged and replicated, the message only reaches listeners present when it is published.
+func (self *Node) PutNotify(n common.Notification, durable *bool) (err error) {
+	if *durable, err = self.Put(common.Item{Key: n.Key, Value: n.Value, Sync: n.Sync}); err != nil {
+		return
+	}
+	var reached int
+	return self.PublishAll(common.Notification{
+		Channel: n.Channel,
+		Message: n.Message,
+		Sync:    n.Sync,
+	}, &reached)
+}
+
+// PublishAll will send n.Message to the ChannelListeners, and subscribers, of n.Channel in all nodes.
+// If n.Sync is set it waits for every node to deliver the message, and returns the number of listeners it reached.
+func (self *Node) PublishAll(n common.Notification, reached *int) error {
+	published := common.Notification{
+		Channel: n.Channel,
+		Message: n.Message,
+	}
+	*reached = 0
+	for _, remote := range self.node.Nodes() {
+		var x int
+		if remote.Addr == self.node.GetBroadcastAddr() {
+			self.Publish(published, &x)
+		} else if n.Sync {
+			remote.Call("DHash.Publish", published, &x)
+		} else {
+			remote.Go("DHash.Publish", published, new(int))
+		}
+		*reached += x
+	}
+	return nil
+}
+
+// Publish will send n.Message to the ChannelListeners of n.Channel in this node, and return the number of listeners it reached.
+func (self *Node) Publish(n common.Notification, reached *int) error {
+	*reached = self.triggerChannelListeners(n.Channel, n.Message)
+	return nil
+}
+
+// NextID will increment the counter at data.Key by the common.EncodeInt64 encoded data.Value, or 1 if data.Value is nil, and return the new value.
+// The replicas are sent the resulting counter rather than the increment.
+func (self *Node) NextID(data common.Item, result *int64) (err error) {
+	count := int64(1)
+	if data.Value != nil {
+		if count, err = common.DecodeInt64(data.Value); err != nil {
+			return
+		}
+	}
+	if count < 1 {
+		return fmt.Errorf("Can't allocate %v ids", count)
+	}
+	return self.addInt64(data, count, result)
+}
+
+// AddInt64 will add the common.EncodeInt64 encoded data.Value, positive or negative, to the counter at data.Key, and return the new value.
+// Like NextID, a missing counter starts at 0, and the replicas are sent the resulting counter rather than the delta.
+func (self *Node) AddInt64(data common.Item, result *int64) (err error) {
+	var delta int64
+	if delta, err = common.DecodeInt64(data.Value); err != nil {
+		return
+	}
+	return self.addInt64(data, delta, result)
+}
+func (self *Node) addInt64(data common.Item, delta int64, result *int64) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	if *result, err = self.tree.AddInt64Live(data.Key, delta, data.Timestamp); err != nil {
+		return
+	}
+	data.Value = common.EncodeInt64(*result)
+	self.notify(common.PutEvent, data.Key)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// SubAddInt64 will add the common.EncodeInt64 encoded data.Value, positive or negative, to the counter under data.SubKey in the sub tree
+// defined by data.Key, and return the new value. Like AddInt64, a missing counter starts at 0, and the replicas are sent the resulting counter.
+func (self *Node) SubAddInt64(data common.Item, result *int64) error {
+	return self.subAddInt64(data, "", result)
+}
+
+// subAddInt64 will add to the counter like SubAddInt64, but fail with common.ErrWrongType unless the sub tree is of kind typ, if typ is not empty.
+func (self *Node) subAddInt64(data common.Item, typ string, result *int64) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	var delta int64
+	if delta, err = common.DecodeInt64(data.Value); err != nil {
+		return
+	}
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	if typ != "" {
+		if err = self.checkType(data.Key, typ, true); err != nil {
+			return
+		}
+	}
+	*result = 0
+	if old, _, existed := self.tree.SubGet(data.Key, data.SubKey); existed {
+		if *result, err = common.DecodeInt64(old); err != nil {
+			return
+		}
+	}
+	*result += delta
+	data.Value = common.EncodeInt64(*result)
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	return self.subPut(data)
+}
+
+// DelOlderThan will delete the value under data.Key if it was last written before the common.EncodeInt64 encoded timestamp in data.Value, and return whether it did.
+// The replicas are only sent actual deletions.
+func (self *Node) DelOlderThan(data common.Item, deleted *bool) (err error) {
+	var than int64
+	if than, err = common.DecodeInt64(data.Value); err != nil {
+		return
+	}
+	data.Value, data.TTL, data.Timestamp = nil, self.node.Redundancy(), self.timer.ContinuousTime()
+	if _, *deleted = self.tree.FakeDelOlderThan(data.Key, than, data.Timestamp); *deleted {
+		self.notify(common.DelEvent, data.Key)
+	}
+	if *deleted && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlaveDel")
+		} else {
+			go self.forwardOperation(data, "DHash.SlaveDel")
+		}
+	}
+	return
+}
+
+// Repair will make this node, a replica of data.Key, catch up with a more recent version of the byte value under it, found by a reader among the other replicas.
+// If data.Exists, data.Value is put with data.Timestamp, otherwise the value is deleted, but only if this node has nothing written at data.Timestamp or later.
+// Nothing is forwarded, since the reader repairs each stale replica itself.
+func (self *Node) Repair(data common.Item, repaired *bool) error {
+	if data.Exists {
+		if *repaired = self.tree.PutNewer(data.Key, data.Value, data.Timestamp); *repaired {
+			self.touch(data.Key)
+		}
+	} else {
+		if _, *repaired = self.tree.FakeDelOlderThan(data.Key, data.Timestamp, data.Timestamp); *repaired {
+			self.untouch(data.Key)
+		}
+	}
+	return nil
+}
+
+// PFAdd will count data.Value in the common.Sketch under data.Key, creating it if missing or expired, and return whether that changed the sketch.
+// The replicas, and the log, get the whole updated sketch.
+func (self *Node) PFAdd(data common.Item, changed *bool) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	element := data.Value
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	if _, *changed, err = self.tree.ModifyLive(data.Key, data.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		sketch := common.NewSketch()
+		if existed {
+			var err error
+			if sketch, err = common.DecodeSketch(oldValue); err != nil {
+				return nil, false, err
+			}
+		}
+		data.Value = sketch
+		return data.Value, sketch.Add(element) || !existed, nil
+	}); err != nil {
+		return
+	}
+	if *changed {
+		self.notify(common.PutEvent, data.Key)
+	}
+	if *changed && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// PFCount will return the estimated number of distinct elements counted in the common.Sketch under data.Key, or 0 if it is missing or expired.
+func (self *Node) PFCount(data common.Item, count *int64) (err error) {
+	value, timestamp, existed := self.tree.Get(data.Key)
+	if !existed || self.expired(data.Key, timestamp) {
+		*count = 0
+		return
+	}
+	var sketch common.Sketch
+	if sketch, err = common.DecodeSketch(value); err != nil {
+		return
+	}
+	*count = sketch.Count()
+	return
+}
+
+// PFMerge will merge the common.Sketches under the keys of data.Items into the common.Sketch under data.Key, creating it if missing,
+// and return the estimated number of distinct elements counted in the result. Missing or expired sketches are treated as empty sketches.
+// Sources owned by other nodes are fetched from them, so only the merge itself is atomic. The replicas, and the log, get the whole merged sketch.
+func (self *Node) PFMerge(data common.Batch, count *int64) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	merged := common.NewSketch()
+	for _, source := range data.Items {
+		var item common.Item
+		if successor := self.node.GetSuccessorFor(source.Key); successor.Addr == self.node.GetBroadcastAddr() {
+			item.Value, item.Timestamp, item.Exists = self.tree.Get(source.Key)
+			item.Exists = item.Exists && !self.expired(source.Key, item.Timestamp)
+		} else if err = successor.Call("DHash.Get", common.Item{Key: source.Key}, &item); err != nil {
+			return
+		}
+		if item.Exists {
+			var sketch common.Sketch
+			if sketch, err = common.DecodeSketch(item.Value); err != nil {
+				return
+			}
+			merged.Merge(sketch)
+		}
+	}
+	item := common.Item{
+		Key:       data.Key,
+		Sync:      data.Sync,
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+	}
+	var changed bool
+	if _, changed, err = self.tree.ModifyLive(item.Key, item.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		sketch := common.NewSketch()
+		if existed {
+			var err error
+			if sketch, err = common.DecodeSketch(oldValue); err != nil {
+				return nil, false, err
+			}
+		}
+		item.Value = sketch
+		put := sketch.Merge(merged) || !existed
+		*count = sketch.Count()
+		return item.Value, put, nil
+	}); err != nil {
+		return
+	}
+	if changed {
+		self.notify(common.PutEvent, item.Key)
+	}
+	if changed && item.TTL > 1 {
+		if item.Sync {
+			self.forwardOperation(item, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(item, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// GetPut will put data.Value under data.Key, and return the value it replaced. An expired value is returned as missing, like Get does.
+func (self *Node) GetPut(data common.Item, old *common.Item) error {
+	if err := self.full(data.Key); err != nil {
+		return err
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	*old = common.Item{Key: data.Key}
+	if _, _, err := self.tree.ModifyLive(data.Key, data.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		old.Value, old.Exists = oldValue, existed
+		return data.Value, true, nil
+	}); err != nil {
+		return err
+	}
+	self.notify(common.PutEvent, data.Key)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return nil
+}
+
+// GetDel will delete the value under data.Key, and return the value it deleted in old, atomically and as a single logged operation.
+// An expired value is deleted, but returned as missing, like Get does.
+func (self *Node) GetDel(data common.Item, old *common.Item) error {
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	*old = common.Item{Key: data.Key}
+	old.Value, old.Exists = self.tree.FakeDelLive(data.Key, data.Timestamp)
+	self.untouch(data.Key)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlaveDel")
+		} else {
+			go self.forwardOperation(data, "DHash.SlaveDel")
+		}
+	}
+	if old.Exists {
+		self.notify(common.DelEvent, data.Key)
+	}
+	return nil
+}
+
+// PutIfMissing will put data.Value under data.Key if there is no value there, checked atomically with the put, and return whether it did.
+// Expired values are treated as missing, like Get does.
+func (self *Node) PutIfMissing(data common.Item, put *bool) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	if _, *put, err = self.tree.ModifyLive(data.Key, data.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		return data.Value, !existed, nil
+	}); err != nil {
+		return
+	}
+	if *put {
+		self.notify(common.PutEvent, data.Key)
+	}
+	if *put && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// CompareAndSwap will put s.Value under s.Key if there is a value there equal to s.Expected, checked atomically with the put, and return whether it did.
+// Expired values are treated as missing, like Get does.
+func (self *Node) CompareAndSwap(s common.Swap, swapped *bool) (err error) {
+	if err = self.full(s.Key); err != nil {
+		return
+	}
+	data := common.Item{
+		Key:       s.Key,
+		Value:     s.Value,
+		Sync:      s.Sync,
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+	}
+	if _, *swapped, err = self.tree.ModifyLive(data.Key, data.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		return data.Value, existed && bytes.Equal(oldValue, s.Expected), nil
+	}); err != nil {
+		return
+	}
+	if *swapped {
+		self.notify(common.PutEvent, data.Key)
+	}
+	if *swapped && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// CompareAndDelete will delete the value under data.Key if it is equal to data.Value, checked atomically with the delete, and return whether it did.
+// Expired values are treated as missing. This lets the holder of a lock, put with a value only it knows, release it without releasing a lock taken by someone else since.
+func (self *Node) CompareAndDelete(data common.Item, deleted *bool) error {
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	if *deleted = self.tree.FakeDelLiveIfEqual(data.Key, data.Value, data.Timestamp); !*deleted {
+		return nil
+	}
+	data.Value = nil
+	self.untouch(data.Key)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlaveDel")
+		} else {
+			go self.forwardOperation(data, "DHash.SlaveDel")
+		}
+	}
+	self.notify(common.DelEvent, data.Key)
+	return nil
+}
+
+// Replace will replace all matches of r.Pattern in the value under r.Key with r.Replacement, and return the new value.
+// Missing values, and values without matches, are left alone. The replicas are only sent values that changed.
+func (self *Node) Replace(r common.Replacement, result *common.Item) (err error) {
+	if err = self.full(r.Key); err != nil {
+		return
+	}
+	var pattern *regexp.Regexp
+	if pattern, err = regexp.Compile(r.Pattern); err != nil {
+		return
+	}
+	data := common.Item{
+		Key:       r.Key,
+		Sync:      r.Sync,
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+	}
+	var put bool
+	if _, put, err = self.tree.Modify(data.Key, data.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		data.Exists = existed
+		if !existed {
+			return nil, false, nil
+		}
+		data.Value = pattern.ReplaceAll(oldValue, r.Replacement)
+		return data.Value, !bytes.Equal(data.Value, oldValue), nil
+	}); err != nil {
+		return
+	}
+	*result = data
+	if put {
+		self.notify(common.PutEvent, data.Key)
+	}
+	if put && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// appendTo returns a radix.Modifier appending suffix to the old value, and keeping the result in data.Value.
+func appendTo(data *common.Item, suffix []byte) radix.Modifier {
+	return func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		data.Value = append(append(make([]byte, 0, len(oldValue)+len(suffix)), oldValue...), suffix...)
+		return data.Value, true, nil
+	}
+}
+
+// Append will append data.Value to the value under data.Key, or put it there if there is none or it has expired, and return the length of the result.
+func (self *Node) Append(data common.Item, length *int) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	suffix := data.Value
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	if _, _, err = self.tree.ModifyLive(data.Key, data.Timestamp, appendTo(&data, suffix)); err != nil {
+		return
+	}
+	*length = len(data.Value)
+	self.notify(common.PutEvent, data.Key)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// AppendIf will append a.Value to the value under a.Key if there is a value under a.Condition, and return whether it did.
+// Expired values are treated as missing, both under a.Condition and under a.Key, like Append does.
+// If a.Condition is owned by this node the check is atomic with the append, otherwise the owner of a.Condition is asked before appending.
+func (self *Node) AppendIf(a common.Append, appended *bool) (err error) {
+	if err = self.full(a.Key); err != nil {
+		return
+	}
+	data := common.Item{
+		Key:       a.Key,
+		Sync:      a.Sync,
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+	}
+	appender := appendTo(&data, a.Value)
+	if successor := self.node.GetSuccessorFor(a.Condition); successor.Addr == self.node.GetBroadcastAddr() {
+		_, *appended, err = self.tree.ModifyLiveIf(a.Condition, data.Key, data.Timestamp, appender)
+	} else {
+		var condition common.Item
+		if err = successor.Call("DHash.Get", common.Item{Key: a.Condition}, &condition); err != nil {
+			return
+		}
+		if condition.Exists {
+			_, *appended, err = self.tree.ModifyLive(data.Key, data.Timestamp, appender)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if *appended {
+		self.notify(common.PutEvent, data.Key)
+	}
+	if *appended && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
 }
 func (self *Node) forwardOperation(data common.Item, operation string) {
 	data.TTL--
@@ -337,6 +1068,31 @@ func (self *Node) forwardOperation(data common.Item, operation string) {
 	}
 	err := successor.Call(operation, data, &x)
 	for err != nil {
+		hinted := data
+		hinted.TTL = 1
+		self.addHint(successor.Addr, operation, hinted)
+		self.node.RemoveNode(successor)
+		successor = self.node.GetSuccessor()
+		err = successor.Call(operation, data, &x)
+	}
+}
+func (self *Node) forwardBatch(data common.Batch, operation string) {
+	data.TTL--
+	successor := self.node.GetSuccessor()
+	var x int
+	if self.hasCommListeners() {
+		self.triggerCommListeners(Comm{
+			Key:         data.Key,
+			Source:      self.node.Remote(),
+			Destination: successor,
+			Type:        operation,
+		})
+	}
+	err := successor.Call(operation, data, &x)
+	for err != nil {
+		hinted := data
+		hinted.TTL = 1
+		self.addHint(successor.Addr, operation, hinted)
 		self.node.RemoveNode(successor)
 		successor = self.node.GetSuccessor()
 		err = successor.Call(operation, data, &x)
@@ -356,6 +1112,48 @@ func (self *Node) subClear(data common.Item) error {
 	self.tree.SubClear(data.Key, data.Timestamp)
 	return nil
 }
+func (self *Node) subReplace(data common.Batch, size *int) error {
+	subKeys := make([][]byte, len(data.Items))
+	values := make([][]byte, len(data.Items))
+	for index, item := range data.Items {
+		subKeys[index], values[index] = item.SubKey, item.Value
+	}
+	*size = self.tree.SubReplace(data.Key, subKeys, values, data.Timestamp)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardBatch(data, "DHash.SlaveSubReplace")
+		} else {
+			go self.forwardBatch(data, "DHash.SlaveSubReplace")
+		}
+	}
+	return nil
+}
+func (self *Node) putAll(data common.Batch) error {
+	self.tree.PutAll(data.Items, data.Timestamp)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardBatch(data, "DHash.SlavePutAll")
+		} else {
+			go self.forwardBatch(data, "DHash.SlavePutAll")
+		}
+	}
+	return nil
+}
+func (self *Node) delAll(data common.Batch) error {
+	keys := make([][]byte, len(data.Items))
+	for index, item := range data.Items {
+		keys[index] = item.Key
+	}
+	self.tree.FakeDelAll(keys, data.Timestamp)
+	if data.TTL > 1 {
+		if data.Sync {
+			self.forwardBatch(data, "DHash.SlaveDelAll")
+		} else {
+			go self.forwardBatch(data, "DHash.SlaveDelAll")
+		}
+	}
+	return nil
+}
 func (self *Node) subDel(data common.Item) error {
 	if data.TTL > 1 {
 		if data.Sync {
@@ -376,6 +1174,24 @@ func (self *Node) subPut(data common.Item) error {
 		}
 	}
 	self.tree.SubPut(data.Key, data.SubKey, data.Value, data.Timestamp)
+	self.touch(data.Key)
+	self.evict()
+	return nil
+}
+func (self *Node) subPutChanged(data common.Batch, changed *int) error {
+	subKeys := make([][]byte, len(data.Items))
+	values := make([][]byte, len(data.Items))
+	for index, item := range data.Items {
+		subKeys[index], values[index] = item.SubKey, item.Value
+	}
+	*changed = self.tree.SubPutChanged(data.Key, subKeys, values, data.Timestamp)
+	if *changed > 0 && data.TTL > 1 {
+		if data.Sync {
+			self.forwardBatch(data, "DHash.SlaveSubPutChanged")
+		} else {
+			go self.forwardBatch(data, "DHash.SlaveSubPutChanged")
+		}
+	}
 	return nil
 }
 func (self *Node) del(data common.Item) error {
@@ -387,9 +1203,10 @@ func (self *Node) del(data common.Item) error {
 		}
 	}
 	self.tree.FakeDel(data.Key, data.Timestamp)
+	self.untouch(data.Key)
 	return nil
 }
-func (self *Node) put(data common.Item) error {
+func (self *Node) put(data common.Item) (durable bool, err error) {
 	if data.TTL > 1 {
 		if data.Sync {
 			self.forwardOperation(data, "DHash.SlavePut")
@@ -397,8 +1214,10 @@ func (self *Node) put(data common.Item) error {
 			go self.forwardOperation(data, "DHash.SlavePut")
 		}
 	}
-	self.tree.Put(data.Key, data.Value, data.Timestamp)
-	return nil
+	_, _, durable = self.tree.Put(data.Key, data.Value, data.Timestamp)
+	self.touch(data.Key)
+	self.evict()
+	return
 }
 func (self *Node) Size() int {
 	pred := self.node.GetPredecessor()
@@ -414,20 +1233,101 @@ func (self *Node) Size() int {
 	}
 	return self.tree.Size()
 }
+
+// KeySize returns the number of keys with a byte value or a non empty sub tree that this node owns, using the counts the tree keeps instead of iterating.
+func (self *Node) KeySize() int {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	cmp := bytes.Compare(pred.Pos, me.Pos)
+	if cmp < 0 {
+		return self.tree.KeySizeBetween(pred.Pos, me.Pos, true, false)
+	} else if cmp > 0 {
+		return self.tree.KeySizeBetween(pred.Pos, nil, true, false) + self.tree.KeySizeBetween(nil, me.Pos, true, false)
+	}
+	if pred.Less(me) {
+		return 0
+	}
+	return self.tree.KeySize()
+}
+
+// RandomKey will return a random key, with a byte value or a non empty sub tree, that this node owns, or nothing if it owns no keys.
+func (self *Node) RandomKey(x int, result *common.Item) error {
+	*result = common.Item{}
+	owned := self.KeySize()
+	if owned == 0 {
+		return nil
+	}
+	// The owned keys start at the position of the predecessor, and wrap around to the start of the key space if that is after this node.
+	total := self.tree.KeySize()
+	if total == 0 {
+		return nil
+	}
+	start := self.tree.KeySizeBetween(nil, self.node.GetPredecessor().Pos, true, false)
+	result.Key, result.Exists = self.tree.KeyIndex((start + rand.Intn(owned)) % total)
+	return nil
+}
+
+// MinKey returns the lexically first key, and its value, that this node owns.
+func (self *Node) MinKey() common.Item {
+	return self.ownedEdge(false)
+}
+
+// MaxKey returns the lexically last key, and its value, that this node owns.
+func (self *Node) MaxKey() common.Item {
+	return self.ownedEdge(true)
+}
+func (self *Node) ownedEdge(last bool) (result common.Item) {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	ranges := [][2][]byte{{pred.Pos, me.Pos}}
+	if cmp := bytes.Compare(pred.Pos, me.Pos); cmp > 0 {
+		ranges = [][2][]byte{{nil, me.Pos}, {pred.Pos, nil}}
+	} else if cmp == 0 {
+		if pred.Less(me) {
+			return
+		}
+		ranges = [][2][]byte{{nil, nil}}
+	}
+	if last {
+		for i := len(ranges) - 1; i >= 0 && !result.Exists; i-- {
+			result.Key, result.Value, result.Timestamp, result.Exists = self.tree.LastBetween(ranges[i][0], ranges[i][1], true, false)
+		}
+	} else {
+		for i := 0; i < len(ranges) && !result.Exists; i++ {
+			result.Key, result.Value, result.Timestamp, result.Exists = self.tree.FirstBetween(ranges[i][0], ranges[i][1], true, false)
+		}
+	}
+	return
+}
 func (self *Node) SubSize(key []byte, result *int) error {
 	*result = self.tree.SubSize(key)
 	return nil
 }
-func (self *Node) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) (err error) {
-	if expr.Dest != nil {
-		if expr.Op.Merge == setop.Append {
-			err = fmt.Errorf("When storing results of Set expressions the Append merge function is not allowed")
+
+// routeSetExpression will parse expr.Code if expr has no Op, and if expr has a Dest make sure it can be stored and forward it to operation on the owner of expr.Dest.
+func (self *Node) routeSetExpression(expr *setop.SetExpression, operation string, reply interface{}) (forwarded bool, err error) {
+	if expr.Op == nil {
+		if expr.Op, err = setop.NewSetOpParser(expr.Code).Parse(); err != nil {
 			return
 		}
-		successor := self.node.GetSuccessorFor(expr.Dest)
-		if successor.Addr != self.node.GetBroadcastAddr() {
-			return successor.Call("DHash.SetExpression", expr, items)
-		}
+	}
+	if expr.Dest == nil {
+		return
+	}
+	if expr.Op.Merge == setop.Append {
+		err = fmt.Errorf("When storing results of Set expressions the Append merge function is not allowed")
+		return
+	}
+	successor := self.node.GetSuccessorFor(expr.Dest)
+	if successor.Addr != self.node.GetBroadcastAddr() {
+		return true, successor.Call(operation, *expr, reply)
+	}
+	return
+}
+func (self *Node) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) (err error) {
+	var forwarded bool
+	if forwarded, err = self.routeSetExpression(&expr, "DHash.SetExpression", items); forwarded || err != nil {
+		return
 	}
 	data := common.Item{
 		Key: expr.Dest,
@@ -455,6 +1355,37 @@ func (self *Node) SetExpression(expr setop.SetExpression, items *[]setop.SetOpRe
 	})
 	return
 }
+
+// SetStore will replace the sub tree defined by expr.Dest with the results of expr, and return the number of results stored.
+// The results are collected before the sub tree is replaced, so readers will see either the old or the new content.
+func (self *Node) SetStore(expr setop.SetExpression, size *int) (err error) {
+	if expr.Dest == nil {
+		err = fmt.Errorf("SetStore needs a destination")
+		return
+	}
+	var forwarded bool
+	if forwarded, err = self.routeSetExpression(&expr, "DHash.SetStore", size); forwarded || err != nil {
+		return
+	}
+	data := common.Batch{
+		Key:  expr.Dest,
+		TTL:  self.node.Redundancy(),
+		Sync: true,
+	}
+	expr.Dest = nil
+	var items []setop.SetOpResult
+	if err = self.SetExpression(expr, &items); err != nil {
+		return
+	}
+	for _, item := range items {
+		data.Items = append(data.Items, common.Item{
+			SubKey: item.Key,
+			Value:  item.Values[0],
+		})
+	}
+	data.Timestamp = self.timer.ContinuousTime()
+	return self.subReplace(data, size)
+}
 func (self *Node) AddConfiguration(c common.ConfItem) {
 	self.tree.AddConfiguration(self.timer.ContinuousTime(), c.Key, c.Value)
 }
@@ -464,6 +1395,9 @@ func (self *Node) forwardConfiguration(c common.ConfItem, operation string) {
 	var x int
 	err := successor.Call(operation, c, &x)
 	for err != nil {
+		hinted := c
+		hinted.TTL = 1
+		self.addHint(successor.Addr, operation, hinted)
 		self.node.RemoveNode(successor)
 		successor = self.node.GetSuccessor()
 		err = successor.Call(operation, c, &x)
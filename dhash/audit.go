@@ -0,0 +1,108 @@
+package dhash
+
+import (
+	"encoding/json"
+	"github.com/zond/god/discord"
+	"io"
+	"log"
+	"time"
+)
+
+// mutatingCalls are the DHash calls from clients that may change data. The Slave calls, which replicate changes between the nodes, are left out.
+var mutatingCalls = map[string]bool{
+	"DHash.Clear":               true,
+	"DHash.SubDel":              true,
+	"DHash.SubClear":            true,
+	"DHash.SubPut":              true,
+	"DHash.ZAdd":                true,
+	"DHash.SAdd":                true,
+	"DHash.HSet":                true,
+	"DHash.HIncrBy":             true,
+	"DHash.Exec":                true,
+	"DHash.PerformAll":          true,
+	"DHash.RestoreKey":          true,
+	"DHash.Eval":                true,
+	"DHash.LPush":               true,
+	"DHash.RPush":               true,
+	"DHash.LPop":                true,
+	"DHash.RPop":                true,
+	"DHash.BLPop":               true,
+	"DHash.BRPop":               true,
+	"DHash.SubPutChanged":       true,
+	"DHash.Del":                 true,
+	"DHash.Put":                 true,
+	"DHash.PutExpire":           true,
+	"DHash.Persist":             true,
+	"DHash.Rename":              true,
+	"DHash.Copy":                true,
+	"DHash.PutNotify":           true,
+	"DHash.AddInt64":            true,
+	"DHash.SubAddInt64":         true,
+	"DHash.NextID":              true,
+	"DHash.DrainPrefix":         true,
+	"DHash.DelOlderThan":        true,
+	"DHash.Repair":              true,
+	"DHash.Append":              true,
+	"DHash.AppendIf":            true,
+	"DHash.PFAdd":               true,
+	"DHash.PFMerge":             true,
+	"DHash.SetBit":              true,
+	"DHash.GetPut":              true,
+	"DHash.GetDel":              true,
+	"DHash.PutIfMissing":        true,
+	"DHash.CompareAndSwap":      true,
+	"DHash.CompareAndDelete":    true,
+	"DHash.Replace":             true,
+	"DHash.MPut":                true,
+	"DHash.SetStore":            true,
+	"DHash.SetExpression":       true,
+	"DHash.AddConfiguration":    true,
+	"DHash.SubAddConfiguration": true,
+}
+
+// AuditRecord is what Audit writes about each call that may have changed data.
+// Caller is the address of the connection the call came from, and Error is empty unless the call failed.
+type AuditRecord struct {
+	Time   time.Time
+	Caller string
+	Method string
+	Key    []byte `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// Audit will make this node write an AuditRecord, as a line of JSON, to w for every call it serves from now on that may change data,
+// once it is handled. Use an append only file for w to keep a trail of who changed which key when. A nil w turns auditing off.
+func (self *Node) Audit(w io.Writer) *Node {
+	self.auditLock.Lock()
+	defer self.auditLock.Unlock()
+	self.audit = nil
+	if w != nil {
+		self.audit = json.NewEncoder(w)
+	}
+	return self
+}
+
+// auditCall will write an AuditRecord about call if it may have changed data, and this node is audited.
+func (self *Node) auditCall(call discord.Call) bool {
+	if !mutatingCalls[call.Method] {
+		return true
+	}
+	self.auditLock.Lock()
+	defer self.auditLock.Unlock()
+	if self.audit == nil {
+		return true
+	}
+	record := AuditRecord{
+		Time:   call.Started,
+		Caller: call.Caller,
+		Method: call.Method,
+		Key:    keyOf(call.Args),
+	}
+	if call.Err != nil {
+		record.Error = call.Err.Error()
+	}
+	if err := self.audit.Encode(record); err != nil {
+		log.Printf("%v failed writing audit record %+v: %v", self.GetBroadcastAddr(), record, err)
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package dhash
+
+import (
+	"fmt"
+	"github.com/zond/god/common"
+	"math/bits"
+)
+
+// maxBitOffset limits bitmaps to 512MB, so that a single mistyped offset can't make a node allocate all its memory.
+const maxBitOffset = 1<<32 - 1
+
+func checkBitOffset(offset int64) error {
+	if offset < 0 || offset > maxBitOffset {
+		return fmt.Errorf("Bit offset %v is not between 0 and %v", offset, maxBitOffset)
+	}
+	return nil
+}
+
+// getBit returns the bit at offset in value, where bits past the end of value are 0.
+func getBit(value []byte, offset int64) bool {
+	index := offset / 8
+	return index < int64(len(value)) && value[index]&(0x80>>uint(offset%8)) != 0
+}
+
+// bitmap returns the value under key, or nothing if it is missing or expired.
+func (self *Node) bitmap(key []byte) []byte {
+	if value, timestamp, existed := self.tree.Get(key); existed && !self.expired(key, timestamp) {
+		return value
+	}
+	return nil
+}
+
+// SetBit will set, or clear, the bit at b.Offset in the value under b.Key, growing the value with zero bytes if it is too short, and return the old bit.
+// An expired value is treated as empty, like a missing value.
+// The replicas, and the log, get the whole updated value, but only if the bit changed.
+func (self *Node) SetBit(b common.Bit, old *bool) (err error) {
+	if err = checkBitOffset(b.Offset); err != nil {
+		return
+	}
+	if err = self.full(b.Key); err != nil {
+		return
+	}
+	data := common.Item{
+		Key:       b.Key,
+		Sync:      b.Sync,
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+	}
+	var changed bool
+	if _, changed, err = self.tree.ModifyLive(data.Key, data.Timestamp, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		if *old = getBit(oldValue, b.Offset); *old == b.Value {
+			return nil, false, nil
+		}
+		length := len(oldValue)
+		if index := int(b.Offset / 8); index >= length {
+			length = index + 1
+		}
+		data.Value = make([]byte, length)
+		copy(data.Value, oldValue)
+		data.Value[b.Offset/8] ^= 0x80 >> uint(b.Offset%8)
+		return data.Value, true, nil
+	}); err != nil {
+		return
+	}
+	if changed {
+		self.notify(common.PutEvent, data.Key)
+	}
+	if changed && data.TTL > 1 {
+		if data.Sync {
+			self.forwardOperation(data, "DHash.SlavePut")
+		} else {
+			go self.forwardOperation(data, "DHash.SlavePut")
+		}
+	}
+	return
+}
+
+// GetBit will return the bit at the common.EncodeInt64 encoded offset in data.Value in the value under data.Key.
+// Bits past the end of the value, and in missing or expired values, are 0.
+func (self *Node) GetBit(data common.Item, bit *bool) (err error) {
+	var offset int64
+	if offset, err = common.DecodeInt64(data.Value); err != nil {
+		return
+	}
+	if err = checkBitOffset(offset); err != nil {
+		return
+	}
+	*bit = getBit(self.bitmap(data.Key), offset)
+	return
+}
+
+// BitCount will return the number of set bits in the value under data.Key, or 0 if it is missing or expired.
+func (self *Node) BitCount(data common.Item, count *int64) error {
+	value := self.bitmap(data.Key)
+	*count = 0
+	for _, b := range value {
+		*count += int64(bits.OnesCount8(b))
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/zond/setop"
 	"math/big"
 	"net"
+	"reflect"
 	"runtime"
 	"testing"
 	"time"
@@ -148,6 +149,9 @@ func testClientInterface(t *testing.T, dhashes []*Node, c testClient) {
 	if rc, ok := c.(*client.Conn); ok {
 		testDump(t, rc)
 		testSubDump(t, rc)
+		testUnionStore(t, rc)
+		testInterStore(t, rc)
+		testSUnionSInter(t, rc)
 	}
 	testNextPrev(t, c)
 	testCounts(t, dhashes, c)
@@ -204,6 +208,73 @@ func assertMirrored(t *testing.T, dhashes []*Node, c testClient, subTree []byte)
 	}, time.Second*10)
 }
 
+func testUnionStore(t *testing.T, c *client.Conn) {
+	t1 := []byte("union1")
+	t2 := []byte("union2")
+	dst := []byte("uniondst")
+	for i := byte(0); i < 5; i++ {
+		c.SSubPut(t1, []byte{i}, []byte{1})
+	}
+	for i := byte(3); i < 8; i++ {
+		c.SSubPut(t2, []byte{i}, []byte{2})
+	}
+	c.SSubPut(dst, []byte{100}, []byte{100})
+	if size, err := c.UnionStore(dst, t1, t2); err != nil || size != 8 {
+		t.Errorf("wanted 8, nil but got %v, %v", size, err)
+	}
+	assertItems(t, c.Slice(dst, nil, nil, true, true), []byte{0, 1, 2, 3, 4, 5, 6, 7}, []byte{1, 1, 1, 1, 1, 2, 2, 2})
+	if size, err := c.UnionStore(dst, t1, []byte("missing")); err != nil || size != 5 {
+		t.Errorf("wanted 5, nil but got %v, %v", size, err)
+	}
+	assertItems(t, c.Slice(dst, nil, nil, true, true), []byte{0, 1, 2, 3, 4}, []byte{1, 1, 1, 1, 1})
+}
+
+func testInterStore(t *testing.T, c *client.Conn) {
+	t1 := []byte("inter1")
+	t2 := []byte("inter2")
+	dst := []byte("interdst")
+	for i := byte(0); i < 5; i++ {
+		c.SSubPut(t1, []byte{i}, []byte{1})
+	}
+	for i := byte(3); i < 8; i++ {
+		c.SSubPut(t2, []byte{i}, []byte{2})
+	}
+	c.SSubPut(dst, []byte{100}, []byte{100})
+	if size, err := c.InterStore(dst, t1, t2); err != nil || size != 2 {
+		t.Errorf("wanted 2, nil but got %v, %v", size, err)
+	}
+	assertItems(t, c.Slice(dst, nil, nil, true, true), []byte{3, 4}, []byte{1, 1})
+	if size, err := c.InterStore(dst, t1, []byte("missing")); err != nil || size != 0 {
+		t.Errorf("wanted 0, nil but got %v, %v", size, err)
+	}
+	if size := c.SubSize(dst); size != 0 {
+		t.Errorf("wanted %v to be empty, but it has %v items", dst, size)
+	}
+}
+
+func testSUnionSInter(t *testing.T, c *client.Conn) {
+	for i := byte(0); i < 5; i++ {
+		c.SSAdd([]byte("sunion1"), []byte{i})
+	}
+	for i := byte(3); i < 8; i++ {
+		c.SSAdd([]byte("sunion2"), []byte{i})
+	}
+	c.SSAdd([]byte("sunion3"), []byte{4})
+	c.SSAdd([]byte("sunion3"), []byte{9})
+	if found := c.SUnion([]byte("sunion1"), []byte("sunion2"), []byte("sunion3")); !reflect.DeepEqual(found, [][]byte{{0}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {9}}) {
+		t.Errorf("wanted the union of all members, got %v", found)
+	}
+	if found := c.SInter([]byte("sunion1"), []byte("sunion2")); !reflect.DeepEqual(found, [][]byte{{3}, {4}}) {
+		t.Errorf("wanted [[3] [4]], got %v", found)
+	}
+	if found := c.SInter([]byte("sunion1"), []byte("sunion2"), []byte("sunion3")); !reflect.DeepEqual(found, [][]byte{{4}}) {
+		t.Errorf("wanted [[4]], got %v", found)
+	}
+	if found := c.SInter([]byte("sunion1"), []byte("missing")); len(found) != 0 {
+		t.Errorf("wanted nothing, got %v", found)
+	}
+}
+
 func testSetExpression(t *testing.T, c testClient) {
 	t1 := []byte("sete1")
 	t2 := []byte("sete2")
@@ -2,12 +2,16 @@ package dhash
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/discord"
 	"github.com/zond/god/murmur"
+	"github.com/zond/god/persistence"
 	"github.com/zond/god/radix"
 	"github.com/zond/god/timenet"
+	"path"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +26,9 @@ type CleanListener func(source, dest common.Remote, cleaned, pushed int) (keep b
 // MigrateListener is a function listening for migrate events where one dhash.Node has migrated from one position to another.
 type MigrateListener func(dhash *Node, source, destination []byte) (keep bool)
 
+// ChannelListener is a function listening for messages published to a channel by PutNotify.
+type ChannelListener func(channel string, message []byte) (keep bool)
+
 // CommListener is a function listening to generic communications between two dhash.Nodes.
 type CommListener func(comm Comm) (keep bool)
 
@@ -50,6 +57,20 @@ const (
 	syncInterval      = time.Second
 	migrateHysteresis = 1.5
 	migrateWaitFactor = 2
+	bulkFetchSize     = 1024
+	maxHints          = 1 << 14
+	cursorTimeout     = time.Minute
+	subscriberTimeout = time.Minute
+	receiveTimeout    = time.Second * 10
+	subscriberBuffer  = 1 << 10
+	keptVersions      = 4
+	readViewLifetime  = time.Minute
+	maxClockSkew      = time.Second
+	slowThreshold     = time.Millisecond * 10
+	slowLogSize       = 128
+	expiresConf       = "expires"
+	mirroredConf      = "mirrored"
+	typeConf          = "type"
 )
 
 const (
@@ -66,11 +87,36 @@ type Node struct {
 	lastReroute      int64
 	state            int32
 	lock             *sync.RWMutex
+	subLock          *sync.Mutex
 	syncListeners    []SyncListener
 	cleanListeners   []CleanListener
 	migrateListeners []MigrateListener
 	commListeners    map[*commListenerContainer]bool
 	nCommListeners   int32
+	channelListeners map[string][]ChannelListener
+	scripts          map[string]Script
+	hints            map[string][]hint
+	cursors          map[int64]*cursor
+	nextCursor       int64
+	subscribers      map[int64]*subscriber
+	nextSubscriber   int64
+	maxBytes         int64
+	evicted          int64
+	readOnly         int32
+	slowLock         *sync.Mutex
+	slowThreshold    time.Duration
+	slowOps          []common.SlowOp
+	nextSlowOp       int
+	slowOpsFull      bool
+	hits             int64
+	misses           int64
+	metricsLock      *sync.Mutex
+	calls            map[string]int64
+	errors           map[string]int64
+	auditLock        *sync.Mutex
+	audit            *json.Encoder
+	evictionPolicy   EvictionPolicy
+	expiries         *radix.Tree
 	node             *discord.Node
 	timer            *timenet.Timer
 	tree             *radix.Tree
@@ -82,11 +128,34 @@ func NewNode(listenAddr, broadcastAddr string) *Node {
 
 // NewNode will return a dhash.Node publishing itself on the given address.
 func NewNodeDir(listenAddr, broadcastAddr, dir string) (result *Node) {
+	var logger *persistence.Logger
+	if dir != "" {
+		logger = persistence.NewLogger(dir)
+	}
+	return NewNodeLogger(listenAddr, broadcastAddr, logger)
+}
+
+// NewNodeLogger will return a dhash.Node publishing itself on the given address, restoring from and logging to logger unless it is nil.
+// Use it to configure the logger, for example with persistence.Logger#CompactEvery, before the node starts using it.
+func NewNodeLogger(listenAddr, broadcastAddr string, logger *persistence.Logger) (result *Node) {
 	result = &Node{
-		node:          discord.NewNode(listenAddr, broadcastAddr),
-		lock:          new(sync.RWMutex),
-		commListeners: make(map[*commListenerContainer]bool),
-		state:         created,
+		node:             discord.NewNode(listenAddr, broadcastAddr),
+		lock:             new(sync.RWMutex),
+		subLock:          new(sync.Mutex),
+		slowLock:         new(sync.Mutex),
+		metricsLock:      new(sync.Mutex),
+		auditLock:        new(sync.Mutex),
+		calls:            make(map[string]int64),
+		errors:           make(map[string]int64),
+		commListeners:    make(map[*commListenerContainer]bool),
+		hints:            make(map[string][]hint),
+		channelListeners: make(map[string][]ChannelListener),
+		scripts:          make(map[string]Script),
+		cursors:          make(map[int64]*cursor),
+		subscribers:      make(map[int64]*subscriber),
+		evictionPolicy:   NewLRU(),
+		expiries:         radix.NewTree(),
+		state:            created,
 	}
 	result.node.AddCommListener(func(source, dest common.Remote, typ string) bool {
 		if result.hasState(started) {
@@ -104,11 +173,20 @@ func NewNodeDir(listenAddr, broadcastAddr, dir string) (result *Node) {
 		atomic.StoreInt64(&result.lastReroute, time.Now().UnixNano())
 		return true
 	})
+	result.LogSlowerThan(slowThreshold, slowLogSize)
+	result.node.AddCallListener(result.logSlow)
+	result.node.AddCallListener(result.countCall)
+	result.node.AddCallListener(result.auditCall)
 	result.timer = timenet.NewTimer((*dhashPeerProducer)(result))
 	result.tree = radix.NewTreeTimer(result.timer)
-	if dir != "" {
-		result.tree.Log(dir).Restore()
+	result.tree.WatchSubConfigurations(result.indexExpiry)
+	result.tree.ExpireWith(result.expiredConf)
+	if logger != nil {
+		result.tree.LogTo(logger).Restore()
 	}
+	// The replayed values were indexed when they were first written.
+	result.tree.WatchValues(result.reindex)
+	result.tree.KeepVersions(keptVersions)
 	result.node.Export("Timenet", (*timerServer)(result.timer))
 	result.node.Export("DHash", (*dhashServer)(result))
 	result.node.Export("HashTree", (*hashTreeServer)(result))
@@ -165,6 +243,11 @@ func (self *Node) AddSyncListener(l SyncListener) {
 	defer self.lock.Unlock()
 	self.syncListeners = append(self.syncListeners, l)
 }
+func (self *Node) AddChannelListener(channel string, l ChannelListener) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.channelListeners[channel] = append(self.channelListeners[channel], l)
+}
 func (self *Node) hasState(s int32) bool {
 	return atomic.LoadInt32(&self.state) == s
 }
@@ -181,16 +264,25 @@ func (self *Node) AddChangeListener(f common.RingChangeListener) {
 	self.node.AddChangeListener(f)
 }
 
+// AddInterceptor will make this dhash.Node let f intercept every rpc call it serves, for example to check authorization, count or log requests, or rewrite keys.
+// The DHash calls of the other nodes of the cluster, which sync, clean and forward values, are intercepted as well.
+func (self *Node) AddInterceptor(f discord.Interceptor) {
+	self.node.AddInterceptor(f)
+}
+
 // Stop will shut down this dhash.Node, including its discord.Node and timenet.Timer,  permanently.
+// Once it stops accepting requests, everything queued for its persistence.Logger is written before the logfile is closed.
 func (self *Node) Stop() {
 	if self.changeState(started, stopped) {
 		self.node.Stop()
 		self.timer.Stop()
+		self.tree.Close()
+		self.unpublish()
 	}
 }
 
 // Start will spin up this dhash.Node, including its discord.Node and timenet.Timer.
-// It will also start the sync, clean and migrate jobs.
+// It will also start the sync, clean, migrate and handoff jobs, and publish its Stats and Metrics with expvar until it is stopped.
 func (self *Node) Start() (err error) {
 	if !self.changeState(created, started) {
 		return fmt.Errorf("%v can only be started when in state 'created'", self)
@@ -202,7 +294,9 @@ func (self *Node) Start() (err error) {
 	go self.syncPeriodically()
 	go self.cleanPeriodically()
 	go self.migratePeriodically()
+	go self.handoffPeriodically()
 	self.startJson()
+	self.publish()
 	return
 }
 func (self *Node) triggerSyncListeners(source, dest common.Remote, pulled, pushed int) {
@@ -246,9 +340,60 @@ func (self *Node) syncPeriodically() {
 		time.Sleep(syncInterval)
 	}
 }
+
+// hint is an operation that failed to reach a replica, kept to be handed off when the replica is reachable again.
+type hint struct {
+	operation string
+	data      interface{}
+}
+
+// addHint will remember that operation with data has to be handed off to addr. The oldest hints for addr are dropped when there are more than maxHints,
+// leaving them to the sync job.
+func (self *Node) addHint(addr, operation string, data interface{}) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.hints[addr] = append(self.hints[addr], hint{
+		operation: operation,
+		data:      data,
+	})
+	if len(self.hints[addr]) > maxHints {
+		self.hints[addr] = self.hints[addr][len(self.hints[addr])-maxHints:]
+	}
+}
+
+// handoff will try to deliver all hints, in order, to their replicas. Hints for replicas that are still unreachable are kept.
+func (self *Node) handoff() {
+	self.lock.Lock()
+	hints := self.hints
+	self.hints = make(map[string][]hint)
+	self.lock.Unlock()
+	for addr, addrHints := range hints {
+		remote := common.Remote{Addr: addr}
+		var x int
+		for len(addrHints) > 0 && remote.Call(addrHints[0].operation, addrHints[0].data, &x) == nil {
+			addrHints = addrHints[1:]
+		}
+		if len(addrHints) > 0 {
+			self.lock.Lock()
+			self.hints[addr] = append(addrHints, self.hints[addr]...)
+			self.lock.Unlock()
+		}
+	}
+}
+func (self *Node) handoffPeriodically() {
+	for self.hasState(started) {
+		self.handoff()
+		time.Sleep(syncInterval)
+	}
+}
 func (self *Node) cleanPeriodically() {
 	for self.hasState(started) {
 		self.clean()
+		self.expireCursors()
+		self.expireSubscribers()
+		self.tree.PruneVersions(self.timer.ContinuousTime() - int64(readViewLifetime))
+		self.expire()
+		self.evict()
 		time.Sleep(syncInterval)
 	}
 }
@@ -348,6 +493,32 @@ func (self *Node) owners(key []byte) (owners common.Remotes, isOwner bool) {
 	}
 	return
 }
+func (self *Node) triggerChannelListeners(channel string, message []byte) (reached int) {
+	self.lock.RLock()
+	listeners := self.channelListeners[channel]
+	self.lock.RUnlock()
+	if reached = len(listeners); reached == 0 {
+		return
+	}
+	var newListeners []ChannelListener
+	for _, l := range listeners {
+		if l(channel, message) {
+			newListeners = append(newListeners, l)
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	// Keep the listeners added while these were running.
+	if current := self.channelListeners[channel]; len(current) > len(listeners) {
+		newListeners = append(newListeners, current[len(listeners):]...)
+	}
+	if len(newListeners) > 0 {
+		self.channelListeners[channel] = newListeners
+	} else {
+		delete(self.channelListeners, channel)
+	}
+	return
+}
 func (self *Node) triggerCleanListeners(source, dest common.Remote, cleaned, pushed int) {
 	self.lock.RLock()
 	newListeners := make([]CleanListener, 0, len(self.cleanListeners))
@@ -389,6 +560,23 @@ func (self *Node) clean() {
 		}
 	}
 }
+// SetRedundancy will make the cluster store each value on redundancy nodes, or all of them if there are fewer.
+// The setting spreads to the other nodes, replacing the ones chosen before it, and they sync and clean the values they hold to match it.
+func (self *Node) SetRedundancy(redundancy int) *Node {
+	self.node.SetRedundancy(redundancy)
+	return self
+}
+
+// ReadOnlyWhenDegraded will make this node refuse writes to the values it owns while its persistence.Logger is degraded,
+// instead of keeping them in memory until the Logger manages to write to disk again, which is the default.
+func (self *Node) ReadOnlyWhenDegraded(readOnly bool) *Node {
+	if readOnly {
+		atomic.StoreInt32(&self.readOnly, 1)
+	} else {
+		atomic.StoreInt32(&self.readOnly, 0)
+	}
+	return self
+}
 func (self *Node) MustStart() *Node {
 	if err := self.Start(); err != nil {
 		panic(err)
@@ -396,8 +584,287 @@ func (self *Node) MustStart() *Node {
 	return self
 }
 func (self *Node) MustJoin(addr string) {
+	if err := self.Join(addr); err != nil {
+		panic(err)
+	}
+}
+
+// Join will conform our timer to, and join the ring of, the node at addr. It will then seed this node with the data it owns by
+// fetching it in bulk from our successor, so that we don't have to wait for the periodic sync and clean jobs to bring it to us.
+func (self *Node) Join(addr string) (err error) {
 	self.timer.Conform(remotePeer(common.Remote{Addr: addr}))
-	self.node.MustJoin(addr)
+	if err = self.node.Join(addr); err != nil {
+		return
+	}
+	return self.seed()
+}
+func (self *Node) seed() (err error) {
+	me := self.node.Remote()
+	pred := self.node.GetPredecessor()
+	succ := self.node.GetSuccessor()
+	if succ.Addr == me.Addr {
+		return
+	}
+	ranges := []common.Range{common.Range{Min: pred.Pos, Max: me.Pos, MinInc: true, Len: bulkFetchSize}}
+	if bytes.Compare(pred.Pos, me.Pos) > 0 {
+		ranges = []common.Range{
+			common.Range{Min: pred.Pos, MinInc: true, Len: bulkFetchSize},
+			common.Range{Max: me.Pos, Len: bulkFetchSize},
+		}
+	}
+	var imported int
+	for _, r := range ranges {
+		for {
+			var items []common.Item
+			if err = succ.Call("DHash.BulkFetch", r, &items); err != nil {
+				return
+			}
+			if len(items) == 0 {
+				break
+			}
+			imported += self.tree.Import(items)
+			r.Min, r.MinInc = items[len(items)-1].Key, false
+		}
+	}
+	if imported > 0 && !self.tree.Snapshot() {
+		err = fmt.Errorf("%v failed writing a snapshot of the %v items it fetched", self, imported)
+	}
+	return
+}
+
+// Snapshot will replace the logfiles of this node with a snapshot of its current state, so that restarting it doesn't have to replay everything ever logged.
+func (self *Node) Snapshot() (err error) {
+	if !self.tree.Snapshot() {
+		err = fmt.Errorf("%v failed writing a snapshot, and kept its old logfiles", self)
+	}
+	return
+}
+
+// Backup will copy the logfiles of this node to the directory dst, so that they can be restored using RestoreFrom.
+func (self *Node) Backup(dst string) error {
+	return self.tree.Backup(dst)
+}
+
+// RestoreFrom will replace the data of this node with the data logged in a backup in src, up to upTo.
+// Restore a node before starting it, and restore all nodes in a cluster to the same time, or the restored data will be overwritten by newer data from the other nodes.
+func (self *Node) RestoreFrom(src string, upTo time.Time) error {
+	return self.tree.RestoreFrom(src, upTo)
+}
+
+// Usage returns statistics about the sizes of the keys and values this node owns.
+// It pages through the entire tree, so it costs about as much as reading all data stored on this node.
+func (self *Node) Usage() (result common.Usage) {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	var min []byte
+	mininc := true
+	for {
+		items := self.tree.ExportBetween(min, nil, mininc, false, bulkFetchSize)
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			if common.BetweenIE(item.Key, pred.Pos, me.Pos) {
+				result.Add(item.Key, item.SubKey, item.Value)
+			}
+		}
+		min, mininc = items[len(items)-1].Key, false
+	}
+	return
+}
+
+// Stats returns statistics about this node that, unlike Usage, are cheap to compute.
+func (self *Node) Stats() common.Stats {
+	logStats := self.tree.LogStats()
+	return common.Stats{
+		Addr:         self.GetBroadcastAddr(),
+		OwnedEntries: self.Owned(),
+		HeldEntries:  self.tree.RealSizeBetween(nil, nil, true, true),
+		Bytes:        self.tree.DataSize(),
+		LogBacklog:   logStats.Queued + logStats.NonDurable,
+		Degraded:     logStats.Degraded,
+		Evicted:      atomic.LoadInt64(&self.evicted),
+	}
+}
+
+// expiry returns the deadline set by PutExpire for the value under key, if any.
+func (self *Node) expiry(key []byte) (deadline int64, ok bool) {
+	conf, _ := self.tree.SubConfiguration(key)
+	return confExpiry(conf)
+}
+
+// confExpiry returns the deadline in the sub tree configuration conf, if any.
+func confExpiry(conf map[string]string) (deadline int64, ok bool) {
+	if value, found := conf[expiresConf]; found {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return parsed, true
+		}
+	}
+	return
+}
+
+// expired returns whether the value under key, written at timestamp, was written before a deadline that has passed.
+func (self *Node) expired(key []byte, timestamp int64) bool {
+	conf, _ := self.tree.SubConfiguration(key)
+	return self.expiredConf(key, timestamp, conf)
+}
+
+// expiredConf is expired given the sub tree configuration conf of key, so that the tree can ask it while locked, as its radix.Expirer.
+func (self *Node) expiredConf(key []byte, timestamp int64, conf map[string]string) bool {
+	deadline, ok := confExpiry(conf)
+	return ok && timestamp < deadline && deadline <= self.timer.ContinuousTime()
+}
+
+// indexExpiry will remember the deadline in conf, if any, of the value under key in the expiries of this node, ordered by deadline,
+// so that expire only has to look at the values that are due. Deadlines that are replaced or removed are forgotten once they are due.
+func (self *Node) indexExpiry(key []byte, conf map[string]string) {
+	if deadline, ok := confExpiry(conf); ok {
+		self.expiries.Put(append(common.EncodeInt64(deadline), key...), key, deadline)
+	}
+}
+
+// expire will delete the expired values owned by this node, and forget their deadlines.
+// Deadlines of values owned by other nodes are kept until those nodes have forgotten them, in case this node comes to own the values.
+func (self *Node) expire() {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	var due []common.Item
+	self.expiries.EachBetween(nil, common.EncodeInt64(self.timer.ContinuousTime()+1), false, false, func(indexKey, key []byte, deadline int64) bool {
+		due = append(due, common.Item{Key: indexKey, Value: key, Timestamp: deadline})
+		return true
+	})
+	for _, entry := range due {
+		if deadline, ok := self.expiry(entry.Value); ok && deadline == entry.Timestamp {
+			if !common.BetweenIE(entry.Value, pred.Pos, me.Pos) {
+				continue
+			}
+			if _, timestamp, existed := self.tree.Get(entry.Value); existed {
+				self.expireItem(common.Item{Key: entry.Value, Timestamp: timestamp})
+			}
+		}
+		self.expiries.Del(entry.Key)
+	}
+}
+
+// expireItem will delete item, and forget its deadline, if it has expired. It holds subLock, so that Persist can't remove the deadline meanwhile.
+func (self *Node) expireItem(item common.Item) {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	if !self.expired(item.Key, item.Timestamp) {
+		return
+	}
+	key := item.Key
+	deadline, _ := self.expiry(key)
+	data := common.Item{
+		Key:       key,
+		TTL:       self.node.Redundancy(),
+		Timestamp: self.timer.ContinuousTime(),
+	}
+	if _, deleted := self.tree.FakeDelOlderThan(key, deadline, data.Timestamp); deleted {
+		if data.TTL > 1 {
+			self.forwardOperation(data, "DHash.SlaveDel")
+		}
+		self.notify(common.ExpireEvent, key)
+	}
+	self.SubAddConfiguration(common.ConfItem{
+		TreeKey: key,
+		Key:     expiresConf,
+	})
+}
+
+// ScanKeys will return the keys with byte values after r.Min, owned by this node, stopping after r.Len keys or at the end of what this node owns.
+// Unless the page is full, or this node owns the end of the key space, the page tells where the owned keys of the next node start.
+// If r.At is not 0 the keys are the ones that had byte values at that cluster time.
+func (self *Node) ScanKeys(r common.Range, page *common.ScanPage) error {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	// If we start before our position we stop there, even if we own the end of the key space too, since the scan gets there last.
+	below := bytes.Compare(r.Min, me.Pos) < 0 && bytes.Compare(pred.Pos, me.Pos) != 0
+	if err := self.eachBetweenAt(r.Min, nil, r.MinInc, false, r.At, func(key, value []byte, timestamp int64) bool {
+		if !common.BetweenIE(key, pred.Pos, me.Pos) || below && bytes.Compare(key, me.Pos) > 0 {
+			return false
+		}
+		page.Keys = append(page.Keys, key)
+		return len(page.Keys) < r.Len
+	}); err != nil {
+		return err
+	}
+	if cmp := bytes.Compare(pred.Pos, me.Pos); len(page.Keys) < r.Len && (cmp == 0 || (cmp > 0 && bytes.Compare(r.Min, me.Pos) >= 0)) {
+		page.Done = true
+	} else {
+		page.Next = me.Pos
+	}
+	return nil
+}
+
+type cursor struct {
+	keys [][]byte
+	used time.Time
+}
+
+// OpenCursor will snapshot the keys with byte values, owned by this node, that start with data.Key, and return an id to page through them with CursorPage.
+// If data.Value is not empty, only keys matching it as a path.Match pattern are snapshotted. Cursors that are not paged through for cursorTimeout are forgotten.
+// If data.Timestamp is not 0, the keys snapshotted are the ones that had byte values at that cluster time, so that all nodes can snapshot the same moment.
+func (self *Node) OpenCursor(data common.Item, id *int64) error {
+	pattern := string(data.Value)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	c := &cursor{used: time.Now()}
+	if err := self.eachBetweenAt(data.Key, nil, true, false, data.Timestamp, func(key, value []byte, timestamp int64) bool {
+		if !bytes.HasPrefix(key, data.Key) {
+			return false
+		}
+		if common.BetweenIE(key, pred.Pos, me.Pos) {
+			if matched, _ := path.Match(pattern, string(key)); pattern == "" || matched {
+				c.keys = append(c.keys, key)
+			}
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.nextCursor++
+	*id = self.nextCursor
+	self.cursors[*id] = c
+	return nil
+}
+
+// CursorPage will return the next c.Len keys of the cursor c.ID, and forget the cursor when it has no keys left to return.
+func (self *Node) CursorPage(c common.Cursor, keys *[][]byte) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	open, ok := self.cursors[c.ID]
+	if !ok {
+		return fmt.Errorf("%v has no cursor %v", self.node, c.ID)
+	}
+	if len(open.keys) == 0 {
+		delete(self.cursors, c.ID)
+		return nil
+	}
+	if c.Len > len(open.keys) {
+		c.Len = len(open.keys)
+	}
+	*keys, open.keys, open.used = open.keys[:c.Len], open.keys[c.Len:], time.Now()
+	return nil
+}
+func (self *Node) expireCursors() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for id, c := range self.cursors {
+		if time.Since(c.used) > cursorTimeout {
+			delete(self.cursors, id)
+		}
+	}
+}
+
+// BulkFetch returns the items between r.Min and r.Max, stopping after the first key that brings their number to at least r.Len.
+func (self *Node) BulkFetch(r common.Range) []common.Item {
+	return self.tree.ExportBetween(r.Min, r.Max, r.MinInc, r.MaxInc, r.Len)
 }
 func (self *Node) Time() time.Time {
 	return time.Unix(0, self.timer.ContinuousTime())
@@ -3,6 +3,7 @@ package dhash
 import (
 	"github.com/zond/god/common"
 	"github.com/zond/setop"
+	"time"
 )
 
 type dhashServer Node
@@ -20,11 +21,28 @@ func (self *dhashServer) SlaveSubClear(data common.Item, x *int) error {
 func (self *dhashServer) SlaveSubDel(data common.Item, x *int) error {
 	return (*Node)(self).subDel(data)
 }
+func (self *dhashServer) SlaveSubPutChanged(data common.Batch, changed *int) error {
+	return (*Node)(self).subPutChanged(data, changed)
+}
+func (self *dhashServer) SlaveSubReplace(data common.Batch, size *int) error {
+	return (*Node)(self).subReplace(data, size)
+}
+func (self *dhashServer) SlaveExec(data common.Batch, x *int) error {
+	(*Node)(self).exec(data)
+	return nil
+}
+func (self *dhashServer) SlavePutAll(data common.Batch, x *int) error {
+	return (*Node)(self).putAll(data)
+}
+func (self *dhashServer) SlaveDelAll(data common.Batch, x *int) error {
+	return (*Node)(self).delAll(data)
+}
 func (self *dhashServer) SlaveDel(data common.Item, x *int) error {
 	return (*Node)(self).del(data)
 }
-func (self *dhashServer) SlavePut(data common.Item, x *int) error {
-	return (*Node)(self).put(data)
+func (self *dhashServer) SlavePut(data common.Item, x *int) (err error) {
+	_, err = (*Node)(self).put(data)
+	return
 }
 func (self *dhashServer) SubDel(data common.Item, x *int) error {
 	return (*Node)(self).SubDel(data)
@@ -35,11 +53,168 @@ func (self *dhashServer) SubClear(data common.Item, x *int) error {
 func (self *dhashServer) SubPut(data common.Item, x *int) error {
 	return (*Node)(self).SubPut(data)
 }
+func (self *dhashServer) ZAdd(data common.Item, x *int) error {
+	return (*Node)(self).ZAdd(data)
+}
+func (self *dhashServer) SAdd(data common.Item, x *int) error {
+	return (*Node)(self).SAdd(data)
+}
+func (self *dhashServer) HSet(data common.Item, x *int) error {
+	return (*Node)(self).HSet(data)
+}
+func (self *dhashServer) Type(key []byte, typ *string) error {
+	return (*Node)(self).Type(key, typ)
+}
+func (self *dhashServer) Exec(data common.Batch, old *[]common.Item) error {
+	return (*Node)(self).Exec(data, old)
+}
+func (self *dhashServer) DumpKey(data common.Item, dump *[]byte) error {
+	return (*Node)(self).DumpKey(data, dump)
+}
+func (self *dhashServer) RestoreKey(r common.Restoration, x *int) error {
+	return (*Node)(self).RestoreKey(r, x)
+}
+func (self *dhashServer) Eval(e common.Eval, result *[]byte) error {
+	return (*Node)(self).Eval(e, result)
+}
+func (self *dhashServer) LPush(data common.Item, length *int) error {
+	return (*Node)(self).LPush(data, length)
+}
+func (self *dhashServer) RPush(data common.Item, length *int) error {
+	return (*Node)(self).RPush(data, length)
+}
+func (self *dhashServer) LPop(data common.Item, result *common.Item) error {
+	return (*Node)(self).LPop(data, result)
+}
+func (self *dhashServer) RPop(data common.Item, result *common.Item) error {
+	return (*Node)(self).RPop(data, result)
+}
+func (self *dhashServer) BLPop(b common.BlockingPop, result *common.Item) error {
+	return (*Node)(self).BLPop(b, result)
+}
+func (self *dhashServer) BRPop(b common.BlockingPop, result *common.Item) error {
+	return (*Node)(self).BRPop(b, result)
+}
+func (self *dhashServer) SubPutChanged(data common.Batch, changed *int) error {
+	return (*Node)(self).SubPutChanged(data, changed)
+}
 func (self *dhashServer) Del(data common.Item, x *int) error {
 	return (*Node)(self).Del(data)
 }
-func (self *dhashServer) Put(data common.Item, x *int) error {
-	return (*Node)(self).Put(data)
+func (self *dhashServer) Put(data common.Item, durable *bool) (err error) {
+	*durable, err = (*Node)(self).Put(data)
+	return
+}
+func (self *dhashServer) PutExpire(e common.Expiry, durable *bool) error {
+	return (*Node)(self).PutExpire(e, durable)
+}
+func (self *dhashServer) TTL(data common.Item, ttl *time.Duration) error {
+	return (*Node)(self).TTL(data, ttl)
+}
+func (self *dhashServer) Rename(r common.Rename, renamed *bool) error {
+	return (*Node)(self).Rename(r, renamed)
+}
+func (self *dhashServer) Copy(c common.Copy, copied *bool) error {
+	return (*Node)(self).Copy(c, copied)
+}
+func (self *dhashServer) CompareAndDelete(data common.Item, deleted *bool) error {
+	return (*Node)(self).CompareAndDelete(data, deleted)
+}
+func (self *dhashServer) PerformAll(p common.Pipeline, responses *[]common.Item) error {
+	return (*Node)(self).PerformAll(p, responses)
+}
+func (self *dhashServer) Repair(data common.Item, repaired *bool) error {
+	return (*Node)(self).Repair(data, repaired)
+}
+func (self *dhashServer) Persist(data common.Item, persisted *bool) error {
+	return (*Node)(self).Persist(data, persisted)
+}
+func (self *dhashServer) PutNotify(n common.Notification, durable *bool) error {
+	return (*Node)(self).PutNotify(n, durable)
+}
+func (self *dhashServer) Publish(n common.Notification, reached *int) error {
+	return (*Node)(self).Publish(n, reached)
+}
+func (self *dhashServer) PublishAll(n common.Notification, reached *int) error {
+	return (*Node)(self).PublishAll(n, reached)
+}
+func (self *dhashServer) Subscribe(s common.Subscription, id *int64) error {
+	return (*Node)(self).Subscribe(s, id)
+}
+func (self *dhashServer) Receive(s common.Subscription, messages *[]common.Notification) error {
+	return (*Node)(self).Receive(s, messages)
+}
+func (self *dhashServer) Unsubscribe(s common.Subscription, x *int) error {
+	return (*Node)(self).Unsubscribe(s, x)
+}
+func (self *dhashServer) AddInt64(data common.Item, result *int64) error {
+	return (*Node)(self).AddInt64(data, result)
+}
+func (self *dhashServer) SubAddInt64(data common.Item, result *int64) error {
+	return (*Node)(self).SubAddInt64(data, result)
+}
+func (self *dhashServer) HIncrBy(data common.Item, result *int64) error {
+	return (*Node)(self).HIncrBy(data, result)
+}
+func (self *dhashServer) NextID(data common.Item, result *int64) error {
+	return (*Node)(self).NextID(data, result)
+}
+func (self *dhashServer) ScanPrefix(data common.Item, items *[]common.Item) error {
+	return (*Node)(self).ScanPrefix(data, items)
+}
+func (self *dhashServer) DrainPrefix(data common.Item, items *[]common.Item) error {
+	return (*Node)(self).DrainPrefix(data, items)
+}
+func (self *dhashServer) DelOlderThan(data common.Item, deleted *bool) error {
+	return (*Node)(self).DelOlderThan(data, deleted)
+}
+func (self *dhashServer) Append(data common.Item, length *int) error {
+	return (*Node)(self).Append(data, length)
+}
+func (self *dhashServer) AppendIf(a common.Append, appended *bool) error {
+	return (*Node)(self).AppendIf(a, appended)
+}
+func (self *dhashServer) PFAdd(data common.Item, changed *bool) error {
+	return (*Node)(self).PFAdd(data, changed)
+}
+func (self *dhashServer) PFCount(data common.Item, count *int64) error {
+	return (*Node)(self).PFCount(data, count)
+}
+func (self *dhashServer) PFMerge(data common.Batch, count *int64) error {
+	return (*Node)(self).PFMerge(data, count)
+}
+func (self *dhashServer) SetBit(b common.Bit, old *bool) error {
+	return (*Node)(self).SetBit(b, old)
+}
+func (self *dhashServer) GetBit(data common.Item, bit *bool) error {
+	return (*Node)(self).GetBit(data, bit)
+}
+func (self *dhashServer) BitCount(data common.Item, count *int64) error {
+	return (*Node)(self).BitCount(data, count)
+}
+func (self *dhashServer) ScanKeys(r common.Range, page *common.ScanPage) error {
+	return (*Node)(self).ScanKeys(r, page)
+}
+func (self *dhashServer) OpenCursor(data common.Item, id *int64) error {
+	return (*Node)(self).OpenCursor(data, id)
+}
+func (self *dhashServer) CursorPage(c common.Cursor, keys *[][]byte) error {
+	return (*Node)(self).CursorPage(c, keys)
+}
+func (self *dhashServer) GetDel(data common.Item, old *common.Item) error {
+	return (*Node)(self).GetDel(data, old)
+}
+func (self *dhashServer) GetPut(data common.Item, old *common.Item) error {
+	return (*Node)(self).GetPut(data, old)
+}
+func (self *dhashServer) PutIfMissing(data common.Item, put *bool) error {
+	return (*Node)(self).PutIfMissing(data, put)
+}
+func (self *dhashServer) CompareAndSwap(s common.Swap, swapped *bool) error {
+	return (*Node)(self).CompareAndSwap(s, swapped)
+}
+func (self *dhashServer) Replace(r common.Replacement, result *common.Item) error {
+	return (*Node)(self).Replace(r, result)
 }
 func (self *dhashServer) RingHash(x int, result *[]byte) error {
 	return (*Node)(self).RingHash(x, result)
@@ -62,13 +237,80 @@ func (self *dhashServer) SubGet(data common.Item, result *common.Item) error {
 func (self *dhashServer) Get(data common.Item, result *common.Item) error {
 	return (*Node)(self).Get(data, result)
 }
+func (self *dhashServer) GetAt(data common.Item, result *common.Item) error {
+	return (*Node)(self).GetAt(data, result)
+}
+func (self *dhashServer) ScanPrefixAt(data common.Item, items *[]common.Item) error {
+	return (*Node)(self).ScanPrefixAt(data, items)
+}
+func (self *dhashServer) Time(x int, result *time.Time) error {
+	*result = (*Node)(self).Time()
+	return nil
+}
+func (self *dhashServer) GetMeta(data common.Item, meta *common.Meta) error {
+	return (*Node)(self).GetMeta(data, meta)
+}
 func (self *dhashServer) Size(x int, result *int) error {
 	*result = (*Node)(self).Size()
 	return nil
 }
+func (self *dhashServer) KeySize(x int, result *int) error {
+	*result = (*Node)(self).KeySize()
+	return nil
+}
+func (self *dhashServer) RandomKey(x int, result *common.Item) error {
+	return (*Node)(self).RandomKey(x, result)
+}
+func (self *dhashServer) Snapshot(x int, y *int) error {
+	return (*Node)(self).Snapshot()
+}
+func (self *dhashServer) Backup(dst string, x *int) error {
+	return (*Node)(self).Backup(dst)
+}
+func (self *dhashServer) Usage(x int, result *common.Usage) error {
+	*result = (*Node)(self).Usage()
+	return nil
+}
+func (self *dhashServer) Stats(x int, result *common.Stats) error {
+	*result = (*Node)(self).Stats()
+	return nil
+}
+func (self *dhashServer) Metrics(x int, result *common.Metrics) error {
+	*result = (*Node)(self).Metrics()
+	return nil
+}
+func (self *dhashServer) SlowLog(x int, result *[]common.SlowOp) error {
+	*result = (*Node)(self).SlowLog()
+	return nil
+}
+func (self *dhashServer) ResetSlowLog(x int, y *int) error {
+	(*Node)(self).ResetSlowLog()
+	return nil
+}
+func (self *dhashServer) MPut(data common.Batch, old *[]common.Item) error {
+	return (*Node)(self).MPut(data, old)
+}
+func (self *dhashServer) MGet(data common.Batch, items *[]common.Item) error {
+	return (*Node)(self).MGet(data, items)
+}
+func (self *dhashServer) MExists(data common.Batch, present *[]bool) error {
+	return (*Node)(self).MExists(data, present)
+}
+func (self *dhashServer) MinKey(x int, result *common.Item) error {
+	*result = (*Node)(self).MinKey()
+	return nil
+}
+func (self *dhashServer) MaxKey(x int, result *common.Item) error {
+	*result = (*Node)(self).MaxKey()
+	return nil
+}
 func (self *dhashServer) SubSize(key []byte, result *int) error {
 	return (*Node)(self).SubSize(key, result)
 }
+func (self *dhashServer) BulkFetch(r common.Range, items *[]common.Item) error {
+	*items = (*Node)(self).BulkFetch(r)
+	return nil
+}
 func (self *dhashServer) Owned(x int, result *int) error {
 	*result = (*Node)(self).Owned()
 	return nil
@@ -168,6 +410,9 @@ func (self *dhashServer) ReverseSliceLen(r common.Range, result *[]common.Item)
 func (self *dhashServer) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) error {
 	return (*Node)(self).SetExpression(expr, items)
 }
+func (self *dhashServer) SetStore(expr setop.SetExpression, size *int) error {
+	return (*Node)(self).SetStore(expr, size)
+}
 
 func (self *dhashServer) AddConfiguration(c common.ConfItem, x *int) error {
 	(*Node)(self).AddConfiguration(c)
@@ -2,11 +2,18 @@ package dhash
 
 import (
 	"bytes"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"github.com/zond/god/client"
 	"github.com/zond/god/common"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -119,6 +126,2135 @@ func testMigrate(t *testing.T, dhashes []*Node) {
 	}, time.Second*100)
 }
 
+func testNextID(t *testing.T, dhashes []*Node) {
+	key := []byte("ids")
+	results := make(chan int64)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		go func() {
+			for i := 0; i < 50; i++ {
+				id, err := conn.NextID(key)
+				if err != nil {
+					t.Errorf("%v", err)
+				}
+				results <- id
+			}
+		}()
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < 50*len(dhashes); i++ {
+		id := <-results
+		if seen[id] {
+			t.Errorf("%v was issued twice", id)
+		}
+		seen[id] = true
+	}
+	if first, last, err := dhashes[0].client().NextIDs(key, 10); err != nil || first != int64(50*len(dhashes)+1) || last != first+9 {
+		t.Errorf("wanted %v-%v, got %v-%v, %v", 50*len(dhashes)+1, 50*len(dhashes)+10, first, last, err)
+	}
+	dhashes[0].client().SPut([]byte("notint"), []byte("x"))
+	if _, err := dhashes[0].client().NextID([]byte("notint")); err == nil {
+		t.Errorf("wanted an error when allocating ids from a non int value")
+	}
+}
+
+func testIncr(t *testing.T, dhashes []*Node) {
+	key := []byte("incr")
+	done := make(chan bool)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		go func() {
+			for i := 0; i < 20; i++ {
+				if _, err := conn.Incr(key); err != nil {
+					t.Errorf("%v", err)
+				}
+				if _, err := conn.IncrBy(key, 2); err != nil {
+					t.Errorf("%v", err)
+				}
+				if _, err := conn.Decr(key); err != nil {
+					t.Errorf("%v", err)
+				}
+			}
+			done <- true
+		}()
+	}
+	for _ = range dhashes {
+		<-done
+	}
+	conn := dhashes[0].client()
+	if value, err := conn.IncrBy(key, -int64(40*len(dhashes))); err != nil || value != 0 {
+		t.Errorf("wanted 0, got %v, %v", value, err)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, common.EncodeInt64(0))
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+	if _, err := conn.Decr([]byte("notint")); err == nil {
+		t.Errorf("wanted an error when decrementing a non int value")
+	}
+}
+
+func testReplace(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("replace"), []byte("hello world"))
+	if value, existed, err := conn.SReplace([]byte("replace"), "o(.)", []byte("0$1$1")); err != nil || !existed || string(value) != "hell0  w0rrld" {
+		t.Errorf("wanted hell0  w0rrld, true, nil, got %s, %v, %v", value, existed, err)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, []byte("replace"), []byte("hell0  w0rrld"))
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+	if value, existed, err := conn.SReplace([]byte("replace"), "x", []byte("0")); err != nil || !existed || string(value) != "hell0  w0rrld" {
+		t.Errorf("wanted a value without matches to be left alone, got %s, %v, %v", value, existed, err)
+	}
+	if _, existed, err := conn.Replace([]byte("missing"), "o", []byte("0")); err != nil || existed {
+		t.Errorf("wanted missing value to be left alone, got %v, %v", existed, err)
+	}
+	if _, _, err := conn.Replace([]byte("replace"), "(", []byte("0")); err == nil {
+		t.Errorf("wanted an error for a bad pattern")
+	}
+}
+
+func testGetPut(t *testing.T, dhashes []*Node) {
+	key := []byte("getput")
+	results := make(chan string)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		value := fmt.Sprint(index)
+		go func() {
+			old, existed := conn.SGetPut(key, []byte(value))
+			if existed {
+				results <- string(old)
+			} else {
+				results <- ""
+			}
+		}()
+	}
+	seen := make(map[string]bool)
+	for _ = range dhashes {
+		old := <-results
+		if seen[old] {
+			t.Errorf("wanted each replaced value to be returned once, got %q twice", old)
+		}
+		seen[old] = true
+	}
+	last, _ := dhashes[0].client().Get(key)
+	if seen[string(last)] || !seen[""] {
+		t.Errorf("wanted every value but the last one, %s, to be replaced exactly once, got %v", last, seen)
+	}
+}
+
+func testGetDel(t *testing.T, dhashes []*Node) {
+	key := []byte("getdel")
+	dhashes[0].client().SPut(key, []byte("token"))
+	results := make(chan string)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		go func() {
+			old, existed := conn.SGetDel(key)
+			if existed {
+				results <- string(old)
+			} else {
+				results <- ""
+			}
+		}()
+	}
+	taken := 0
+	for _ = range dhashes {
+		if old := <-results; old == "token" {
+			taken++
+		} else if old != "" {
+			t.Errorf("wanted token or nothing, got %q", old)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("wanted the token to be taken once, got %v", taken)
+	}
+	if _, existed := dhashes[0].client().Get(key); existed {
+		t.Errorf("wanted %s to be deleted", key)
+	}
+	conn := dhashes[0].client()
+	conn.SetChunkSize(4)
+	defer conn.SetChunkSize(0)
+	size := conn.Size()
+	conn.SPut(key, []byte("a chunked token"))
+	if old, existed := conn.SGetDel(key); !existed || string(old) != "a chunked token" {
+		t.Errorf("wanted a chunked token, got %q, %v", old, existed)
+	}
+	if after := conn.Size(); after != size {
+		t.Errorf("wanted the chunks to be deleted along with the manifest, had %v keys, got %v", size, after)
+	}
+}
+
+func testTTL(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	if ttl := conn.TTL([]byte("ttl/missing")); ttl != common.MissingTTL {
+		t.Errorf("wanted %v for a missing value, got %v", common.MissingTTL, ttl)
+	}
+	conn.SPut([]byte("ttl/persistent"), []byte("value"))
+	if ttl := conn.TTL([]byte("ttl/persistent")); ttl != common.NoTTL {
+		t.Errorf("wanted %v for a value without expiry, got %v", common.NoTTL, ttl)
+	}
+	if err := conn.SPutExpire([]byte("ttl/expiring"), []byte("value"), time.Hour); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ttl := conn.TTL([]byte("ttl/expiring")); ttl <= time.Minute*59 || ttl > time.Hour {
+		t.Errorf("wanted about an hour left, got %v", ttl)
+	}
+}
+
+func testPersist(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	if conn.Persist([]byte("persist/missing")) {
+		t.Errorf("wanted a missing value not to be persisted")
+	}
+	if err := conn.SPutExpire([]byte("persist/expiring"), []byte("value"), time.Hour); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !conn.Persist([]byte("persist/expiring")) {
+		t.Errorf("wanted an expiring value to be persisted")
+	}
+	if ttl := conn.TTL([]byte("persist/expiring")); ttl != common.NoTTL {
+		t.Errorf("wanted %v for a persisted value, got %v", common.NoTTL, ttl)
+	}
+	if conn.Persist([]byte("persist/expiring")) {
+		t.Errorf("wanted a value without expiry not to be persisted again")
+	}
+}
+
+func testRename(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	if conn.SRename([]byte("rename/missing"), []byte("rename/nowhere")) {
+		t.Errorf("wanted a missing value not to be renamed")
+	}
+	// Enough destinations to get both ones owned by the owner of the source and ones owned by others.
+	for i := 0; i < 10; i++ {
+		src, dest := []byte(fmt.Sprint("rename/src", i)), []byte(fmt.Sprint("rename/dest", i))
+		if err := conn.SPutExpire(src, []byte("value"), time.Hour); err != nil {
+			t.Fatalf("%v", err)
+		}
+		conn.SPut(dest, []byte("old"))
+		if conn.SRenameNX(src, dest) {
+			t.Errorf("wanted %s not to be renamed to the existing %s", src, dest)
+		}
+		if value, _ := conn.Get(src); string(value) != "value" {
+			t.Errorf("wanted %s to keep value, got %q", src, value)
+		}
+		if !conn.SRename(src, dest) {
+			t.Errorf("wanted %s to be renamed to %s", src, dest)
+		}
+		if _, existed := conn.Get(src); existed {
+			t.Errorf("wanted %s to be gone after the rename", src)
+		}
+		if value, _ := conn.Get(dest); string(value) != "value" {
+			t.Errorf("wanted %s to have value, got %q", dest, value)
+		}
+		if ttl := conn.TTL(dest); ttl <= time.Minute*59 || ttl > time.Hour {
+			t.Errorf("wanted %s to have about an hour left, got %v", dest, ttl)
+		}
+		if !conn.SRenameNX(dest, src) {
+			t.Errorf("wanted %s to be renamed back to the missing %s", dest, src)
+		}
+	}
+}
+
+func testCopy(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	if conn.SCopy([]byte("copy/missing"), []byte("copy/nowhere"), false) {
+		t.Errorf("wanted a missing value not to be copied")
+	}
+	// Enough destinations to get both ones owned by the owner of the source and ones owned by others.
+	for i := 0; i < 10; i++ {
+		src, dest, kept := []byte(fmt.Sprint("copy/src", i)), []byte(fmt.Sprint("copy/dest", i)), []byte(fmt.Sprint("copy/kept", i))
+		if err := conn.SPutExpire(src, []byte("value"), time.Hour); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := conn.SPutExpire(dest, []byte("old"), time.Hour); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !conn.SCopy(src, dest, false) || !conn.SCopy(src, kept, true) {
+			t.Errorf("wanted %s to be copied", src)
+		}
+		for _, key := range [][]byte{src, dest, kept} {
+			if value, _ := conn.Get(key); string(value) != "value" {
+				t.Errorf("wanted %s to have value, got %q", key, value)
+			}
+		}
+		if ttl := conn.TTL(dest); ttl != common.NoTTL {
+			t.Errorf("wanted %s to have no expiry, got %v", dest, ttl)
+		}
+		if ttl := conn.TTL(kept); ttl <= time.Minute*59 || ttl > time.Hour {
+			t.Errorf("wanted %s to have about an hour left, got %v", kept, ttl)
+		}
+	}
+}
+
+func testPutIfMissing(t *testing.T, dhashes []*Node) {
+	key := []byte("putifmissing")
+	results := make(chan string)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		value := fmt.Sprint(index)
+		go func() {
+			if conn.SPutIfMissing(key, []byte(value)) {
+				results <- value
+			} else {
+				results <- ""
+			}
+		}()
+	}
+	var winners []string
+	for _ = range dhashes {
+		if value := <-results; value != "" {
+			winners = append(winners, value)
+		}
+	}
+	if len(winners) != 1 {
+		t.Fatalf("wanted exactly one concurrent put to succeed, got %v", winners)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, []byte(winners[0]))
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+}
+
+func testCompareAndDelete(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("cdel")
+	if conn.SCompareAndDelete(key, []byte("token")) {
+		t.Errorf("wanted nothing to delete under a missing key")
+	}
+	conn.SPut(key, []byte("token"))
+	if conn.SCompareAndDelete(key, []byte("other token")) {
+		t.Errorf("wanted %s to survive a different expected value", key)
+	}
+	if value, _ := conn.Get(key); string(value) != "token" {
+		t.Errorf("wanted token, got %q", value)
+	}
+	if !conn.SCompareAndDelete(key, []byte("token")) {
+		t.Errorf("wanted %s to be deleted", key)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		for _, d := range dhashes {
+			if _, _, existed := d.tree.Get(key); existed {
+				return fmt.Sprintf("%v still has %s", d, key), false
+			}
+		}
+		return "", true
+	}, time.Second*10)
+}
+
+func testCompareAndSwap(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("cas")
+	if conn.SCompareAndSwap(key, nil, []byte("a")) {
+		t.Errorf("wanted no swap of a missing value")
+	}
+	conn.SPut(key, []byte("a"))
+	if conn.SCompareAndSwap(key, []byte("b"), []byte("c")) {
+		t.Errorf("wanted no swap of a value that isn't the expected one")
+	}
+	results := make(chan bool)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		go func() {
+			results <- conn.SCompareAndSwap(key, []byte("a"), []byte("b"))
+		}()
+	}
+	swaps := 0
+	for _ = range dhashes {
+		if <-results {
+			swaps++
+		}
+	}
+	if swaps != 1 {
+		t.Errorf("wanted exactly one concurrent swap to succeed, got %v", swaps)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, []byte("b"))
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+}
+
+func testAppend(t *testing.T, dhashes []*Node) {
+	key := []byte("append")
+	done := make(chan bool)
+	for index, _ := range dhashes {
+		conn := dhashes[index].client()
+		go func() {
+			for i := 0; i < 10; i++ {
+				conn.SAppend(key, []byte("x"))
+			}
+			done <- true
+		}()
+	}
+	for _ = range dhashes {
+		<-done
+	}
+	expected := bytes.Repeat([]byte("x"), 10*len(dhashes))
+	if length := dhashes[0].client().SAppend(key, nil); length != len(expected) {
+		t.Errorf("wanted %v, got %v", len(expected), length)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, expected)
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+}
+
+func testAppendIf(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("appendif")
+	if appended, err := conn.SAppendIf([]byte("appendifgate"), key, []byte("a")); err != nil || appended {
+		t.Errorf("wanted no append without the condition key, got %v, %v", appended, err)
+	}
+	if value, existed := conn.Get(key); existed {
+		t.Errorf("wanted nothing under %s, got %s", key, value)
+	}
+	conn.SPut([]byte("appendifgate"), []byte("on"))
+	for _, value := range []string{"a", "b"} {
+		if appended, err := conn.SAppendIf([]byte("appendifgate"), key, []byte(value)); err != nil || !appended {
+			t.Errorf("wanted an append with the condition key, got %v, %v", appended, err)
+		}
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, []byte("ab"))
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+}
+
+func testPFAdd(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("pfadd")
+	for i := 0; i < 1000; i++ {
+		if _, err := conn.SPFAdd(key, []byte(fmt.Sprint(i%500))); err != nil {
+			t.Fatalf("wanted no error, got %v", err)
+		}
+	}
+	if count, err := conn.PFCount(key); err != nil || count < 475 || count > 525 {
+		t.Errorf("wanted about 500, got %v, %v", count, err)
+	}
+	value, _ := conn.Get(key)
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, value)
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+	conn.SPut([]byte("pfaddplain"), []byte("x"))
+	if _, err := conn.SPFAdd([]byte("pfaddplain"), []byte("a")); err == nil {
+		t.Errorf("wanted an error adding to a value that is not a sketch")
+	}
+}
+
+func testPFMerge(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	for i := 250; i < 750; i++ {
+		conn.SPFAdd([]byte("pfmerge"), []byte(fmt.Sprint(i)))
+	}
+	if count, err := conn.SPFMerge([]byte("pfmergedst"), []byte("pfadd"), []byte("pfmerge"), []byte("pfmergemissing")); err != nil || count < 712 || count > 788 {
+		t.Errorf("wanted about 750, got %v, %v", count, err)
+	}
+	if count, err := conn.PFCount([]byte("pfmergedst")); err != nil || count < 712 || count > 788 {
+		t.Errorf("wanted about 750, got %v, %v", count, err)
+	}
+	if count, err := conn.PFCount([]byte("pfmerge")); err != nil || count < 475 || count > 525 {
+		t.Errorf("wanted the sources to be left alone, got %v, %v", count, err)
+	}
+	if _, err := conn.SPFMerge([]byte("pfmergedst"), []byte("pfaddplain")); err == nil {
+		t.Errorf("wanted an error merging a value that is not a sketch")
+	}
+}
+
+func testKeyCursor(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	expected := make(map[string]bool)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("cursor/%03d", i)
+		conn.SPut([]byte(key), []byte("x"))
+		expected[key] = true
+	}
+	cursor, err := conn.OpenKeys([]byte("cursor/"), 7)
+	if err != nil {
+		t.Fatalf("wanted no error opening a cursor, got %v", err)
+	}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			conn.SPut([]byte(fmt.Sprintf("cursor/new%v", i)), []byte("x"))
+			conn.SDel([]byte(fmt.Sprintf("cursor/%03d", i)))
+		}
+		close(done)
+	}()
+	found := make(map[string]bool)
+	for {
+		page, err := cursor.Next()
+		if err != nil {
+			t.Fatalf("wanted no error paging, got %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 7 {
+			t.Errorf("wanted at most 7 keys per page, got %v", len(page))
+		}
+		for _, key := range page {
+			if found[string(key)] {
+				t.Errorf("wanted each key once, got %s again", key)
+			}
+			found[string(key)] = true
+		}
+	}
+	<-done
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("wanted the %v keys of the snapshot, got %v", len(expected), len(found))
+	}
+	if cursor, err = conn.OpenMatchingKeys("cursor/2?5", 100); err != nil {
+		t.Fatalf("wanted no error opening a matching cursor, got %v", err)
+	}
+	var matched []string
+	for page, err := cursor.Next(); len(page) > 0 || err != nil; page, err = cursor.Next() {
+		if err != nil {
+			t.Fatalf("wanted no error paging, got %v", err)
+		}
+		for _, key := range page {
+			matched = append(matched, string(key))
+		}
+	}
+	sort.Strings(matched)
+	if expected := []string{"cursor/205", "cursor/215", "cursor/225", "cursor/235", "cursor/245", "cursor/255", "cursor/265", "cursor/275", "cursor/285", "cursor/295"}; !reflect.DeepEqual(matched, expected) {
+		t.Errorf("wanted %v, got %v", expected, matched)
+	}
+	if _, err = conn.OpenMatchingKeys("cursor/[", 100); err == nil {
+		t.Errorf("wanted an error for a malformed pattern")
+	}
+	view := conn.ReadView()
+	time.Sleep(time.Millisecond * 100)
+	conn.SPut([]byte("cursor/late"), []byte("x"))
+	conn.SDel([]byte("cursor/299"))
+	if cursor, err = view.OpenMatchingKeys("cursor/[2l]*", 1000); err != nil {
+		t.Fatalf("wanted no error opening a cursor in a view, got %v", err)
+	}
+	found = make(map[string]bool)
+	for page, err := cursor.Next(); len(page) > 0 || err != nil; page, err = cursor.Next() {
+		if err != nil {
+			t.Fatalf("wanted no error paging, got %v", err)
+		}
+		for _, key := range page {
+			found[string(key)] = true
+		}
+	}
+	if len(found) != 100 || !found["cursor/299"] || found["cursor/late"] {
+		t.Errorf("wanted cursor/200 to cursor/299 as they were when the view was created, got %v", found)
+	}
+}
+
+func testScan(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	cursor, err := conn.OpenKeys(nil, 1000)
+	if err != nil {
+		t.Fatalf("wanted no error opening a cursor, got %v", err)
+	}
+	var expected []string
+	for page, err := cursor.Next(); len(page) > 0 || err != nil; page, err = cursor.Next() {
+		if err != nil {
+			t.Fatalf("wanted no error paging, got %v", err)
+		}
+		for _, key := range page {
+			expected = append(expected, string(key))
+		}
+	}
+	sort.Strings(expected)
+	var scanned []string
+	var next []byte
+	for {
+		var keys [][]byte
+		keys, next = conn.Scan(next, 13)
+		if len(keys) > 13 {
+			t.Errorf("wanted at most 13 keys, got %v", len(keys))
+		}
+		for _, key := range keys {
+			scanned = append(scanned, string(key))
+		}
+		if next == nil {
+			break
+		}
+	}
+	if !reflect.DeepEqual(scanned, expected) {
+		t.Errorf("wanted the %v keys in order, got %v", len(expected), scanned)
+	}
+}
+
+func testScanPrefix(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	var expected []common.Item
+	for i := 0; i < 20; i++ {
+		item := common.Item{Key: []byte(fmt.Sprintf("scanprefix/%02d", i)), Value: []byte(fmt.Sprint(i))}
+		conn.SPut(item.Key, item.Value)
+		expected = append(expected, item)
+	}
+	conn.SPut([]byte("scanprefix"), []byte("outside"))
+	conn.SPut([]byte("scanprefiy"), []byte("outside"))
+	conn.SDel([]byte("scanprefix/05"))
+	expected = append(expected[:5], expected[6:]...)
+	scanned := conn.ScanPrefix([]byte("scanprefix/"))
+	if len(scanned) != len(expected) {
+		t.Fatalf("wanted %v items, got %v", len(expected), scanned)
+	}
+	for index, item := range scanned {
+		if !bytes.Equal(item.Key, expected[index].Key) || !bytes.Equal(item.Value, expected[index].Value) {
+			t.Errorf("wanted %s => %s at %v, got %s => %s", expected[index].Key, expected[index].Value, index, item.Key, item.Value)
+		}
+	}
+}
+
+func testSnapshot(t *testing.T, dhashes []*Node) {
+	if err := dhashes[0].client().Snapshot(); err != nil {
+		t.Fatalf("wanted no error snapshotting, got %v", err)
+	}
+	for _, d := range dhashes {
+		for suffix, wanted := range map[string]int{"snap": 1, "log": 1} {
+			if files, _ := filepath.Glob(filepath.Join(d.node.GetBroadcastAddr(), "*."+suffix)); len(files) != wanted {
+				t.Errorf("wanted %v %v file in %v after snapshotting, got %v", wanted, suffix, d, files)
+			}
+		}
+	}
+}
+
+func testBackup(t *testing.T, dhashes []*Node) {
+	os.RemoveAll("backups")
+	defer os.RemoveAll("backups")
+	if err := dhashes[0].client().Backup("backups"); err != nil {
+		t.Fatalf("wanted no error backing up, got %v", err)
+	}
+	for _, d := range dhashes {
+		dir := filepath.Join("backups", strings.Replace(d.node.GetBroadcastAddr(), ":", "_", -1))
+		if files, _ := filepath.Glob(filepath.Join(dir, "*.snap")); len(files) != 1 {
+			t.Errorf("wanted a snapshot in %v after backing up, got %v", dir, files)
+		}
+	}
+}
+
+func testPutExpire(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("putexpire")
+	if err := conn.SPutExpire(key, []byte("v"), 0); err == nil {
+		t.Errorf("wanted an error for a lifetime of 0")
+	}
+	if err := conn.SPutExpire(key, []byte("v"), time.Millisecond*500); err != nil {
+		t.Fatalf("wanted no error, got %v", err)
+	}
+	if value, existed := conn.Get(key); !existed || string(value) != "v" {
+		t.Errorf("wanted v before the deadline, got %s, %v", value, existed)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if value, existed := conn.Get(key); existed {
+		t.Errorf("wanted nothing after the deadline, got %s", value)
+	}
+	if present, _ := conn.MExists([][]byte{key}); present[0] {
+		t.Errorf("wanted %s to be missing after the deadline", key)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, key, []byte("v"))
+		return fmt.Sprint(having), having == 0
+	}, time.Second*10)
+	conn.SPut(key, []byte("v2"))
+	if value, existed := conn.Get(key); !existed || string(value) != "v2" {
+		t.Errorf("wanted v2 put after the deadline to stay, got %s, %v", value, existed)
+	}
+}
+
+func testExpiredWrites(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	// expiredKey returns a key with a value that is past its deadline, but most likely not yet collected by the owner.
+	expiredKey := func(name string, value []byte) []byte {
+		key := []byte("expiredwrites/" + name)
+		if err := conn.SPutExpire(key, value, time.Millisecond*100); err != nil {
+			t.Fatalf("wanted no error, got %v", err)
+		}
+		time.Sleep(time.Millisecond * 150)
+		return key
+	}
+	key := expiredKey("putifmissing", []byte("old"))
+	if !conn.SPutIfMissing(key, []byte("new")) {
+		t.Errorf("wanted to put in place of an expired value")
+	}
+	if value, existed := conn.Get(key); !existed || string(value) != "new" {
+		t.Errorf("wanted new, got %s, %v", value, existed)
+	}
+	key = expiredKey("getput", []byte("old"))
+	if value, existed := conn.SGetPut(key, []byte("new")); existed {
+		t.Errorf("wanted no value replaced by GetPut, got %s", value)
+	}
+	key = expiredKey("getdel", []byte("old"))
+	if value, existed := conn.SGetDel(key); existed {
+		t.Errorf("wanted no value deleted by GetDel, got %s", value)
+	}
+	key = expiredKey("append", []byte("old"))
+	if length := conn.SAppend(key, []byte("new")); length != 3 {
+		t.Errorf("wanted to append to nothing, got a length of %v", length)
+	}
+	if value, existed := conn.Get(key); !existed || string(value) != "new" {
+		t.Errorf("wanted new, got %s, %v", value, existed)
+	}
+	key = expiredKey("compareandswap", []byte("old"))
+	if conn.SCompareAndSwap(key, []byte("old"), []byte("new")) {
+		t.Errorf("wanted no swap of an expired value")
+	}
+	if value, existed := conn.Get(key); existed {
+		t.Errorf("wanted nothing, got %s", value)
+	}
+	key = expiredKey("incr", common.EncodeInt64(41))
+	if value, err := conn.Incr(key); err != nil || value != 1 {
+		t.Errorf("wanted to count from 0, got %v, %v", value, err)
+	}
+	sketch := common.NewSketch()
+	sketch.Add([]byte("old"))
+	key = expiredKey("pfadd", sketch)
+	if count, err := conn.PFCount(key); err != nil || count != 0 {
+		t.Errorf("wanted nothing counted, got %v, %v", count, err)
+	}
+	if changed, err := conn.SPFAdd(key, []byte("new")); err != nil || !changed {
+		t.Errorf("wanted new to be counted, got %v, %v", changed, err)
+	}
+	if count, err := conn.PFCount(key); err != nil || count != 1 {
+		t.Errorf("wanted only new counted, got %v, %v", count, err)
+	}
+	key = expiredKey("appendif", []byte("old"))
+	if appended, err := conn.SAppendIf(key, []byte("expiredwrites/appended"), []byte("new")); err != nil || appended {
+		t.Errorf("wanted no append on an expired condition, got %v, %v", appended, err)
+	}
+	key = expiredKey("compareanddelete", []byte("old"))
+	if conn.SCompareAndDelete(key, []byte("old")) {
+		t.Errorf("wanted no delete of an expired value")
+	}
+	key = expiredKey("bits", []byte{0xff})
+	if bit, err := conn.GetBit(key, 0); err != nil || bit {
+		t.Errorf("wanted no bit set, got %v, %v", bit, err)
+	}
+	if count := conn.BitCount(key); count != 0 {
+		t.Errorf("wanted no bits counted, got %v", count)
+	}
+	if old, err := conn.SSetBit(key, 1, true); err != nil || old {
+		t.Errorf("wanted no old bit, got %v, %v", old, err)
+	}
+	if value, existed := conn.Get(key); !existed || string(value) != string([]byte{0x40}) {
+		t.Errorf("wanted only the new bit, got %v, %v", value, existed)
+	}
+	key = expiredKey("type", []byte("old"))
+	if typ := conn.Type(key); typ != common.NoneType {
+		t.Errorf("wanted %v, got %v", common.NoneType, typ)
+	}
+	if err := conn.SSAdd(key, []byte("member")); err != nil {
+		t.Errorf("wanted to add to a set in place of an expired value, got %v", err)
+	}
+	if typ := conn.Type(key); typ != common.SetType {
+		t.Errorf("wanted %v, got %v", common.SetType, typ)
+	}
+	conn.SubClear(key)
+}
+
+func testPutNotify(t *testing.T, dhashes []*Node) {
+	received := make(chan string, len(dhashes))
+	for _, d := range dhashes {
+		d.AddChannelListener("putnotify", func(channel string, message []byte) bool {
+			received <- string(message)
+			return false
+		})
+	}
+	dhashes[0].AddChannelListener("other", func(channel string, message []byte) bool {
+		t.Errorf("wanted no message on other channels, got %s", message)
+		return false
+	})
+	conn := dhashes[0].client()
+	conn.SPutNotify([]byte("putnotify"), []byte("v"), "putnotify", []byte("hello"))
+	if value, existed := conn.Get([]byte("putnotify")); !existed || string(value) != "v" {
+		t.Errorf("wanted v under putnotify, got %s, %v", value, existed)
+	}
+	for range dhashes {
+		select {
+		case message := <-received:
+			if message != "hello" {
+				t.Errorf("wanted hello, got %v", message)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf("wanted every node to publish the message")
+		}
+	}
+	conn.SPutNotify([]byte("putnotify"), []byte("v2"), "putnotify", []byte("again"))
+	select {
+	case message := <-received:
+		t.Errorf("wanted listeners returning false to be removed, got %v", message)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func testPubSub(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	subscription := conn.Subscribe("pubsub", "pubsub2")
+	// Wait for the subscription to reach its node before publishing.
+	common.AssertWithin(t, func() (string, bool) {
+		reached := conn.SPublish("pubsub", []byte("hello"))
+		return fmt.Sprint(reached), reached == 1
+	}, time.Second*10)
+	conn.SPublish("pubsub3", []byte("ignored"))
+	conn.SPublish("pubsub2", []byte("world"))
+	for _, wanted := range []string{"pubsub: hello", "pubsub2: world"} {
+		select {
+		case message := <-subscription.C:
+			if found := fmt.Sprintf("%v: %s", message.Channel, message.Message); found != wanted {
+				t.Errorf("wanted %v, got %v", wanted, found)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf("wanted %v", wanted)
+		}
+	}
+	subscription.Close()
+	for range subscription.C {
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		reached := conn.SPublish("pubsub", []byte("closed"))
+		return fmt.Sprint(reached), reached == 0
+	}, time.Second*10)
+	var id int64
+	if err := dhashes[0].Subscribe(common.Subscription{}, &id); err == nil {
+		t.Errorf("wanted subscribing to no channels to fail")
+	}
+	var messages []common.Notification
+	if err := dhashes[0].Receive(common.Subscription{ID: -1}, &messages); err == nil {
+		t.Errorf("wanted receiving from a missing subscription to fail")
+	}
+}
+
+func testKeyspaceWatch(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	events, stop := conn.Watch("kswatch/*")
+	// Wait for the watch to subscribe in every node before changing anything.
+	common.AssertWithin(t, func() (string, bool) {
+		subscribed := 0
+		for _, d := range dhashes {
+			d.lock.RLock()
+			if len(d.channelListeners[common.KeyspaceChannel+common.PutEvent]) > 0 {
+				subscribed++
+			}
+			d.lock.RUnlock()
+		}
+		return fmt.Sprint(subscribed), subscribed == len(dhashes)
+	}, time.Second*10)
+	conn.SPut([]byte("kswatch/a"), []byte("1"))
+	conn.SPut([]byte("other/a"), []byte("1"))
+	conn.SDel([]byte("kswatch/a"))
+	conn.SDel([]byte("kswatch/missing"))
+	if err := conn.PutExpire([]byte("kswatch/b"), []byte("2"), time.Millisecond*100); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, wanted := range []string{"put kswatch/a", "del kswatch/a", "put kswatch/b", "expire kswatch/b"} {
+		select {
+		case event := <-events:
+			if found := fmt.Sprintf("%v %s", event.Type, event.Key); found != wanted {
+				t.Errorf("wanted %v, got %v", wanted, found)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf("wanted %v", wanted)
+		}
+	}
+	stop()
+	for event := range events {
+		t.Errorf("wanted no more events, got %+v", event)
+	}
+}
+
+func testSubReplace(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("subreplace")
+	conn.SSubPut(key, []byte("a"), []byte("1"))
+	var owner *Node
+	for _, d := range dhashes {
+		if d.node.GetSuccessorFor(key).Addr == d.node.GetBroadcastAddr() {
+			owner = d
+		}
+	}
+	var size int
+	owner.subReplace(common.Batch{
+		Key:       key,
+		Items:     []common.Item{common.Item{SubKey: []byte("b"), Value: []byte("2")}},
+		TTL:       owner.node.Redundancy(),
+		Timestamp: owner.timer.ContinuousTime(),
+		Sync:      true,
+	}, &size)
+	if size != 1 {
+		t.Errorf("wanted size 1, got %v", size)
+	}
+	if _, existed := conn.SubGet(key, []byte("a")); existed {
+		t.Errorf("wanted a to be replaced")
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := 0
+		for _, d := range dhashes {
+			if value, _, existed := d.tree.SubGet(key, []byte("b")); existed && string(value) == "2" {
+				having++
+			}
+		}
+		return fmt.Sprint(having), having == common.Redundancy
+	}, time.Second*10)
+}
+
+func testDrainPrefix(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	for i := 0; i < 50; i++ {
+		conn.SPut([]byte(fmt.Sprintf("drain/%v", i)), []byte(fmt.Sprint(i)))
+	}
+	conn.SPut([]byte("drainage"), []byte("kept"))
+	results := make(chan []common.Item)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- dhashes[0].client().SDrainPrefix([]byte("drain/"))
+		}()
+	}
+	drained := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		for _, item := range <-results {
+			if drained[string(item.Key)] {
+				t.Errorf("%s was drained twice", item.Key)
+			}
+			drained[string(item.Key)] = true
+		}
+	}
+	if len(drained) != 50 {
+		t.Errorf("wanted 50 drained keys, got %v", len(drained))
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := 0
+		for _, d := range dhashes {
+			d.tree.Each(func(key, value []byte, timestamp int64) bool {
+				if bytes.HasPrefix(key, []byte("drain/")) {
+					having++
+				}
+				return true
+			})
+		}
+		return fmt.Sprint(having), having == 0
+	}, time.Second*10)
+	if value, existed := conn.Get([]byte("drainage")); !existed || string(value) != "kept" {
+		t.Errorf("wanted drainage => kept, got %s, %v", value, existed)
+	}
+}
+
+func testDelOlderThan(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("olderthan"), []byte("stale"))
+	if conn.SDelOlderThan([]byte("olderthan"), 0) {
+		t.Errorf("wanted a value written after 0 to survive")
+	}
+	if !conn.SDelOlderThan([]byte("olderthan"), dhashes[0].timer.ContinuousTime()+int64(time.Second)) {
+		t.Errorf("wanted a value written before now to be deleted")
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, []byte("olderthan"), []byte("stale"))
+		return fmt.Sprint(having), having == 0
+	}, time.Second*10)
+	if conn.SDelOlderThan([]byte("olderthan"), dhashes[0].timer.ContinuousTime()+int64(time.Second)) {
+		t.Errorf("wanted nothing to delete the second time")
+	}
+}
+
+func testMExists(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("mexists1"), []byte("1"))
+	conn.SPut([]byte("mexists2"), []byte("2"))
+	keys := [][]byte{[]byte("mexists1"), []byte("mexistsmissing"), []byte("mexists2"), []byte{255, 1}}
+	if present, all := conn.MExists(keys); all || !reflect.DeepEqual(present, []bool{true, false, true, false}) {
+		t.Errorf("wanted [true false true false], false, got %v, %v", present, all)
+	}
+	if present, all := conn.MExists(keys[:1]); !all || !reflect.DeepEqual(present, []bool{true}) {
+		t.Errorf("wanted [true], true, got %v, %v", present, all)
+	}
+}
+
+func testMGet(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	keys := [][]byte{[]byte("mexists1"), []byte("mexistsmissing"), []byte("mexists2"), []byte{255, 1}}
+	values, existed := conn.MGet(keys)
+	if !reflect.DeepEqual(existed, []bool{true, false, true, false}) {
+		t.Errorf("wanted [true false true false], got %v", existed)
+	}
+	if string(values[0]) != "1" || values[1] != nil || string(values[2]) != "2" || values[3] != nil {
+		t.Errorf("wanted [1 nil 2 nil], got %q", values)
+	}
+}
+
+func testMPut(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("mput1"), []byte("old"))
+	keys := [][]byte{[]byte("mput1"), []byte("mput2"), []byte("mput3")}
+	values := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+	old, existed, err := conn.SMPut(keys, values)
+	if err != nil || !reflect.DeepEqual(existed, []bool{true, false, false}) || string(old[0]) != "old" {
+		t.Errorf("wanted [old nil nil], [true false false], nil, got %q, %v, %v", old, existed, err)
+	}
+	for index, key := range keys {
+		common.AssertWithin(t, func() (string, bool) {
+			having := countHaving(t, dhashes, key, values[index])
+			return fmt.Sprint(having), having == common.Redundancy
+		}, time.Second*10)
+	}
+	if _, _, err := conn.SMPut(keys, values[:1]); err == nil {
+		t.Errorf("wanted an error for mismatched keys and values")
+	}
+}
+
+func testPutDurable(t *testing.T, dhashes []*Node) {
+	if !dhashes[0].client().SPutDurable([]byte("durable"), []byte("yes")) {
+		t.Errorf("wanted a put to a healthy node to be durable")
+	}
+}
+
+func testSubPutChanged(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("changed")
+	subKeys := [][]byte{[]byte("a"), []byte("b")}
+	values := [][]byte{[]byte("1"), []byte("2")}
+	if changed, err := conn.SSubPutChanged(key, subKeys, values); err != nil || changed != 2 {
+		t.Errorf("wanted 2, nil, got %v, %v", changed, err)
+	}
+	if changed, err := conn.SSubPutChanged(key, subKeys, values); err != nil || changed != 0 {
+		t.Errorf("wanted 0, nil, got %v, %v", changed, err)
+	}
+	if _, err := conn.SSubPutChanged(key, subKeys, values[:1]); err == nil {
+		t.Errorf("wanted an error for mismatched sub keys and values")
+	}
+}
+
+func testJoin(t *testing.T, dhashes []*Node, port int) []*Node {
+	owned := make(map[string][]byte)
+	var keys [][]byte
+	for _, d := range dhashes {
+		pred := d.node.GetPredecessor()
+		if bytes.Compare(pred.Pos, d.node.GetPosition()) < 0 {
+			var found [][]byte
+			values := make(map[string][]byte)
+			d.tree.EachBetween(pred.Pos, d.node.GetPosition(), true, false, func(key, value []byte, timestamp int64) bool {
+				found = append(found, key)
+				values[string(key)] = value
+				return true
+			})
+			if len(found) > len(keys) {
+				keys, owned = found, values
+			}
+		}
+	}
+	if len(keys) < 2 {
+		t.Fatalf("found no range to join in")
+	}
+	addr := fmt.Sprintf("127.0.0.1:%v", port)
+	os.RemoveAll(addr)
+	joined := NewNode(addr, addr)
+	joined.changePosition(keys[len(keys)/2])
+	joined.MustStart()
+	joined.MustJoin(dhashes[0].GetBroadcastAddr())
+	pred := joined.node.GetPredecessor()
+	seeded := 0
+	for key, value := range owned {
+		if common.BetweenIE([]byte(key), pred.Pos, joined.node.GetPosition()) {
+			if found, _, existed := joined.tree.Get([]byte(key)); !existed || bytes.Compare(found, value) != 0 {
+				t.Errorf("%v should have been seeded with %v => %v, got %v, %v", joined, []byte(key), value, found, existed)
+			}
+			seeded++
+		}
+	}
+	if seeded == 0 {
+		t.Errorf("%v should own some of %v", joined, keys)
+	}
+	dhashes = append(dhashes, joined)
+	// Pings from nodes that haven't heard of the joined node yet may drop it from the rings they reach, until they converge.
+	common.AssertWithin(t, func() (string, bool) {
+		for _, d := range dhashes {
+			if nodes := d.node.Nodes(); len(nodes) != len(dhashes) {
+				return fmt.Sprint(d, nodes.Describe()), false
+			}
+		}
+		return "", true
+	}, time.Second*10)
+	return dhashes
+}
+
+func testKeyBounds(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte{0}, []byte("min"))
+	conn.SPut([]byte{255}, []byte("max"))
+	if key, value, existed := conn.MinKey(); !existed || bytes.Compare(key, []byte{0}) != 0 || string(value) != "min" {
+		t.Errorf("wanted %v => min, got %v => %s, %v", []byte{0}, key, value, existed)
+	}
+	if key, value, existed := conn.MaxKey(); !existed || bytes.Compare(key, []byte{255}) != 0 || string(value) != "max" {
+		t.Errorf("wanted %v => max, got %v => %s, %v", []byte{255}, key, value, existed)
+	}
+	conn.SSubPut([]byte{255, 255}, []byte("sub"), []byte("tree"))
+	if key, value, existed := conn.MaxKey(); !existed || bytes.Compare(key, []byte{255, 255}) != 0 || value != nil {
+		t.Errorf("wanted %v => nil, got %v => %s, %v", []byte{255, 255}, key, value, existed)
+	}
+	for _, d := range dhashes {
+		d.Clear()
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		minKey, _, minExisted := conn.MinKey()
+		maxKey, _, maxExisted := conn.MaxKey()
+		return fmt.Sprint(minKey, maxKey), !minExisted && !maxExisted
+	}, time.Second*10)
+}
+
+func testUsage(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("a"), []byte("1"))
+	conn.SPut([]byte("bb"), []byte("22"))
+	conn.SSubPut([]byte("c"), []byte("d"), []byte("4444"))
+	usage := conn.Usage()
+	if usage.Values != 3 || usage.KeyBytes != 5 || usage.ValueBytes != 7 {
+		t.Errorf("wanted 3 values, 5 key bytes and 7 value bytes, got %+v", usage)
+	}
+	if len(usage.Largest) != 3 || string(usage.Largest[0].Key) != "c" || string(usage.Largest[0].SubKey) != "d" {
+		t.Errorf("wanted c/d to be the largest value, got %+v", usage.Largest)
+	}
+}
+
+func testDBSize(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	before := conn.DBSize()
+	conn.SPut([]byte("dbsize/a"), []byte("1"))
+	conn.SPut([]byte("dbsize/b"), []byte("1"))
+	conn.SSubPut([]byte("dbsize/b"), []byte("x"), []byte("1"))
+	conn.SSubPut([]byte("dbsize/c"), []byte("x"), []byte("1"))
+	if size := conn.DBSize(); size != before+3 {
+		t.Errorf("wanted %v keys, got %v", before+3, size)
+	}
+	conn.SDel([]byte("dbsize/a"))
+	conn.SSubDel([]byte("dbsize/c"), []byte("x"))
+	if size := conn.DBSize(); size != before+1 {
+		t.Errorf("wanted %v keys, got %v", before+1, size)
+	}
+}
+
+func testRandomKey(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	for _, d := range dhashes {
+		var result common.Item
+		d.RandomKey(0, &result)
+		if result.Exists && d.node.GetSuccessorFor(result.Key).Addr != d.node.GetBroadcastAddr() {
+			t.Errorf("wanted %v to return a key it owns, got %s", d.node.GetBroadcastAddr(), result.Key)
+		}
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		key, existed := conn.RandomKey()
+		if !existed {
+			t.Fatalf("wanted a random key")
+		}
+		if _, existed := conn.Get(key); !existed && conn.SubSize(key) == 0 {
+			t.Errorf("wanted %s to exist", key)
+		}
+		seen[string(key)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("wanted more than one random key, got %v", seen)
+	}
+}
+
+func testTypes(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("types_string"), []byte("value"))
+	conn.SRPush([]byte("types_list"), []byte("a"))
+	conn.SSAdd([]byte("types_set"), []byte("a"))
+	conn.SHSet([]byte("types_hash"), []byte("a"), []byte("b"))
+	conn.SZAdd([]byte("types_zset"), []byte("a"), 1)
+	conn.SSubPut([]byte("types_tree"), []byte("a"), []byte("b"))
+	for key, expected := range map[string]string{
+		"types_none":   common.NoneType,
+		"types_string": common.StringType,
+		"types_list":   common.ListType,
+		"types_set":    common.SetType,
+		"types_hash":   common.HashType,
+		"types_zset":   common.ZSetType,
+		"types_tree":   common.TreeType,
+	} {
+		if typ := conn.Type([]byte(key)); typ != expected {
+			t.Errorf("wanted %v to be %v, got %v", key, expected, typ)
+		}
+	}
+	if _, err := conn.SLPush([]byte("types_string"), []byte("a")); err != common.ErrWrongType {
+		t.Errorf("wanted %v, got %v", common.ErrWrongType, err)
+	}
+	if _, err := conn.SRPush([]byte("types_set"), []byte("a")); err != common.ErrWrongType {
+		t.Errorf("wanted %v, got %v", common.ErrWrongType, err)
+	}
+	if err := conn.SSAdd([]byte("types_hash"), []byte("a")); err != common.ErrWrongType {
+		t.Errorf("wanted %v, got %v", common.ErrWrongType, err)
+	}
+	if err := conn.SHSet([]byte("types_zset"), []byte("a"), []byte("b")); err != common.ErrWrongType {
+		t.Errorf("wanted %v, got %v", common.ErrWrongType, err)
+	}
+	if _, err := conn.HIncrBy([]byte("types_list"), []byte("a"), 1); err != common.ErrWrongType {
+		t.Errorf("wanted %v, got %v", common.ErrWrongType, err)
+	}
+	if err := conn.SZAdd([]byte("types_set"), []byte("a"), 1); err != common.ErrWrongType {
+		t.Errorf("wanted %v, got %v", common.ErrWrongType, err)
+	}
+	if _, existed := conn.LPop([]byte("types_set")); existed {
+		t.Errorf("wanted no element popped from a set")
+	}
+	if size := conn.SubSize([]byte("types_set")); size != 1 {
+		t.Errorf("wanted the set to keep its member, got %v members", size)
+	}
+	// Sub trees written with the plain sub tree functions take the kind of the first typed write.
+	if err := conn.SSAdd([]byte("types_tree"), []byte("c")); err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+	if typ := conn.Type([]byte("types_tree")); typ != common.SetType {
+		t.Errorf("wanted %v, got %v", common.SetType, typ)
+	}
+	// Emptied sub trees can be reused for other kinds.
+	conn.LPop([]byte("types_list"))
+	if err := conn.SSAdd([]byte("types_list"), []byte("a")); err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+	if typ := conn.Type([]byte("types_list")); typ != common.SetType {
+		t.Errorf("wanted %v, got %v", common.SetType, typ)
+	}
+}
+
+func testChunking(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SetChunkSize(1024)
+	key := []byte("chunked")
+	value := make([]byte, 10000)
+	for index := range value {
+		value[index] = byte(index)
+	}
+	before := conn.DBSize()
+	conn.SPut(key, value)
+	if found, existed := conn.Get(key); !existed || !bytes.Equal(found, value) {
+		t.Errorf("wanted %v bytes, got %v bytes", len(value), len(found))
+	}
+	if raw, _ := dhashes[0].client().Get(key); !bytes.Equal(raw, value) {
+		t.Errorf("wanted a Conn without chunking to reassemble the value too")
+	}
+	if size := conn.DBSize(); size != before+11 {
+		t.Errorf("wanted the value and its 10 chunks to add 11 keys, got %v more", size-before)
+	}
+	conn.SPut(key, []byte("small"))
+	if found, _ := conn.Get(key); string(found) != "small" {
+		t.Errorf("wanted small, got %v bytes", len(found))
+	}
+	conn.SPut(key, value)
+	conn.SDel(key)
+	if _, existed := conn.Get(key); existed {
+		t.Errorf("wanted %s to be deleted", key)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		size := conn.DBSize()
+		return fmt.Sprint(size), size == before
+	}, time.Second*10)
+}
+
+func testGetMeta(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("meta")
+	if meta := conn.GetMeta(key); meta.Exists {
+		t.Errorf("wanted no meta for a missing key, got %+v", meta)
+	}
+	conn.SPut(key, []byte("value"))
+	_, version, _ := conn.GetVersion(key)
+	meta := conn.GetMeta(key)
+	if !meta.Exists || meta.Timestamp != version || meta.Size != 5 {
+		t.Errorf("wanted timestamp %v and size 5, got %+v", version, meta)
+	}
+	conn.SPut(key, []byte("other value"))
+	if newer := conn.GetMeta(key); newer.Timestamp <= meta.Timestamp || newer.Size != 11 {
+		t.Errorf("wanted a timestamp after %v and size 11, got %+v", meta.Timestamp, newer)
+	}
+}
+
+func testReadRepair(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("readrepair")
+	conn.SPut(key, []byte("recent"))
+	_, version, _ := conn.GetVersion(key)
+	var replica *Node
+	for _, d := range dhashes {
+		if _, isOwner := d.owners(key); isOwner && d.node.GetSuccessorFor(key).Addr != d.GetBroadcastAddr() {
+			replica = d
+			break
+		}
+	}
+	var repaired bool
+	replica.Repair(common.Item{Key: key, Value: []byte("older"), Exists: true, Timestamp: version - 1}, &repaired)
+	if repaired {
+		t.Errorf("wanted %v to keep the more recent value", replica)
+	}
+	replica.tree.Put(key, []byte("stale"), version-1)
+	if value, _ := conn.Get(key); string(value) != "recent" {
+		t.Errorf("wanted recent, got %q", value)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		value, _, _ := replica.tree.Get(key)
+		return string(value), string(value) == "recent"
+	}, time.Second*10)
+	conn.SDel(key)
+	replica.tree.Put(key, []byte("stale"), version)
+	if _, existed := conn.Get(key); existed {
+		t.Errorf("wanted %s to be deleted", key)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		value, _, existed := replica.tree.Get(key)
+		return string(value), !existed
+	}, time.Second*10)
+}
+
+func testStrLen(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	if length := conn.StrLen([]byte("strlen/missing")); length != 0 {
+		t.Errorf("wanted 0 for a missing value, got %v", length)
+	}
+	conn.SPut([]byte("strlen/plain"), []byte("hello"))
+	if length := conn.StrLen([]byte("strlen/plain")); length != 5 {
+		t.Errorf("wanted 5, got %v", length)
+	}
+	conn.SetChunkSize(4)
+	conn.SPut([]byte("strlen/chunked"), []byte("0123456789"))
+	if length := conn.StrLen([]byte("strlen/chunked")); length != 10 {
+		t.Errorf("wanted 10 for a chunked value, got %v", length)
+	}
+}
+
+func testMetrics(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	var before common.Metrics
+	for _, metrics := range conn.Metrics() {
+		before.Merge(metrics)
+	}
+	conn.SPut([]byte("metrics"), []byte("value"))
+	conn.Get([]byte("metrics"))
+	conn.Get([]byte("metrics missing"))
+	var after common.Metrics
+	metrics := conn.Metrics()
+	if len(metrics) != len(dhashes) {
+		t.Fatalf("wanted metrics for %v nodes, got %+v", len(dhashes), metrics)
+	}
+	for _, m := range metrics {
+		after.Merge(m)
+	}
+	if after.Hits < before.Hits+1 || after.Misses < before.Misses+1 {
+		t.Errorf("wanted at least one more hit and miss than %+v, got %+v", before, after)
+	}
+	if after.Calls["DHash.Put"] < before.Calls["DHash.Put"]+1 || after.Calls["DHash.Get"] < before.Calls["DHash.Get"]+2 {
+		t.Errorf("wanted at least one more DHash.Put and two more DHash.Get calls than %v, got %v", before.Calls, after.Calls)
+	}
+	if err := conn.SAdd([]byte("metrics"), []byte("member")); err == nil {
+		t.Errorf("wanted adding to a set under a byte value to fail")
+	}
+	errors := int64(0)
+	for _, m := range conn.Metrics() {
+		errors += m.Errors["DHash.SAdd"]
+	}
+	if errors < before.Errors["DHash.SAdd"]+1 {
+		t.Errorf("wanted the failed DHash.SAdd to be counted, got %v", errors)
+	}
+}
+
+func testExpvar(t *testing.T, dhashes []*Node) {
+	var vars map[string]NodeVars
+	if err := json.Unmarshal([]byte(expvar.Get("god").String()), &vars); err != nil {
+		t.Fatalf("wanted the published vars to be JSON, got %v", err)
+	}
+	for _, d := range dhashes {
+		if v, found := vars[d.GetBroadcastAddr()]; !found || v.Stats.Addr != d.GetBroadcastAddr() || v.Metrics.Calls == nil {
+			t.Errorf("wanted vars for %v in %+v", d.GetBroadcastAddr(), vars)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to write to from the goroutines of a node while a test reads it.
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (self *lockedBuffer) Write(b []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.buf.Write(b)
+}
+func (self *lockedBuffer) String() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.buf.String()
+}
+
+func testAudit(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	buffers := make([]*lockedBuffer, len(dhashes))
+	for index, d := range dhashes {
+		buffers[index] = &lockedBuffer{}
+		d.Audit(buffers[index])
+	}
+	conn.Get([]byte("audited"))
+	conn.SPut([]byte("audited"), []byte("value"))
+	conn.Incr([]byte("audited counter"))
+	for _, d := range dhashes {
+		d.Audit(nil)
+	}
+	conn.SPut([]byte("unaudited"), []byte("value"))
+	conn.Del([]byte("audited counter"))
+	var records []AuditRecord
+	for _, buffer := range buffers {
+		decoder := json.NewDecoder(strings.NewReader(buffer.String()))
+		for {
+			var record AuditRecord
+			if err := decoder.Decode(&record); err != nil {
+				break
+			}
+			records = append(records, record)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("wanted an audited put and increment, got %+v", records)
+	}
+	methods := map[string]string{}
+	for _, record := range records {
+		if record.Caller == "" || record.Time.IsZero() || record.Error != "" {
+			t.Errorf("wanted a caller and time without error, got %+v", record)
+		}
+		methods[record.Method] = string(record.Key)
+	}
+	if expected := map[string]string{"DHash.Put": "audited", "DHash.AddInt64": "audited counter"}; !reflect.DeepEqual(methods, expected) {
+		t.Errorf("wanted %v, got %+v", expected, records)
+	}
+}
+
+func testSlowLog(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	for _, d := range dhashes {
+		d.LogSlowerThan(0, 4)
+	}
+	defer func() {
+		for _, d := range dhashes {
+			d.LogSlowerThan(slowThreshold, slowLogSize)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		conn.SPut([]byte("slow"), []byte(fmt.Sprint(i)))
+	}
+	ops := conn.SlowLog()
+	if len(ops) > 4*len(dhashes) {
+		t.Errorf("wanted at most 4 slow calls per node, got %v", len(ops))
+	}
+	found := false
+	for index, op := range ops {
+		if index > 0 && op.Took > ops[index-1].Took {
+			t.Errorf("wanted the slowest calls first, got %+v", ops)
+		}
+		if op.Method == "DHash.Put" && string(op.Key) == "slow" && op.Addr != "" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("wanted a DHash.Put of slow among %+v", ops)
+	}
+	conn.ResetSlowLog()
+	for _, d := range dhashes {
+		d.LogSlowerThan(time.Hour, 4)
+	}
+	conn.SPut([]byte("slow"), []byte("fast"))
+	if ops := conn.SlowLog(); len(ops) != 0 {
+		t.Errorf("wanted no slow calls after resetting with a threshold of an hour, got %+v", ops)
+	}
+}
+
+func testReadView(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("view/a"), []byte("1"))
+	conn.SPut([]byte("view/b"), []byte("1"))
+	// Leave room for the clocks of the nodes to differ.
+	time.Sleep(time.Millisecond * 100)
+	view := conn.ReadView()
+	time.Sleep(time.Millisecond * 100)
+	conn.SPut([]byte("view/a"), []byte("2"))
+	conn.SDel([]byte("view/b"))
+	conn.SPut([]byte("view/c"), []byte("1"))
+	for key, expected := range map[string]string{"view/a": "1", "view/b": "1", "view/c": ""} {
+		if value, existed, err := view.Get([]byte(key)); err != nil || string(value) != expected || existed != (expected != "") {
+			t.Errorf("wanted %v under %v in the view, got %s, %v, %v", expected, key, value, existed, err)
+		}
+	}
+	if value, _ := conn.Get([]byte("view/a")); string(value) != "2" {
+		t.Errorf("wanted 2 under view/a, got %s", value)
+	}
+	if items, err := view.ScanPrefix([]byte("view/")); err != nil || fmt.Sprint(items) != fmt.Sprint([]common.Item{
+		{Key: []byte("view/a"), Value: []byte("1"), Timestamp: items[0].Timestamp},
+		{Key: []byte("view/b"), Value: []byte("1"), Timestamp: items[1].Timestamp},
+	}) {
+		t.Errorf("wanted view/a and view/b, got %v, %v", items, err)
+	}
+	var found []string
+	keys, _, err := view.Scan(nil, 1<<20)
+	for _, key := range keys {
+		if bytes.HasPrefix(key, []byte("view/")) {
+			found = append(found, string(key))
+		}
+	}
+	if err != nil || fmt.Sprint(found) != "[view/a view/b]" {
+		t.Errorf("wanted [view/a view/b], got %v, %v", found, err)
+	}
+	old := &client.ReadView{}
+	*old = *view
+	old.At -= int64(readViewLifetime * 2)
+	if _, _, err := old.Get([]byte("view/a")); err != common.ErrSnapshotTooOld {
+		t.Errorf("wanted %v, got %v", common.ErrSnapshotTooOld, err)
+	}
+}
+
+func testStats(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("stats"), []byte("value"))
+	stats := conn.Stats()
+	if len(stats) != len(dhashes) {
+		t.Fatalf("wanted stats for %v nodes, got %+v", len(dhashes), stats)
+	}
+	// Replication and cleanup from earlier tests may still change the entries between counting the owned and held ones.
+	common.AssertWithin(t, func() (string, bool) {
+		for _, s := range conn.Stats() {
+			if s.Addr == "" || s.HeldEntries < s.OwnedEntries || s.HeldEntries > 0 && s.Bytes == 0 {
+				return fmt.Sprintf("%+v", s), false
+			}
+		}
+		return "", true
+	}, time.Second*10)
+	owned := 0
+	for _, s := range stats {
+		owned += s.OwnedEntries
+	}
+	if owned < conn.Size() {
+		t.Errorf("wanted the nodes to own at least %v entries, got %+v", conn.Size(), stats)
+	}
+}
+
+func testMaxBytes(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.Clear()
+	limit := 200000
+	for _, d := range dhashes {
+		d.MaxBytes(limit)
+	}
+	defer func() {
+		for _, d := range dhashes {
+			d.MaxBytes(0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		conn.Put([]byte(fmt.Sprint("evict", i)), make([]byte, 10000))
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		evicted := int64(0)
+		for _, d := range dhashes {
+			if size := d.ownedDataSize(); size > limit {
+				return fmt.Sprintf("%v owns %v bytes", d.GetBroadcastAddr(), size), false
+			}
+		}
+		for _, s := range conn.Stats() {
+			evicted += s.Evicted
+		}
+		return fmt.Sprint(evicted), evicted > 0 && conn.Size() < 100
+	}, time.Second*10)
+	conn.Clear()
+}
+
+func testNoEviction(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.Clear()
+	evicted := make(map[string]int64)
+	for _, s := range conn.Stats() {
+		evicted[s.Addr] = s.Evicted
+	}
+	for _, d := range dhashes {
+		d.Evict(NoEviction).MaxBytes(1)
+	}
+	defer func() {
+		for _, d := range dhashes {
+			d.Evict(NewLRU()).MaxBytes(0)
+		}
+	}()
+	if err := conn.PutExpire([]byte("full"), []byte("value"), time.Minute); err == nil {
+		t.Errorf("wanted an error putting in full nodes")
+	}
+	if _, existed := conn.Get([]byte("full")); existed {
+		t.Errorf("wanted no value put in full nodes")
+	}
+	for _, s := range conn.Stats() {
+		if s.Evicted != evicted[s.Addr] {
+			t.Errorf("wanted nothing to be evicted, got %+v", s)
+		}
+	}
+}
+
+func testNamespaces(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.Clear()
+	db1, db2 := conn.Select("one"), conn.Select("two")
+	conn.SPut([]byte("a"), []byte("plain"))
+	for i := 0; i < 10; i++ {
+		db1.SPut([]byte(fmt.Sprint("a", i)), []byte("one"))
+		db2.SPut([]byte(fmt.Sprint("a", i)), []byte("two"))
+	}
+	db1.SubPut([]byte("tree"), []byte("sub"), []byte("one"))
+	if value, existed := db1.Get([]byte("a0")); !existed || string(value) != "one" {
+		t.Errorf("wanted one, got %s, %v", value, existed)
+	}
+	if value, existed := conn.Get([]byte("a")); !existed || string(value) != "plain" {
+		t.Errorf("wanted plain, got %s, %v", value, existed)
+	}
+	if _, existed := db1.Get([]byte("a")); existed {
+		t.Errorf("wanted a to be missing in db one")
+	}
+	keys, next := db2.Scan(nil, 5)
+	if len(keys) != 5 || string(keys[0]) != "a0" || string(next) != "a4" {
+		t.Errorf("wanted a0-a4, got %s, %s", keys, next)
+	}
+	if keys, next = db2.Scan(next, 10); len(keys) != 5 || string(keys[4]) != "a9" || next != nil {
+		t.Errorf("wanted a5-a9, got %s, %s", keys, next)
+	}
+	if items := db1.ScanPrefix([]byte("a")); len(items) != 10 || string(items[0].Key) != "a0" {
+		t.Errorf("wanted a0-a9, got %v", items)
+	}
+	if deleted := db1.Flush(); deleted != 11 {
+		t.Errorf("wanted 11 deleted values, got %v", deleted)
+	}
+	if keys, _ := db1.Scan(nil, 10); len(keys) != 0 || db1.SubSize([]byte("tree")) != 0 {
+		t.Errorf("wanted db one to be empty, got %s", keys)
+	}
+	if keys, _ := db2.Scan(nil, 20); len(keys) != 10 {
+		t.Errorf("wanted db two to keep its keys, got %s", keys)
+	}
+	if _, existed := conn.Get([]byte("a")); !existed {
+		t.Errorf("wanted a to survive flushing db one")
+	}
+	conn.Clear()
+}
+
+func testIndexes(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.Clear()
+	conn.SPut([]byte("before"), []byte(`{"color": "red"}`))
+	conn.AddIndex("color", "color")
+	defer conn.AddIndex("color", "")
+	conn.SPut([]byte("apple"), []byte(`{"color": "red", "size": 3}`))
+	conn.SPut([]byte("banana"), []byte(`{"color": "yellow"}`))
+	conn.SPut([]byte("cherry"), []byte(`{"color": "red"}`))
+	conn.SPut([]byte("plain"), []byte("red"))
+	assertKeys := func(value string, expected ...string) {
+		common.AssertWithin(t, func() (string, bool) {
+			found := conn.Query("color", []byte(value))
+			return fmt.Sprintf("%s", found), fmt.Sprintf("%s", found) == fmt.Sprintf("%s", expected)
+		}, time.Second*5)
+	}
+	assertKeys("red", "apple", "before", "cherry")
+	assertKeys("yellow", "banana")
+	conn.SPut([]byte("cherry"), []byte(`{"color": "black"}`))
+	conn.SDel([]byte("apple"))
+	assertKeys("red", "before")
+	assertKeys("black", "cherry")
+	conn.SMPut([][]byte{[]byte("fig"), []byte("grape")}, [][]byte{[]byte(`{"color": "red"}`), []byte(`{"color": "green"}`)})
+	conn.SCompareAndSwap([]byte("banana"), []byte(`{"color": "yellow"}`), []byte(`{"color": "red"}`))
+	conn.SPutExpire([]byte("date"), []byte(`{"color": "red"}`), time.Millisecond*100)
+	assertKeys("red", "banana", "before", "fig")
+	assertKeys("yellow")
+	assertKeys("green", "grape")
+	conn.Clear()
+}
+
+func testSortedSets(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("leaderboard")
+	for index, member := range []string{"carol", "alice", "dave", "bob"} {
+		conn.SZAdd(key, []byte(member), float64(index*10-15))
+	}
+	conn.SZAdd(key, []byte("alice"), 100)
+	conn.SZAdd(key, []byte("erin"), -15)
+	assertMembers := func(found []client.ScoredMember, expected ...string) {
+		var members []string
+		for _, member := range found {
+			members = append(members, fmt.Sprintf("%s:%v", member.Member, member.Score))
+		}
+		if fmt.Sprint(members) != fmt.Sprint(expected) {
+			t.Errorf("wanted %v, got %v", expected, members)
+		}
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		found := conn.ZRange(key, 0, 10)
+		return fmt.Sprint(found), len(found) == 5
+	}, time.Second*10)
+	assertMembers(conn.ZRange(key, 0, 10), "carol:-15", "erin:-15", "dave:5", "bob:15", "alice:100")
+	assertMembers(conn.ZRange(key, 1, 2), "erin:-15", "dave:5")
+	assertMembers(conn.ZRangeByScore(key, -15, 15), "carol:-15", "erin:-15", "dave:5", "bob:15")
+	assertMembers(conn.ZRangeByScore(key, 0, 50), "dave:5", "bob:15")
+	if rank, existed := conn.ZRank(key, []byte("bob")); !existed || rank != 3 {
+		t.Errorf("wanted bob at rank 3, got %v, %v", rank, existed)
+	}
+	if score, existed := conn.ZScore(key, []byte("alice")); !existed || score != 100 {
+		t.Errorf("wanted alice to score 100, got %v, %v", score, existed)
+	}
+	conn.ZRem(key, []byte("dave"))
+	common.AssertWithin(t, func() (string, bool) {
+		_, existed := conn.ZRank(key, []byte("dave"))
+		return fmt.Sprint(conn.ZRange(key, 0, 10)), !existed && len(conn.ZRange(key, 0, 10)) == 4
+	}, time.Second*10)
+}
+
+func testLists(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("queue")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				conn.SRPush(key, []byte(fmt.Sprint("r", i)))
+			} else {
+				conn.SLPush(key, []byte(fmt.Sprint("l", i)))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if length := conn.LLen(key); length != 20 {
+		t.Errorf("wanted 20 elements after concurrent pushes, got %v", length)
+	}
+	conn.SubClear(key)
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(conn.LRange(key, 0, 100)), len(conn.LRange(key, 0, 100)) == 0
+	}, time.Second*10)
+	if length, err := conn.SRPush(key, []byte("b")); err != nil || length != 1 {
+		t.Errorf("wanted length 1, got %v, %v", length, err)
+	}
+	conn.SRPush(key, []byte("c"))
+	if length, err := conn.SLPush(key, []byte("a")); err != nil || length != 3 {
+		t.Errorf("wanted length 3, got %v, %v", length, err)
+	}
+	assertRange := func(start, stop int, expected ...string) {
+		var found []string
+		for _, value := range conn.LRange(key, start, stop) {
+			found = append(found, string(value))
+		}
+		if fmt.Sprint(found) != fmt.Sprint(expected) {
+			t.Errorf("wanted %v, got %v", expected, found)
+		}
+	}
+	assertRange(0, 10, "a", "b", "c")
+	assertRange(1, 1, "b")
+	if value, existed := conn.LPop(key); !existed || string(value) != "a" {
+		t.Errorf("wanted to pop a, got %s, %v", value, existed)
+	}
+	if value, existed := conn.RPop(key); !existed || string(value) != "c" {
+		t.Errorf("wanted to pop c, got %s, %v", value, existed)
+	}
+	assertRange(0, 10, "b")
+	conn.RPop(key)
+	if value, existed := conn.LPop(key); existed {
+		t.Errorf("wanted nothing to pop, got %s", value)
+	}
+}
+
+func testBlockingPops(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("blockingpops")
+	start := time.Now()
+	if value, existed := conn.BLPop(key, time.Millisecond*100); existed {
+		t.Errorf("wanted nothing to pop, got %s", value)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*100 {
+		t.Errorf("wanted to wait for the timeout, waited %v", elapsed)
+	}
+	popped := make(chan string)
+	for i := 0; i < 2; i++ {
+		go func() {
+			value, _ := conn.BLPop(key, 0)
+			popped <- string(value)
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+	conn.SRPush(key, []byte("a"))
+	conn.SRPush(key, []byte("b"))
+	found := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case value := <-popped:
+			found[value] = true
+		case <-time.After(time.Second * 10):
+			t.Fatalf("wanted the waiting pops to get the pushed elements")
+		}
+	}
+	if !found["a"] || !found["b"] {
+		t.Errorf("wanted a and b to be popped, got %v", found)
+	}
+	conn.SRPush(key, []byte("c"))
+	conn.SRPush(key, []byte("d"))
+	if value, existed := conn.BRPop(key, time.Second); !existed || string(value) != "d" {
+		t.Errorf("wanted to pop d, got %s, %v", value, existed)
+	}
+	if value, existed := conn.BLPop(key, time.Second); !existed || string(value) != "c" {
+		t.Errorf("wanted to pop c, got %s, %v", value, existed)
+	}
+	conn.SRPush(key, []byte("e"))
+	for _, d := range dhashes {
+		d.lock.RLock()
+		listeners := len(d.channelListeners[pushChannel+string(key)])
+		d.lock.RUnlock()
+		if listeners > 0 {
+			t.Errorf("wanted the finished pops to stop listening in %v, got %v listeners", d.GetBroadcastAddr(), listeners)
+		}
+	}
+	conn.LPop(key)
+}
+
+func testDumpRestore(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("dump/a"), []byte("value"))
+	conn.SSubPut([]byte("dump/a"), []byte("x"), []byte("1"))
+	conn.SSubPut([]byte("dump/a"), []byte("y"), []byte("2"))
+	if dump := conn.DumpKey([]byte("dump/missing")); dump != nil {
+		t.Errorf("wanted no dump of a missing key, got %v", dump)
+	}
+	dump := conn.DumpKey([]byte("dump/a"))
+	assertRestored := func() {
+		if value, existed := conn.Get([]byte("dump/b")); !existed || string(value) != "value" {
+			t.Errorf("wanted value under dump/b, got %s, %v", value, existed)
+		}
+		if size := conn.SubSize([]byte("dump/b")); size != 2 {
+			t.Errorf("wanted 2 sub values under dump/b, got %v", size)
+		}
+		if value, existed := conn.SubGet([]byte("dump/b"), []byte("y")); !existed || string(value) != "2" {
+			t.Errorf("wanted 2 under dump/b/y, got %s, %v", value, existed)
+		}
+	}
+	if err := conn.SRestoreKey([]byte("dump/b"), dump, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertRestored()
+	conn.SPut([]byte("dump/b"), []byte("changed"))
+	conn.SSubPut([]byte("dump/b"), []byte("z"), []byte("3"))
+	if err := conn.SRestoreKey([]byte("dump/b"), dump, false); err == nil {
+		t.Errorf("wanted restoring over an existing key to fail without replace")
+	}
+	if err := conn.SRestoreKey([]byte("dump/b"), dump, true); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertRestored()
+	if err := conn.SRestoreKey([]byte("dump/c"), []byte("garbage"), false); err == nil {
+		t.Errorf("wanted restoring garbage to fail")
+	}
+}
+
+func testSets(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	for _, member := range []string{"c", "a", "b"} {
+		conn.SSAdd([]byte("set"), []byte(member))
+	}
+	var members []string
+	for _, member := range conn.SMembers([]byte("set")) {
+		members = append(members, string(member))
+	}
+	if fmt.Sprint(members) != "[a b c]" {
+		t.Errorf("wanted [a b c], got %v", members)
+	}
+	if !conn.SIsMember([]byte("set"), []byte("a")) || conn.SIsMember([]byte("set"), []byte("d")) {
+		t.Errorf("wanted a and not d in the set")
+	}
+	conn.SRem([]byte("set"), []byte("a"))
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(conn.SCard([]byte("set"))), !conn.SIsMember([]byte("set"), []byte("a")) && conn.SCard([]byte("set")) == 2
+	}, time.Second*10)
+}
+
+func testHashes(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("user")
+	conn.SHSet(key, []byte("name"), []byte("ann"))
+	conn.SHSet(key, []byte("email"), []byte("ann@example.com"))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.HIncrBy(key, []byte("visits"), 2); err != nil {
+				t.Errorf("%v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if value, err := conn.HIncrBy(key, []byte("visits"), -5); err != nil || value != 15 {
+		t.Errorf("wanted 15 visits, got %v, %v", value, err)
+	}
+	if _, err := conn.HIncrBy(key, []byte("name"), 1); err == nil {
+		t.Errorf("wanted an error incrementing a field that isn't a counter")
+	}
+	if value, existed := conn.HGet(key, []byte("name")); !existed || string(value) != "ann" {
+		t.Errorf("wanted ann, got %s, %v", value, existed)
+	}
+	conn.HDel(key, []byte("email"))
+	common.AssertWithin(t, func() (string, bool) {
+		all := conn.HGetAll(key)
+		return fmt.Sprint(all), len(all) == 2 && string(all["name"]) == "ann" && common.MustDecodeInt64(all["visits"]) == 15
+	}, time.Second*10)
+}
+
+func testBitmaps(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("active")
+	for _, offset := range []int64{0, 7, 9, 1000} {
+		if old, err := conn.SSetBit(key, offset, true); err != nil || old {
+			t.Errorf("wanted to set a cleared bit at %v, got %v, %v", offset, old, err)
+		}
+	}
+	if old, err := conn.SSetBit(key, 9, true); err != nil || !old {
+		t.Errorf("wanted the bit at 9 to be set already, got %v, %v", old, err)
+	}
+	if value, _ := conn.Get(key); len(value) != 126 || value[0] != 0x81 || value[1] != 0x40 {
+		t.Errorf("wanted 126 bytes starting with 0x81 0x40, got %v bytes starting with %v", len(value), value[:2])
+	}
+	if count := conn.BitCount(key); count != 4 {
+		t.Errorf("wanted 4 set bits, got %v", count)
+	}
+	conn.SSetBit(key, 7, false)
+	for offset, expected := range map[int64]bool{0: true, 7: false, 8: false, 9: true, 1000: true, 5000: false} {
+		if bit, err := conn.GetBit(key, offset); err != nil || bit != expected {
+			t.Errorf("wanted %v at %v, got %v, %v", expected, offset, bit, err)
+		}
+	}
+	if count := conn.BitCount(key); count != 3 {
+		t.Errorf("wanted 3 set bits, got %v", count)
+	}
+	if _, err := conn.SSetBit(key, -1, true); err == nil {
+		t.Errorf("wanted an error for a negative offset")
+	}
+	if count := conn.BitCount([]byte("missing bitmap")); count != 0 {
+		t.Errorf("wanted no set bits in a missing bitmap, got %v", count)
+	}
+}
+
+func testPerformAll(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("perform/a"), []byte("1"))
+	responses, err := conn.SPerformAll([]common.Operation{
+		{Method: "Get", Key: []byte("perform/a")},
+		{Method: "Put", Key: []byte("perform/a"), Value: []byte("2")},
+		{Method: "Get", Key: []byte("perform/a")},
+		{Method: "Put", Key: []byte("perform/b"), Value: []byte("3")},
+		{Method: "SubPut", Key: []byte("perform/c"), SubKey: []byte("x"), Value: []byte("4")},
+		{Method: "SubGet", Key: []byte("perform/c"), SubKey: []byte("x")},
+		{Method: "Del", Key: []byte("perform/b")},
+		{Method: "Get", Key: []byte("perform/b")},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	wanted := []string{"1", "1", "2", "", "", "4", "3", ""}
+	for index, response := range responses {
+		if string(response.Value) != wanted[index] || response.Exists != (wanted[index] != "") {
+			t.Errorf("wanted %q from operation %v, got %+v", wanted[index], index, response)
+		}
+	}
+	if value, _ := conn.Get([]byte("perform/a")); string(value) != "2" {
+		t.Errorf("wanted 2, got %q", value)
+	}
+	if _, existed := conn.Get([]byte("perform/b")); existed {
+		t.Errorf("wanted perform/b to be deleted")
+	}
+	if value, _ := conn.SubGet([]byte("perform/c"), []byte("x")); string(value) != "4" {
+		t.Errorf("wanted 4, got %q", value)
+	}
+	if _, err = conn.SPerformAll([]common.Operation{{Method: "Clear", Key: []byte("perform/a")}}); err == nil {
+		t.Errorf("wanted an error performing an unknown method")
+	}
+}
+
+func testTransactions(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.SPut([]byte("tx-gone"), []byte("x"))
+	tx := conn.Multi()
+	for i := 0; i < 20; i++ {
+		tx.Put([]byte(fmt.Sprint("tx-", i)), []byte(fmt.Sprint(i)))
+	}
+	tx.Del([]byte("tx-gone")).SubPut([]byte("tx-tree"), []byte("a"), []byte("1")).SubPut([]byte("tx-tree"), []byte("b"), []byte("2")).SubDel([]byte("tx-tree"), []byte("a"))
+	if tx.Len() != 24 {
+		t.Errorf("wanted 24 queued operations, got %v", tx.Len())
+	}
+	if err := tx.SExec(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for i := 0; i < 20; i++ {
+		key, value := []byte(fmt.Sprint("tx-", i)), []byte(fmt.Sprint(i))
+		if having := countHaving(t, dhashes, key, value); having != common.Redundancy {
+			t.Errorf("wanted %v nodes to have %s => %s, got %v", common.Redundancy, key, value, having)
+		}
+	}
+	if _, existed := conn.Get([]byte("tx-gone")); existed {
+		t.Errorf("wanted tx-gone to be deleted")
+	}
+	if items := conn.Slice([]byte("tx-tree"), nil, nil, true, true); len(items) != 1 || string(items[0].Key) != "b" {
+		t.Errorf("wanted only b in tx-tree, got %v", items)
+	}
+}
+
+func testWatch(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	key := []byte("watched")
+	conn.SPut(key, []byte("1"))
+	_, version, existed := conn.GetVersion(key)
+	if !existed || version == 0 {
+		t.Fatalf("wanted a version, got %v, %v", version, existed)
+	}
+	if err := conn.Multi().Watch(key, version).Put(key, []byte("2")).SExec(); err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+	if err := conn.Multi().Watch(key, version).Put(key, []byte("3")).SExec(); err != common.ErrWatchChanged {
+		t.Errorf("wanted %v, got %v", common.ErrWatchChanged, err)
+	}
+	if value, _ := conn.Get(key); string(value) != "2" {
+		t.Errorf("wanted 2, got %s", value)
+	}
+	if err := conn.Multi().Watch([]byte("watched-missing"), 0).Put([]byte("watched-missing"), []byte("x")).SExec(); err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+	tx := conn.Multi().Watch(key, version)
+	for i := 0; i < 20; i++ {
+		other := []byte(fmt.Sprint("watched-", i))
+		_, otherVersion, _ := conn.GetVersion(other)
+		tx.Watch(other, otherVersion).Put(other, []byte("x"))
+	}
+	if err := tx.SExec(); err != common.ErrWatchChanged {
+		t.Errorf("wanted %v, got %v", common.ErrWatchChanged, err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, existed := conn.Get([]byte(fmt.Sprint("watched-", i))); existed {
+			t.Errorf("wanted nothing to be applied when a watch on another node failed")
+		}
+	}
+}
+
+func testScripts(t *testing.T, dhashes []*Node) {
+	for _, d := range dhashes {
+		d.AddScript("incr", func(ctx *ScriptContext, args [][]byte) ([]byte, error) {
+			count := int64(0)
+			if value, existed := ctx.Get(args[0]); existed {
+				count = common.MustDecodeInt64(value)
+			}
+			count++
+			ctx.Put(args[0], common.EncodeInt64(count))
+			return common.EncodeInt64(count), nil
+		})
+	}
+	conn := dhashes[0].client()
+	key := []byte("scripted")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.SEval("incr", key, key); err != nil {
+				t.Errorf("%v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := conn.Get(key); common.MustDecodeInt64(value) != 20 {
+		t.Errorf("wanted 20 after 20 concurrent increments, got %v", common.MustDecodeInt64(value))
+	}
+	if _, err := conn.Eval("missing", key); err == nil {
+		t.Errorf("wanted an error running a missing script")
+	}
+	owner := dhashes[0].node.GetSuccessorFor(key)
+	for i := 0; i < 100; i++ {
+		other := []byte(fmt.Sprint("scripted-", i))
+		if dhashes[0].node.GetSuccessorFor(other).Addr != owner.Addr {
+			if _, err := conn.Eval("incr", key, other); err == nil {
+				t.Errorf("wanted an error using a key owned by another node")
+			}
+			break
+		}
+	}
+}
+
+func testExportJSON(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	conn.Clear()
+	expected := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		conn.SPut([]byte(fmt.Sprint("export", i)), []byte(fmt.Sprint(i)))
+		expected[fmt.Sprint("export", i)] = fmt.Sprint(i)
+	}
+	conn.SSubPut([]byte("exporttree"), []byte("sub"), []byte("value"))
+	buf := new(bytes.Buffer)
+	if exported, err := conn.ExportJSON(buf); err != nil || exported != 101 {
+		t.Fatalf("wanted 101 exported values, got %v, %v", exported, err)
+	}
+	conn.Clear()
+	if imported, err := conn.ImportJSON(buf); err != nil || imported != 101 {
+		t.Fatalf("wanted 101 imported values, got %v, %v", imported, err)
+	}
+	for key, value := range expected {
+		if found, existed := conn.Get([]byte(key)); !existed || string(found) != value {
+			t.Errorf("wanted %v => %v after importing, got %s, %v", key, value, found, existed)
+		}
+	}
+	if found, existed := conn.SubGet([]byte("exporttree"), []byte("sub")); !existed || string(found) != "value" {
+		t.Errorf("wanted exporttree/sub => value after importing, got %s, %v", found, existed)
+	}
+}
+
+func testImportRDB(t *testing.T, dhashes []*Node) {
+	conn := dhashes[0].client()
+	// A dump with a single string value, type 0, followed by the end of file opcode.
+	dump := bytes.NewBufferString("REDIS0009")
+	dump.WriteByte(0)
+	for _, s := range []string{"rdbkey", "rdbvalue"} {
+		dump.WriteByte(byte(len(s)))
+		dump.WriteString(s)
+	}
+	dump.WriteByte(0xff)
+	if imported, skipped, err := conn.ImportRDB(dump); err != nil || imported != 1 || skipped != 0 {
+		t.Fatalf("wanted 1 imported and 0 skipped values, got %v, %v, %v", imported, skipped, err)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		value, existed := conn.Get([]byte("rdbkey"))
+		return fmt.Sprintf("%s, %v", value, existed), existed && string(value) == "rdbvalue"
+	}, time.Second*10)
+}
+
+func TestHintedHandoff(t *testing.T) {
+	dhashes := testStartup(t, 3, 10291)
+	defer stopServers(dhashes)
+	key := []byte("handoff")
+	var owner *Node
+	for _, d := range dhashes {
+		if d.node.GetSuccessorFor(key).Addr == d.node.GetBroadcastAddr() {
+			owner = d
+		}
+	}
+	victimAddr := owner.node.GetSuccessor().Addr
+	for _, d := range dhashes {
+		if d.GetBroadcastAddr() == victimAddr {
+			d.Stop()
+		}
+	}
+	common.Switch.Close(victimAddr)
+	if _, err := owner.Put(common.Item{Key: key, Value: []byte("hinted"), Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(victimAddr)
+	// Only the discord.Node of the revived replica is started, since the json server of the stopped one still holds its port.
+	revived := NewNodeDir(victimAddr, victimAddr, "")
+	revived.node.MustStart()
+	defer revived.node.Stop()
+	common.AssertWithin(t, func() (string, bool) {
+		value, _, existed := revived.tree.Get(key)
+		return fmt.Sprintf("%s, %v", value, existed), existed && string(value) == "hinted"
+	}, time.Second*10)
+}
+
 func stopServers(servers []*Node) {
 	for _, d := range servers {
 		d.Stop()
@@ -132,4 +2268,71 @@ func TestDHash(t *testing.T) {
 	testClean(t, dhashes)
 	testPut(t, dhashes)
 	testMigrate(t, dhashes)
+	testNextID(t, dhashes)
+	testIncr(t, dhashes)
+	testReplace(t, dhashes)
+	testGetPut(t, dhashes)
+	testPutIfMissing(t, dhashes)
+	testCompareAndSwap(t, dhashes)
+	testCompareAndDelete(t, dhashes)
+	testAppend(t, dhashes)
+	testAppendIf(t, dhashes)
+	testPFAdd(t, dhashes)
+	testPFMerge(t, dhashes)
+	testKeyCursor(t, dhashes)
+	testPutNotify(t, dhashes)
+	testPubSub(t, dhashes)
+	testKeyspaceWatch(t, dhashes)
+	testPutExpire(t, dhashes)
+	testExpiredWrites(t, dhashes)
+	testScan(t, dhashes)
+	testScanPrefix(t, dhashes)
+	testSnapshot(t, dhashes)
+	testBackup(t, dhashes)
+	testSubPutChanged(t, dhashes)
+	testSubReplace(t, dhashes)
+	testDrainPrefix(t, dhashes)
+	testDelOlderThan(t, dhashes)
+	testMExists(t, dhashes)
+	testMGet(t, dhashes)
+	testMPut(t, dhashes)
+	testPutDurable(t, dhashes)
+	dhashes = testJoin(t, dhashes, 10203)
+	testKeyBounds(t, dhashes)
+	testUsage(t, dhashes)
+	testDBSize(t, dhashes)
+	testRandomKey(t, dhashes)
+	testTypes(t, dhashes)
+	testChunking(t, dhashes)
+	testGetMeta(t, dhashes)
+	testReadView(t, dhashes)
+	testSlowLog(t, dhashes)
+	testMetrics(t, dhashes)
+	testExpvar(t, dhashes)
+	testAudit(t, dhashes)
+	testGetDel(t, dhashes)
+	testTTL(t, dhashes)
+	testPersist(t, dhashes)
+	testRename(t, dhashes)
+	testCopy(t, dhashes)
+	testStrLen(t, dhashes)
+	testReadRepair(t, dhashes)
+	testStats(t, dhashes)
+	testMaxBytes(t, dhashes)
+	testNoEviction(t, dhashes)
+	testNamespaces(t, dhashes)
+	testIndexes(t, dhashes)
+	testSortedSets(t, dhashes)
+	testLists(t, dhashes)
+	testBlockingPops(t, dhashes)
+	testDumpRestore(t, dhashes)
+	testSets(t, dhashes)
+	testHashes(t, dhashes)
+	testBitmaps(t, dhashes)
+	testTransactions(t, dhashes)
+	testPerformAll(t, dhashes)
+	testWatch(t, dhashes)
+	testScripts(t, dhashes)
+	testExportJSON(t, dhashes)
+	testImportRDB(t, dhashes)
 }
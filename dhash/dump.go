@@ -0,0 +1,101 @@
+package dhash
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/zond/god/common"
+)
+
+// dumpVersion is the first byte of every dump, so that dumps from incompatible versions are refused instead of misread.
+const dumpVersion = 1
+
+// keyDump is everything stored under one key: the byte value, the sub tree and its configuration.
+type keyDump struct {
+	Value         []byte
+	Exists        bool
+	SubItems      []common.Item
+	Configuration map[string]string
+}
+
+// DumpKey will serialize the byte value, sub tree and sub tree configuration under data.Key to an opaque blob that RestoreKey accepts,
+// or return nil if there is nothing under data.Key.
+func (self *Node) DumpKey(data common.Item, dump *[]byte) error {
+	self.subLock.Lock()
+	d := keyDump{}
+	d.Value, _, d.Exists = self.tree.Get(data.Key)
+	self.tree.SubEachBetween(data.Key, nil, nil, true, true, func(subKey, value []byte, timestamp int64) bool {
+		d.SubItems = append(d.SubItems, common.Item{
+			SubKey: subKey,
+			Value:  value,
+			Exists: true,
+		})
+		return true
+	})
+	d.Configuration, _ = self.tree.SubConfiguration(data.Key)
+	self.subLock.Unlock()
+	*dump = nil
+	if !d.Exists && len(d.SubItems) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer([]byte{dumpVersion})
+	if err := gob.NewEncoder(buffer).Encode(d); err != nil {
+		return err
+	}
+	*dump = buffer.Bytes()
+	return nil
+}
+
+// RestoreKey will put what r.Dump, as returned by DumpKey, contains under r.Key, as one transaction.
+// Unless r.Replace is set it fails if there already is something under r.Key, otherwise what is there is replaced.
+func (self *Node) RestoreKey(r common.Restoration, x *int) error {
+	if len(r.Dump) == 0 || r.Dump[0] != dumpVersion {
+		return fmt.Errorf("Not a dump of version %v", dumpVersion)
+	}
+	d := keyDump{}
+	if err := gob.NewDecoder(bytes.NewBuffer(r.Dump[1:])).Decode(&d); err != nil {
+		return err
+	}
+	_, version, existed := self.tree.Get(r.Key)
+	subSize := self.tree.SubSize(r.Key)
+	if !r.Replace && (existed || subSize > 0) {
+		return fmt.Errorf("%v already has something under %v", self.GetBroadcastAddr(), string(r.Key))
+	}
+	if !existed {
+		version = 0
+	}
+	data := common.Batch{
+		// Fail instead of overwriting a byte value put since it was checked.
+		Watches: []common.Item{common.Item{Key: r.Key, Timestamp: version}},
+		Sync:    r.Sync,
+	}
+	if d.Exists {
+		data.Items = append(data.Items, common.Item{Key: r.Key, Value: d.Value, Exists: true})
+	} else if existed {
+		data.Items = append(data.Items, common.Item{Key: r.Key})
+	}
+	if subSize > 0 {
+		self.tree.SubEachBetween(r.Key, nil, nil, true, true, func(subKey, subValue []byte, timestamp int64) bool {
+			data.Items = append(data.Items, common.Item{Key: r.Key, SubKey: subKey})
+			return true
+		})
+	}
+	for _, item := range d.SubItems {
+		item.Key = r.Key
+		data.Items = append(data.Items, item)
+	}
+	var old []common.Item
+	if err := self.Exec(data, &old); err != nil {
+		return err
+	}
+	current, _ := self.tree.SubConfiguration(r.Key)
+	for key := range current {
+		if _, found := d.Configuration[key]; !found {
+			self.SubAddConfiguration(common.ConfItem{TreeKey: r.Key, Key: key})
+		}
+	}
+	for key, value := range d.Configuration {
+		self.SubAddConfiguration(common.ConfItem{TreeKey: r.Key, Key: key, Value: value})
+	}
+	return nil
+}
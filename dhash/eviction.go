@@ -0,0 +1,421 @@
+package dhash
+
+import (
+	"bytes"
+	"container/heap"
+	"container/list"
+	"fmt"
+	"github.com/zond/god/common"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// EvictionPolicy decides which values a Node evicts when it grows above the size set by MaxBytes.
+// expires is the deadline set by PutExpire for the value under key, or 0 if it has none.
+// Implementations must be safe to use from multiple goroutines.
+type EvictionPolicy interface {
+	// Touch will be called when the value under key is read or written.
+	Touch(key []byte, expires int64)
+	// Add will be called with keys that have not been touched since the node started, to make them possible to evict,
+	// and return whether key was unknown to the policy.
+	Add(key []byte, expires int64) (added bool)
+	// Remove will be called when the value under key is deleted.
+	Remove(key []byte)
+	// Victim will forget and return the key to evict next, or return false if the policy knows no keys.
+	Victim() (key []byte, ok bool)
+}
+
+// NoEviction is an EvictionPolicy that never evicts anything. Instead, a Node using it will refuse writes to the values it owns
+// while it is above the size set by MaxBytes.
+var NoEviction EvictionPolicy = noEviction{}
+
+type noEviction struct{}
+
+func (self noEviction) Touch(key []byte, expires int64)            {}
+func (self noEviction) Add(key []byte, expires int64) (added bool) { return }
+func (self noEviction) Remove(key []byte)                          {}
+func (self noEviction) Victim() (key []byte, ok bool)              { return }
+
+type lru struct {
+	lock     sync.Mutex
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+// NewLRU returns an EvictionPolicy evicting the least recently used value first. It is the default policy.
+func NewLRU() EvictionPolicy {
+	return &lru{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+func (self *lru) Touch(key []byte, expires int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if element, found := self.elements[string(key)]; found {
+		self.order.MoveToFront(element)
+	} else {
+		self.elements[string(key)] = self.order.PushFront(string(key))
+	}
+}
+func (self *lru) Add(key []byte, expires int64) (added bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, found := self.elements[string(key)]; !found {
+		self.elements[string(key)] = self.order.PushBack(string(key))
+		added = true
+	}
+	return
+}
+func (self *lru) Remove(key []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if element, found := self.elements[string(key)]; found {
+		self.order.Remove(element)
+		delete(self.elements, string(key))
+	}
+}
+func (self *lru) Victim() (key []byte, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if element := self.order.Back(); element != nil {
+		self.order.Remove(element)
+		delete(self.elements, element.Value.(string))
+		return []byte(element.Value.(string)), true
+	}
+	return
+}
+
+type prioritized struct {
+	key      string
+	priority int64
+	index    int
+}
+
+// priorities is a heap.Interface of keys with the lowest priority first.
+type priorities []*prioritized
+
+func (self priorities) Len() int           { return len(self) }
+func (self priorities) Less(i, j int) bool { return self[i].priority < self[j].priority }
+func (self priorities) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+	self[i].index, self[j].index = i, j
+}
+func (self *priorities) Push(x interface{}) {
+	p := x.(*prioritized)
+	p.index = len(*self)
+	*self = append(*self, p)
+}
+func (self *priorities) Pop() interface{} {
+	old := *self
+	p := old[len(old)-1]
+	*self = old[:len(old)-1]
+	return p
+}
+
+type lfu struct {
+	lock     sync.Mutex
+	heap     priorities
+	elements map[string]*prioritized
+}
+
+// NewLFU returns an EvictionPolicy evicting the least frequently used value first, counting uses since the node started.
+func NewLFU() EvictionPolicy {
+	return &lfu{
+		elements: make(map[string]*prioritized),
+	}
+}
+func (self *lfu) Touch(key []byte, expires int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if element, found := self.elements[string(key)]; found {
+		element.priority++
+		heap.Fix(&self.heap, element.index)
+	} else {
+		element = &prioritized{key: string(key), priority: 1}
+		heap.Push(&self.heap, element)
+		self.elements[element.key] = element
+	}
+}
+func (self *lfu) Add(key []byte, expires int64) (added bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, found := self.elements[string(key)]; !found {
+		element := &prioritized{key: string(key)}
+		heap.Push(&self.heap, element)
+		self.elements[element.key] = element
+		added = true
+	}
+	return
+}
+func (self *lfu) Remove(key []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if element, found := self.elements[string(key)]; found {
+		heap.Remove(&self.heap, element.index)
+		delete(self.elements, element.key)
+	}
+}
+func (self *lfu) Victim() (key []byte, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.heap) > 0 {
+		element := heap.Pop(&self.heap).(*prioritized)
+		delete(self.elements, element.key)
+		return []byte(element.key), true
+	}
+	return
+}
+
+type randomEviction struct {
+	lock    sync.Mutex
+	keys    []string
+	indices map[string]int
+}
+
+// NewRandomEviction returns an EvictionPolicy evicting random values.
+func NewRandomEviction() EvictionPolicy {
+	return &randomEviction{
+		indices: make(map[string]int),
+	}
+}
+func (self *randomEviction) Touch(key []byte, expires int64) {
+	self.Add(key, expires)
+}
+func (self *randomEviction) Add(key []byte, expires int64) (added bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, found := self.indices[string(key)]; !found {
+		self.indices[string(key)] = len(self.keys)
+		self.keys = append(self.keys, string(key))
+		added = true
+	}
+	return
+}
+func (self *randomEviction) remove(index int) string {
+	removed, last := self.keys[index], self.keys[len(self.keys)-1]
+	self.keys[index] = last
+	self.indices[last] = index
+	self.keys = self.keys[:len(self.keys)-1]
+	delete(self.indices, removed)
+	return removed
+}
+func (self *randomEviction) Remove(key []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if index, found := self.indices[string(key)]; found {
+		self.remove(index)
+	}
+}
+func (self *randomEviction) Victim() (key []byte, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.keys) > 0 {
+		return []byte(self.remove(rand.Intn(len(self.keys)))), true
+	}
+	return
+}
+
+type ttlFirst struct {
+	lock     sync.Mutex
+	heap     priorities
+	elements map[string]*prioritized
+	lru      *lru
+}
+
+// NewTTLFirst returns an EvictionPolicy evicting the values with the earliest deadlines first, and the least recently used value
+// when no values have deadlines.
+func NewTTLFirst() EvictionPolicy {
+	return &ttlFirst{
+		elements: make(map[string]*prioritized),
+		lru:      NewLRU().(*lru),
+	}
+}
+
+// deadline will put key in the heap of expiring keys, or remove it from there if expires is 0.
+func (self *ttlFirst) deadline(key []byte, expires int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	element, found := self.elements[string(key)]
+	if expires == 0 {
+		if found {
+			heap.Remove(&self.heap, element.index)
+			delete(self.elements, element.key)
+		}
+	} else if found {
+		element.priority = expires
+		heap.Fix(&self.heap, element.index)
+	} else {
+		element = &prioritized{key: string(key), priority: expires}
+		heap.Push(&self.heap, element)
+		self.elements[element.key] = element
+	}
+}
+func (self *ttlFirst) Touch(key []byte, expires int64) {
+	self.lru.Touch(key, expires)
+	self.deadline(key, expires)
+}
+func (self *ttlFirst) Add(key []byte, expires int64) (added bool) {
+	if added = self.lru.Add(key, expires); added {
+		self.deadline(key, expires)
+	}
+	return
+}
+func (self *ttlFirst) Remove(key []byte) {
+	self.lru.Remove(key)
+	self.deadline(key, 0)
+}
+func (self *ttlFirst) Victim() (key []byte, ok bool) {
+	self.lock.Lock()
+	if len(self.heap) > 0 {
+		element := heap.Pop(&self.heap).(*prioritized)
+		delete(self.elements, element.key)
+		self.lock.Unlock()
+		key, ok = []byte(element.key), true
+		self.lru.Remove(key)
+		return
+	}
+	self.lock.Unlock()
+	return self.lru.Victim()
+}
+
+// MaxBytes will make this node evict values it owns, chosen by its EvictionPolicy, when the approximate size of the data it owns,
+// as returned by radix.Tree#DataSizeBetween, grows above maxBytes. A maxBytes of 0, the default, will never evict anything.
+// Evicted values are deleted like any other value, so the deletion is logged and replicated.
+func (self *Node) MaxBytes(maxBytes int) *Node {
+	atomic.StoreInt64(&self.maxBytes, int64(maxBytes))
+	return self
+}
+
+// Evict will make this node choose the values to evict with policy, forgetting what the previous policy knew about the use of its values.
+func (self *Node) Evict(policy EvictionPolicy) *Node {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.evictionPolicy = policy
+	return self
+}
+
+func (self *Node) getEvictionPolicy() EvictionPolicy {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.evictionPolicy
+}
+
+// touch will tell the EvictionPolicy that key was used, if this node has a maximum size.
+func (self *Node) touch(key []byte) {
+	if atomic.LoadInt64(&self.maxBytes) > 0 {
+		expires, _ := self.expiry(key)
+		self.getEvictionPolicy().Touch(key, expires)
+	}
+}
+
+// untouch will tell the EvictionPolicy that key was deleted, if this node has a maximum size.
+func (self *Node) untouch(key []byte) {
+	if atomic.LoadInt64(&self.maxBytes) > 0 {
+		self.getEvictionPolicy().Remove(key)
+	}
+}
+
+// full returns an error if key is owned by this node, and the node is above its maximum size with the NoEviction policy,
+// or read only while its persistence.Logger is degraded.
+func (self *Node) full(key []byte) error {
+	if atomic.LoadInt32(&self.readOnly) == 1 && self.tree.LogStats().Degraded && common.BetweenIE(key, self.node.GetPredecessor().Pos, self.node.GetPosition()) {
+		return fmt.Errorf("%v is unable to write to disk, and refuses writes until it is", self.GetBroadcastAddr())
+	}
+	maxBytes := atomic.LoadInt64(&self.maxBytes)
+	if maxBytes == 0 || self.getEvictionPolicy() != NoEviction {
+		return nil
+	}
+	if size := self.ownedDataSize(); int64(size) > maxBytes && common.BetweenIE(key, self.node.GetPredecessor().Pos, self.node.GetPosition()) {
+		return fmt.Errorf("%v holds %v bytes, more than its maximum of %v", self.GetBroadcastAddr(), size, maxBytes)
+	}
+	return nil
+}
+
+// ownedDataSize returns the approximate number of bytes used by the data owned by this node, leaving out the replicas it holds for others.
+func (self *Node) ownedDataSize() int {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	cmp := bytes.Compare(pred.Pos, me.Pos)
+	if cmp < 0 {
+		return self.tree.DataSizeBetween(pred.Pos, me.Pos, true, false)
+	} else if cmp > 0 {
+		return self.tree.DataSizeBetween(pred.Pos, nil, true, false) + self.tree.DataSizeBetween(nil, me.Pos, true, false)
+	}
+	if pred.Less(me) {
+		return 0
+	}
+	return self.tree.DataSize()
+}
+
+// addUnused will add all keys owned by this node to the EvictionPolicy, unless they are known to it already.
+// It is used to make values restored or synced from other nodes, which were never touched, possible to evict.
+func (self *Node) addUnused(policy EvictionPolicy) (added int) {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	var min, last []byte
+	mininc := true
+	for {
+		items := self.tree.ExportBetween(min, nil, mininc, false, bulkFetchSize)
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			if last == nil || string(item.Key) != string(last) {
+				if common.BetweenIE(item.Key, pred.Pos, me.Pos) {
+					expires, _ := self.expiry(item.Key)
+					if policy.Add(item.Key, expires) {
+						added++
+					}
+				}
+				last = item.Key
+			}
+		}
+		min, mininc = items[len(items)-1].Key, false
+	}
+	return
+}
+
+// evict will delete the values owned by this node chosen by its EvictionPolicy until the data it owns is no larger than the maximum set by MaxBytes,
+// or until there is nothing owned left to evict. With the NoEviction policy it does nothing, since full refuses the writes instead.
+func (self *Node) evict() {
+	maxBytes := atomic.LoadInt64(&self.maxBytes)
+	if maxBytes == 0 {
+		return
+	}
+	policy := self.getEvictionPolicy()
+	if policy == NoEviction {
+		return
+	}
+	refilled := false
+	for int64(self.ownedDataSize()) > maxBytes {
+		key, ok := policy.Victim()
+		if !ok {
+			// Only look for unused values once in a row, since a policy that still has no victims after that never will.
+			if refilled || self.addUnused(policy) == 0 {
+				return
+			}
+			refilled = true
+			continue
+		}
+		if !common.BetweenIE(key, self.node.GetPredecessor().Pos, self.node.GetPosition()) {
+			continue
+		}
+		data := common.Item{
+			Key:       key,
+			TTL:       self.node.Redundancy(),
+			Timestamp: self.timer.ContinuousTime(),
+		}
+		if _, _, existed := self.tree.Get(key); existed {
+			self.del(data)
+			atomic.AddInt64(&self.evicted, 1)
+			refilled = false
+		}
+		if self.tree.SubSize(key) > 0 {
+			self.subClear(data)
+			atomic.AddInt64(&self.evicted, 1)
+			refilled = false
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package dhash
+
+import (
+	"testing"
+)
+
+func victims(policy EvictionPolicy) (result []string) {
+	for key, ok := policy.Victim(); ok; key, ok = policy.Victim() {
+		result = append(result, string(key))
+	}
+	return
+}
+
+func assertVictims(t *testing.T, name string, policy EvictionPolicy, expected ...string) {
+	found := victims(policy)
+	if len(found) != len(expected) {
+		t.Errorf("%v: wanted %v, got %v", name, expected, found)
+		return
+	}
+	for index, key := range expected {
+		if found[index] != key {
+			t.Errorf("%v: wanted %v, got %v", name, expected, found)
+			return
+		}
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	policy := NewLRU()
+	policy.Touch([]byte("a"), 0)
+	policy.Touch([]byte("b"), 0)
+	policy.Touch([]byte("c"), 0)
+	policy.Touch([]byte("a"), 0)
+	policy.Add([]byte("d"), 0)
+	policy.Remove([]byte("c"))
+	assertVictims(t, "lru", policy, "d", "b", "a")
+
+	policy = NewLFU()
+	policy.Touch([]byte("a"), 0)
+	policy.Touch([]byte("a"), 0)
+	policy.Touch([]byte("a"), 0)
+	policy.Touch([]byte("b"), 0)
+	policy.Touch([]byte("b"), 0)
+	policy.Touch([]byte("c"), 0)
+	policy.Add([]byte("d"), 0)
+	policy.Add([]byte("a"), 0)
+	policy.Remove([]byte("c"))
+	assertVictims(t, "lfu", policy, "d", "b", "a")
+
+	policy = NewTTLFirst()
+	policy.Touch([]byte("a"), 0)
+	policy.Touch([]byte("b"), 20)
+	policy.Touch([]byte("c"), 10)
+	policy.Touch([]byte("d"), 0)
+	policy.Touch([]byte("a"), 0)
+	policy.Add([]byte("e"), 5)
+	policy.Touch([]byte("c"), 0)
+	assertVictims(t, "ttl", policy, "e", "b", "d", "a", "c")
+
+	policy = NewRandomEviction()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		policy.Touch([]byte(key), 0)
+	}
+	policy.Touch([]byte("a"), 0)
+	policy.Remove([]byte("b"))
+	found := make(map[string]bool)
+	for _, key := range victims(policy) {
+		found[key] = true
+	}
+	if len(found) != 3 || !found["a"] || !found["c"] || !found["d"] {
+		t.Errorf("random: wanted a, c and d, got %v", found)
+	}
+
+	policy = NoEviction
+	policy.Touch([]byte("a"), 0)
+	if policy.Add([]byte("b"), 0) {
+		t.Errorf("no eviction: wanted nothing to be added")
+	}
+	assertVictims(t, "no eviction", policy)
+}
@@ -0,0 +1,53 @@
+package dhash
+
+import (
+	"expvar"
+	"github.com/zond/god/common"
+	"sync"
+)
+
+// NodeVars are the statistics and counters of a started node published with expvar.
+type NodeVars struct {
+	Stats   common.Stats
+	Metrics common.Metrics
+}
+
+// published contains the started nodes in this process, to publish with expvar under the name "god".
+var published = struct {
+	lock  sync.Mutex
+	nodes map[*Node]bool
+}{
+	nodes: make(map[*Node]bool),
+}
+
+func init() {
+	expvar.Publish("god", expvar.Func(publishedVars))
+}
+
+// publishedVars returns the NodeVars of the started nodes in this process by their broadcast address.
+func publishedVars() interface{} {
+	published.lock.Lock()
+	nodes := make([]*Node, 0, len(published.nodes))
+	for node := range published.nodes {
+		nodes = append(nodes, node)
+	}
+	published.lock.Unlock()
+	result := make(map[string]NodeVars)
+	for _, node := range nodes {
+		result[node.GetBroadcastAddr()] = NodeVars{
+			Stats:   node.Stats(),
+			Metrics: node.Metrics(),
+		}
+	}
+	return result
+}
+func (self *Node) publish() {
+	published.lock.Lock()
+	defer published.lock.Unlock()
+	published.nodes[self] = true
+}
+func (self *Node) unpublish() {
+	published.lock.Lock()
+	defer published.lock.Unlock()
+	delete(published.nodes, self)
+}
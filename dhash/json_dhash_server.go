@@ -1,6 +1,7 @@
 package dhash
 
 import (
+	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/setop"
 )
@@ -25,6 +26,12 @@ type SubValueOp struct {
 	Value  []byte
 	Sync   bool
 }
+type SubValuesOp struct {
+	Key     []byte
+	SubKeys [][]byte
+	Values  [][]byte
+	Sync    bool
+}
 type SubKeyOp struct {
 	Key    []byte
 	SubKey []byte
@@ -150,6 +157,26 @@ func (self *JSONApi) SubPut(d SubValueOp, n *Nothing) (err error) {
 	}
 	return
 }
+func (self *JSONApi) SubPutChanged(d SubValuesOp, changed *int) (err error) {
+	if len(d.SubKeys) != len(d.Values) {
+		return fmt.Errorf("%v sub keys but %v values", len(d.SubKeys), len(d.Values))
+	}
+	data := common.Batch{
+		Key:  d.Key,
+		Sync: d.Sync,
+	}
+	for index, subKey := range d.SubKeys {
+		data.Items = append(data.Items, common.Item{
+			SubKey: subKey,
+			Value:  d.Values[index],
+		})
+	}
+	var f bool
+	if f, err = self.forwardUnlessMe("DHash.SubPutChanged", data.Key, data, changed); !f {
+		err = (*Node)(self).SubPutChanged(data, changed)
+	}
+	return
+}
 func (self *JSONApi) Del(d KeyOp, n *Nothing) (err error) {
 	data := common.Item{
 		Key:  d.Key,
@@ -168,10 +195,35 @@ func (self *JSONApi) Put(d ValueOp, n *Nothing) (err error) {
 		Value: d.Value,
 		Sync:  d.Sync,
 	}
-	var x int
+	var durable bool
 	var f bool
-	if f, err = self.forwardUnlessMe("DHash.Put", data.Key, data, &x); !f {
-		err = (*Node)(self).Put(data)
+	if f, err = self.forwardUnlessMe("DHash.Put", data.Key, data, &durable); !f {
+		_, err = (*Node)(self).Put(data)
+	}
+	return
+}
+func (self *JSONApi) NextID(d ValueOp, result *int64) (err error) {
+	data := common.Item{
+		Key:   d.Key,
+		Value: d.Value,
+		Sync:  d.Sync,
+	}
+	var f bool
+	if f, err = self.forwardUnlessMe("DHash.NextID", data.Key, data, result); !f {
+		err = (*Node)(self).NextID(data, result)
+	}
+	return
+}
+func (self *JSONApi) Replace(r common.Replacement, result *ValueRes) (err error) {
+	var item common.Item
+	var f bool
+	if f, err = self.forwardUnlessMe("DHash.Replace", r.Key, r, &item); !f {
+		err = (*Node)(self).Replace(r, &item)
+	}
+	*result = ValueRes{
+		Key:    item.Key,
+		Value:  item.Value,
+		Exists: item.Exists,
 	}
 	return
 }
@@ -768,6 +820,14 @@ func (self *JSONApi) SetExpression(expr setop.SetExpression, items *[]setop.SetO
 	}
 	return (*Node)(self).SetExpression(expr, items)
 }
+func (self *JSONApi) SetStore(expr setop.SetExpression, size *int) (err error) {
+	if expr.Op == nil {
+		if expr.Op, err = setop.NewSetOpParser(expr.Code).Parse(); err != nil {
+			return
+		}
+	}
+	return (*Node)(self).SetStore(expr, size)
+}
 
 func (self *JSONApi) AddConfiguration(co Conf, x *Nothing) (err error) {
 	c := common.ConfItem{
@@ -3,6 +3,7 @@ package dhash
 import (
 	"code.google.com/p/go.net/websocket"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/zond/god/common"
@@ -235,6 +236,7 @@ func (self *Node) startJson() {
 	}, router)
 	mux := http.NewServeMux()
 	mux.Handle("/", router)
+	mux.Handle("/debug/vars", expvar.Handler())
 	listener, err := net.Listen("tcp", fmt.Sprintf("%v:%v", nodeAddr.IP, nodeAddr.Port+1))
 	if err != nil {
 		panic(err)
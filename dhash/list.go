@@ -0,0 +1,153 @@
+package dhash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/zond/god/common"
+	"time"
+)
+
+// pushChannel followed by the key of a list is the channel pushes to the list are published to, in the node owning it, to wake blocking pops.
+const pushChannel = common.KeyspaceChannel + "push:"
+
+// listPosition returns the sub key of the element at position i in a list, encoded so that the sub keys sort like the positions.
+func listPosition(i int64) []byte {
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, uint64(i)^(1<<63))
+	return result
+}
+
+func decodeListPosition(b []byte) (i int64, err error) {
+	if len(b) != 8 {
+		err = fmt.Errorf("%v is not a list position", b)
+		return
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63)), nil
+}
+
+// push will put data.Value first, or last if last is true, in the list defined by data.Key, and return the new length of the list.
+// The sub tree lock is held from finding the current end of the list until the new element is put, so concurrent pushes never collide.
+func (self *Node) push(data common.Item, last bool, length *int) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	if err = self.checkType(data.Key, common.ListType, true); err != nil {
+		return
+	}
+	var edge []byte
+	var existed bool
+	if last {
+		edge, _, _, existed = self.tree.SubLast(data.Key)
+	} else {
+		edge, _, _, existed = self.tree.SubFirst(data.Key)
+	}
+	position := int64(0)
+	if existed {
+		if position, err = decodeListPosition(edge); err != nil {
+			return
+		}
+		if last {
+			position++
+		} else {
+			position--
+		}
+	}
+	data.SubKey = listPosition(position)
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	if err = self.subPut(data); err != nil {
+		return
+	}
+	*length = self.tree.SubSize(data.Key)
+	self.triggerChannelListeners(pushChannel+string(data.Key), nil)
+	return
+}
+
+// LPush will put data.Value first in the list defined by data.Key, and return the new length of the list.
+func (self *Node) LPush(data common.Item, length *int) error {
+	return self.push(data, false, length)
+}
+
+// RPush will put data.Value last in the list defined by data.Key, and return the new length of the list.
+func (self *Node) RPush(data common.Item, length *int) error {
+	return self.push(data, true, length)
+}
+
+// pop will remove and return the first, or last if last is true, element of the list defined by data.Key.
+func (self *Node) pop(data common.Item, last bool, result *common.Item) (err error) {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	*result = common.Item{Key: data.Key}
+	if err = self.checkType(data.Key, common.ListType, false); err != nil {
+		return
+	}
+	if last {
+		data.SubKey, result.Value, _, result.Exists = self.tree.SubLast(data.Key)
+	} else {
+		data.SubKey, result.Value, _, result.Exists = self.tree.SubFirst(data.Key)
+	}
+	if !result.Exists {
+		return
+	}
+	data.Value, data.TTL, data.Timestamp = nil, self.node.Redundancy(), self.timer.ContinuousTime()
+	return self.subDel(data)
+}
+
+// LPop will remove and return the first element of the list defined by data.Key.
+func (self *Node) LPop(data common.Item, result *common.Item) error {
+	return self.pop(data, false, result)
+}
+
+// RPop will remove and return the last element of the list defined by data.Key.
+func (self *Node) RPop(data common.Item, result *common.Item) error {
+	return self.pop(data, true, result)
+}
+
+// blockingPop will pop like pop, but wait up to b.Timeout, or until an element is pushed if b.Timeout is 0, for an element if the list is empty.
+func (self *Node) blockingPop(b common.BlockingPop, last bool, result *common.Item) (err error) {
+	if b.Timeout < 0 {
+		return fmt.Errorf("Can't wait %v for an element", b.Timeout)
+	}
+	var timeout <-chan time.Time
+	if b.Timeout > 0 {
+		timeout = time.After(b.Timeout)
+	}
+	pushed := make(chan bool, 1)
+	done := make(chan struct{})
+	defer close(done)
+	// Listen once, before popping, so that an element pushed between a pop and the wait still wakes us.
+	// Like a stopped subscription, the listener is dropped by the first push after we are done, whether we popped something or timed out.
+	self.AddChannelListener(pushChannel+string(b.Key), func(channel string, message []byte) bool {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+		select {
+		case pushed <- true:
+		default:
+		}
+		return true
+	})
+	for {
+		if err = self.pop(common.Item{Key: b.Key, Sync: true}, last, result); err != nil || result.Exists {
+			return
+		}
+		select {
+		case <-pushed:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// BLPop will remove and return the first element of the list defined by b.Key, waiting up to b.Timeout for one to be pushed if the list is empty.
+func (self *Node) BLPop(b common.BlockingPop, result *common.Item) error {
+	return self.blockingPop(b, false, result)
+}
+
+// BRPop will remove and return the last element of the list defined by b.Key, waiting up to b.Timeout for one to be pushed if the list is empty.
+func (self *Node) BRPop(b common.BlockingPop, result *common.Item) error {
+	return self.blockingPop(b, true, result)
+}
@@ -0,0 +1,51 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+	"github.com/zond/god/discord"
+	"strings"
+	"sync/atomic"
+)
+
+// Metrics returns what this node has done since it started.
+func (self *Node) Metrics() (result common.Metrics) {
+	result = common.Metrics{
+		Addr:   self.GetBroadcastAddr(),
+		Hits:   atomic.LoadInt64(&self.hits),
+		Misses: atomic.LoadInt64(&self.misses),
+		Calls:  make(map[string]int64),
+		Errors: make(map[string]int64),
+	}
+	self.metricsLock.Lock()
+	defer self.metricsLock.Unlock()
+	for method, count := range self.calls {
+		result.Calls[method] = count
+	}
+	for method, count := range self.errors {
+		result.Errors[method] = count
+	}
+	return
+}
+
+// countCall will count call, and whether it returned an error, if it is a DHash call.
+func (self *Node) countCall(call discord.Call) bool {
+	if !strings.HasPrefix(call.Method, "DHash.") {
+		return true
+	}
+	self.metricsLock.Lock()
+	defer self.metricsLock.Unlock()
+	self.calls[call.Method]++
+	if call.Err != nil {
+		self.errors[call.Method]++
+	}
+	return true
+}
+
+// countGet will count a lookup that found a value if found is true, and one that didn't otherwise.
+func (self *Node) countGet(found bool) {
+	if found {
+		atomic.AddInt64(&self.hits, 1)
+	} else {
+		atomic.AddInt64(&self.misses, 1)
+	}
+}
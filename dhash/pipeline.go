@@ -0,0 +1,84 @@
+package dhash
+
+import (
+	"fmt"
+	"github.com/zond/god/common"
+)
+
+// pipelineWrites are the methods a Pipeline can write with, and whether they put.
+var pipelineWrites = map[string]bool{
+	"Put":    true,
+	"SubPut": true,
+	"Del":    false,
+	"SubDel": false,
+}
+
+func pipelineKey(key, subKey []byte) string {
+	return fmt.Sprintf("%q/%q", key, subKey)
+}
+
+// PerformAll will perform p.Operations in order, and return what each of them found: the value read by the Gets and SubGets,
+// and the value replaced by the Puts, Dels, SubPuts and SubDels.
+// The reads see the writes before them in p, and the writes are applied as one transaction, logged and sent to the replicas as one operation,
+// once the reads are done. If a byte value read changes meanwhile, the operations are performed again.
+func (self *Node) PerformAll(p common.Pipeline, responses *[]common.Item) error {
+	for _, op := range p.Operations {
+		switch op.Method {
+		case "Get", "Put", "Del":
+		case "SubGet", "SubPut", "SubDel":
+			if op.SubKey == nil {
+				return fmt.Errorf("%v of %q needs a sub key", op.Method, op.Key)
+			}
+		default:
+			return fmt.Errorf("%v can't be performed in a pipeline", op.Method)
+		}
+	}
+	for {
+		*responses = make([]common.Item, len(p.Operations))
+		batch := common.Batch{Sync: p.Sync}
+		pending := make(map[string]common.Item)
+		watched := make(map[string]bool)
+		for index, op := range p.Operations {
+			if op.Method == "Get" || op.Method == "Put" || op.Method == "Del" {
+				op.SubKey = nil
+			}
+			key := pipelineKey(op.Key, op.SubKey)
+			found, ok := pending[key]
+			if !ok {
+				found = common.Item{Key: op.Key, SubKey: op.SubKey}
+				if op.SubKey == nil {
+					value, timestamp, existed := self.tree.Get(op.Key)
+					if !existed {
+						timestamp = 0
+					} else if !self.expired(op.Key, timestamp) {
+						found.Value, found.Timestamp, found.Exists = value, timestamp, true
+					}
+					if !watched[key] {
+						watched[key] = true
+						batch.Watches = append(batch.Watches, common.Item{Key: op.Key, Timestamp: timestamp})
+					}
+				} else {
+					found.Value, found.Timestamp, found.Exists = self.tree.SubGet(op.Key, op.SubKey)
+				}
+			}
+			(*responses)[index] = found
+			if put, write := pipelineWrites[op.Method]; write {
+				item := common.Item{Key: op.Key, SubKey: op.SubKey, Exists: put}
+				if put {
+					item.Value = op.Value
+				}
+				batch.Items = append(batch.Items, item)
+				pending[key] = item
+			} else {
+				self.countGet(found.Exists)
+			}
+		}
+		if len(batch.Items) == 0 {
+			return nil
+		}
+		var old []common.Item
+		if err := self.Exec(batch, &old); err != common.ErrWatchChanged {
+			return err
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package dhash
+
+import (
+	"fmt"
+	"github.com/zond/god/common"
+	"path"
+	"time"
+)
+
+// subscriber buffers the messages published to the channels of a common.Subscription until they are polled with Receive.
+type subscriber struct {
+	messages chan common.Notification
+	stop     chan struct{}
+	used     time.Time
+}
+
+// Subscribe will start buffering the messages published to s.Channels in this node, and matching s.Pattern if it is not empty,
+// and return an id to poll for them with Receive.
+// Since messages are published to all nodes, subscribing in one node is enough, except for the keyspace channels of the keys this node owns.
+// Subscriptions not polled for subscriberTimeout are forgotten.
+func (self *Node) Subscribe(s common.Subscription, id *int64) error {
+	if len(s.Channels) == 0 {
+		return fmt.Errorf("Can't subscribe to no channels")
+	}
+	if _, err := path.Match(s.Pattern, ""); err != nil {
+		return err
+	}
+	sub := &subscriber{
+		messages: make(chan common.Notification, subscriberBuffer),
+		stop:     make(chan struct{}),
+		used:     time.Now(),
+	}
+	self.lock.Lock()
+	self.nextSubscriber++
+	*id = self.nextSubscriber
+	self.subscribers[*id] = sub
+	self.lock.Unlock()
+	for _, channel := range s.Channels {
+		self.AddChannelListener(channel, func(channel string, message []byte) bool {
+			select {
+			case <-sub.stop:
+				return false
+			default:
+			}
+			if s.Pattern != "" {
+				if matched, _ := path.Match(s.Pattern, string(message)); !matched {
+					return true
+				}
+			}
+			select {
+			case sub.messages <- common.Notification{Channel: channel, Message: message}:
+			default:
+				// Drop the message rather than block the publisher when the subscriber doesn't keep up.
+			}
+			return true
+		})
+	}
+	return nil
+}
+
+// Receive will wait up to receiveTimeout for messages published to the channels of the subscription s.ID, and return all buffered messages.
+func (self *Node) Receive(s common.Subscription, messages *[]common.Notification) error {
+	self.lock.Lock()
+	sub, ok := self.subscribers[s.ID]
+	if ok {
+		sub.used = time.Now()
+	}
+	self.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("%v has no subscription %v", self.node, s.ID)
+	}
+	*messages = nil
+	select {
+	case message := <-sub.messages:
+		*messages = append(*messages, message)
+	case <-sub.stop:
+		return nil
+	case <-time.After(receiveTimeout):
+		return nil
+	}
+	for {
+		select {
+		case message := <-sub.messages:
+			*messages = append(*messages, message)
+		default:
+			return nil
+		}
+	}
+}
+
+// Unsubscribe will forget the subscription s.ID, and make any Receive waiting for it return.
+func (self *Node) Unsubscribe(s common.Subscription, x *int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if sub, ok := self.subscribers[s.ID]; ok {
+		close(sub.stop)
+		delete(self.subscribers, s.ID)
+	}
+	return nil
+}
+func (self *Node) expireSubscribers() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for id, sub := range self.subscribers {
+		if time.Since(sub.used) > subscriberTimeout {
+			close(sub.stop)
+			delete(self.subscribers, id)
+		}
+	}
+}
+
+// notify will publish key to the subscribers of the keyspace channel of typ in this node, which owns key.
+func (self *Node) notify(typ string, key []byte) {
+	self.triggerChannelListeners(common.KeyspaceChannel+typ, key)
+}
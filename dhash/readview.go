@@ -0,0 +1,80 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+	"time"
+)
+
+// tooOld returns whether at is too long ago for reads of the values as they were then, even if they are not pruned yet.
+func (self *Node) tooOld(at int64) bool {
+	return at < self.timer.ContinuousTime()-int64(readViewLifetime)
+}
+
+// catchUp will wait until the cluster time at has passed on the clock of this node, so that what is written here after a read at at
+// gets a later timestamp, even if at came from a node whose clock is ahead. It returns an error if at is further ahead than clocks drift.
+func (self *Node) catchUp(at int64) error {
+	ahead := time.Duration(at - self.timer.ContinuousTime())
+	if ahead > maxClockSkew {
+		return fmt.Errorf("%v is %v ahead of the clock of %v", time.Unix(0, at), ahead, self.GetBroadcastAddr())
+	}
+	if ahead > 0 {
+		time.Sleep(ahead)
+	}
+	return nil
+}
+
+// eachBetweenAt will iterate over the byte values between min and max like radix.Tree#EachBetween, or as they were at the cluster time at if it is not 0.
+// It returns common.ErrSnapshotTooOld if this node no longer remembers the values from then, which it does for readViewLifetime.
+func (self *Node) eachBetweenAt(min, max []byte, mininc, maxinc bool, at int64, f radix.TreeIterator) error {
+	if at == 0 {
+		self.tree.EachBetween(min, max, mininc, maxinc, f)
+		return nil
+	}
+	if err := self.catchUp(at); err != nil {
+		return err
+	}
+	if self.tooOld(at) || !self.tree.EachBetweenAt(min, max, mininc, maxinc, at, f) {
+		return common.ErrSnapshotTooOld
+	}
+	return nil
+}
+
+// GetAt will return the byte value under data.Key as it was at the cluster time data.Timestamp, or common.ErrSnapshotTooOld if this node
+// no longer remembers it. Replaced values are remembered for readViewLifetime, but only the last keptVersions of them per key.
+func (self *Node) GetAt(data common.Item, result *common.Item) error {
+	*result = common.Item{Key: data.Key}
+	if err := self.catchUp(data.Timestamp); err != nil {
+		return err
+	}
+	if self.tooOld(data.Timestamp) {
+		return common.ErrSnapshotTooOld
+	}
+	var ok bool
+	if result.Value, result.Timestamp, result.Exists, ok = self.tree.GetAt(data.Key, data.Timestamp); !ok {
+		return common.ErrSnapshotTooOld
+	}
+	return nil
+}
+
+// ScanPrefixAt will return all values this node owns under keys starting with data.Key, in key order, as they were at the cluster time data.Timestamp,
+// or common.ErrSnapshotTooOld if this node no longer remembers them.
+func (self *Node) ScanPrefixAt(data common.Item, items *[]common.Item) error {
+	pred := self.node.GetPredecessor()
+	me := self.node.Remote()
+	return self.eachBetweenAt(data.Key, nil, true, false, data.Timestamp, func(key, value []byte, timestamp int64) bool {
+		if !bytes.HasPrefix(key, data.Key) {
+			return false
+		}
+		if common.BetweenIE(key, pred.Pos, me.Pos) && !self.expired(key, timestamp) {
+			*items = append(*items, common.Item{
+				Key:       key,
+				Value:     value,
+				Timestamp: timestamp,
+			})
+		}
+		return true
+	})
+}
@@ -0,0 +1,172 @@
+package dhash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/zond/god/common"
+	"log"
+	"net/rpc"
+)
+
+// Rename will move the byte value under r.Key, and its expiry, to r.Dest, unless r.NX is set and there is a value under r.Dest already, and return whether it was moved.
+// When this node owns both keys the move is one transaction, logged and replicated as one operation. Otherwise the value is taken from r.Key, put under r.Dest by its owner,
+// and put back under r.Key if that fails, so readers may briefly find neither.
+func (self *Node) Rename(r common.Rename, renamed *bool) (err error) {
+	if bytes.Equal(r.Key, r.Dest) {
+		return fmt.Errorf("Can't rename %q to itself", r.Key)
+	}
+	*renamed = false
+	for {
+		value, timestamp, existed := self.tree.Get(r.Key)
+		if !existed || self.expired(r.Key, timestamp) {
+			return
+		}
+		src := common.Item{Key: r.Key, Timestamp: timestamp}
+		dst := common.Item{Key: r.Dest, Value: value, Exists: true}
+		if successor := self.node.GetSuccessorFor(r.Dest); successor.Addr == self.node.GetBroadcastAddr() {
+			err = self.renameLocal(r, src, dst)
+		} else {
+			err = self.renameRemote(r, src, dst, successor)
+		}
+		if err == common.ErrWatchChanged {
+			// r.Key changed after we read it, so try again with what is there now.
+			continue
+		}
+		if err == nil {
+			*renamed = true
+		} else if err == errDestExists {
+			err = nil
+		}
+		return
+	}
+}
+
+// errDestExists means that a Rename with NX set found a value under its Dest.
+var errDestExists = errors.New("The destination of the rename exists")
+
+// renameLocal will move src to dst in one transaction, since this node owns both.
+func (self *Node) renameLocal(r common.Rename, src, dst common.Item) error {
+	_, destTimestamp, destExisted := self.tree.Get(r.Dest)
+	if !destExisted {
+		destTimestamp = 0
+	} else if r.NX && !self.expired(r.Dest, destTimestamp) {
+		return errDestExists
+	}
+	var old []common.Item
+	if err := self.Exec(common.Batch{
+		Sync:    r.Sync,
+		Watches: []common.Item{src, {Key: r.Dest, Timestamp: destTimestamp}},
+		Items:   []common.Item{{Key: r.Key}, dst},
+	}, &old); err != nil {
+		return err
+	}
+	self.moveExpiry(r, src.Timestamp, nil)
+	return nil
+}
+
+// renameRemote will take src from this node, and put it as dst on successor, which owns r.Dest. If that fails src is put back.
+func (self *Node) renameRemote(r common.Rename, src, dst common.Item, successor common.Remote) (err error) {
+	var old []common.Item
+	if err = self.Exec(common.Batch{
+		Sync:    r.Sync,
+		Watches: []common.Item{src},
+		Items:   []common.Item{{Key: r.Key}},
+	}, &old); err != nil {
+		return
+	}
+	put := common.Batch{
+		Sync:  r.Sync,
+		Items: []common.Item{dst},
+	}
+	if r.NX {
+		put.Watches = []common.Item{{Key: r.Dest}}
+	}
+	if err = successor.Call("DHash.Exec", put, &old); err != nil {
+		if serverError, ok := err.(rpc.ServerError); ok && string(serverError) == common.ErrWatchChanged.Error() {
+			err = errDestExists
+		}
+		restored := common.Item{Key: r.Key, Value: dst.Value, Exists: true}
+		if restoreErr := self.Exec(common.Batch{
+			Sync:    r.Sync,
+			Watches: []common.Item{{Key: r.Key}},
+			Items:   []common.Item{restored},
+		}, &old); restoreErr != nil {
+			log.Printf("%v failed putting back %q after failing to rename it to %q: %v", self.GetBroadcastAddr(), r.Key, r.Dest, restoreErr)
+		}
+		return
+	}
+	self.moveExpiry(r, src.Timestamp, &successor)
+	return
+}
+
+// copyExpiry will give dest, owned by successor or by this node if successor is nil, the expiry of the value under key written at timestamp if keep is set and it has one,
+// or no expiry otherwise. It returns whether key has an expiry to forget.
+func (self *Node) copyExpiry(key []byte, timestamp int64, dest []byte, keep bool, successor *common.Remote) (hadExpiry bool) {
+	c := common.ConfItem{
+		TreeKey: dest,
+		Key:     expiresConf,
+	}
+	deadline, hadExpiry := self.expiry(key)
+	if keep && hadExpiry && timestamp < deadline {
+		c.Value = fmt.Sprint(deadline)
+	}
+	if successor == nil {
+		self.SubAddConfiguration(c)
+	} else {
+		var x int
+		if err := successor.Call("DHash.SubAddConfiguration", c, &x); err != nil {
+			log.Printf("%v failed copying the expiry of %q to %q: %v", self.GetBroadcastAddr(), key, dest, err)
+		}
+	}
+	return
+}
+
+// moveExpiry will give r.Dest the expiry the value under r.Key, written at timestamp, had, or no expiry if it had none, and then forget the expiry of r.Key.
+// If successor is not nil it owns r.Dest.
+func (self *Node) moveExpiry(r common.Rename, timestamp int64, successor *common.Remote) {
+	if self.copyExpiry(r.Key, timestamp, r.Dest, true, successor) {
+		self.SubAddConfiguration(common.ConfItem{
+			TreeKey: r.Key,
+			Key:     expiresConf,
+		})
+	}
+}
+
+// Copy will put the byte value under c.Key under c.Dest too, replacing what is there, and return whether there was a value to copy.
+// The copy gets the expiry of the value if c.KeepTTL is set, and no expiry otherwise.
+// When this node owns both keys the value is read and copied in one transaction, otherwise it is sent straight to the owner of c.Dest.
+func (self *Node) Copy(c common.Copy, copied *bool) (err error) {
+	if bytes.Equal(c.Key, c.Dest) {
+		return fmt.Errorf("Can't copy %q to itself", c.Key)
+	}
+	*copied = false
+	for {
+		value, timestamp, existed := self.tree.Get(c.Key)
+		if !existed || self.expired(c.Key, timestamp) {
+			return
+		}
+		put := common.Batch{
+			Sync:  c.Sync,
+			Items: []common.Item{{Key: c.Dest, Value: value, Exists: true}},
+		}
+		var old []common.Item
+		if successor := self.node.GetSuccessorFor(c.Dest); successor.Addr == self.node.GetBroadcastAddr() {
+			put.Watches = []common.Item{{Key: c.Key, Timestamp: timestamp}}
+			if err = self.Exec(put, &old); err == common.ErrWatchChanged {
+				// c.Key changed after we read it, so try again with what is there now.
+				continue
+			} else if err != nil {
+				return
+			}
+			self.copyExpiry(c.Key, timestamp, c.Dest, c.KeepTTL, nil)
+		} else {
+			if err = successor.Call("DHash.Exec", put, &old); err != nil {
+				return
+			}
+			self.copyExpiry(c.Key, timestamp, c.Dest, c.KeepTTL, &successor)
+		}
+		*copied = true
+		return
+	}
+}
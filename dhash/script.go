@@ -0,0 +1,125 @@
+package dhash
+
+import (
+	"fmt"
+	"github.com/zond/god/common"
+)
+
+// maxScriptAttempts is how many times Eval runs a script whose reads keep being changed by other writers before giving up.
+const maxScriptAttempts = 64
+
+// Script is a function run on the server by Node#Eval, with the arguments of the client, reading and writing values through a ScriptContext.
+// The result is returned to the client. A Script may be run several times for one call, so it should have no other side effects.
+type Script func(ctx *ScriptContext, args [][]byte) (result []byte, err error)
+
+// ScriptContext gives a Script access to the byte values owned by the node running it.
+// Writes are kept in the context, and visible to later reads, until the script returns.
+// They are then applied as one transaction, unless a value the script read has changed since, in which case the script is run again.
+type ScriptContext struct {
+	node     *Node
+	versions map[string]int64
+	writes   map[string]int
+	items    []common.Item
+	err      error
+}
+
+func (self *ScriptContext) owned(key []byte) bool {
+	if self.err != nil {
+		return false
+	}
+	if successor := self.node.node.GetSuccessorFor(key); successor.Addr != self.node.node.GetBroadcastAddr() {
+		self.err = fmt.Errorf("%v is owned by %v, not by %v running the script", string(key), successor.Addr, self.node.node.GetBroadcastAddr())
+		return false
+	}
+	return true
+}
+
+// Get returns the value under key, including the changes made by the script so far.
+// Keys not owned by the node running the script make the script fail when it returns.
+func (self *ScriptContext) Get(key []byte) (value []byte, existed bool) {
+	if !self.owned(key) {
+		return
+	}
+	if index, found := self.writes[string(key)]; found {
+		return self.items[index].Value, self.items[index].Exists
+	}
+	var version int64
+	value, version, existed = self.node.tree.Get(key)
+	if !existed {
+		version = 0
+	}
+	if _, found := self.versions[string(key)]; !found {
+		self.versions[string(key)] = version
+	}
+	return
+}
+
+func (self *ScriptContext) write(item common.Item) {
+	if !self.owned(item.Key) {
+		return
+	}
+	if index, found := self.writes[string(item.Key)]; found {
+		self.items[index] = item
+	} else {
+		self.writes[string(item.Key)] = len(self.items)
+		self.items = append(self.items, item)
+	}
+}
+
+// Put will put value under key when the script returns.
+func (self *ScriptContext) Put(key, value []byte) {
+	self.write(common.Item{Key: key, Value: value, Exists: true})
+}
+
+// Del will delete the value under key when the script returns.
+func (self *ScriptContext) Del(key []byte) {
+	self.write(common.Item{Key: key})
+}
+
+// AddScript will register script to be run by Eval under name, replacing any script already registered under that name.
+// Scripts are not replicated, so register the same scripts on every node.
+func (self *Node) AddScript(name string, script Script) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.scripts[name] = script
+}
+
+// Eval will run the script registered as e.Script with e.Args, apply its writes atomically, and return its result.
+// If a value the script read was changed before its writes could be applied, or between its reads, the script is run again.
+// Scripts can only use keys owned by this node, so e.Key is used to route the call, and should be the key, or one of the keys, the script uses.
+func (self *Node) Eval(e common.Eval, result *[]byte) (err error) {
+	self.lock.RLock()
+	script, found := self.scripts[e.Script]
+	self.lock.RUnlock()
+	if !found {
+		return fmt.Errorf("No script named %#v in %v", e.Script, self.GetBroadcastAddr())
+	}
+	for attempt := 0; attempt < maxScriptAttempts; attempt++ {
+		ctx := &ScriptContext{
+			node:     self,
+			versions: make(map[string]int64),
+			writes:   make(map[string]int),
+		}
+		if *result, err = script(ctx, e.Args); err != nil {
+			return
+		}
+		if ctx.err != nil {
+			return ctx.err
+		}
+		if len(ctx.items) == 0 && len(ctx.versions) < 2 {
+			return
+		}
+		data := common.Batch{
+			Items: ctx.items,
+			Sync:  e.Sync,
+		}
+		for key, version := range ctx.versions {
+			data.Watches = append(data.Watches, common.Item{Key: []byte(key), Timestamp: version})
+		}
+		var old []common.Item
+		if err = self.Exec(data, &old); err != common.ErrWatchChanged {
+			return
+		}
+	}
+	return fmt.Errorf("Script %#v was interrupted by other writers %v times", e.Script, maxScriptAttempts)
+}
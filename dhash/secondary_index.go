@@ -0,0 +1,48 @@
+package dhash
+
+import (
+	"bytes"
+	"github.com/zond/god/common"
+)
+
+// indexes returns the names and fields of the indexes declared in the configuration of this node.
+func (self *Node) indexes() map[string]string {
+	conf, _ := self.tree.Configuration()
+	return common.Indexes(conf)
+}
+
+// reindex will move key from the index sub trees matching oldValue to those matching newValue, for all declared indexes.
+// It watches every change to the byte values of this node, so that puts, deletes, expiry, eviction and repairs alike keep the indexes up to date,
+// but only the owner of key updates them. The index sub trees are owned by other nodes, so this is done by a client in the background,
+// since the tree of this node is locked while it is told about the change.
+func (self *Node) reindex(key, oldValue []byte, oldExisted bool, newValue []byte, newExists bool) {
+	if common.IsIndexKey(key) || !common.BetweenIE(key, self.node.GetPredecessor().Pos, self.node.GetPosition()) {
+		return
+	}
+	go func() {
+		indexes := self.indexes()
+		if len(indexes) == 0 {
+			return
+		}
+		conn := self.client()
+		for name, field := range indexes {
+			var oldField, newField []byte
+			var oldOk, newOk bool
+			if oldExisted {
+				oldField, oldOk = common.ExtractField(oldValue, field)
+			}
+			if newExists {
+				newField, newOk = common.ExtractField(newValue, field)
+			}
+			if oldOk && newOk && bytes.Equal(oldField, newField) {
+				continue
+			}
+			if oldOk {
+				conn.SSubDel(common.IndexKey(name, oldField), key)
+			}
+			if newOk {
+				conn.SSubPut(common.IndexKey(name, newField), key, nil)
+			}
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+	"github.com/zond/god/discord"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogSlowerThan will make this node remember the size latest DHash calls it served that took longer than threshold to handle, for SlowLog to return,
+// forgetting the ones it remembers. A size of 0 turns the slow log off.
+func (self *Node) LogSlowerThan(threshold time.Duration, size int) *Node {
+	self.slowLock.Lock()
+	defer self.slowLock.Unlock()
+	self.slowThreshold = threshold
+	self.slowOps = make([]common.SlowOp, size)
+	self.nextSlowOp, self.slowOpsFull = 0, false
+	return self
+}
+
+// SlowLog returns the slow calls this node remembers, slowest first.
+func (self *Node) SlowLog() (result []common.SlowOp) {
+	self.slowLock.Lock()
+	defer self.slowLock.Unlock()
+	if self.slowOpsFull {
+		result = append(result, self.slowOps...)
+	} else {
+		result = append(result, self.slowOps[:self.nextSlowOp]...)
+	}
+	sort.Sort(common.SlowOps(result))
+	return
+}
+
+// ResetSlowLog will make this node forget the slow calls it remembers.
+func (self *Node) ResetSlowLog() {
+	self.slowLock.Lock()
+	defer self.slowLock.Unlock()
+	self.nextSlowOp, self.slowOpsFull = 0, false
+}
+
+// waitingCalls are the DHash calls that wait for something to happen, and are slow by design.
+var waitingCalls = map[string]bool{
+	"DHash.BLPop":   true,
+	"DHash.BRPop":   true,
+	"DHash.Receive": true,
+}
+
+// logSlow will remember call if it is a DHash call, that doesn't wait by design, that took longer than the threshold set by LogSlowerThan.
+func (self *Node) logSlow(call discord.Call) bool {
+	if !strings.HasPrefix(call.Method, "DHash.") || waitingCalls[call.Method] {
+		return true
+	}
+	self.slowLock.Lock()
+	defer self.slowLock.Unlock()
+	if len(self.slowOps) == 0 || call.Took <= self.slowThreshold {
+		return true
+	}
+	self.slowOps[self.nextSlowOp] = common.SlowOp{
+		Addr:   self.GetBroadcastAddr(),
+		Method: call.Method,
+		Key:    keyOf(call.Args),
+		At:     call.Started,
+		Took:   call.Took,
+	}
+	if self.nextSlowOp++; self.nextSlowOp == len(self.slowOps) {
+		self.nextSlowOp, self.slowOpsFull = 0, true
+	}
+	return true
+}
+
+// keyOf returns the Key field of the arguments of a call, if they have one.
+func keyOf(args interface{}) []byte {
+	value := reflect.Indirect(reflect.ValueOf(args))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	if field := value.FieldByName("Key"); field.IsValid() {
+		if key, ok := field.Interface().([]byte); ok {
+			return key
+		}
+	}
+	return nil
+}
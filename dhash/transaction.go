@@ -0,0 +1,61 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+)
+
+// Exec will apply data.Items as one transaction, and return the values they replaced.
+// Items with Exists set are put, the others deleted, and items with a SubKey are put in or deleted from the sub tree defined by their Key.
+// Other writers on this node see either none or all of the transaction, and it is logged, and sent to the replicas, as one operation.
+// If any of the data.Watches has a Timestamp other than the one of the byte value under its key, nothing is applied and common.ErrWatchChanged is returned.
+func (self *Node) Exec(data common.Batch, old *[]common.Item) error {
+	for _, item := range data.Items {
+		if item.Exists {
+			if err := self.full(item.Key); err != nil {
+				return err
+			}
+		}
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	var applied bool
+	if *old, applied = self.exec(data); !applied {
+		return common.ErrWatchChanged
+	}
+	for index, item := range data.Items {
+		if item.SubKey == nil {
+			if item.Exists {
+				self.notify(common.PutEvent, item.Key)
+			} else if (*old)[index].Exists {
+				self.notify(common.DelEvent, item.Key)
+			}
+		}
+	}
+	return nil
+}
+func (self *Node) exec(data common.Batch) (old []common.Item, applied bool) {
+	// The sub tree lock keeps list pushes and pops from interleaving with the transaction.
+	self.subLock.Lock()
+	old, applied = self.tree.ApplyIf(data.Watches, data.Items, data.Timestamp)
+	self.subLock.Unlock()
+	if !applied {
+		return
+	}
+	if data.TTL > 1 && len(data.Items) > 0 {
+		// The replicas don't need to check the watches again, and might not have the same timestamps yet.
+		data.Watches = nil
+		if data.Sync {
+			self.forwardBatch(data, "DHash.SlaveExec")
+		} else {
+			go self.forwardBatch(data, "DHash.SlaveExec")
+		}
+	}
+	for _, item := range data.Items {
+		if item.Exists {
+			self.touch(item.Key)
+		} else if item.SubKey == nil {
+			self.untouch(item.Key)
+		}
+	}
+	self.evict()
+	return
+}
@@ -0,0 +1,74 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+)
+
+// Type will return the kind of value under key: the kind of its sub tree if it has one, common.StringType if it only has a byte value,
+// and common.NoneType if it has neither. Expired byte values are treated as missing, like Get does.
+func (self *Node) Type(key []byte, typ *string) error {
+	if self.tree.SubSize(key) > 0 {
+		if conf, _ := self.tree.SubConfiguration(key); conf[typeConf] != "" {
+			*typ = conf[typeConf]
+		} else {
+			*typ = common.TreeType
+		}
+	} else if _, timestamp, existed := self.tree.Get(key); existed && !self.expired(key, timestamp) {
+		*typ = common.StringType
+	} else {
+		*typ = common.NoneType
+	}
+	return nil
+}
+
+// checkType will return common.ErrWrongType unless key has no byte value, or an expired one, and either no sub tree or a sub tree of kind typ or of no kind yet.
+// If record is true typ is recorded as the kind of the sub tree, unless it already is. It must be called with the sub tree lock held.
+func (self *Node) checkType(key []byte, typ string, record bool) error {
+	if _, timestamp, existed := self.tree.Get(key); existed && !self.expired(key, timestamp) {
+		return common.ErrWrongType
+	}
+	conf, _ := self.tree.SubConfiguration(key)
+	if current := conf[typeConf]; current != typ {
+		// An empty sub tree holds no value, whatever kind it used to be.
+		if current != "" && self.tree.SubSize(key) > 0 {
+			return common.ErrWrongType
+		}
+		if record {
+			self.SubAddConfiguration(common.ConfItem{
+				TreeKey: key,
+				Key:     typeConf,
+				Value:   typ,
+			})
+		}
+	}
+	return nil
+}
+
+// typedSubPut will put data.Value under data.SubKey in the sub tree defined by data.Key, of kind typ.
+func (self *Node) typedSubPut(data common.Item, typ string) (err error) {
+	if err = self.full(data.Key); err != nil {
+		return
+	}
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+	if err = self.checkType(data.Key, typ, true); err != nil {
+		return
+	}
+	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
+	return self.subPut(data)
+}
+
+// SAdd will put data.Value under data.SubKey in the set defined by data.Key.
+func (self *Node) SAdd(data common.Item) error {
+	return self.typedSubPut(data, common.SetType)
+}
+
+// HSet will put data.Value under data.SubKey in the hash defined by data.Key.
+func (self *Node) HSet(data common.Item) error {
+	return self.typedSubPut(data, common.HashType)
+}
+
+// HIncrBy will add the common.EncodeInt64 encoded data.Value to the counter under data.SubKey in the hash defined by data.Key, like SubAddInt64.
+func (self *Node) HIncrBy(data common.Item, result *int64) error {
+	return self.subAddInt64(data, common.HashType, result)
+}
@@ -3,6 +3,10 @@ package discord
 import (
 	"fmt"
 	"github.com/zond/god/common"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -28,3 +32,256 @@ func TestStartup(t *testing.T) {
 		return fmt.Sprint(routes), len(routes) == 1 && nodes[0].ring.Size() > 0
 	}, time.Second*30)
 }
+
+func TestMaxConnections(t *testing.T) {
+	node := NewNode("127.0.0.1:9291", "127.0.0.1:9291").SetMaxConnections(5)
+	node.MustStart()
+	defer node.Stop()
+	var conns []net.Conn
+	for i := 0; i < 20; i++ {
+		conn, err := net.Dial("tcp", node.GetBroadcastAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		health := node.Health()
+		return fmt.Sprintf("%+v", health), health.Connections == 5
+	}, time.Second*5)
+	if health := node.Health(); health.Connections > 5 || health.Goroutines != health.Connections+3 {
+		t.Errorf("%+v is out of bounds", health)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		health := node.Health()
+		return fmt.Sprintf("%+v", health), health.Connections < 5 && health.Goroutines == health.Connections+3
+	}, time.Second*5)
+}
+
+func TestCallAtMaxConnections(t *testing.T) {
+	node := NewNode("127.0.0.1:9295", "127.0.0.1:9295").SetMaxConnections(2)
+	node.MustStart()
+	defer node.Stop()
+	var conns []net.Conn
+	for i := 0; i < 5; i++ {
+		conn, err := net.Dial("tcp", node.GetBroadcastAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		health := node.Health()
+		return fmt.Sprintf("%+v", health), health.Connections == 2
+	}, time.Second*5)
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	var health Health
+	if err := node.Remote().Call("Discord.Health", 0, &health); err != nil || health.MaxConnections != 2 {
+		t.Errorf("wanted a max of 2 connections, got %+v, %v", health, err)
+	}
+}
+
+func TestUnixSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var nodes []*Node
+	for i := 0; i < 2; i++ {
+		addr := fmt.Sprintf("unix:%v", filepath.Join(dir, fmt.Sprint(i)))
+		nodes = append(nodes, NewNode(addr, addr))
+		nodes[i].MustStart()
+		defer nodes[i].Stop()
+	}
+	nodes[1].MustJoin(nodes[0].GetBroadcastAddr())
+	common.AssertWithin(t, func() (string, bool) {
+		return nodes[0].Nodes().Describe(), nodes[0].CountNodes() == 2 && nodes[1].CountNodes() == 2
+	}, time.Second*10)
+	var health Health
+	if err := nodes[1].GetSuccessor().Call("Discord.Health", 0, &health); err != nil || health.Goroutines == 0 {
+		t.Errorf("wanted the health of the other node, got %+v, %v", health, err)
+	}
+	mixed := NewNode(fmt.Sprintf("unix:%v", filepath.Join(dir, "mixed")), "127.0.0.1:9297")
+	if err := mixed.Start(); err == nil {
+		t.Errorf("wanted an error starting a node that listens at a unix socket but broadcasts a tcp address")
+	}
+}
+
+type echoServer struct{}
+
+func (self *echoServer) Echo(s string, result *string) error {
+	*result = s
+	return nil
+}
+
+type keyServer struct{}
+
+func (self *keyServer) Keys(n int, result *[]string) error {
+	for i := 0; i < n; i++ {
+		*result = append(*result, fmt.Sprintf("key-%v", i))
+	}
+	return nil
+}
+
+func TestCompression(t *testing.T) {
+	node := NewNode("127.0.0.1:9311", "127.0.0.1:9311")
+	node.Export("Keys", &keyServer{})
+	node.MustStart()
+	defer node.Stop()
+	common.Switch.SetCompression(true)
+	defer common.Switch.SetCompression(false)
+	var keys []string
+	if err := common.Switch.Call(node.GetBroadcastAddr(), "Keys.Keys", 100000, &keys); err != nil {
+		t.Fatalf("wanted no error, got %v", err)
+	}
+	if len(keys) != 100000 {
+		t.Fatalf("wanted 100000 keys, got %v", len(keys))
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("key-%v", i) {
+			t.Fatalf("wanted key-%v at %v, got %v", i, i, key)
+		}
+	}
+}
+
+func TestExportAfterStart(t *testing.T) {
+	node := NewNode("127.0.0.1:9293", "127.0.0.1:9293")
+	node.MustStart()
+	if err := node.Export("Echo", &echoServer{}); err != nil {
+		t.Fatalf("wanted no error exporting a started node, got %v", err)
+	}
+	var result string
+	if err := common.Switch.Call(node.GetBroadcastAddr(), "Echo.Echo", "hello", &result); err != nil || result != "hello" {
+		t.Errorf("wanted hello, nil, got %v, %v", result, err)
+	}
+	if err := node.Export("Discord", &echoServer{}); err == nil {
+		t.Errorf("wanted an error exporting an already exported name")
+	}
+	node.Stop()
+	if err := node.Export("Echo2", &echoServer{}); err == nil {
+		t.Errorf("wanted an error exporting a stopped node")
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	node := NewNode("127.0.0.1:9313", "127.0.0.1:9313")
+	node.MustStart()
+	defer node.Stop()
+	node.Export("Echo", &echoServer{})
+	var intercepted []string
+	node.AddInterceptor(func(method string, args interface{}) error {
+		if s, ok := args.(*string); ok {
+			intercepted = append(intercepted, method)
+			if *s == "forbidden" {
+				return fmt.Errorf("%v is forbidden", *s)
+			}
+			*s = "rewritten " + *s
+		}
+		return nil
+	})
+	var result string
+	if err := common.Switch.Call(node.GetBroadcastAddr(), "Echo.Echo", "hello", &result); err != nil || result != "rewritten hello" {
+		t.Errorf("wanted rewritten hello, nil, got %v, %v", result, err)
+	}
+	if err := common.Switch.Call(node.GetBroadcastAddr(), "Echo.Echo", "forbidden", &result); err == nil || err.Error() != "forbidden is forbidden" {
+		t.Errorf("wanted the interceptor to refuse the call, got %v", err)
+	}
+	if err := common.Switch.Call(node.GetBroadcastAddr(), "Echo.Echo", "again", &result); err != nil || result != "rewritten again" {
+		t.Errorf("wanted the connection to keep working after a refused call, got %v, %v", result, err)
+	}
+	if len(intercepted) != 3 || intercepted[0] != "Echo.Echo" || intercepted[2] != "Echo.Echo" {
+		t.Errorf("wanted 3 intercepted Echo.Echo calls, got %v", intercepted)
+	}
+}
+
+func TestRejoin(t *testing.T) {
+	firstPort := 9301
+	var nodes []*Node
+	n := 6
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, NewNode(fmt.Sprintf("127.0.0.1:%v", firstPort+i), fmt.Sprintf("127.0.0.1:%v", firstPort+i)))
+		nodes[i].MustStart()
+		defer nodes[i].Stop()
+	}
+	for i := 1; i < n; i++ {
+		nodes[i].MustJoin(nodes[0].GetBroadcastAddr())
+	}
+	converged := func(nodes []*Node) func() (string, bool) {
+		return func() (string, bool) {
+			routes := make(map[string]bool)
+			for _, node := range nodes {
+				routes[node.Nodes().Describe()] = true
+			}
+			return fmt.Sprint(routes), len(routes) == 1 && nodes[0].ring.Size() == len(nodes)
+		}
+	}
+	common.AssertWithin(t, converged(nodes), time.Second*30)
+	left, right := nodes[:n/2], nodes[n/2:]
+	for _, side := range [][]*Node{left, right} {
+		partition := common.NewRing()
+		for _, node := range side {
+			partition.Add(node.Remote())
+		}
+		for _, node := range side {
+			node.ring.SetNodes(partition.Nodes())
+		}
+	}
+	right[0].SetPosition(left[0].GetPosition())
+	common.AssertWithin(t, converged(left), time.Second*30)
+	common.AssertWithin(t, converged(right), time.Second*30)
+	if err := right[0].Rejoin(left[0].GetBroadcastAddr()); err != nil {
+		t.Fatalf("wanted no error rejoining, got %v", err)
+	}
+	common.AssertWithin(t, converged(nodes), time.Second*30)
+	positions := make(map[string]bool)
+	for _, remote := range nodes[0].Nodes() {
+		positions[string(remote.Pos)] = true
+	}
+	if len(positions) != n {
+		t.Errorf("wanted %v distinct positions, got %v", n, nodes[0].Nodes().Describe())
+	}
+}
+
+func TestSetRedundancy(t *testing.T) {
+	firstPort := 9321
+	var nodes []*Node
+	n := 5
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, NewNode(fmt.Sprintf("127.0.0.1:%v", firstPort+i), fmt.Sprintf("127.0.0.1:%v", firstPort+i)))
+		nodes[i].MustStart()
+		defer nodes[i].Stop()
+	}
+	for i := 1; i < n-1; i++ {
+		nodes[i].MustJoin(nodes[0].GetBroadcastAddr())
+	}
+	agreeOn := func(nodes []*Node, redundancy int) func() (string, bool) {
+		return func() (string, bool) {
+			var found []int
+			for _, node := range nodes {
+				found = append(found, node.Redundancy())
+			}
+			for _, r := range found {
+				if r != redundancy {
+					return fmt.Sprint(found), false
+				}
+			}
+			return fmt.Sprint(found), true
+		}
+	}
+	common.AssertWithin(t, agreeOn(nodes[:n-1], common.Redundancy), time.Second*10)
+	nodes[2].SetRedundancy(2)
+	common.AssertWithin(t, agreeOn(nodes[:n-1], 2), time.Second*10)
+	nodes[1].SetRedundancy(4)
+	common.AssertWithin(t, agreeOn(nodes[:n-1], 4), time.Second*10)
+	nodes[n-1].MustJoin(nodes[0].GetBroadcastAddr())
+	common.AssertWithin(t, agreeOn(nodes, 4), time.Second*10)
+}
@@ -0,0 +1,166 @@
+package discord
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Interceptor is a function intercepting the rpc calls served by a Node, including the Discord calls between the Nodes themselves, before they are handled.
+// args is a pointer to the decoded arguments of the call to method, so it can be inspected or rewritten.
+// If it returns an error the call is not handled, and the caller gets the error instead.
+type Interceptor func(method string, args interface{}) error
+
+// AddInterceptor will make this Node let f intercept every rpc call it serves from now on, after the Interceptors added before it.
+func (self *Node) AddInterceptor(f Interceptor) {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.interceptors = append(self.interceptors, f)
+}
+func (self *Node) intercept(method string, args interface{}) error {
+	self.metaLock.RLock()
+	interceptors := self.interceptors
+	self.metaLock.RUnlock()
+	for _, interceptor := range interceptors {
+		if err := interceptor(method, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call is an rpc call to Method, with the decoded arguments in Args, that a Node served to the connection from Caller.
+// Started is when it was decoded, Took how long handling it took, and Err the error returned to the caller, if any.
+type Call struct {
+	Caller  string
+	Method  string
+	Args    interface{}
+	Started time.Time
+	Took    time.Duration
+	Err     error
+}
+
+// CallListener is a function listening for the rpc calls served by a Node once they are handled.
+type CallListener func(call Call) (keep bool)
+
+// AddCallListener will make this Node tell f about every rpc call it handles from now on, until f returns false.
+func (self *Node) AddCallListener(f CallListener) {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.callListeners = append(self.callListeners, f)
+}
+func (self *Node) hasCallListeners() bool {
+	self.metaLock.RLock()
+	defer self.metaLock.RUnlock()
+	return len(self.callListeners) > 0
+}
+func (self *Node) triggerCallListeners(call Call) {
+	self.metaLock.RLock()
+	newListeners := make([]CallListener, 0, len(self.callListeners))
+	for _, l := range self.callListeners {
+		self.metaLock.RUnlock()
+		if l(call) {
+			newListeners = append(newListeners, l)
+		}
+		self.metaLock.RLock()
+	}
+	self.metaLock.RUnlock()
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.callListeners = newListeners
+}
+
+// serverCodec is the gob codec rpc.Server#ServeConn uses, except that it lets the Interceptors of its Node intercept every call, and tells its CallListeners about them.
+type serverCodec struct {
+	node   *Node
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	caller string
+	method string
+	seq    uint64
+	calls  map[uint64]Call
+	lock   sync.Mutex
+	closed bool
+}
+
+func newServerCodec(node *Node, caller string, conn io.ReadWriteCloser) *serverCodec {
+	buf := bufio.NewWriter(conn)
+	return &serverCodec{
+		node:   node,
+		caller: caller,
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+		calls:  make(map[uint64]Call),
+	}
+}
+func (self *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := self.dec.Decode(r); err != nil {
+		return err
+	}
+	self.method, self.seq = r.ServiceMethod, r.Seq
+	return nil
+}
+func (self *serverCodec) ReadRequestBody(body interface{}) error {
+	if err := self.dec.Decode(body); err != nil {
+		return err
+	}
+	// A nil body means the call is discarded, for example because the method is unknown.
+	if body == nil {
+		return nil
+	}
+	if err := self.node.intercept(self.method, body); err != nil {
+		return err
+	}
+	if self.node.hasCallListeners() {
+		self.lock.Lock()
+		self.calls[self.seq] = Call{
+			Caller:  self.caller,
+			Method:  self.method,
+			Args:    body,
+			Started: time.Now(),
+		}
+		self.lock.Unlock()
+	}
+	return nil
+}
+func (self *serverCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	self.lock.Lock()
+	call, found := self.calls[r.Seq]
+	delete(self.calls, r.Seq)
+	self.lock.Unlock()
+	if found {
+		call.Took = time.Since(call.Started)
+		if r.Error != "" {
+			call.Err = rpc.ServerError(r.Error)
+		}
+		// Tell the listeners before the caller gets the response, so that they know about the call once it returns.
+		self.node.triggerCallListeners(call)
+	}
+	if err = self.enc.Encode(r); err != nil {
+		if self.encBuf.Flush() == nil {
+			self.Close()
+		}
+		return
+	}
+	if err = self.enc.Encode(body); err != nil {
+		if self.encBuf.Flush() == nil {
+			self.Close()
+		}
+		return
+	}
+	return self.encBuf.Flush()
+}
+func (self *serverCodec) Close() error {
+	if self.closed {
+		return nil
+	}
+	self.closed = true
+	return self.rwc.Close()
+}
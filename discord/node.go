@@ -21,6 +21,26 @@ type PingPack struct {
 	RingHash []byte
 }
 
+// NotifyPack contains the sender, and the redundancy setting of its ring, to let the receiver adopt the setting if it was chosen later than its own.
+type NotifyPack struct {
+	Caller     common.Remote
+	Redundancy common.RedundancySetting
+}
+
+// NotifyReply contains the predecessor of the receiver of a NotifyPack, and the redundancy setting of its ring, to let the sender adopt the setting
+// if it was chosen later than its own.
+type NotifyReply struct {
+	Predecessor common.Remote
+	Redundancy  common.RedundancySetting
+}
+
+// Health contains the number of goroutines and connections spawned by a Node, and the configured maximum number of connections.
+type Health struct {
+	Goroutines     int
+	Connections    int
+	MaxConnections int
+}
+
 const (
 	created = iota
 	started
@@ -32,16 +52,22 @@ const (
 // Like chord networks, it is a ring of nodes ordered by a position metric. Unlike chord, every node has every other node in its routing table.
 // This allows stable networks to route with a constant time complexity.
 type Node struct {
-	ring          *common.Ring
-	position      []byte
-	listenAddr    string
-	broadcastAddr string
-	listener      *net.TCPListener
-	metaLock      *sync.RWMutex
-	routeLock     *sync.Mutex
-	state         int32
-	exports       map[string]interface{}
-	commListeners []CommListener
+	ring           *common.Ring
+	position       []byte
+	listenAddr     string
+	broadcastAddr  string
+	listener       net.Listener
+	metaLock       *sync.RWMutex
+	routeLock      *sync.Mutex
+	state          int32
+	goroutines     int32
+	connections    int32
+	maxConnections int32
+	exports        map[string]interface{}
+	server         *rpc.Server
+	commListeners  []CommListener
+	interceptors   []Interceptor
+	callListeners  []CallListener
 }
 
 func NewNode(listenAddr, broadcastAddr string) (result *Node) {
@@ -58,14 +84,20 @@ func NewNode(listenAddr, broadcastAddr string) (result *Node) {
 }
 
 // Export will export the given api on a net/rpc server running on this Node.
+// If this Node is already started the api will be registered on the running server immediately.
 func (self *Node) Export(name string, api interface{}) error {
-	if self.hasState(created) {
-		self.metaLock.Lock()
-		defer self.metaLock.Unlock()
-		self.exports[name] = api
-		return nil
+	if self.hasState(stopped) {
+		return fmt.Errorf("%v can not export when in state 'stopped'", self)
+	}
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	if self.server != nil {
+		if err := self.server.RegisterName(name, api); err != nil {
+			return err
+		}
 	}
-	return fmt.Errorf("%v can only export when in state 'created'")
+	self.exports[name] = api
+	return nil
 }
 func (self *Node) AddCommListener(f CommListener) {
 	self.metaLock.Lock()
@@ -101,6 +133,23 @@ func (self *Node) SetPosition(position []byte) *Node {
 	return self
 }
 
+// SetMaxConnections will make this Node stop accepting incoming connections while it serves max connections at the same time,
+// leaving new connections waiting in the listen backlog until a served connection closes. A max of 0 means no limit.
+// It has to be called before Start.
+func (self *Node) SetMaxConnections(max int) *Node {
+	atomic.StoreInt32(&self.maxConnections, int32(max))
+	return self
+}
+
+// Health will return the current number of goroutines and connections spawned by this Node.
+func (self *Node) Health() Health {
+	return Health{
+		Goroutines:     int(atomic.LoadInt32(&self.goroutines)),
+		Connections:    int(atomic.LoadInt32(&self.connections)),
+		MaxConnections: int(atomic.LoadInt32(&self.maxConnections)),
+	}
+}
+
 // GetNodes will return remotes to all Nodes in the ring.
 func (self *Node) GetNodes() (result common.Remotes) {
 	return self.ring.Nodes()
@@ -111,6 +160,30 @@ func (self *Node) Redundancy() int {
 	return self.ring.Redundancy()
 }
 
+// SetRedundancy will make the ring store each value on redundancy Nodes, or all of them if there are fewer.
+// The setting is passed on to the other Nodes when they notify each other, replacing the ones chosen before it, so the whole ring agrees on it.
+func (self *Node) SetRedundancy(redundancy int) *Node {
+	if redundancy < 1 {
+		panic(fmt.Errorf("%v can't store values on %v nodes", self, redundancy))
+	}
+	self.ring.SetRedundancy(common.RedundancySetting{
+		Redundancy: redundancy,
+		Set:        time.Now().UnixNano(),
+	})
+	return self
+}
+
+// RedundancySetting returns the redundancy setting of the ring, which is empty if none has been chosen with SetRedundancy.
+func (self *Node) RedundancySetting() common.RedundancySetting {
+	return self.ring.RedundancySetting()
+}
+func (self *Node) notifyPack() NotifyPack {
+	return NotifyPack{
+		Caller:     self.Remote(),
+		Redundancy: self.ring.RedundancySetting(),
+	}
+}
+
 // CountNodes returns the number of Nodes in the ring.
 func (self *Node) CountNodes() int {
 	return self.ring.Size()
@@ -151,12 +224,12 @@ func (self *Node) hasState(s int32) bool {
 func (self *Node) changeState(old, neu int32) bool {
 	return atomic.CompareAndSwapInt32(&self.state, old, neu)
 }
-func (self *Node) getListener() *net.TCPListener {
+func (self *Node) getListener() net.Listener {
 	self.metaLock.RLock()
 	defer self.metaLock.RUnlock()
 	return self.listener
 }
-func (self *Node) setListener(l *net.TCPListener) {
+func (self *Node) setListener(l net.Listener) {
 	self.metaLock.Lock()
 	defer self.metaLock.Unlock()
 	self.listener = l
@@ -180,19 +253,20 @@ func (self *Node) MustStart() {
 }
 
 // Start will spin up this Node, export all its api interfaces and start its notify and ping jobs.
+// Addresses prefixed with "unix:" will make it listen at a Unix domain socket instead of a TCP port, see common.SplitAddr.
 func (self *Node) Start() (err error) {
 	if !self.changeState(created, started) {
 		return fmt.Errorf("%v can only be started when in state 'created'", self)
 	}
-	if self.listenAddr == "" {
+	network, addr := common.SplitAddr(self.listenAddr)
+	if addr == "" {
 		return fmt.Errorf("%v needs to have an address to listen at", self)
 	}
-	var addr *net.TCPAddr
-	if addr, err = net.ResolveTCPAddr("tcp", self.listenAddr); err != nil {
-		return
+	if broadcastNetwork, _ := common.SplitAddr(self.broadcastAddr); broadcastNetwork != network {
+		return fmt.Errorf("%v listens at a %v address, but broadcasts a %v address", self, network, broadcastNetwork)
 	}
-	var listener *net.TCPListener
-	if listener, err = net.ListenTCP("tcp", addr); err != nil {
+	var listener net.Listener
+	if listener, err = net.Listen(network, addr); err != nil {
 		return
 	}
 	self.setListener(listener)
@@ -200,24 +274,62 @@ func (self *Node) Start() (err error) {
 	if err = server.RegisterName("Discord", (*nodeServer)(self)); err != nil {
 		return
 	}
+	self.metaLock.Lock()
 	for name, api := range self.exports {
 		if err = server.RegisterName(name, api); err != nil {
+			self.metaLock.Unlock()
 			return
 		}
 	}
+	self.server = server
+	self.metaLock.Unlock()
 	self.ring.Add(self.Remote())
-	go server.Accept(self.getListener())
+	atomic.AddInt32(&self.goroutines, 3)
+	go self.accept(server, self.getListener())
 	go self.notifyPeriodically()
 	go self.pingPeriodically()
 	return
 }
+func (self *Node) serve(server *rpc.Server, conn net.Conn, slots chan bool) {
+	defer atomic.AddInt32(&self.goroutines, -1)
+	defer atomic.AddInt32(&self.connections, -1)
+	if slots != nil {
+		defer func() { <-slots }()
+	}
+	caller := ""
+	if addr := conn.RemoteAddr(); addr != nil {
+		caller = addr.String()
+	}
+	server.ServeCodec(newServerCodec(self, caller, common.AcceptConn(conn)))
+}
+func (self *Node) accept(server *rpc.Server, listener net.Listener) {
+	defer atomic.AddInt32(&self.goroutines, -1)
+	var slots chan bool
+	if max := atomic.LoadInt32(&self.maxConnections); max > 0 {
+		slots = make(chan bool, max)
+	}
+	for {
+		if slots != nil {
+			slots <- true
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&self.connections, 1)
+		atomic.AddInt32(&self.goroutines, 1)
+		go self.serve(server, conn, slots)
+	}
+}
 func (self *Node) notifyPeriodically() {
+	defer atomic.AddInt32(&self.goroutines, -1)
 	for self.hasState(started) {
 		self.notifySuccessor()
 		time.Sleep(common.PingInterval)
 	}
 }
 func (self *Node) pingPeriodically() {
+	defer atomic.AddInt32(&self.goroutines, -1)
 	for self.hasState(started) {
 		self.pingPredecessor()
 		time.Sleep(common.PingInterval)
@@ -271,26 +383,31 @@ func (self *Node) Nodes() common.Remotes {
 	return self.ring.Nodes()
 }
 
-// Notify will add the caller to the ring of this Node.
-func (self *Node) Notify(caller common.Remote) common.Remote {
+// Notify will add the caller to the ring of this Node, and adopt its redundancy setting if it was chosen later than ours.
+func (self *Node) Notify(pack NotifyPack) NotifyReply {
 	self.routeLock.Lock()
 	defer self.routeLock.Unlock()
-	self.ring.Add(caller)
-	return self.GetPredecessor()
+	self.ring.Add(pack.Caller)
+	self.ring.SetRedundancy(pack.Redundancy)
+	return NotifyReply{
+		Predecessor: self.GetPredecessor(),
+		Redundancy:  self.ring.RedundancySetting(),
+	}
 }
 func (self *Node) notifySuccessor() {
 	succ := self.GetSuccessor()
-	var otherPred common.Remote
+	var reply NotifyReply
 	op := "Discord.Notify"
-	selfRemote := self.Remote()
-	self.triggerCommListeners(selfRemote, succ, op)
-	if err := succ.Call(op, selfRemote, &otherPred); err != nil {
+	pack := self.notifyPack()
+	self.triggerCommListeners(pack.Caller, succ, op)
+	if err := succ.Call(op, pack, &reply); err != nil {
 		self.RemoveNode(succ)
 	} else {
-		if otherPred.Addr != self.GetBroadcastAddr() {
+		self.ring.SetRedundancy(reply.Redundancy)
+		if reply.Predecessor.Addr != self.GetBroadcastAddr() {
 			self.routeLock.Lock()
 			defer self.routeLock.Unlock()
-			self.ring.Add(otherPred)
+			self.ring.Add(reply.Predecessor)
 		}
 	}
 }
@@ -312,10 +429,52 @@ func (self *Node) Join(addr string) (err error) {
 	self.routeLock.Lock()
 	self.ring.SetNodes(newNodes)
 	self.routeLock.Unlock()
-	var x common.Remote
-	if err = common.Switch.Call(addr, "Discord.Notify", self.Remote(), &x); err != nil {
+	var reply NotifyReply
+	if err = common.Switch.Call(addr, "Discord.Notify", self.notifyPack(), &reply); err != nil {
+		return
+	}
+	self.ring.SetRedundancy(reply.Redundancy)
+	return
+}
+
+// Rejoin will fetch the routing ring of the Node at addr and merge it with ours, keeping our position unless the received ring has another Node at it,
+// and notify the other Node of our presence. Unlike Join it is meant for Nodes that already have a position and data, such as after a healed network partition.
+// Nodes in our ring sharing a position with a Node in the received ring are replaced by the received ones.
+func (self *Node) Rejoin(addr string) (err error) {
+	var newNodes common.Remotes
+	if err = common.Switch.Call(addr, "Discord.Nodes", 0, &newNodes); err != nil {
+		return
+	}
+	me := self.Remote()
+	merged := common.NewRingNodes(self.Nodes())
+	overlap := false
+	for _, remote := range newNodes {
+		if remote.Addr == me.Addr {
+			continue
+		}
+		if bytes.Compare(remote.Pos, me.Pos) == 0 {
+			overlap = true
+		}
+		for _, current := range merged.Nodes() {
+			if current.Addr != remote.Addr && current.Addr != me.Addr && bytes.Compare(current.Pos, remote.Pos) == 0 {
+				merged.Remove(current)
+			}
+		}
+		merged.Add(remote)
+	}
+	if overlap {
+		merged.Remove(me)
+		self.SetPosition(merged.GetSlot())
+		merged.Add(self.Remote())
+	}
+	self.routeLock.Lock()
+	self.ring.SetNodes(merged.Nodes())
+	self.routeLock.Unlock()
+	var reply NotifyReply
+	if err = common.Switch.Call(addr, "Discord.Notify", self.notifyPack(), &reply); err != nil {
 		return
 	}
+	self.ring.SetRedundancy(reply.Redundancy)
 	return
 }
 
@@ -6,8 +6,12 @@ import (
 
 type nodeServer Node
 
-func (self *nodeServer) Notify(caller common.Remote, predecessor *common.Remote) error {
-	*predecessor = (*Node)(self).Notify(caller)
+func (self *nodeServer) Notify(pack NotifyPack, reply *NotifyReply) error {
+	*reply = (*Node)(self).Notify(pack)
+	return nil
+}
+func (self *nodeServer) Redundancy(x int, setting *common.RedundancySetting) error {
+	*setting = (*Node)(self).RedundancySetting()
 	return nil
 }
 func (self *nodeServer) Nodes(x int, nodes *common.Remotes) error {
@@ -26,3 +30,11 @@ func (self *nodeServer) GetSuccessorFor(key []byte, successor *common.Remote) er
 	*successor = (*Node)(self).GetSuccessorFor(key)
 	return nil
 }
+func (self *nodeServer) Capabilities(x int, capabilities *common.Capabilities) error {
+	*capabilities = common.Capabilities{Compression: true}
+	return nil
+}
+func (self *nodeServer) Health(x int, health *Health) error {
+	*health = (*Node)(self).Health()
+	return nil
+}
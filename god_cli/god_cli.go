@@ -12,9 +12,11 @@ import (
 	"math/big"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,9 +24,10 @@ const (
 	floatFormat  = "float"
 	intFormat    = "int"
 	bigFormat    = "big"
+	hexFormat    = "hex"
 )
 
-var formats = []string{stringFormat, floatFormat, intFormat, bigFormat}
+var formats = []string{stringFormat, floatFormat, intFormat, bigFormat, hexFormat}
 
 type action func(conn *client.Conn, args []string)
 
@@ -54,6 +57,12 @@ func encode(s string) []byte {
 			panic(fmt.Errorf("Bad BigInt format: %v", s))
 		}
 		return common.EncodeBigInt(result)
+	case hexFormat:
+		result, err := hex.DecodeString(s)
+		if err != nil {
+			panic(err)
+		}
+		return result
 	}
 	panic(fmt.Errorf("Unknown encoding: %v", *enc))
 }
@@ -75,80 +84,216 @@ func decode(b []byte) string {
 		return fmt.Sprint(res)
 	case bigFormat:
 		return fmt.Sprint(common.DecodeBigInt(b))
+	case hexFormat:
+		return hex.EncodeToString(b)
 	}
 	panic(fmt.Errorf("Unknown encoding: %v", *enc))
 }
 
 type actionSpec struct {
-	cmd  string
-	args []*regexp.Regexp
+	cmd    string
+	args   []*regexp.Regexp
+	params []string
 }
 
+// newActionSpec parses a pattern like "put KEY:\\S+ VALUE:\\S+", where each argument is named and followed by the regular expression it has to match.
+// Trailing arguments in brackets, like "[COUNT]", are optional and only used to describe the command.
 func newActionSpec(pattern string) (result *actionSpec) {
 	result = &actionSpec{}
 	parts := strings.Split(pattern, " ")
 	result.cmd = parts[0]
-	for _, r := range parts[1:] {
-		result.args = append(result.args, regexp.MustCompile(r))
+	optional := false
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "[") {
+			optional = true
+		}
+		if optional {
+			result.params = append(result.params, part)
+			continue
+		}
+		param := strings.SplitN(part, ":", 2)
+		if len(param) != 2 {
+			panic(fmt.Errorf("%v in %v is not on the form NAME:REGEXP", part, pattern))
+		}
+		result.params = append(result.params, param[0])
+		result.args = append(result.args, regexp.MustCompile(param[1]))
+	}
+	return
+}
+
+func (self *actionSpec) usage() string {
+	return strings.Join(append([]string{self.cmd}, self.params...), " ")
+}
+
+func (self *actionSpec) matches(args []string) bool {
+	if len(args) < len(self.args)+1 {
+		return false
+	}
+	for index, reg := range self.args {
+		if !reg.MatchString(args[index+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// findAction will return the action matching args, or an error telling what the command expected.
+func findAction(args []string) (result action, err error) {
+	var known *actionSpec
+	for spec, fun := range actions {
+		if spec.cmd == args[0] {
+			known = spec
+			if spec.matches(args) {
+				return fun, nil
+			}
+		}
+	}
+	if known != nil {
+		err = fmt.Errorf("Wrong arguments to %v, expected: %v", args[0], known.usage())
+	} else {
+		err = fmt.Errorf("Unknown command: %v", args[0])
 	}
 	return
 }
 
 var actions = map[*actionSpec]action{
-	newActionSpec("mirrorReverseSliceIndex \\S+ \\d+ \\d+"): mirrorReverseSliceIndex,
-	newActionSpec("mirrorSliceIndex \\S+ \\d+ \\d+"):        mirrorSliceIndex,
-	newActionSpec("mirrorReverseSlice \\S+ \\S+ \\S+"):      mirrorReverseSlice,
-	newActionSpec("mirrorSlice \\S+ \\S+ \\S+"):             mirrorSlice,
-	newActionSpec("mirrorSliceLen \\S+ \\S+ \\d+"):          mirrorSliceLen,
-	newActionSpec("mirrorReverseSliceLen \\S+ \\S+ \\d+"):   mirrorReverseSliceLen,
-	newActionSpec("reverseSliceIndex \\S+ \\d+ \\d+"):       reverseSliceIndex,
-	newActionSpec("sliceIndex \\S+ \\d+ \\d+"):              sliceIndex,
-	newActionSpec("reverseSlice \\S+ \\S+ \\S+"):            reverseSlice,
-	newActionSpec("slice \\S+ \\S+ \\S+"):                   slice,
-	newActionSpec("sliceLen \\S+ \\S+ \\d+"):                sliceLen,
-	newActionSpec("reverseSliceLen \\S+ \\S+ \\d+"):         reverseSliceLen,
-	newActionSpec("setOp .+"):                               setOp,
-	newActionSpec("dumpSetOp \\S+ .+"):                      dumpSetOp,
-	newActionSpec("put \\S+ \\S+"):                          put,
-	newActionSpec("clear"):                                  clear,
-	newActionSpec("dump"):                                   dump,
-	newActionSpec("subDump \\S+"):                           subDump,
-	newActionSpec("subSize \\S+"):                           subSize,
-	newActionSpec("size"):                                   size,
-	newActionSpec("count \\S+ \\S+ \\S+"):                   count,
-	newActionSpec("mirrorCount \\S+ \\S+ \\S+"):             mirrorCount,
-	newActionSpec("get \\S+"):                               get,
-	newActionSpec("del \\S+"):                               del,
-	newActionSpec("subPut \\S+ \\S+ \\S+"):                  subPut,
-	newActionSpec("subGet \\S+ \\S+"):                       subGet,
-	newActionSpec("subDel \\S+ \\S+"):                       subDel,
-	newActionSpec("subClear \\S+"):                          subClear,
-	newActionSpec("describeAll"):                            describeAll,
-	newActionSpec("describe \\S+"):                          describe,
-	newActionSpec("describeTree \\S+"):                      describeTree,
-	newActionSpec("describeAllTrees"):                       describeAllTrees,
-	newActionSpec("mirrorFirst \\S+"):                       mirrorFirst,
-	newActionSpec("mirrorLast \\S+"):                        mirrorLast,
-	newActionSpec("mirrorPrevIndex \\S+ \\d+"):              mirrorPrevIndex,
-	newActionSpec("mirrorNextIndex \\S+ \\d+"):              mirrorNextIndex,
-	newActionSpec("first \\S+"):                             first,
-	newActionSpec("last \\S+"):                              last,
-	newActionSpec("prevIndex \\S+ \\d+"):                    prevIndex,
-	newActionSpec("nextIndex \\S+ \\d+"):                    nextIndex,
-	newActionSpec("next \\S+"):                              next,
-	newActionSpec("prev \\S+"):                              prev,
-	newActionSpec("subMirrorNext \\S+ \\S+"):                subMirrorNext,
-	newActionSpec("subMirrorPrev \\S+ \\S+"):                subMirrorPrev,
-	newActionSpec("mirrorIndexOf \\S+ \\S+"):                mirrorIndexOf,
-	newActionSpec("mirrorReverseIndexOf \\S+ \\S+"):         mirrorReverseIndexOf,
-	newActionSpec("subNext \\S+ \\S+"):                      subNext,
-	newActionSpec("subPrev \\S+ \\S+"):                      subPrev,
-	newActionSpec("indexOf \\S+ \\S+"):                      indexOf,
-	newActionSpec("reverseIndexOf \\S+ \\S+"):               reverseIndexOf,
-	newActionSpec("configuration"):                          configuration,
-	newActionSpec("subConfiguration \\S+"):                  subConfiguration,
-	newActionSpec("configure \\S+ \\S+"):                    configure,
-	newActionSpec("subConfigure \\S+ \\S+ \\S+"):            subConfigure,
+	newActionSpec("mirrorReverseSliceIndex KEY:\\S+ MIN_INDEX:\\d+ MAX_INDEX:\\d+"):  mirrorReverseSliceIndex,
+	newActionSpec("mirrorSliceIndex KEY:\\S+ MIN_INDEX:\\d+ MAX_INDEX:\\d+"):         mirrorSliceIndex,
+	newActionSpec("mirrorReverseSlice KEY:\\S+ MIN_VALUE:\\S+ MAX_VALUE:\\S+"):       mirrorReverseSlice,
+	newActionSpec("mirrorSlice KEY:\\S+ MIN_VALUE:\\S+ MAX_VALUE:\\S+"):              mirrorSlice,
+	newActionSpec("mirrorSliceLen KEY:\\S+ MIN_VALUE:\\S+ LEN:\\d+"):                 mirrorSliceLen,
+	newActionSpec("mirrorReverseSliceLen KEY:\\S+ MAX_VALUE:\\S+ LEN:\\d+"):          mirrorReverseSliceLen,
+	newActionSpec("reverseSliceIndex KEY:\\S+ MIN_INDEX:\\d+ MAX_INDEX:\\d+"):        reverseSliceIndex,
+	newActionSpec("sliceIndex KEY:\\S+ MIN_INDEX:\\d+ MAX_INDEX:\\d+"):               sliceIndex,
+	newActionSpec("reverseSlice KEY:\\S+ MIN_SUBKEY:\\S+ MAX_SUBKEY:\\S+"):           reverseSlice,
+	newActionSpec("slice KEY:\\S+ MIN_SUBKEY:\\S+ MAX_SUBKEY:\\S+"):                  slice,
+	newActionSpec("sliceLen KEY:\\S+ MIN_SUBKEY:\\S+ LEN:\\d+"):                      sliceLen,
+	newActionSpec("reverseSliceLen KEY:\\S+ MAX_SUBKEY:\\S+ LEN:\\d+"):               reverseSliceLen,
+	newActionSpec("setOp EXPRESSION:.+"):                                             setOp,
+	newActionSpec("dumpSetOp DESTINATION:\\S+ EXPRESSION:.+"):                        dumpSetOp,
+	newActionSpec("unionStore DESTINATION:\\S+ KEY1:\\S+ KEY2:\\S+"):                 unionStore,
+	newActionSpec("interStore DESTINATION:\\S+ KEY1:\\S+ KEY2:\\S+"):                 interStore,
+	newActionSpec("put KEY:\\S+ VALUE:\\S+"):                                         put,
+	newActionSpec("putExpire KEY:\\S+ VALUE:\\S+ LIFETIME:\\S+"):                     putExpire,
+	newActionSpec("ttl KEY:\\S+"):                                                    ttl,
+	newActionSpec("persist KEY:\\S+"):                                                persist,
+	newActionSpec("rename KEY:\\S+ DEST:\\S+"):                                       rename,
+	newActionSpec("renameNX KEY:\\S+ DEST:\\S+"):                                     renameNX,
+	newActionSpec("copy KEY:\\S+ DEST:\\S+"):                                         copyValue,
+	newActionSpec("copyKeepTTL KEY:\\S+ DEST:\\S+"):                                  copyKeepTTL,
+	newActionSpec("putNotify KEY:\\S+ VALUE:\\S+ CHANNEL:\\S+ MESSAGE:\\S+"):         putNotify,
+	newActionSpec("publish CHANNEL:\\S+ MESSAGE:\\S+"):                               publish,
+	newActionSpec("subscribe CHANNEL:\\S+ [CHANNELS...]"):                            subscribe,
+	newActionSpec("watch PATTERN:\\S+"):                                              watch,
+	newActionSpec("drainPrefix PREFIX:\\S+"):                                         drainPrefix,
+	newActionSpec("clear"):                                                           clear,
+	newActionSpec("dump"):                                                            dump,
+	newActionSpec("subDump KEY:\\S+"):                                                subDump,
+	newActionSpec("dumpKey KEY:\\S+"):                                                dumpKey,
+	newActionSpec("restoreKey KEY:\\S+ HEX_DUMP:[0-9a-fA-F]+ [REPLACE]"):             restoreKey,
+	newActionSpec("exportJson"):                                                      exportJson,
+	newActionSpec("importJson"):                                                      importJson,
+	newActionSpec("importRdb"):                                                       importRdb,
+	newActionSpec("subSize KEY:\\S+"):                                                subSize,
+	newActionSpec("snapshot"):                                                        snapshot,
+	newActionSpec("backup DIR:\\S+"):                                                 backup,
+	newActionSpec("usage"):                                                           usage,
+	newActionSpec("stats"):                                                           stats,
+	newActionSpec("metrics"):                                                         metrics,
+	newActionSpec("slowlog"):                                                         slowLog,
+	newActionSpec("slowlogReset"):                                                    resetSlowLog,
+	newActionSpec("flushDb NAME:\\S+"):                                               flushDb,
+	newActionSpec("addIndex NAME:\\S+ FIELD:\\S+"):                                   addIndex,
+	newActionSpec("query NAME:\\S+ VALUE:\\S+"):                                      query,
+	newActionSpec("size"):                                                            size,
+	newActionSpec("dbSize"):                                                          dbSize,
+	newActionSpec("randomKey"):                                                       randomKey,
+	newActionSpec("type KEY:\\S+"):                                                   typeOf,
+	newActionSpec("minKey"):                                                          minKey,
+	newActionSpec("maxKey"):                                                          maxKey,
+	newActionSpec("count KEY:\\S+ MIN_SUBKEY:\\S+ MAX_SUBKEY:\\S+"):                  count,
+	newActionSpec("mirrorCount KEY:\\S+ MIN_VALUE:\\S+ MAX_VALUE:\\S+"):              mirrorCount,
+	newActionSpec("get KEY:\\S+"):                                                    get,
+	newActionSpec("getMeta KEY:\\S+"):                                                getMeta,
+	newActionSpec("strlen KEY:\\S+"):                                                 strlen,
+	newActionSpec("replace KEY:\\S+ PATTERN:\\S+ REPLACEMENT:\\S+"):                  replace,
+	newActionSpec("getPut KEY:\\S+ VALUE:\\S+"):                                      getPut,
+	newActionSpec("getDel KEY:\\S+"):                                                 getDel,
+	newActionSpec("putIfMissing KEY:\\S+ VALUE:\\S+"):                                putIfMissing,
+	newActionSpec("compareAndSwap KEY:\\S+ EXPECTED:\\S+ VALUE:\\S+"):                compareAndSwap,
+	newActionSpec("compareAndDelete KEY:\\S+ EXPECTED:\\S+"):                         compareAndDelete,
+	newActionSpec("append KEY:\\S+ VALUE:\\S+"):                                      appendValue,
+	newActionSpec("appendIf CONDKEY:\\S+ KEY:\\S+ VALUE:\\S+"):                       appendIf,
+	newActionSpec("pfAdd KEY:\\S+ ELEMENT:\\S+"):                                     pfAdd,
+	newActionSpec("pfCount KEY:\\S+"):                                                pfCount,
+	newActionSpec("pfMerge DST:\\S+ SOURCE:\\S+ [SOURCES...]"):                       pfMerge,
+	newActionSpec("eval SCRIPT:\\S+ KEY:\\S+ [ARGS...]"):                             eval,
+	newActionSpec("setBit KEY:\\S+ OFFSET:\\d+ BIT:[01]"):                            setBit,
+	newActionSpec("getBit KEY:\\S+ OFFSET:\\d+"):                                     getBit,
+	newActionSpec("bitCount KEY:\\S+"):                                               bitCount,
+	newActionSpec("lPush KEY:\\S+ VALUE:\\S+"):                                       lPush,
+	newActionSpec("rPush KEY:\\S+ VALUE:\\S+"):                                       rPush,
+	newActionSpec("lPop KEY:\\S+"):                                                   lPop,
+	newActionSpec("rPop KEY:\\S+"):                                                   rPop,
+	newActionSpec("bLPop KEY:\\S+ TIMEOUT:\\S+"):                                     bLPop,
+	newActionSpec("bRPop KEY:\\S+ TIMEOUT:\\S+"):                                     bRPop,
+	newActionSpec("lRange KEY:\\S+ START:\\d+ STOP:\\d+"):                            lRange,
+	newActionSpec("sAdd KEY:\\S+ MEMBER:\\S+"):                                       sAdd,
+	newActionSpec("sRem KEY:\\S+ MEMBER:\\S+"):                                       sRem,
+	newActionSpec("sMembers KEY:\\S+"):                                               sMembers,
+	newActionSpec("sUnion KEY:\\S+ [KEYS...]"):                                       sUnion,
+	newActionSpec("sInter KEY:\\S+ [KEYS...]"):                                       sInter,
+	newActionSpec("hSet KEY:\\S+ FIELD:\\S+ VALUE:\\S+"):                             hSet,
+	newActionSpec("hGet KEY:\\S+ FIELD:\\S+"):                                        hGet,
+	newActionSpec("hDel KEY:\\S+ FIELD:\\S+"):                                        hDel,
+	newActionSpec("hGetAll KEY:\\S+"):                                                hGetAll,
+	newActionSpec("hIncrBy KEY:\\S+ FIELD:\\S+ [DELTA]"):                             hIncrBy,
+	newActionSpec("zAdd KEY:\\S+ MEMBER:\\S+ SCORE:\\S+"):                            zAdd,
+	newActionSpec("zRange KEY:\\S+ START:\\d+ STOP:\\d+"):                            zRange,
+	newActionSpec("zRangeByScore KEY:\\S+ MIN:\\S+ MAX:\\S+"):                        zRangeByScore,
+	newActionSpec("zRank KEY:\\S+ MEMBER:\\S+"):                                      zRank,
+	newActionSpec("scanPrefix PREFIX:\\S+"):                                          scanPrefix,
+	newActionSpec("scan [CURSOR] [COUNT]"):                                           scan,
+	newActionSpec("keys PATTERN:\\S+"):                                               keys,
+	newActionSpec("incr KEY:\\S+ [DELTA]"):                                           incr,
+	newActionSpec("decr KEY:\\S+"):                                                   decr,
+	newActionSpec("nextId KEY:\\S+ [COUNT]"):                                         nextId,
+	newActionSpec("delOlderThan KEY:\\S+ TIMESTAMP:\\d+"):                            delOlderThan,
+	newActionSpec("mPut KEY:\\S+ VALUE:\\S+ [KEY VALUE...]"):                         mPut,
+	newActionSpec("mGet KEY:\\S+ [KEY...]"):                                          mGet,
+	newActionSpec("mExists KEY:\\S+ [KEY...]"):                                       mExists,
+	newActionSpec("del KEY:\\S+"):                                                    del,
+	newActionSpec("subPut KEY:\\S+ SUBKEY:\\S+ VALUE:\\S+"):                          subPut,
+	newActionSpec("subPutChanged KEY:\\S+ SUBKEY:\\S+ VALUE:\\S+ [SUBKEY VALUE...]"): subPutChanged,
+	newActionSpec("subGet KEY:\\S+ SUBKEY:\\S+"):                                     subGet,
+	newActionSpec("subDel KEY:\\S+ SUBKEY:\\S+"):                                     subDel,
+	newActionSpec("subClear KEY:\\S+"):                                               subClear,
+	newActionSpec("describeAll"):                                                     describeAll,
+	newActionSpec("describe HEX_POSITION:\\S+"):                                      describe,
+	newActionSpec("describeTree HEX_POSITION:\\S+"):                                  describeTree,
+	newActionSpec("describeAllTrees"):                                                describeAllTrees,
+	newActionSpec("mirrorFirst KEY:\\S+"):                                            mirrorFirst,
+	newActionSpec("mirrorLast KEY:\\S+"):                                             mirrorLast,
+	newActionSpec("mirrorPrevIndex KEY:\\S+ INDEX:\\d+"):                             mirrorPrevIndex,
+	newActionSpec("mirrorNextIndex KEY:\\S+ INDEX:\\d+"):                             mirrorNextIndex,
+	newActionSpec("first KEY:\\S+"):                                                  first,
+	newActionSpec("last KEY:\\S+"):                                                   last,
+	newActionSpec("prevIndex KEY:\\S+ INDEX:\\d+"):                                   prevIndex,
+	newActionSpec("nextIndex KEY:\\S+ INDEX:\\d+"):                                   nextIndex,
+	newActionSpec("next KEY:\\S+"):                                                   next,
+	newActionSpec("prev KEY:\\S+"):                                                   prev,
+	newActionSpec("subMirrorNext KEY:\\S+ VALUE:\\S+"):                               subMirrorNext,
+	newActionSpec("subMirrorPrev KEY:\\S+ VALUE:\\S+"):                               subMirrorPrev,
+	newActionSpec("mirrorIndexOf KEY:\\S+ VALUE:\\S+"):                               mirrorIndexOf,
+	newActionSpec("mirrorReverseIndexOf KEY:\\S+ VALUE:\\S+"):                        mirrorReverseIndexOf,
+	newActionSpec("subNext KEY:\\S+ SUBKEY:\\S+"):                                    subNext,
+	newActionSpec("subPrev KEY:\\S+ SUBKEY:\\S+"):                                    subPrev,
+	newActionSpec("indexOf KEY:\\S+ SUBKEY:\\S+"):                                    indexOf,
+	newActionSpec("reverseIndexOf KEY:\\S+ SUBKEY:\\S+"):                             reverseIndexOf,
+	newActionSpec("configuration"):                                                   configuration,
+	newActionSpec("subConfiguration KEY:\\S+"):                                       subConfiguration,
+	newActionSpec("configure CONF_KEY:\\S+ CONF_VALUE:\\S+"):                         configure,
+	newActionSpec("subConfigure KEY:\\S+ CONF_KEY:\\S+ CONF_VALUE:\\S+"):             subConfigure,
 }
 
 func mustAtoi(s string) *int {
@@ -183,6 +328,32 @@ func size(conn *client.Conn, args []string) {
 	fmt.Println(conn.Size())
 }
 
+func dbSize(conn *client.Conn, args []string) {
+	fmt.Println(conn.DBSize())
+}
+
+func randomKey(conn *client.Conn, args []string) {
+	if key, existed := conn.RandomKey(); existed {
+		fmt.Println(string(key))
+	}
+}
+
+func typeOf(conn *client.Conn, args []string) {
+	fmt.Println(conn.Type([]byte(args[1])))
+}
+
+func minKey(conn *client.Conn, args []string) {
+	if key, value, existed := conn.MinKey(); existed {
+		fmt.Println(string(key), "=>", decode(value))
+	}
+}
+
+func maxKey(conn *client.Conn, args []string) {
+	if key, value, existed := conn.MaxKey(); existed {
+		fmt.Println(string(key), "=>", decode(value))
+	}
+}
+
 func mirrorReverseSliceIndex(conn *client.Conn, args []string) {
 	for _, item := range conn.MirrorReverseSliceIndex([]byte(args[1]), mustAtoi(args[2]), mustAtoi(args[3])) {
 		fmt.Printf("%v: %v => %v\n", item.Index, decode(item.Key), string(item.Value))
@@ -285,6 +456,22 @@ func dumpSetOp(conn *client.Conn, args []string) {
 	}
 }
 
+func unionStore(conn *client.Conn, args []string) {
+	if size, err := conn.UnionStore([]byte(args[1]), []byte(args[2]), []byte(args[3])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(size)
+	}
+}
+
+func interStore(conn *client.Conn, args []string) {
+	if size, err := conn.InterStore([]byte(args[1]), []byte(args[2]), []byte(args[3])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(size)
+	}
+}
+
 func mirrorReverseIndexOf(conn *client.Conn, args []string) {
 	if index, existed := conn.MirrorReverseIndexOf([]byte(args[1]), []byte(args[2])); existed {
 		fmt.Println(index)
@@ -439,18 +626,525 @@ func describeTree(conn *client.Conn, args []string) {
 	}
 }
 
+func nextId(conn *client.Conn, args []string) {
+	if len(args) > 2 {
+		if first, last, err := conn.NextIDs([]byte(args[1]), int64(*(mustAtoi(args[2])))); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("%v-%v\n", first, last)
+		}
+	} else {
+		if id, err := conn.NextID([]byte(args[1])); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println(id)
+		}
+	}
+}
+
+func incr(conn *client.Conn, args []string) {
+	delta := int64(1)
+	if len(args) > 2 {
+		delta = int64(*(mustAtoi(args[2])))
+	}
+	if value, err := conn.IncrBy([]byte(args[1]), delta); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(value)
+	}
+}
+
+func decr(conn *client.Conn, args []string) {
+	if value, err := conn.Decr([]byte(args[1])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(value)
+	}
+}
+
+func replace(conn *client.Conn, args []string) {
+	if value, existed, err := conn.Replace([]byte(args[1]), args[2], []byte(args[3])); err != nil {
+		fmt.Println(err)
+	} else if existed {
+		fmt.Println(string(value))
+	}
+}
+
+func getPut(conn *client.Conn, args []string) {
+	if old, existed := conn.GetPut([]byte(args[1]), encode(args[2])); existed {
+		fmt.Printf("%v\n", decode(old))
+	}
+}
+
+func getDel(conn *client.Conn, args []string) {
+	if old, existed := conn.GetDel([]byte(args[1])); existed {
+		fmt.Printf("%v\n", decode(old))
+	}
+}
+
+func putIfMissing(conn *client.Conn, args []string) {
+	fmt.Println(conn.PutIfMissing([]byte(args[1]), encode(args[2])))
+}
+
+func compareAndDelete(conn *client.Conn, args []string) {
+	fmt.Println(conn.CompareAndDelete([]byte(args[1]), encode(args[2])))
+}
+
+func compareAndSwap(conn *client.Conn, args []string) {
+	fmt.Println(conn.CompareAndSwap([]byte(args[1]), encode(args[2]), encode(args[3])))
+}
+
+func appendValue(conn *client.Conn, args []string) {
+	fmt.Println(conn.Append([]byte(args[1]), []byte(args[2])))
+}
+
+func appendIf(conn *client.Conn, args []string) {
+	if appended, err := conn.AppendIf([]byte(args[1]), []byte(args[2]), []byte(args[3])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(appended)
+	}
+}
+
+func pfAdd(conn *client.Conn, args []string) {
+	if changed, err := conn.PFAdd([]byte(args[1]), []byte(args[2])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(changed)
+	}
+}
+
+func pfCount(conn *client.Conn, args []string) {
+	if count, err := conn.PFCount([]byte(args[1])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(count)
+	}
+}
+
+func pfMerge(conn *client.Conn, args []string) {
+	var sources [][]byte
+	for _, source := range args[2:] {
+		sources = append(sources, []byte(source))
+	}
+	if count, err := conn.PFMerge([]byte(args[1]), sources...); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(count)
+	}
+}
+
+func eval(conn *client.Conn, args []string) {
+	var scriptArgs [][]byte
+	for _, arg := range args[3:] {
+		scriptArgs = append(scriptArgs, []byte(arg))
+	}
+	if result, err := conn.Eval(args[1], []byte(args[2]), scriptArgs...); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(decode(result))
+	}
+}
+
+func setBit(conn *client.Conn, args []string) {
+	if old, err := conn.SetBit([]byte(args[1]), int64(*(mustAtoi(args[2]))), args[3] == "1"); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(old)
+	}
+}
+
+func getBit(conn *client.Conn, args []string) {
+	if bit, err := conn.GetBit([]byte(args[1]), int64(*(mustAtoi(args[2])))); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(bit)
+	}
+}
+
+func bitCount(conn *client.Conn, args []string) {
+	fmt.Println(conn.BitCount([]byte(args[1])))
+}
+
+func lPush(conn *client.Conn, args []string) {
+	if length, err := conn.LPush([]byte(args[1]), []byte(args[2])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(length)
+	}
+}
+
+func rPush(conn *client.Conn, args []string) {
+	if length, err := conn.RPush([]byte(args[1]), []byte(args[2])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(length)
+	}
+}
+
+func lPop(conn *client.Conn, args []string) {
+	if value, existed := conn.LPop([]byte(args[1])); existed {
+		fmt.Println(string(value))
+	}
+}
+
+func rPop(conn *client.Conn, args []string) {
+	if value, existed := conn.RPop([]byte(args[1])); existed {
+		fmt.Println(string(value))
+	}
+}
+
+func bLPop(conn *client.Conn, args []string) {
+	if timeout, err := time.ParseDuration(args[2]); err != nil {
+		fmt.Println(err)
+	} else if value, existed := conn.BLPop([]byte(args[1]), timeout); existed {
+		fmt.Println(string(value))
+	}
+}
+
+func bRPop(conn *client.Conn, args []string) {
+	if timeout, err := time.ParseDuration(args[2]); err != nil {
+		fmt.Println(err)
+	} else if value, existed := conn.BRPop([]byte(args[1]), timeout); existed {
+		fmt.Println(string(value))
+	}
+}
+
+func lRange(conn *client.Conn, args []string) {
+	for _, value := range conn.LRange([]byte(args[1]), *(mustAtoi(args[2])), *(mustAtoi(args[3]))) {
+		fmt.Println(string(value))
+	}
+}
+
+func sAdd(conn *client.Conn, args []string) {
+	if err := conn.SAdd([]byte(args[1]), []byte(args[2])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func sRem(conn *client.Conn, args []string) {
+	conn.SRem([]byte(args[1]), []byte(args[2]))
+}
+
+func printMembers(members [][]byte) {
+	for _, member := range members {
+		fmt.Println(string(member))
+	}
+}
+
+func sMembers(conn *client.Conn, args []string) {
+	printMembers(conn.SMembers([]byte(args[1])))
+}
+
+func setKeys(args []string) (keys [][]byte) {
+	for _, key := range args[1:] {
+		keys = append(keys, []byte(key))
+	}
+	return
+}
+
+func sUnion(conn *client.Conn, args []string) {
+	printMembers(conn.SUnion(setKeys(args)...))
+}
+
+func sInter(conn *client.Conn, args []string) {
+	printMembers(conn.SInter(setKeys(args)...))
+}
+
+func hSet(conn *client.Conn, args []string) {
+	if err := conn.HSet([]byte(args[1]), []byte(args[2]), []byte(args[3])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func hGet(conn *client.Conn, args []string) {
+	if value, existed := conn.HGet([]byte(args[1]), []byte(args[2])); existed {
+		fmt.Println(decode(value))
+	}
+}
+
+func hDel(conn *client.Conn, args []string) {
+	conn.HDel([]byte(args[1]), []byte(args[2]))
+}
+
+func hGetAll(conn *client.Conn, args []string) {
+	for field, value := range conn.HGetAll([]byte(args[1])) {
+		fmt.Printf("%v => %v\n", field, decode(value))
+	}
+}
+
+func hIncrBy(conn *client.Conn, args []string) {
+	delta := int64(1)
+	if len(args) > 3 {
+		delta = int64(*(mustAtoi(args[3])))
+	}
+	if value, err := conn.HIncrBy([]byte(args[1]), []byte(args[2]), delta); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(value)
+	}
+}
+
+func zAdd(conn *client.Conn, args []string) {
+	score, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := conn.ZAdd([]byte(args[1]), []byte(args[2]), score); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func printScoredMembers(members []client.ScoredMember) {
+	for _, member := range members {
+		fmt.Printf("%v => %v\n", string(member.Member), member.Score)
+	}
+}
+
+func zRange(conn *client.Conn, args []string) {
+	printScoredMembers(conn.ZRange([]byte(args[1]), *(mustAtoi(args[2])), *(mustAtoi(args[3]))))
+}
+
+func zRangeByScore(conn *client.Conn, args []string) {
+	min, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	max, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printScoredMembers(conn.ZRangeByScore([]byte(args[1]), min, max))
+}
+
+func zRank(conn *client.Conn, args []string) {
+	fmt.Println(conn.ZRank([]byte(args[1]), []byte(args[2])))
+}
+
 func get(conn *client.Conn, args []string) {
 	if value, existed := conn.Get([]byte(args[1])); existed {
 		fmt.Printf("%v\n", decode(value))
 	}
 }
 
+func strlen(conn *client.Conn, args []string) {
+	fmt.Println(conn.StrLen([]byte(args[1])))
+}
+
+func getMeta(conn *client.Conn, args []string) {
+	if meta := conn.GetMeta([]byte(args[1])); meta.Exists {
+		fmt.Printf("timestamp: %v (%v)\nsize: %v\n", meta.Timestamp, time.Unix(0, meta.Timestamp), meta.Size)
+	}
+}
+
 func subGet(conn *client.Conn, args []string) {
 	if value, existed := conn.SubGet([]byte(args[1]), []byte(args[2])); existed {
 		fmt.Printf("%v\n", decode(value))
 	}
 }
 
+func snapshot(conn *client.Conn, args []string) {
+	if err := conn.Snapshot(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func backup(conn *client.Conn, args []string) {
+	if err := conn.Backup(args[1]); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func addIndex(conn *client.Conn, args []string) {
+	conn.AddIndex(args[1], args[2])
+}
+
+func query(conn *client.Conn, args []string) {
+	for _, key := range conn.Query(args[1], []byte(args[2])) {
+		fmt.Println(string(key))
+	}
+}
+
+func flushDb(conn *client.Conn, args []string) {
+	fmt.Println(conn.Select(args[1]).Flush())
+}
+
+func stats(conn *client.Conn, args []string) {
+	for _, stats := range conn.Stats() {
+		fmt.Println(stats.Describe())
+	}
+}
+
+func metrics(conn *client.Conn, args []string) {
+	var total common.Metrics
+	for _, metrics := range conn.Metrics() {
+		total.Merge(metrics)
+	}
+	fmt.Printf("hits: %v\nmisses: %v\n", total.Hits, total.Misses)
+	var methods []string
+	for method := range total.Calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		fmt.Printf("%v: %v calls, %v errors\n", method, total.Calls[method], total.Errors[method])
+	}
+}
+
+func slowLog(conn *client.Conn, args []string) {
+	for _, op := range conn.SlowLog() {
+		fmt.Println(op.Describe())
+	}
+}
+
+func resetSlowLog(conn *client.Conn, args []string) {
+	conn.ResetSlowLog()
+}
+
+func usage(conn *client.Conn, args []string) {
+	result := conn.Usage()
+	fmt.Printf("values: %v\nkey bytes: %v\nvalue bytes: %v\n", result.Values, result.KeyBytes, result.ValueBytes)
+	for bucket, count := range result.ValueSizes {
+		if bucket == 0 {
+			fmt.Printf("empty values: %v\n", count)
+		} else {
+			fmt.Printf("values of %v-%v bytes: %v\n", 1<<uint(bucket-1), 1<<uint(bucket)-1, count)
+		}
+	}
+	for _, size := range result.Largest {
+		if size.SubKey == nil {
+			fmt.Printf("%v: %v bytes\n", string(size.Key), size.Size)
+		} else {
+			fmt.Printf("%v/%v: %v bytes\n", string(size.Key), string(size.SubKey), size.Size)
+		}
+	}
+}
+
+func putExpire(conn *client.Conn, args []string) {
+	if lifetime, err := time.ParseDuration(args[3]); err != nil {
+		fmt.Println(err)
+	} else if err := conn.PutExpire([]byte(args[1]), encode(args[2]), lifetime); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func ttl(conn *client.Conn, args []string) {
+	switch ttl := conn.TTL([]byte(args[1])); ttl {
+	case common.NoTTL:
+		fmt.Println("no expiry")
+	case common.MissingTTL:
+		fmt.Println("missing")
+	default:
+		fmt.Println(ttl)
+	}
+}
+
+func rename(conn *client.Conn, args []string) {
+	fmt.Println(conn.Rename([]byte(args[1]), []byte(args[2])))
+}
+
+func renameNX(conn *client.Conn, args []string) {
+	fmt.Println(conn.RenameNX([]byte(args[1]), []byte(args[2])))
+}
+
+func copyValue(conn *client.Conn, args []string) {
+	fmt.Println(conn.Copy([]byte(args[1]), []byte(args[2]), false))
+}
+
+func copyKeepTTL(conn *client.Conn, args []string) {
+	fmt.Println(conn.Copy([]byte(args[1]), []byte(args[2]), true))
+}
+
+func persist(conn *client.Conn, args []string) {
+	fmt.Println(conn.Persist([]byte(args[1])))
+}
+
+func putNotify(conn *client.Conn, args []string) {
+	conn.PutNotify([]byte(args[1]), encode(args[2]), args[3], []byte(args[4]))
+}
+
+func publish(conn *client.Conn, args []string) {
+	fmt.Println(conn.SPublish(args[1], []byte(args[2])))
+}
+
+func subscribe(conn *client.Conn, args []string) {
+	subscription := conn.Subscribe(args[1], args[2:]...)
+	for message := range subscription.C {
+		fmt.Printf("%v: %s\n", message.Channel, message.Message)
+	}
+}
+
+func watch(conn *client.Conn, args []string) {
+	conn.Start()
+	events, _ := conn.Watch(args[1])
+	for event := range events {
+		fmt.Printf("%v %v\n", event.Type, string(event.Key))
+	}
+}
+
+func dumpKey(conn *client.Conn, args []string) {
+	if dump := conn.DumpKey([]byte(args[1])); dump != nil {
+		fmt.Println(hex.EncodeToString(dump))
+	}
+}
+
+func restoreKey(conn *client.Conn, args []string) {
+	if dump, err := hex.DecodeString(args[2]); err != nil {
+		fmt.Println(err)
+	} else if err := conn.RestoreKey([]byte(args[1]), dump, len(args) > 3 && args[3] == "replace"); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func drainPrefix(conn *client.Conn, args []string) {
+	for _, item := range conn.SDrainPrefix([]byte(args[1])) {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+	}
+}
+
+func scanPrefix(conn *client.Conn, args []string) {
+	for _, item := range conn.ScanPrefix([]byte(args[1])) {
+		fmt.Printf("%v => %v\n", string(item.Key), decode(item.Value))
+	}
+}
+
+func scan(conn *client.Conn, args []string) {
+	var cursor []byte
+	count := 1024
+	if len(args) > 1 {
+		cursor = []byte(args[1])
+	}
+	if len(args) > 2 {
+		count = *(mustAtoi(args[2]))
+	}
+	keys, next := conn.Scan(cursor, count)
+	for _, key := range keys {
+		fmt.Println(string(key))
+	}
+	if next != nil {
+		fmt.Printf("next: %v\n", string(next))
+	}
+}
+
+func keys(conn *client.Conn, args []string) {
+	cursor, err := conn.OpenMatchingKeys(args[1], 1024)
+	for err == nil {
+		var page [][]byte
+		if page, err = cursor.Next(); len(page) == 0 {
+			break
+		}
+		for _, key := range page {
+			fmt.Println(string(key))
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
 func clear(conn *client.Conn, args []string) {
 	conn.Clear()
 }
@@ -465,6 +1159,28 @@ func subDump(conn *client.Conn, args []string) {
 	linedump(dump, wait)
 }
 
+func exportJson(conn *client.Conn, args []string) {
+	if _, err := conn.ExportJSON(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func importJson(conn *client.Conn, args []string) {
+	imported, err := conn.ImportJSON(os.Stdin)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(imported)
+}
+
+func importRdb(conn *client.Conn, args []string) {
+	imported, skipped, err := conn.ImportRDB(bufio.NewReader(os.Stdin))
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("imported: %v\nskipped: %v\n", imported, skipped)
+}
+
 func linedump(dump chan [2][]byte, wait *sync.WaitGroup) {
 	defer func() {
 		close(dump)
@@ -495,6 +1211,23 @@ func subPut(conn *client.Conn, args []string) {
 	conn.SubPut([]byte(args[1]), []byte(args[2]), encode(args[3]))
 }
 
+func subPutChanged(conn *client.Conn, args []string) {
+	if len(args)%2 != 0 {
+		fmt.Println("subPutChanged needs a key followed by pairs of sub keys and values")
+		return
+	}
+	var subKeys, values [][]byte
+	for i := 2; i < len(args); i += 2 {
+		subKeys = append(subKeys, []byte(args[i]))
+		values = append(values, encode(args[i+1]))
+	}
+	if changed, err := conn.SubPutChanged([]byte(args[1]), subKeys, values); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(changed)
+	}
+}
+
 func subClear(conn *client.Conn, args []string) {
 	conn.SubClear([]byte(args[1]))
 }
@@ -503,31 +1236,71 @@ func subDel(conn *client.Conn, args []string) {
 	conn.SubDel([]byte(args[1]), []byte(args[2]))
 }
 
+func mPut(conn *client.Conn, args []string) {
+	var keys, values [][]byte
+	for i := 1; i+1 < len(args); i += 2 {
+		keys = append(keys, []byte(args[i]))
+		values = append(values, encode(args[i+1]))
+	}
+	if old, existed, err := conn.MPut(keys, values); err != nil {
+		fmt.Println(err)
+	} else {
+		for index, value := range old {
+			if existed[index] {
+				fmt.Printf("%v => %v\n", args[index*2+1], decode(value))
+			}
+		}
+	}
+}
+
+func mGet(conn *client.Conn, args []string) {
+	keys := make([][]byte, len(args)-1)
+	for index, key := range args[1:] {
+		keys[index] = []byte(key)
+	}
+	values, existed := conn.MGet(keys)
+	for index, value := range values {
+		if existed[index] {
+			fmt.Printf("%v => %v\n", args[index+1], decode(value))
+		}
+	}
+}
+
+func mExists(conn *client.Conn, args []string) {
+	keys := make([][]byte, len(args)-1)
+	for index, key := range args[1:] {
+		keys[index] = []byte(key)
+	}
+	present, all := conn.MExists(keys)
+	for index, existed := range present {
+		fmt.Printf("%v: %v\n", args[index+1], existed)
+	}
+	fmt.Println(all)
+}
+
 func del(conn *client.Conn, args []string) {
 	conn.Del([]byte(args[1]))
 }
 
+func delOlderThan(conn *client.Conn, args []string) {
+	than, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(conn.SDelOlderThan([]byte(args[1]), than))
+}
+
 func main() {
 	flag.Parse()
-	conn := client.MustConn(fmt.Sprintf("%v:%v", *ip, *port))
 	if len(flag.Args()) == 0 {
-		show(conn)
-	} else {
-		for spec, fun := range actions {
-			if spec.cmd == flag.Args()[0] {
-				matchingParts := true
-				for index, reg := range spec.args {
-					if !reg.MatchString(flag.Args()[index+1]) {
-						matchingParts = false
-						break
-					}
-				}
-				if matchingParts {
-					fun(conn, flag.Args())
-					return
-				}
-			}
-		}
-		fmt.Println("No command given?")
+		show(client.MustConn(fmt.Sprintf("%v:%v", *ip, *port)))
+		return
+	}
+	fun, err := findAction(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
+	fun(client.MustConn(fmt.Sprintf("%v:%v", *ip, *port)), flag.Args())
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewActionSpec(t *testing.T) {
+	spec := newActionSpec("nextId KEY:\\S+ [COUNT]")
+	if spec.cmd != "nextId" || len(spec.args) != 1 || spec.usage() != "nextId KEY [COUNT]" {
+		t.Errorf("wanted nextId with one argument and usage nextId KEY [COUNT], got %v, %v, %v", spec.cmd, spec.args, spec.usage())
+	}
+	if !spec.matches([]string{"nextId", "k", "3"}) || spec.matches([]string{"nextId"}) {
+		t.Errorf("wanted %v to match only with a KEY", spec.usage())
+	}
+}
+
+func TestFindAction(t *testing.T) {
+	if _, err := findAction([]string{"put", "k", "v"}); err != nil {
+		t.Errorf("wanted put to be found, got %v", err)
+	}
+	if _, err := findAction([]string{"subPut", "k"}); err == nil || !strings.Contains(err.Error(), "subPut KEY SUBKEY VALUE") {
+		t.Errorf("wanted the expected parameters of subPut in the error, got %v", err)
+	}
+	if _, err := findAction([]string{"prevIndex", "k", "notanint"}); err == nil || !strings.Contains(err.Error(), "prevIndex KEY INDEX") {
+		t.Errorf("wanted the expected parameters of prevIndex in the error, got %v", err)
+	}
+	if _, err := findAction([]string{"nosuchcommand"}); err == nil {
+		t.Errorf("wanted an error for an unknown command")
+	}
+}
+
+func TestHexFormat(t *testing.T) {
+	old := *enc
+	defer func() {
+		*enc = old
+	}()
+	*enc = hexFormat
+	if b := encode("00ff0a"); !bytes.Equal(b, []byte{0, 255, 10}) {
+		t.Errorf("wanted 00ff0a to encode to [0 255 10], got %v", b)
+	}
+	if s := decode([]byte{0, 255, 10}); s != "00ff0a" {
+		t.Errorf("wanted [0 255 10] to decode to 00ff0a, got %v", s)
+	}
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/dhash"
+	"github.com/zond/god/persistence"
+	"io/ioutil"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const (
 	address = "address"
+	never   = "never"
+	always  = "always"
 )
 
 var listenIp = flag.String("listenIp", "127.0.0.1", "IP address to listen at.")
@@ -19,6 +29,24 @@ var joinIp = flag.String("joinIp", "", "IP address to join.")
 var joinPort = flag.Int("joinPort", 9191, "Port to join.")
 var verbose = flag.Bool("verbose", false, "Whether the server should be log verbosely to the console.")
 var dir = flag.String("dir", address, "Where to store logfiles and snapshots. Defaults to a directory named after the listening ip/port. The empty string will turn off persistence.")
+var rotateSize = flag.Int64("rotateSize", 64<<20, "How many bytes to write to a logfile before starting a new one. 0 will turn off rotation.")
+var fullSnapshotEvery = flag.Int("fullSnapshotEvery", 0, "How many compactions to do before merging everything into a new snapshot. The compactions in between only merge the logfiles since the last one into a delta. 0 will merge everything every time.")
+var compactInterval = flag.Duration("compactInterval", time.Hour, "How often to merge the logfiles into a new snapshot, dropping overwritten and deleted values. 0 will turn off compaction.")
+var compress = flag.Bool("compress", false, "Whether to flate compress the logfiles and snapshots. Files are replayed whether they were compressed or not.")
+var keyFile = flag.String("keyFile", "", "A file containing a hex encoded AES key of 16, 24 or 32 bytes to encrypt the logfiles and snapshots with. The empty string will turn off encryption.")
+var replayWorkers = flag.Int("replayWorkers", runtime.NumCPU(), "How many goroutines to replay the logfiles and snapshots with when starting up.")
+var restoreFrom = flag.String("restoreFrom", "", "A directory with a backup to replace the data of this node with before starting it. The empty string will turn off restoring.")
+var restoreUpTo = flag.String("restoreUpTo", "", "An RFC3339 time to restore the backup in restoreFrom up to. The empty string will restore everything in it.")
+var replayUpTo = flag.String("replayUpTo", "", "An RFC3339 time to stop replaying the logfiles at when starting up. The operations after it are dropped. The empty string will replay everything.")
+var replayOps = flag.Int("replayOps", 0, "How many logged operations to stop replaying after when starting up. The operations after them are dropped. 0 will replay everything.")
+var stopAtCorruption = flag.Bool("stopAtCorruption", false, "Whether to stop replaying at the last intact operation of a corrupt logfile when starting up, dropping the operations after it, instead of continuing with the next logfile.")
+var maxBytes = flag.Int("maxBytes", 0, "How many bytes of keys and values, approximately, the node may hold before it evicts the least recently used values it owns. 0 will turn off eviction.")
+var evictionPolicy = flag.String("evictionPolicy", "lru", "Which values to evict first when the node holds more than maxBytes: lru (least recently used), lfu (least frequently used), random, ttl (earliest expiring, then least recently used) or noeviction (refuse writes instead).")
+var backlog = flag.Int("backlog", 1024, "How many operations to queue up for writing to the logfiles before writes wait for the disk.")
+var readOnlyWhenDegraded = flag.Bool("readOnlyWhenDegraded", false, "Whether to refuse writes while the logfiles can't be written, instead of keeping the writes in memory until they can.")
+var auditFile = flag.String("auditFile", "", "A file to append a line of JSON to for every call that may change data, with the address it came from, the method, the key and when. The empty string will turn off auditing.")
+var redundancy = flag.Int("redundancy", 0, "How many nodes to store each value on. It replaces the setting of the cluster once this node has joined it. 0 will keep the setting of the cluster.")
+var fsync = flag.String("fsync", never, "When to sync the logfiles to disk: never (leaving it to the operating system), always (before acknowledging each write) or an interval like 100ms.")
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -26,7 +54,79 @@ func main() {
 	if *dir == address {
 		*dir = fmt.Sprintf("%v_%v", *broadcastIp, *port)
 	}
-	s := dhash.NewNodeDir(fmt.Sprintf("%v:%v", *listenIp, *port), fmt.Sprintf("%v:%v", *broadcastIp, *port), *dir)
+	var logger *persistence.Logger
+	if *dir != "" {
+		logger = persistence.NewLogger(*dir).RotateAt(*rotateSize, 0).CompactEvery(*compactInterval).Incremental(*fullSnapshotEvery).Parallel(*replayWorkers).Backlog(*backlog)
+		if *compress {
+			logger.Compress()
+		}
+		if *keyFile != "" {
+			encoded, err := ioutil.ReadFile(*keyFile)
+			if err != nil {
+				panic(err)
+			}
+			key, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+			if err != nil {
+				panic(err)
+			}
+			logger.Encrypt(key)
+		}
+		options := persistence.PlayOptions{
+			Ops:              *replayOps,
+			StopAtCorruption: *stopAtCorruption,
+		}
+		if *replayUpTo != "" {
+			var err error
+			if options.UpTo, err = time.Parse(time.RFC3339, *replayUpTo); err != nil {
+				panic(err)
+			}
+		}
+		logger.PlayWith(options)
+		switch *fsync {
+		case never:
+		case always:
+			logger.Fsync(0)
+		default:
+			interval, err := time.ParseDuration(*fsync)
+			if err != nil {
+				panic(err)
+			}
+			logger.Fsync(interval)
+		}
+	}
+	s := dhash.NewNodeLogger(fmt.Sprintf("%v:%v", *listenIp, *port), fmt.Sprintf("%v:%v", *broadcastIp, *port), logger).MaxBytes(*maxBytes).ReadOnlyWhenDegraded(*readOnlyWhenDegraded)
+	switch *evictionPolicy {
+	case "lru":
+	case "lfu":
+		s.Evict(dhash.NewLFU())
+	case "random":
+		s.Evict(dhash.NewRandomEviction())
+	case "ttl":
+		s.Evict(dhash.NewTTLFirst())
+	case "noeviction":
+		s.Evict(dhash.NoEviction)
+	default:
+		panic(fmt.Errorf("Unknown eviction policy %#v", *evictionPolicy))
+	}
+	if *auditFile != "" {
+		audit, err := os.OpenFile(*auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			panic(err)
+		}
+		s.Audit(audit)
+	}
+	if *restoreFrom != "" {
+		upTo := time.Now()
+		if *restoreUpTo != "" {
+			var err error
+			if upTo, err = time.Parse(time.RFC3339, *restoreUpTo); err != nil {
+				panic(err)
+			}
+		}
+		if err := s.RestoreFrom(*restoreFrom, upTo); err != nil {
+			panic(err)
+		}
+	}
 	if *verbose {
 		s.AddChangeListener(func(ring *common.Ring) bool {
 			fmt.Println(s.Describe())
@@ -49,6 +149,12 @@ func main() {
 	if *joinIp != "" {
 		s.MustJoin(fmt.Sprintf("%v:%v", *joinIp, *joinPort))
 	}
+	if *redundancy > 0 {
+		s.SetRedundancy(*redundancy)
+	}
 
-	select {}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	s.Stop()
 }
@@ -0,0 +1,192 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// fileMagic and a version start all files written by this version, followed by a byte of format flags and frames of a gob stream, each prefixed with its length and CRC-32 checksum.
+// Version 1 files have no format flags, and files without fileMagic are plain gob streams written by older versions.
+var fileMagic = []byte{'g', 'o', 'd'}
+
+const (
+	fileVersion     = 2
+	frameHeaderSize = 8
+)
+
+const (
+	compressedFormat = 1 << iota
+	encryptedFormat
+)
+
+// checksumError is returned when a frame read from a file doesn't match its checksum.
+type checksumError struct {
+	Expected uint32
+	Found    uint32
+}
+
+func (self checksumError) Error() string {
+	return fmt.Sprintf("expected checksum %08x, found %08x", self.Expected, self.Found)
+}
+
+// newCipher returns an AES-GCM cipher using key.
+func newCipher(key []byte) (result cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+type frameWriter struct {
+	w          io.Writer
+	compressor *flate.Writer
+	buffer     *bytes.Buffer
+	cipher     cipher.AEAD
+}
+
+// newFrameWriter will write the header of a file with the given format flags to w, and return a writer of frames to it, encrypted with key if it is not nil.
+func newFrameWriter(w io.Writer, format byte, key []byte) (result *frameWriter, err error) {
+	var aead cipher.AEAD
+	if key != nil {
+		if aead, err = newCipher(key); err != nil {
+			return
+		}
+		format |= encryptedFormat
+	}
+	if _, err = w.Write(append(append([]byte{}, fileMagic...), fileVersion, format)); err != nil {
+		return
+	}
+	result = &frameWriter{w: w, cipher: aead}
+	if format&compressedFormat != 0 {
+		result.buffer = new(bytes.Buffer)
+		result.compressor, _ = flate.NewWriter(result.buffer, flate.BestSpeed)
+	}
+	return
+}
+
+// Write will write b as one frame in one write to the underlying io.Writer, so that a failed write leaves at most one truncated frame.
+// Compressed or encrypted frames are compressed, and then encrypted with a random nonce prepended to them, one by one, so that each frame can be read on its own.
+func (self *frameWriter) Write(b []byte) (n int, err error) {
+	payload := b
+	if self.compressor != nil {
+		self.buffer.Reset()
+		self.compressor.Reset(self.buffer)
+		if _, err = self.compressor.Write(b); err != nil {
+			return
+		}
+		if err = self.compressor.Close(); err != nil {
+			return
+		}
+		payload = self.buffer.Bytes()
+	}
+	if self.cipher != nil {
+		nonce := make([]byte, self.cipher.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return
+		}
+		payload = self.cipher.Seal(nonce, nonce, payload, nil)
+	}
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(payload))
+	copy(frame[frameHeaderSize:], payload)
+	if _, err = self.w.Write(frame); err != nil {
+		return
+	}
+	return len(b), nil
+}
+
+type frameReader struct {
+	r          io.Reader
+	compressed bool
+	cipher     cipher.AEAD
+	frame      []byte
+}
+
+// Read will return the contents of the frames of the underlying io.Reader, and a checksumError for the first frame that is corrupt.
+// A frame cut short returns io.ErrUnexpectedEOF.
+func (self *frameReader) Read(b []byte) (n int, err error) {
+	for len(self.frame) == 0 {
+		header := make([]byte, frameHeaderSize)
+		if _, err = io.ReadFull(self.r, header); err != nil {
+			return
+		}
+		// Copying instead of allocating the whole frame up front keeps a corrupt length from making us allocate more than is left in the file.
+		frame := new(bytes.Buffer)
+		if _, err = io.CopyN(frame, self.r, int64(binary.BigEndian.Uint32(header))); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		if found, expected := crc32.ChecksumIEEE(frame.Bytes()), binary.BigEndian.Uint32(header[4:]); found != expected {
+			err = checksumError{Expected: expected, Found: found}
+			return
+		}
+		self.frame = frame.Bytes()
+		if self.cipher != nil {
+			nonceSize := self.cipher.NonceSize()
+			if len(self.frame) < nonceSize {
+				self.frame = nil
+				err = fmt.Errorf("encrypted frame of %v bytes is shorter than its nonce", frame.Len())
+				return
+			}
+			if self.frame, err = self.cipher.Open(nil, self.frame[:nonceSize], self.frame[nonceSize:], nil); err != nil {
+				return
+			}
+		}
+		if self.compressed {
+			if self.frame, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(self.frame))); err != nil {
+				return
+			}
+		}
+	}
+	n = copy(b, self.frame)
+	self.frame = self.frame[n:]
+	return
+}
+
+// newFileReader returns a reader of the gob stream in r, verifying its frames unless r is a plain gob stream from an older version.
+// key is used to decrypt r if it is encrypted.
+func newFileReader(r io.Reader, key []byte) (result io.Reader, err error) {
+	buffered := bufio.NewReader(r)
+	head, _ := buffered.Peek(len(fileMagic) + 1)
+	if len(head) < len(fileMagic)+1 || !bytes.Equal(head[:len(fileMagic)], fileMagic) {
+		return buffered, nil
+	}
+	version := head[len(fileMagic)]
+	buffered.Discard(len(head))
+	reader := &frameReader{r: buffered}
+	switch version {
+	case 1:
+	case fileVersion:
+		var format byte
+		if format, err = buffered.ReadByte(); err != nil {
+			return
+		}
+		reader.compressed = format&compressedFormat != 0
+		if format&encryptedFormat != 0 {
+			if key == nil {
+				err = fmt.Errorf("file is encrypted, but no key was given")
+				return
+			}
+			if reader.cipher, err = newCipher(key); err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown file version %v", version)
+		return
+	}
+	return reader, nil
+}
@@ -3,6 +3,7 @@ package persistence
 import (
 	"encoding/gob"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"os"
@@ -15,7 +16,7 @@ import (
 	"time"
 )
 
-var logfileReg = regexp.MustCompile("^(\\d+)\\.(snap|log)$")
+var logfileReg = regexp.MustCompile("^(\\d+)\\.(snap|delta|log)$")
 
 const (
 	stopped = iota
@@ -23,13 +24,21 @@ const (
 	playing
 )
 
+const (
+	defaultRetryInterval = time.Second
+	groupSize            = 1024
+)
+
 const (
 	snapSuffix       = "snap"
+	deltaSuffix      = "delta"
 	logSuffix        = "log"
 	unfinishedSuffix = "unfinished"
 )
 
 // Op is a simple get/put/clear or configuration operation to log or replay.
+// An Op with a Batch is logged as a single operation, but replayed as the Ops in the Batch.
+// Time is when the Op was logged in Unix nanoseconds, and is set when it is queued unless it already is.
 type Op struct {
 	Key           []byte
 	SubKey        []byte
@@ -38,6 +47,17 @@ type Op struct {
 	Put           bool
 	Clear         bool
 	Configuration map[string]string
+	Batch         []Op
+	Time          int64
+}
+
+// Stats describes the health of a Logger.
+// When Degraded is true the Logger has failed writing to disk, and is keeping NonDurable operations in memory until it manages to write them.
+// Queued is the number of operations waiting to be picked up by the writing goroutine.
+type Stats struct {
+	Degraded   bool
+	NonDurable int
+	Queued     int
 }
 
 type logfile struct {
@@ -47,6 +67,7 @@ type logfile struct {
 	file      *os.File
 	encoder   *gob.Encoder
 	decoder   *gob.Decoder
+	ops       int
 }
 
 func createLogfile(dir, suffix string) (rval *logfile) {
@@ -74,44 +95,79 @@ func parseLogfile(file string) (rval *logfile, err error) {
 	return
 }
 
-func (self *logfile) play(operate Operate) {
+// play will replay the operations in this logfile using operate, and return true if it reached the limits of p, which may be nil.
+// A corrupt or truncated logfile is replayed up to its last intact operation, and if p stops at corruption the error is returned as well.
+func (self *logfile) play(logger *Logger, operate Operate, p *playback) (stopped bool, err error) {
 	if self == nil {
 		return
 	}
-	self.read()
+	self.read(logger)
 	defer self.close()
-	var err error
-	for {
+	var played int
+	for ; ; played++ {
 		var op Op
 		err = self.decoder.Decode(&op)
 		if err != nil {
 			break
 		}
-		operate(op)
+		if p != nil && p.reached(self, op) {
+			return true, nil
+		}
+		if op.Batch != nil {
+			for _, batched := range op.Batch {
+				operate(batched)
+			}
+		} else {
+			operate(op)
+		}
 	}
-	if err != io.EOF {
+	if _, corrupt := err.(checksumError); corrupt && self.suffix == logSuffix {
+		log.Printf("%v is corrupt after %v operations, ignoring the rest of it: %v", self.filename, played, err)
+	} else if err == io.ErrUnexpectedEOF && self.suffix == logSuffix {
+		log.Printf("%v ends with a truncated operation, probably from a failed write", self.filename)
+	} else if err == io.EOF {
+		return false, nil
+	} else {
 		panic(err)
 	}
+	if p != nil && p.StopAtCorruption {
+		return true, fmt.Errorf("%v is corrupt after %v operations: %v", self.filename, played, err)
+	}
+	return false, nil
 }
 
-func (self *logfile) read() *logfile {
+func (self *logfile) read(logger *Logger) *logfile {
 	var err error
 	self.file, err = os.Open(self.filename)
 	if err != nil {
 		panic(err)
 	}
-	self.decoder = gob.NewDecoder(self.file)
+	reader, err := newFileReader(self.file, logger.key)
+	if err != nil {
+		panic(fmt.Errorf("%v: %v", self.filename, err))
+	}
+	self.decoder = gob.NewDecoder(reader)
 	return self
 }
 
-func (self *logfile) write() *logfile {
-	var err error
-	self.file, err = os.Create(self.filename)
+func (self *logfile) create(logger *Logger) (err error) {
+	if self.file, err = os.Create(self.filename); err != nil {
+		return
+	}
+	var w io.Writer = self.file
+	if logger.wrap != nil {
+		w = logger.wrap(w)
+	}
+	var format byte
+	if logger.compress {
+		format |= compressedFormat
+	}
+	frames, err := newFrameWriter(w, format, logger.key)
 	if err != nil {
-		panic(err)
+		return
 	}
-	self.encoder = gob.NewEncoder(self.file)
-	return self
+	self.encoder = gob.NewEncoder(frames)
+	return
 }
 
 func (self *logfile) close() {
@@ -130,21 +186,74 @@ func (self logfiles) Swap(i, j int) {
 	self[i], self[j] = self[j], self[i]
 }
 
+// PlayOptions limit how far a Logger replays. The zero value replays everything.
+type PlayOptions struct {
+	// UpTo stops the replay at the first operation logged after it, unless it is zero.
+	UpTo time.Time
+	// Ops stops the replay after this many logged operations, counting from the start of the latest snapshot and a batch as one operation, unless it is zero.
+	Ops int
+	// StopAtCorruption stops the replay at the last intact operation of a corrupt or truncated logfile, instead of continuing with the logfiles after it.
+	StopAtCorruption bool
+}
+
+// playback is the progress of a replay limited by PlayOptions.
+type playback struct {
+	PlayOptions
+	played int
+}
+
+// reached returns whether op, read from logf, is past the limits of this playback, and counts it otherwise.
+// Snapshots and deltas are replayed in full as far as UpTo goes, since everything in them was logged before they were started.
+func (self *playback) reached(logf *logfile, op Op) bool {
+	if self.Ops != 0 && self.played >= self.Ops {
+		return true
+	}
+	if !self.UpTo.IsZero() && logf.suffix == logSuffix && op.Time > self.UpTo.UnixNano() {
+		return true
+	}
+	self.played++
+	return false
+}
+
+type queued struct {
+	op      Op
+	durable chan bool
+}
+
 // Operate is a function that operates on an Op, for replay purposes.
 // It is supposed to insert Ops with the Put flag, Clear data if the Clear flag is set, handle configuration changes or delete data.
 type Operate func(o Op)
 
 // Logger is something that can log or replay Ops.
 type Logger struct {
-	ops      chan Op
-	stops    chan chan bool
-	dir      string
-	state    int32
-	snapping int32
-	maxSize  int64
-	suffix   string
-	cond     *sync.Cond
-	lock     *sync.Mutex
+	ops             chan queued
+	stops           chan chan bool
+	rotations       chan chan bool
+	errors          chan error
+	dir             string
+	state           int32
+	snapping        int32
+	degraded        int32
+	failed          int32
+	nonDurable      int64
+	pending         []Op
+	maxSize         int64
+	rotateSize      int64
+	rotateOps       int
+	compactInterval time.Duration
+	fsync           bool
+	fsyncInterval   time.Duration
+	compress        bool
+	key             []byte
+	fullEvery       int
+	workers         int
+	playOptions     PlayOptions
+	retryInterval   time.Duration
+	suffix          string
+	cond            *sync.Cond
+	lock            *sync.Mutex
+	wrap            func(io.Writer) io.Writer
+	syncFile        func(*os.File) error
 }
 
 // NewLogger will return a Logger that will dump data into dir, or replay data from dir.
@@ -154,12 +263,15 @@ func NewLogger(dir string) *Logger {
 	}
 	lock := new(sync.Mutex)
 	return &Logger{
-		ops:    make(chan Op),
-		stops:  make(chan chan bool),
-		dir:    dir,
-		suffix: logSuffix,
-		lock:   lock,
-		cond:   sync.NewCond(lock),
+		ops:           make(chan queued, groupSize),
+		stops:         make(chan chan bool),
+		rotations:     make(chan chan bool),
+		errors:        make(chan error, 16),
+		dir:           dir,
+		suffix:        logSuffix,
+		retryInterval: defaultRetryInterval,
+		lock:          lock,
+		cond:          sync.NewCond(lock),
 	}
 }
 
@@ -175,6 +287,19 @@ func (self *Logger) setSuffix(s string) *Logger {
 	return self
 }
 
+// In returns a new Logger that will dump data into dir, or replay data from dir, with the same compression and encryption as this Logger.
+func (self *Logger) In(dir string) (result *Logger) {
+	result = NewLogger(dir)
+	result.compress = self.compress
+	result.key = self.key
+	return
+}
+
+// snapshotter returns a Logger writing unfinished snapshots in the same format as this Logger.
+func (self *Logger) snapshotter() *Logger {
+	return self.In(self.dir).setSuffix(unfinishedSuffix)
+}
+
 // Limit will limit the size of the last logfile to maxSize bytes.
 // When the last logfile is bigger than maxSize, it will merge the last snapshot and any logfile created after it into a new snapshot, 
 // and start a new logfile to continue. All this will happen transparently in a separate goroutine.
@@ -183,6 +308,191 @@ func (self *Logger) Limit(maxSize int64) *Logger {
 	return self
 }
 
+// RotateAt will make this Logger start a new logfile when the current one is at least maxSize bytes, or contains maxOps operations. Zero turns a limit off.
+// Unlike with Limit, nothing is merged, so the old logfiles are kept until they are compacted, or can be archived or removed one by one.
+func (self *Logger) RotateAt(maxSize int64, maxOps int) *Logger {
+	self.rotateSize = maxSize
+	self.rotateOps = maxOps
+	return self
+}
+
+// CompactEvery will make this Logger, every interval it spends recording, start a new logfile and merge the last snapshot and the logfiles before
+// the new one into a new snapshot, the same way as when a Limit is exceeded. This keeps replay time and disk usage down when the same keys are written over and over.
+func (self *Logger) CompactEvery(interval time.Duration) *Logger {
+	self.compactInterval = interval
+	return self
+}
+
+// Fsync will make this Logger sync its logfiles to disk every interval, instead of leaving it to the operating system when to write them.
+// If interval is 0 every operation is synced before Dump returns, so that durable means that the operation survives a crash of the machine.
+func (self *Logger) Fsync(interval time.Duration) *Logger {
+	self.fsync = true
+	self.fsyncInterval = interval
+	return self
+}
+
+// Incremental will make this Logger, when compacting because of Limit or CompactEvery, only merge the logfiles since the last compaction into a delta
+// replayed after the last snapshot, instead of merging everything into a new snapshot. Every fullEvery compaction still merges everything into a new snapshot, so that the deltas don't pile up.
+func (self *Logger) Incremental(fullEvery int) *Logger {
+	self.fullEvery = fullEvery
+	return self
+}
+
+// Parallel will make Play apply the replayed operations using workers goroutines, each getting the operations for the keys hashed to it in the order they were logged.
+// operate must then be safe to call concurrently. Operations without a Key, like clearing or configuring everything, are applied when all operations before them are done.
+func (self *Logger) Parallel(workers int) *Logger {
+	self.workers = workers
+	return self
+}
+
+// Backlog will make this Logger queue up to size operations for the writing goroutine before Dump and Queue block, instead of groupSize.
+// It must be called before the Logger starts recording.
+func (self *Logger) Backlog(size int) *Logger {
+	self.ops = make(chan queued, size)
+	return self
+}
+
+// PlayWith will make Play replay only as far as options allow.
+// The operations that aren't replayed are left in the logfiles, so whatever records after a limited replay should snapshot the replayed state, like radix.Tree#Restore does, to drop them.
+func (self *Logger) PlayWith(options PlayOptions) *Logger {
+	self.playOptions = options
+	return self
+}
+
+// Limited returns whether Play is limited by the PlayOptions given to PlayWith.
+func (self *Logger) Limited() bool {
+	return self.playOptions != PlayOptions{}
+}
+
+// Compress will make this Logger flate compress the operations in the logfiles and snapshots it writes.
+// Whether a file is compressed is recorded in it, so files are replayed the same way whether they were compressed or not.
+func (self *Logger) Compress() *Logger {
+	self.compress = true
+	return self
+}
+
+// Encrypt will make this Logger encrypt the operations in the logfiles and snapshots it writes with AES-GCM, and decrypt encrypted files it replays, using key.
+// key must be 16, 24 or 32 bytes long, to select AES-128, AES-192 or AES-256, or Encrypt will panic.
+func (self *Logger) Encrypt(key []byte) *Logger {
+	if _, err := newCipher(key); err != nil {
+		panic(err)
+	}
+	self.key = key
+	return self
+}
+
+// RetryInterval will make this Logger try to resume writing to disk every interval while it is degraded.
+func (self *Logger) RetryInterval(interval time.Duration) *Logger {
+	self.retryInterval = interval
+	return self
+}
+
+// Errors returns a channel where write errors will be delivered. Errors will be dropped if nobody is reading them.
+func (self *Logger) Errors() <-chan error {
+	return self.errors
+}
+
+// Degraded returns true if this Logger has failed writing to disk and is keeping operations in memory.
+func (self *Logger) Degraded() bool {
+	return atomic.LoadInt32(&self.degraded) == 1
+}
+
+// Stats returns the current Stats of this Logger.
+func (self *Logger) Stats() Stats {
+	return Stats{
+		Degraded:   self.Degraded(),
+		NonDurable: int(atomic.LoadInt64(&self.nonDurable)),
+		Queued:     len(self.ops),
+	}
+}
+
+func (self *Logger) report(err error) {
+	log.Printf("%v failed writing: %v", self.dir, err)
+	select {
+	case self.errors <- err:
+	default:
+	}
+}
+
+func (self *Logger) fail(err error) {
+	atomic.StoreInt32(&self.failed, 1)
+	if atomic.CompareAndSwapInt32(&self.degraded, 0, 1) {
+		self.report(err)
+	}
+}
+
+func (self *Logger) degrade(op Op, err error) {
+	self.pending = append(self.pending, op)
+	atomic.StoreInt64(&self.nonDurable, int64(len(self.pending)))
+	if err != nil {
+		self.fail(err)
+	}
+}
+
+// resume will try to write all pending operations to a new logfile, and leave degraded mode if it succeeds.
+func (self *Logger) resume(rec *logfile) *logfile {
+	self.close(rec)
+	rec = createLogfile(self.dir, self.suffix)
+	if err := rec.create(self); err != nil {
+		self.report(err)
+		return rec
+	}
+	for index, op := range self.pending {
+		if err := rec.encoder.Encode(op); err != nil {
+			self.pending = self.pending[index:]
+			atomic.StoreInt64(&self.nonDurable, int64(len(self.pending)))
+			self.report(err)
+			return rec
+		}
+	}
+	// The pending operations are in the new logfile now, so they are forgotten even if syncing it fails, or the next resume would write them again.
+	self.pending = nil
+	atomic.StoreInt64(&self.nonDurable, 0)
+	if self.fsync {
+		if err := self.sync(rec); err != nil {
+			self.report(err)
+			return rec
+		}
+	}
+	atomic.StoreInt32(&self.degraded, 0)
+	return rec
+}
+
+// sync will sync the file of rec to disk.
+func (self *Logger) sync(rec *logfile) error {
+	if self.syncFile != nil {
+		return self.syncFile(rec.file)
+	}
+	return rec.file.Sync()
+}
+
+// close will close rec, after syncing it if this Logger syncs its logfiles.
+func (self *Logger) close(rec *logfile) {
+	if self.fsync && rec.file != nil {
+		if err := self.sync(rec); err != nil {
+			self.report(err)
+		}
+	}
+	rec.close()
+}
+
+// synced will sync the file named filename if this Logger syncs its logfiles, and return whether it succeeded.
+func (self *Logger) synced(filename string) bool {
+	if !self.fsync {
+		return true
+	}
+	file, err := os.Open(filename)
+	if err == nil {
+		err = file.Sync()
+		file.Close()
+	}
+	if err != nil {
+		self.report(err)
+		return false
+	}
+	return true
+}
+
 func (self *Logger) logfiles() (result logfiles) {
 	dir, err := os.Open(self.dir)
 	if err != nil {
@@ -212,7 +522,7 @@ func (self *Logger) latest() (latestSnapshot *logfile, logs logfiles) {
 		}
 	}
 	for _, logf := range self.logfiles() {
-		if logf.suffix == logSuffix {
+		if logf.suffix == logSuffix || logf.suffix == deltaSuffix {
 			if latestSnapshot == nil || latestSnapshot.timestamp.Before(logf.timestamp) {
 				logs = append(logs, logf)
 			}
@@ -227,16 +537,96 @@ func (self *Logger) Recording() bool {
 	return self.hasState(recording)
 }
 
-// Play will replay the latest snapshot and all logfiles created after it using the provided operate.
-func (self *Logger) Play(operate Operate) {
+// replayer applies replayed operations in a number of workers, routing every operation to a worker by the hash of its key.
+type replayer struct {
+	operate Operate
+	workers []chan Op
+	pending *sync.WaitGroup
+	done    *sync.WaitGroup
+}
+
+func newReplayer(operate Operate, workers int) (result *replayer) {
+	result = &replayer{
+		operate: operate,
+		workers: make([]chan Op, workers),
+		pending: new(sync.WaitGroup),
+		done:    new(sync.WaitGroup),
+	}
+	for index := range result.workers {
+		result.workers[index] = make(chan Op, groupSize)
+		result.done.Add(1)
+		go result.work(result.workers[index])
+	}
+	return
+}
+
+func (self *replayer) work(ops chan Op) {
+	defer self.done.Done()
+	for op := range ops {
+		self.operate(op)
+		self.pending.Done()
+	}
+}
+
+func (self *replayer) route(op Op) {
+	if op.Key == nil {
+		self.pending.Wait()
+		self.operate(op)
+		return
+	}
+	hash := fnv.New32a()
+	hash.Write(op.Key)
+	self.pending.Add(1)
+	self.workers[hash.Sum32()%uint32(len(self.workers))] <- op
+}
+
+func (self *replayer) close() {
+	for _, ops := range self.workers {
+		close(ops)
+	}
+	self.done.Wait()
+}
+
+// Play will replay the latest snapshot and all logfiles created after it using the provided operate, as far as the PlayOptions given to PlayWith allow.
+// It returns an error if it stopped at a corrupt logfile.
+func (self *Logger) Play(operate Operate) (err error) {
+	return self.play(self.playOptions, operate)
+}
+
+// PlayUntil will replay like Play, but stop at the first operation logged after upTo.
+// Snapshots and deltas don't keep the operations they replaced, so it will return an error without replaying anything if the latest snapshot or delta was started after upTo.
+func (self *Logger) PlayUntil(upTo time.Time, operate Operate) (err error) {
+	options := self.playOptions
+	options.UpTo = upTo
+	return self.play(options, operate)
+}
+
+func (self *Logger) play(options PlayOptions, operate Operate) (err error) {
 	if self.changeState(stopped, playing) {
 		defer self.changeState(playing, stopped)
 		snapshot, logs := self.latest()
-		snapshot.play(operate)
-		for _, logf := range logs {
-			logf.play(operate)
+		files := append(logfiles{snapshot}, logs...)
+		if !options.UpTo.IsZero() {
+			for _, logf := range files {
+				if logf != nil && logf.suffix != logSuffix && logf.timestamp.After(options.UpTo) {
+					return fmt.Errorf("%v was started after %v, and can't be replayed up to it", logf.filename, options.UpTo)
+				}
+			}
+		}
+		if self.workers > 1 {
+			replay := newReplayer(operate, self.workers)
+			defer replay.close()
+			operate = replay.route
+		}
+		p := &playback{PlayOptions: options}
+		for _, logf := range files {
+			var stopped bool
+			if stopped, err = logf.play(self, operate, p); stopped {
+				return
+			}
 		}
 	}
+	return
 }
 
 // Stop will stop this Logger. It will not return until all running recordings or snaphots are finished.
@@ -245,11 +635,11 @@ func (self *Logger) Stop() *Logger {
 		stop := make(chan bool)
 		self.stops <- stop
 		<-stop
+		self.lock.Lock()
 		for atomic.LoadInt32(&self.snapping) == 1 {
-			self.lock.Lock()
 			self.cond.Wait()
-			self.lock.Unlock()
 		}
+		self.lock.Unlock()
 	} else {
 		panic(fmt.Errorf("%v is not in state recording", self))
 	}
@@ -273,9 +663,13 @@ func (self *Logger) Clear() {
 	<-self.Record()
 }
 
-func (self *Logger) snapshot(snap *logfile, files logfiles) {
+// snapshot will dump the state that replaying snap and files would result in. If delta is true the deletions are dumped as well,
+// so that the dumped operations can be replayed on top of what came before files.
+func (self *Logger) snapshot(snap *logfile, files logfiles, delta bool) {
 	byteCompressor := make(map[string]Op)
 	treeCompressor := make(map[string]map[string]Op)
+	var clearAll *Op
+	clears := make(map[string]Op)
 	var latestConf *Op
 	confCompressor := make(map[string]Op)
 	var subMap map[string]Op
@@ -303,15 +697,29 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 				if op.Clear {
 					if op.Key == nil {
 						byteCompressor = make(map[string]Op)
+						if delta {
+							clearAll = &op
+						}
 					} else {
 						delete(treeCompressor, string(op.Key))
+						if delta {
+							clears[string(op.Key)] = op
+						}
 					}
+				} else if delta {
+					byteCompressor[string(op.Key)] = op
 				} else {
 					delete(byteCompressor, string(op.Key))
 				}
 			} else {
 				subMap, ok = treeCompressor[string(op.Key)]
-				if ok {
+				if delta {
+					if !ok {
+						subMap = make(map[string]Op)
+						treeCompressor[string(op.Key)] = subMap
+					}
+					subMap[string(op.SubKey)] = op
+				} else if ok {
 					delete(subMap, string(op.SubKey))
 					if len(subMap) == 0 {
 						delete(treeCompressor, string(op.Key))
@@ -320,9 +728,9 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 			}
 		}
 	}
-	snap.play(operate)
+	snap.play(self, operate, nil)
 	for _, logf := range files {
-		logf.play(operate)
+		logf.play(self, operate, nil)
 	}
 	if latestConf != nil {
 		self.Dump(*latestConf)
@@ -330,6 +738,12 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 	for _, op := range confCompressor {
 		self.Dump(op)
 	}
+	if clearAll != nil {
+		self.Dump(*clearAll)
+	}
+	for _, op := range clears {
+		self.Dump(op)
+	}
 	for _, op := range byteCompressor {
 		self.Dump(op)
 	}
@@ -340,35 +754,225 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 	}
 }
 
-func (self *Logger) snapshotAndDelete(oldrec *logfile, p chan *logfile, snapping *int32) {
-	defer atomic.StoreInt32(snapping, 0)
-	defer self.cond.Broadcast()
-	latestSnapshot, logfiles := self.latest()
-	snapshotter := NewLogger(self.dir).setSuffix(unfinishedSuffix)
+// startSnapping will wait until no snapshot, compaction or backup is running, and then mark one as running.
+func (self *Logger) startSnapping() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for !atomic.CompareAndSwapInt32(&self.snapping, 0, 1) {
+		self.cond.Wait()
+	}
+}
+
+// tryStartSnapping will mark a snapshot, compaction or backup as running unless one already is, and return whether it did.
+func (self *Logger) tryStartSnapping() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return atomic.CompareAndSwapInt32(&self.snapping, 0, 1)
+}
+
+// stopSnapping will mark the running snapshot, compaction or backup as finished, and wake everyone waiting for it.
+// Both happen while holding the lock, so that waiters can't check snapping before it is reset and then miss the wakeup.
+func (self *Logger) stopSnapping() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	atomic.StoreInt32(&self.snapping, 0)
+	self.cond.Broadcast()
+}
+
+func (self *Logger) snapshotAndDelete(oldrec *logfile, p chan *logfile) {
+	defer self.stopSnapping()
+	latestSnapshot, files := self.latest()
+	var logs logfiles
+	deltas := 0
+	for _, logf := range files {
+		if logf.suffix == deltaSuffix {
+			deltas++
+		} else {
+			logs = append(logs, logf)
+		}
+	}
+	delta := latestSnapshot != nil && deltas+1 < self.fullEvery
+	snapshotter := self.snapshotter()
 	snapshotfile := <-snapshotter.Record()
 	p <- snapshotfile
-	snapshotter.snapshot(latestSnapshot, logfiles)
+	if delta {
+		snapshotter.snapshot(nil, logs, true)
+	} else {
+		snapshotter.snapshot(latestSnapshot, files, false)
+	}
 	snapshotter.Stop()
+	if atomic.LoadInt32(&snapshotter.failed) == 1 || !self.synced(snapshotfile.filename) {
+		self.report(fmt.Errorf("failed writing snapshot %v, keeping old logfiles", snapshotfile.filename))
+		if unfinished, err := filepath.Glob(filepath.Join(self.dir, "*."+unfinishedSuffix)); err == nil {
+			for _, filename := range unfinished {
+				os.Remove(filename)
+			}
+		}
+		return
+	}
+	if delta {
+		if err := os.Rename(snapshotfile.filename, filepath.Join(self.dir, fmt.Sprintf("%v.%v", snapshotfile.timestamp.UnixNano(), deltaSuffix))); err != nil {
+			self.report(err)
+			os.Remove(snapshotfile.filename)
+			return
+		}
+		for _, logf := range logs {
+			if err := os.Remove(logf.filename); err != nil {
+				log.Printf("failed removing %v: %v", logf.filename, err)
+			}
+		}
+		return
+	}
 	if err := os.Rename(snapshotfile.filename, filepath.Join(self.dir, fmt.Sprintf("%v.%v", snapshotfile.timestamp.UnixNano(), snapSuffix))); err != nil {
-		panic(err)
+		self.report(err)
+		os.Remove(snapshotfile.filename)
+		return
+	}
+	self.clearOlderThan(snapshotfile.timestamp)
+}
+
+// Snapshot will let dump emit a complete state into a new snapshot, and remove all snapshots and logfiles it replaces.
+// Operations dumped while dump runs end up in a new logfile, so the caller must make sure the state dump emits includes everything dumped before Snapshot was called.
+// If the snapshot could not be written it is removed, all old files are kept, and ok will be false.
+func (self *Logger) Snapshot(dump func(emit func(Op))) (ok bool) {
+	if !self.hasState(recording) {
+		panic(fmt.Errorf("%v is not recording", self))
+	}
+	self.startSnapping()
+	defer self.stopSnapping()
+	snapshotter := self.snapshotter()
+	snapshotfile := <-snapshotter.Record()
+	rotated := make(chan bool)
+	self.rotations <- rotated
+	<-rotated
+	dump(func(op Op) {
+		snapshotter.Dump(op)
+	})
+	snapshotter.Stop()
+	if atomic.LoadInt32(&snapshotter.failed) == 1 || !self.synced(snapshotfile.filename) {
+		self.report(fmt.Errorf("failed writing snapshot %v, keeping old logfiles", snapshotfile.filename))
+		os.Remove(snapshotfile.filename)
+		return
+	}
+	if err := os.Rename(snapshotfile.filename, filepath.Join(self.dir, fmt.Sprintf("%v.%v", snapshotfile.timestamp.UnixNano(), snapSuffix))); err != nil {
+		self.report(err)
+		os.Remove(snapshotfile.filename)
+		return
 	}
 	self.clearOlderThan(snapshotfile.timestamp)
+	return true
+}
+
+// Backup will copy the latest snapshot, and all deltas and logfiles created after it, to dst. The current logfile is closed and a new one started first, so that
+// the copied files contain everything dumped before Backup was called, and no compactions run while they are copied. Replay the backup using PlayUntil of a Logger for dst created with In.
+func (self *Logger) Backup(dst string) (err error) {
+	if !self.hasState(recording) {
+		panic(fmt.Errorf("%v is not recording", self))
+	}
+	if err = os.MkdirAll(dst, os.ModePerm); err != nil {
+		return
+	}
+	self.startSnapping()
+	defer self.stopSnapping()
+	cutoff := time.Now()
+	rotated := make(chan bool)
+	self.rotations <- rotated
+	<-rotated
+	snapshot, logs := self.latest()
+	for _, logf := range append(logfiles{snapshot}, logs...) {
+		if logf != nil && logf.timestamp.Before(cutoff) {
+			if err = copyFile(logf.filename, filepath.Join(dst, filepath.Base(logf.filename))); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// copyFile will copy the file named src to a synced file named dst.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return
 }
 
 func (self *Logger) swap(fi *os.FileInfo, err *error, rec *logfile) *logfile {
 	if atomic.LoadInt32(&self.snapping) == 0 {
 		if *fi, *err = os.Stat(rec.filename); *err != nil {
-			panic(*err)
+			self.fail(*err)
+		} else if (*fi).Size() > self.maxSize {
+			rec = self.compact(rec, err)
 		}
-		if (*fi).Size() > self.maxSize {
-			rec.close()
-			started := make(chan *logfile)
-			atomic.StoreInt32(&self.snapping, 1)
-			go self.snapshotAndDelete(rec, started, &self.snapping)
-			<-started
-			rec = createLogfile(self.dir, self.suffix)
-			rec.write()
+	}
+	return rec
+}
+
+// full returns whether rec has reached the limits set by RotateAt.
+func (self *Logger) full(rec *logfile) bool {
+	if self.rotateOps != 0 && rec.ops >= self.rotateOps {
+		return true
+	}
+	if self.rotateSize != 0 {
+		fi, err := os.Stat(rec.filename)
+		if err != nil {
+			self.fail(err)
+			return false
 		}
+		return fi.Size() >= self.rotateSize
+	}
+	return false
+}
+
+// uncompacted returns whether anything has been logged since the last compaction, either in rec or in logfiles rotated before it.
+func (self *Logger) uncompacted(rec *logfile) bool {
+	if rec.ops > 0 {
+		return true
+	}
+	_, files := self.latest()
+	for _, logf := range files {
+		if logf.suffix == logSuffix && logf.filename != rec.filename {
+			return true
+		}
+	}
+	return false
+}
+
+// rotate will close rec and start a new logfile.
+func (self *Logger) rotate(rec *logfile, err *error) *logfile {
+	self.close(rec)
+	rec = createLogfile(self.dir, self.suffix)
+	if *err = rec.create(self); *err != nil {
+		self.fail(*err)
+	}
+	return rec
+}
+
+// compact will close rec and start a new logfile, while everything logged before it is merged into a new snapshot in a separate goroutine.
+// If a snapshot, compaction or backup is already running rec is kept as it is.
+func (self *Logger) compact(rec *logfile, err *error) *logfile {
+	if !self.tryStartSnapping() {
+		return rec
+	}
+	self.close(rec)
+	started := make(chan *logfile)
+	go self.snapshotAndDelete(rec, started)
+	<-started
+	rec = createLogfile(self.dir, self.suffix)
+	if *err = rec.create(self); *err != nil {
+		self.fail(*err)
 	}
 	return rec
 }
@@ -383,28 +987,117 @@ func (self *Logger) Record() (rval chan *logfile) {
 	return
 }
 
+// write will write q, and the operations queued behind it, to rec. If this Logger syncs after every operation they are all synced at once before anyone is told they are durable.
+func (self *Logger) write(rec *logfile, q queued) {
+	group := []queued{q}
+	for full := false; !full && len(group) < groupSize; {
+		select {
+		case q = <-self.ops:
+			group = append(group, q)
+		default:
+			full = true
+		}
+	}
+	durable := make([]bool, len(group))
+	written := false
+	for index, q := range group {
+		if self.Degraded() {
+			self.degrade(q.op, nil)
+		} else if err := rec.encoder.Encode(q.op); err != nil {
+			self.degrade(q.op, err)
+		} else {
+			rec.ops++
+			durable[index], written = true, true
+		}
+	}
+	if written && self.fsync && self.fsyncInterval == 0 {
+		if err := self.sync(rec); err != nil {
+			// The operations are in the logfile already, even if it might not be on disk, so they are not kept to be written again when resuming.
+			for index := range group {
+				durable[index] = false
+			}
+			self.fail(err)
+		}
+	}
+	for index, q := range group {
+		q.durable <- durable[index]
+	}
+}
+
+// drain will write all queued operations to rec.
+func (self *Logger) drain(rec *logfile) {
+	for {
+		select {
+		case q := <-self.ops:
+			self.write(rec, q)
+		default:
+			return
+		}
+	}
+}
+
 func (self *Logger) record(p chan *logfile) {
 	var err error
-	var op Op
+	var q queued
 	var fi os.FileInfo
 	var stop chan bool
+	var rotated chan bool
+	var retry <-chan time.Time
+	var compaction <-chan time.Time
+	var fsync <-chan time.Time
 
 	rec := createLogfile(self.dir, self.suffix)
-	rec.write()
+	if err = rec.create(self); err != nil {
+		self.fail(err)
+	}
 	p <- rec
-	defer rec.close()
+	defer func() {
+		self.close(rec)
+	}()
 
 	for {
-		if self.maxSize != 0 {
+		if self.maxSize != 0 && !self.Degraded() {
 			rec = self.swap(&fi, &err, rec)
 		}
+		if (self.rotateSize != 0 || self.rotateOps != 0) && !self.Degraded() && self.full(rec) {
+			rec = self.rotate(rec, &err)
+		}
+		if self.Degraded() && retry == nil {
+			retry = time.After(self.retryInterval)
+		}
+		if self.compactInterval != 0 && compaction == nil {
+			compaction = time.After(self.compactInterval)
+		}
+		if self.fsyncInterval != 0 && fsync == nil {
+			fsync = time.After(self.fsyncInterval)
+		}
 
 		select {
-		case op = <-self.ops:
-			if err = rec.encoder.Encode(op); err != nil {
-				panic(err)
+		case q = <-self.ops:
+			self.write(rec, q)
+		case <-retry:
+			retry = nil
+			rec = self.resume(rec)
+		case <-fsync:
+			fsync = nil
+			if !self.Degraded() {
+				if err = self.sync(rec); err != nil {
+					self.fail(err)
+				}
+			}
+		case <-compaction:
+			compaction = nil
+			if !self.Degraded() && atomic.LoadInt32(&self.snapping) == 0 && self.uncompacted(rec) {
+				rec = self.compact(rec, &err)
 			}
+		case rotated = <-self.rotations:
+			rec = self.rotate(rec, &err)
+			rotated <- true
 		case stop = <-self.stops:
+			self.drain(rec)
+			if self.Degraded() {
+				rec = self.resume(rec)
+			}
 			if !self.changeState(recording, stopped) {
 				panic(fmt.Errorf("%v unable to change state from recording to stopped", self))
 			}
@@ -413,6 +1106,10 @@ func (self *Logger) record(p chan *logfile) {
 		}
 		select {
 		case stop = <-self.stops:
+			self.drain(rec)
+			if self.Degraded() {
+				rec = self.resume(rec)
+			}
 			if !self.changeState(recording, stopped) {
 				panic(fmt.Errorf("%v unable to change state from recording to stopped", self))
 			}
@@ -424,9 +1121,25 @@ func (self *Logger) record(p chan *logfile) {
 }
 
 // Dump will accept an operation if this Logger is recording, and dump it into a logfile.
-func (self *Logger) Dump(o Op) {
+// If the operation could not be written it will only be kept in memory until the disk is writable again, and durable will be false.
+func (self *Logger) Dump(o Op) (durable bool) {
+	return <-self.Queue(o)
+}
+
+// Queue will accept an operation like Dump, but return a channel that will receive whether it was durable instead of waiting for it.
+// Operations are written in the order they are queued, and operations queued while the Logger is busy are written, and synced, together.
+// This lets a caller queue operations while holding a lock that orders them, and wait for them to become durable after releasing it.
+func (self *Logger) Queue(o Op) (durable <-chan bool) {
 	if !self.hasState(recording) {
 		panic(fmt.Errorf("%v is not recording", self))
 	}
-	self.ops <- o
+	if o.Time == 0 {
+		o.Time = time.Now().UnixNano()
+	}
+	q := queued{
+		op:      o,
+		durable: make(chan bool, 1),
+	}
+	self.ops <- q
+	return q.durable
 }
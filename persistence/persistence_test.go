@@ -1,11 +1,20 @@
 package persistence
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+	"github.com/zond/god/common"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testmap struct {
@@ -59,8 +68,10 @@ func (self testmap) operator() Operate {
 	}
 }
 
+// operator returns an Operate appending the Ops to ary without their Time, to compare them to the Ops that were dumped.
 func operator(ary *[]Op) Operate {
 	return func(o Op) {
+		o.Time = 0
 		*ary = append(*ary, o)
 	}
 }
@@ -114,6 +125,641 @@ func TestSwap(t *testing.T) {
 	}
 }
 
+type fillingWriter struct {
+	w       io.Writer
+	written int
+	limit   int
+	full    *int32
+}
+
+func (self *fillingWriter) Write(b []byte) (n int, err error) {
+	if atomic.LoadInt32(self.full) == 1 && self.written+len(b) > self.limit {
+		n, _ = self.w.Write(b[:self.limit-self.written])
+		self.written += n
+		err = fmt.Errorf("disk full")
+		return
+	}
+	n, err = self.w.Write(b)
+	self.written += n
+	return
+}
+
+func TestDegrade(t *testing.T) {
+	os.RemoveAll("test4")
+	defer os.RemoveAll("test4")
+	full := int32(1)
+	p := NewLogger("test4").RetryInterval(time.Millisecond * 10)
+	p.wrap = func(w io.Writer) io.Writer {
+		return &fillingWriter{w: w, limit: 256, full: &full}
+	}
+	p.Record()
+	expected := make(map[string]string)
+	nonDurable := false
+	for i := 0; i < 100; i++ {
+		if !p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}) {
+			nonDurable = true
+		}
+		expected[fmt.Sprint(i)] = fmt.Sprint(i)
+	}
+	if !nonDurable {
+		t.Errorf("wanted some operations to be marked non durable")
+	}
+	if stats := p.Stats(); !stats.Degraded || stats.NonDurable == 0 {
+		t.Errorf("wanted %+v to be degraded with non durable operations", stats)
+	}
+	select {
+	case err := <-p.Errors():
+		if err == nil {
+			t.Errorf("wanted an error")
+		}
+	default:
+		t.Errorf("wanted an error to be reported")
+	}
+	atomic.StoreInt32(&full, 0)
+	common.AssertWithin(t, func() (string, bool) {
+		stats := p.Stats()
+		return fmt.Sprintf("%+v", stats), !stats.Degraded && stats.NonDurable == 0
+	}, time.Second)
+	p.Dump(Op{Key: []byte("last"), Value: []byte("last"), Put: true})
+	expected["last"] = "last"
+	p.Stop()
+	found := make(map[string]string)
+	p.Play(func(o Op) {
+		found[string(o.Key)] = string(o.Value)
+	})
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("%v should be %v", found, expected)
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Millisecond} {
+		os.RemoveAll("test22")
+		failing := int32(1)
+		p := NewLogger("test22").Fsync(interval).RetryInterval(time.Millisecond * 10)
+		p.syncFile = func(f *os.File) error {
+			if atomic.LoadInt32(&failing) == 1 {
+				return fmt.Errorf("failing sync of %v", f.Name())
+			}
+			return f.Sync()
+		}
+		p.Record()
+		for i := 0; i < 10; i++ {
+			if p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}) && interval == 0 {
+				t.Errorf("wanted operations to be non durable while syncing fails")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		common.AssertWithin(t, func() (string, bool) {
+			stats := p.Stats()
+			return fmt.Sprintf("%+v", stats), stats.Degraded
+		}, time.Second)
+		atomic.StoreInt32(&failing, 0)
+		common.AssertWithin(t, func() (string, bool) {
+			stats := p.Stats()
+			return fmt.Sprintf("%+v", stats), !stats.Degraded && stats.NonDurable == 0
+		}, time.Second)
+		if !p.Dump(Op{Key: []byte("last"), Value: []byte("last"), Put: true}) {
+			t.Errorf("wanted operations to be durable once syncing works when syncing every %v", interval)
+		}
+		p.Stop()
+		replayed := make(map[string]int)
+		p.Play(func(o Op) {
+			replayed[string(o.Key)]++
+		})
+		if len(replayed) != 11 {
+			t.Errorf("wanted 11 keys to be replayed when syncing every %v, got %v", interval, replayed)
+		}
+		for key, count := range replayed {
+			if count != 1 {
+				t.Errorf("wanted %v to be replayed once when syncing every %v, got %v", key, interval, replayed)
+			}
+		}
+	}
+	os.RemoveAll("test22")
+}
+
+func TestRemovedLogfile(t *testing.T) {
+	os.RemoveAll("test20")
+	defer os.RemoveAll("test20")
+	p := NewLogger("test20").RotateAt(1<<20, 0).RetryInterval(time.Millisecond * 10)
+	p.Record()
+	os.RemoveAll("test20")
+	// Depending on whether the logfile is found missing before or after it is written, this operation is lost with it or kept in memory.
+	p.Dump(Op{Key: []byte("removed"), Value: []byte("removed"), Put: true})
+	select {
+	case err := <-p.Errors():
+		if err == nil {
+			t.Errorf("wanted an error")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("wanted the removed logfile to be reported")
+	}
+	os.MkdirAll("test20", os.ModePerm)
+	expected := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true})
+		expected[fmt.Sprint(i)] = fmt.Sprint(i)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		stats := p.Stats()
+		return fmt.Sprintf("%+v", stats), !stats.Degraded && stats.NonDurable == 0
+	}, time.Second)
+	p.Stop()
+	found := make(map[string]string)
+	p.Play(func(o Op) {
+		found[string(o.Key)] = string(o.Value)
+	})
+	delete(found, "removed")
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("%v should be %v", found, expected)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	os.RemoveAll("test5")
+	defer os.RemoveAll("test5")
+	p := NewLogger("test5")
+	p.Record()
+	for i := 0; i < 10; i++ {
+		p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true})
+	}
+	state := Op{Key: []byte("state"), Value: []byte("state"), Put: true}
+	after := Op{Key: []byte("after"), Value: []byte("after"), Put: true}
+	if !p.Snapshot(func(emit func(Op)) {
+		emit(state)
+		p.Dump(after)
+	}) {
+		t.Errorf("wanted the snapshot to be written")
+	}
+	p.Stop()
+	files, err := filepath.Glob(filepath.Join("test5", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%v should only be a snapshot and a logfile", files)
+	}
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, []Op{state, after}) {
+		t.Errorf("%+v should be %+v", ary, []Op{state, after})
+	}
+}
+
+func TestConcurrentSnapshots(t *testing.T) {
+	os.RemoveAll("test21")
+	defer os.RemoveAll("test21")
+	for i := 0; i < 4; i++ {
+		defer os.RemoveAll(fmt.Sprint("test21backup", i))
+	}
+	p := NewLogger("test21").CompactEvery(time.Millisecond)
+	p.Record()
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			for j := 0; j < 20; j++ {
+				p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(j)), Put: true})
+				p.Snapshot(func(emit func(Op)) {})
+				if err := p.Backup(fmt.Sprint("test21backup", i)); err != nil {
+					t.Error(err)
+				}
+			}
+			done <- true
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second * 10):
+			t.Fatalf("wanted the snapshots and backups to finish while compacting")
+		}
+	}
+	p.Stop()
+}
+
+func TestRotateAt(t *testing.T) {
+	os.RemoveAll("test7")
+	defer os.RemoveAll("test7")
+	p := NewLogger("test7").RotateAt(0, 10)
+	p.Record()
+	var expected []Op
+	for i := 0; i < 35; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+		p.Dump(op)
+		expected = append(expected, op)
+	}
+	p.Stop()
+	if logs, _ := filepath.Glob(filepath.Join("test7", "*.log")); len(logs) != 4 {
+		t.Errorf("wanted 35 operations to be logged in 4 logfiles, got %v", logs)
+	}
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, expected) {
+		t.Errorf("%+v should be %+v", ary, expected)
+	}
+}
+
+func TestFsync(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Millisecond} {
+		os.RemoveAll("test8")
+		p := NewLogger("test8").Fsync(interval)
+		p.Record()
+		var expected []Op
+		for i := 0; i < 10; i++ {
+			op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+			if !p.Dump(op) {
+				t.Errorf("wanted %+v to be durable when syncing every %v", op, interval)
+			}
+			expected = append(expected, op)
+			time.Sleep(time.Millisecond)
+		}
+		p.Stop()
+		var ary []Op
+		p.Play(operator(&ary))
+		if !reflect.DeepEqual(ary, expected) {
+			t.Errorf("%+v should be %+v", ary, expected)
+		}
+	}
+	os.RemoveAll("test8")
+}
+
+func TestQueue(t *testing.T) {
+	os.RemoveAll("test9")
+	defer os.RemoveAll("test9")
+	p := NewLogger("test9").Fsync(0)
+	p.Record()
+	var expected []Op
+	var durables []<-chan bool
+	for i := 0; i < 100; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+		durables = append(durables, p.Queue(op))
+		expected = append(expected, op)
+	}
+	for index, durable := range durables {
+		if !<-durable {
+			t.Errorf("wanted %+v to be durable", expected[index])
+		}
+	}
+	last := Op{Key: []byte("last"), Value: []byte("last"), Put: true}
+	p.Queue(last)
+	expected = append(expected, last)
+	p.Stop()
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, expected) {
+		t.Errorf("wanted the operations in the order they were queued, got %+v", ary)
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	os.RemoveAll("test19")
+	defer os.RemoveAll("test19")
+	p := NewLogger("test19").Backlog(1)
+	p.Record()
+	var expected []Op
+	for i := 0; i < 100; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+		p.Queue(op)
+		expected = append(expected, op)
+	}
+	p.Stop()
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, expected) {
+		t.Errorf("wanted the operations in the order they were queued, got %+v", ary)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	os.RemoveAll("test10")
+	defer os.RemoveAll("test10")
+	p := NewLogger("test10")
+	p.Record()
+	var expected []Op
+	for i := 0; i < 10; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+		p.Dump(op)
+		expected = append(expected, op)
+	}
+	p.Stop()
+	logs, err := filepath.Glob(filepath.Join("test10", "*.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("wanted one logfile, got %v, %v", logs, err)
+	}
+	content, err := ioutil.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 1
+	if err = ioutil.WriteFile(logs[0], content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, expected[:9]) {
+		t.Errorf("wanted replay to stop before the corrupt operation, got %+v", ary)
+	}
+}
+
+func TestPlayWith(t *testing.T) {
+	os.RemoveAll("test18")
+	defer os.RemoveAll("test18")
+	p := NewLogger("test18")
+	p.Record()
+	var expected []Op
+	for i := 0; i < 10; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+		p.Dump(op)
+		expected = append(expected, op)
+	}
+	p.Stop()
+	logs, err := filepath.Glob(filepath.Join("test18", "*.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("wanted one logfile, got %v, %v", logs, err)
+	}
+	content, err := ioutil.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 1
+	if err = ioutil.WriteFile(logs[0], content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	p.Record()
+	after := Op{Key: []byte("after"), Value: []byte("after"), Put: true}
+	p.Dump(after)
+	p.Stop()
+	var ary []Op
+	if err := p.PlayWith(PlayOptions{Ops: 5}).Play(operator(&ary)); err != nil || !reflect.DeepEqual(ary, expected[:5]) {
+		t.Errorf("wanted replay to stop after 5 operations, got %+v, %v", ary, err)
+	}
+	ary = nil
+	if err := p.PlayWith(PlayOptions{StopAtCorruption: true}).Play(operator(&ary)); err == nil || !reflect.DeepEqual(ary, expected[:9]) {
+		t.Errorf("wanted replay to stop with an error at the corrupt operation, got %+v, %v", ary, err)
+	}
+	ary = nil
+	if err := p.PlayWith(PlayOptions{}).Play(operator(&ary)); err != nil || !reflect.DeepEqual(ary, append(expected[:9], after)) {
+		t.Errorf("wanted replay to continue after the corrupt logfile, got %+v, %v", ary, err)
+	}
+}
+
+func TestPlainGob(t *testing.T) {
+	os.RemoveAll("test11")
+	defer os.RemoveAll("test11")
+	p := NewLogger("test11")
+	file, err := os.Create(filepath.Join("test11", fmt.Sprintf("%v.log", time.Now().UnixNano())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := gob.NewEncoder(file)
+	var expected []Op
+	for i := 0; i < 3; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprint(i)), Put: true}
+		if err = encoder.Encode(op); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, op)
+	}
+	file.Close()
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, expected) {
+		t.Errorf("wanted the logfile of an older version to be replayed, got %+v", ary)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	defer os.RemoveAll("test12")
+	defer os.RemoveAll("test13")
+	sizes := make(map[string]int64)
+	for dir, compress := range map[string]bool{"test12": false, "test13": true} {
+		os.RemoveAll(dir)
+		p := NewLogger(dir)
+		if compress {
+			p.Compress()
+		}
+		p.Record()
+		var expected []Op
+		for i := 0; i < 100; i++ {
+			op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(strings.Repeat(fmt.Sprintf("{\"value\": %v}", i), 20)), Put: true}
+			p.Dump(op)
+			expected = append(expected, op)
+		}
+		snapshotted := Op{Key: []byte("snapshotted"), Value: []byte(strings.Repeat("snapshotted", 20)), Put: true}
+		p.Snapshot(func(emit func(Op)) {
+			for _, op := range expected {
+				emit(op)
+			}
+			emit(snapshotted)
+		})
+		expected = append(expected, snapshotted)
+		p.Stop()
+		var ary []Op
+		p.Play(operator(&ary))
+		if !reflect.DeepEqual(ary, expected) {
+			t.Errorf("wanted the operations back when compressing is %v, got %+v", compress, ary)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, file := range files {
+			if fi, err := os.Stat(file); err == nil {
+				sizes[dir] += fi.Size()
+			}
+		}
+	}
+	if sizes["test13"]*2 > sizes["test12"] {
+		t.Errorf("wanted the compressed files to be less than half the size of the uncompressed, got %v", sizes)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	os.RemoveAll("test14")
+	defer os.RemoveAll("test14")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	p := NewLogger("test14").Encrypt(key).Compress()
+	p.Record()
+	var expected []Op
+	for i := 0; i < 10; i++ {
+		op := Op{Key: []byte(fmt.Sprint(i)), Value: []byte(fmt.Sprintf("secret %v", i)), Put: true}
+		p.Dump(op)
+		expected = append(expected, op)
+	}
+	p.Stop()
+	files, _ := filepath.Glob(filepath.Join("test14", "*"))
+	for _, file := range files {
+		if content, err := ioutil.ReadFile(file); err != nil || bytes.Contains(content, []byte("secret")) {
+			t.Errorf("wanted %v to be readable and encrypted, got %v, %q", file, err, content)
+		}
+	}
+	var ary []Op
+	p.Play(operator(&ary))
+	if !reflect.DeepEqual(ary, expected) {
+		t.Errorf("%+v should be %+v", ary, expected)
+	}
+	for _, other := range []*Logger{NewLogger("test14"), NewLogger("test14").Encrypt([]byte("fedcba9876543210fedcba9876543210"))} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("wanted replaying encrypted files with the wrong key to panic")
+				}
+			}()
+			other.Play(func(o Op) {})
+		}()
+	}
+}
+
+func TestCompactEvery(t *testing.T) {
+	os.RemoveAll("test6")
+	defer os.RemoveAll("test6")
+	p := NewLogger("test6").CompactEvery(time.Millisecond * 10)
+	p.Record()
+	expected := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		p.Dump(Op{Key: []byte(fmt.Sprint(i % 10)), Value: []byte(fmt.Sprint(i)), Put: true})
+		expected[fmt.Sprint(i%10)] = fmt.Sprint(i)
+	}
+	p.Dump(Op{Key: []byte("0")})
+	delete(expected, "0")
+	common.AssertWithin(t, func() (string, bool) {
+		snaps, _ := filepath.Glob(filepath.Join("test6", "*.snap"))
+		logs, _ := filepath.Glob(filepath.Join("test6", "*.log"))
+		return fmt.Sprint(snaps, logs), len(snaps) == 1 && len(logs) == 1
+	}, time.Second)
+	p.Stop()
+	var ary []Op
+	p.Play(operator(&ary))
+	if len(ary) != len(expected) {
+		t.Errorf("wanted the %v live keys to be replayed once each, got %+v", len(expected), ary)
+	}
+	found := make(map[string]string)
+	for _, op := range ary {
+		found[string(op.Key)] = string(op.Value)
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("%v should be %v", found, expected)
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	os.RemoveAll("test15")
+	defer os.RemoveAll("test15")
+	p := NewLogger("test15").CompactEvery(time.Millisecond * 10).Incremental(3)
+	p.Record()
+	expected := make(map[string]string)
+	for round, deltas := range []int{0, 1, 2, 0} {
+		// One batch per round, so that each round is compacted exactly once.
+		var batch []Op
+		for i := 0; i < 10; i++ {
+			batch = append(batch, Op{Key: []byte(fmt.Sprint(round, i)), Value: []byte(fmt.Sprint(i)), Put: true})
+			expected[fmt.Sprint(round, i)] = fmt.Sprint(i)
+		}
+		if round > 0 {
+			batch = append(batch, Op{Key: []byte(fmt.Sprint(round-1, 0))})
+			delete(expected, fmt.Sprint(round-1, 0))
+		}
+		p.Dump(Op{Batch: batch})
+		common.AssertWithin(t, func() (string, bool) {
+			snaps, _ := filepath.Glob(filepath.Join("test15", "*.snap"))
+			found, _ := filepath.Glob(filepath.Join("test15", "*.delta"))
+			logs, _ := filepath.Glob(filepath.Join("test15", "*.log"))
+			return fmt.Sprint(snaps, found, logs), len(snaps) == 1 && len(found) == deltas && len(logs) == 1
+		}, time.Second)
+		p.Stop()
+		found := make(map[string]string)
+		p.Play(func(op Op) {
+			if op.Put {
+				found[string(op.Key)] = string(op.Value)
+			} else {
+				delete(found, string(op.Key))
+			}
+		})
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("after round %v, %v should be %v", round, found, expected)
+		}
+		p.Record()
+	}
+	p.Stop()
+}
+
+func TestParallel(t *testing.T) {
+	os.RemoveAll("test16")
+	defer os.RemoveAll("test16")
+	p := NewLogger("test16").Parallel(4)
+	p.Record()
+	expected := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		p.Dump(Op{Key: []byte(fmt.Sprint(i % 100)), Value: []byte(fmt.Sprint(i)), Put: true})
+		if i == 500 {
+			p.Dump(Op{Clear: true})
+		}
+		if i%7 == 0 {
+			p.Dump(Op{Key: []byte(fmt.Sprint(i % 100))})
+		}
+	}
+	for i := 501; i < 1000; i++ {
+		if i%7 == 0 {
+			delete(expected, fmt.Sprint(i%100))
+		} else {
+			expected[fmt.Sprint(i%100)] = fmt.Sprint(i)
+		}
+	}
+	p.Stop()
+	lock := new(sync.Mutex)
+	found := make(map[string]string)
+	p.Play(func(op Op) {
+		lock.Lock()
+		defer lock.Unlock()
+		if op.Clear {
+			found = make(map[string]string)
+		} else if op.Put {
+			found[string(op.Key)] = string(op.Value)
+		} else {
+			delete(found, string(op.Key))
+		}
+	})
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("%v should be %v", found, expected)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	os.RemoveAll("test17")
+	os.RemoveAll("test17backup")
+	defer os.RemoveAll("test17")
+	defer os.RemoveAll("test17backup")
+	p := NewLogger("test17").Compress()
+	p.Record()
+	for i := 0; i < 10; i++ {
+		p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte("before"), Put: true})
+	}
+	time.Sleep(time.Millisecond * 10)
+	upTo := time.Now()
+	time.Sleep(time.Millisecond * 10)
+	for i := 5; i < 15; i++ {
+		p.Dump(Op{Key: []byte(fmt.Sprint(i)), Value: []byte("after"), Put: true})
+	}
+	if err := p.Backup("test17backup"); err != nil {
+		t.Fatal(err)
+	}
+	p.Dump(Op{Key: []byte("not backed up"), Put: true})
+	p.Stop()
+	for _, wanted := range []map[string]string{
+		map[string]string{"0": "before", "1": "before", "2": "before", "3": "before", "4": "before", "5": "before", "6": "before", "7": "before", "8": "before", "9": "before"},
+		map[string]string{"0": "before", "1": "before", "2": "before", "3": "before", "4": "before", "5": "after", "6": "after", "7": "after", "8": "after", "9": "after", "10": "after", "11": "after", "12": "after", "13": "after", "14": "after"},
+	} {
+		found := make(map[string]string)
+		if err := p.In("test17backup").PlayUntil(upTo, func(op Op) {
+			found[string(op.Key)] = string(op.Value)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(found, wanted) {
+			t.Errorf("playing up to %v, wanted %v, got %v", upTo, wanted, found)
+		}
+		upTo = time.Now()
+	}
+}
+
 func BenchmarkRecord(b *testing.B) {
 	b.StopTimer()
 	os.RemoveAll("test2")
@@ -19,7 +19,7 @@ func assertExistance(t *testing.T, tree *Tree, k, v string) {
 
 func assertNewPut(t *testing.T, tree *Tree, k, v string) {
 	assertNonExistance(t, tree, k)
-	if value, existed := tree.Put([]byte(k), []byte(v), 0); existed || value != nil {
+	if value, existed, _ := tree.Put([]byte(k), []byte(v), 0); existed || value != nil {
 		t.Errorf("%v should not contain %v, got %v, %v", tree.Describe(), Rip([]byte(k)), value, existed)
 	}
 	assertExistance(t, tree, k, v)
@@ -27,7 +27,7 @@ func assertNewPut(t *testing.T, tree *Tree, k, v string) {
 
 func assertOldPut(t *testing.T, tree *Tree, k, v, old string) {
 	assertExistance(t, tree, k, old)
-	if value, existed := tree.Put([]byte(k), []byte(v), 0); !existed || string(value) != old {
+	if value, existed, _ := tree.Put([]byte(k), []byte(v), 0); !existed || string(value) != old {
 		t.Errorf("%v should contain %v => %v, got %v, %v", tree.Describe(), Rip([]byte(k)), v, value, existed)
 	}
 	assertExistance(t, tree, k, v)
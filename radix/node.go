@@ -16,6 +16,8 @@ const (
 
 const (
 	zombieLifetime = int64(time.Hour * 24)
+	// nodeOverhead is roughly the number of bytes a node uses apart from its segment and values.
+	nodeOverhead = 256
 )
 
 type nodeIndexIterator func(key, byteValue []byte, treeValue *Tree, use int, timestamp int64, index int) (cont bool)
@@ -43,6 +45,8 @@ type node struct {
 	treeSize  int  // size of the tree in this node and those of all of its children
 	byteSize  int  // number of byte values in this node and all of its children
 	realSize  int  // number of actual values, including tombstones
+	keySize   int  // number of keys with a byte value or a non empty tree value in this node and all of its children
+	dataSize  int  // approximate number of bytes used by this node and all of its children, including segments, values and sub trees
 }
 
 func newNode(segment []Nibble, byteValue []byte, treeValue *Tree, timestamp int64, empty bool, use int) *node {
@@ -66,6 +70,11 @@ func (self *node) setSegment(part []Nibble) {
 	self.segment = new_segment
 }
 
+// hasKey returns whether this node has a byte value or a non empty tree value, and is counted in keySize.
+func (self *node) hasKey() bool {
+	return self.use&byteValue != 0 || self.use&treeValue != 0 && self.treeValue.Size() > 0
+}
+
 // rehash will recount the size of this node by summing the sizes of its own data and
 // the data of its children.
 //
@@ -77,6 +86,8 @@ func (self *node) rehash(key []Nibble, now int64) {
 	self.treeSize = 0
 	self.byteSize = 0
 	self.realSize = 0
+	self.keySize = 0
+	self.dataSize = nodeOverhead + len(self.segment) + len(self.byteValue) + self.treeValue.DataSize()
 	self.realSize += self.treeValue.RealSize()
 	if self.timestamp != 0 {
 		self.realSize++
@@ -87,6 +98,9 @@ func (self *node) rehash(key []Nibble, now int64) {
 	if self.use&byteValue != 0 {
 		self.byteSize = 1
 	}
+	if self.hasKey() {
+		self.keySize = 1
+	}
 	h := murmur.NewBytes(toBytes(key))
 	h.Write(self.byteHash)
 	h.Write(self.treeValue.Hash())
@@ -100,6 +114,8 @@ func (self *node) rehash(key []Nibble, now int64) {
 			self.treeSize += child.treeSize
 			self.byteSize += child.byteSize
 			self.realSize += child.realSize
+			self.keySize += child.keySize
+			self.dataSize += child.dataSize
 			h.Write(child.hash)
 		}
 	}
@@ -112,11 +112,19 @@ func (self *node) reverseEachBetween(prefix, min, max []Nibble, mincmp, maxcmp,
 func (self *node) sizeBetween(prefix, min, max []Nibble, mincmp, maxcmp, use int) (result int) {
 	prefix = append(prefix, self.segment...)
 	if !self.empty && (use == 0 || self.use&use != 0) && (min == nil || nComp(prefix, min) > mincmp) && (max == nil || nComp(prefix, max) < maxcmp) {
-		if use == 0 || self.use&use&byteValue != 0 {
-			result++
-		}
-		if use == 0 || self.use&use&treeValue != 0 {
-			result += self.treeValue.Size()
+		if use == 0 {
+			// Count the same way as realSize, so that ranges add up to the real size of the whole tree.
+			if self.timestamp != 0 {
+				result++
+			}
+			result += self.treeValue.RealSize()
+		} else {
+			if self.use&use&byteValue != 0 {
+				result++
+			}
+			if self.use&use&treeValue != 0 {
+				result += self.treeValue.Size()
+			}
 		}
 	}
 	for _, child := range self.children {
@@ -155,6 +163,97 @@ func (self *node) sizeBetween(prefix, min, max []Nibble, mincmp, maxcmp, use int
 	return
 }
 
+// keySizeBetween will count the keys between min and max, including each depending on mincmp and maxcmp, that have a byte value or a non empty tree value.
+func (self *node) keySizeBetween(prefix, min, max []Nibble, mincmp, maxcmp int) (result int) {
+	prefix = append(prefix, self.segment...)
+	if !self.empty && self.hasKey() && (min == nil || nComp(prefix, min) > mincmp) && (max == nil || nComp(prefix, max) < maxcmp) {
+		result++
+	}
+	for _, child := range self.children {
+		if child != nil {
+			childKey := make([]Nibble, len(prefix)+len(child.segment))
+			copy(childKey, prefix)
+			copy(childKey[len(prefix):], child.segment)
+			mmi := len(childKey)
+			if mmi > len(min) {
+				mmi = len(min)
+			}
+			mma := len(childKey)
+			if mma > len(max) {
+				mma = len(max)
+			}
+			mires := nComp(childKey[:mmi], min[:mmi])
+			mares := nComp(childKey[:mma], max[:mma])
+			if (min == nil || mires > -1) && (max == nil || mares < 1) {
+				if (min == nil || mires > 0) && (max == nil || mares < 0) {
+					result += child.keySize
+				} else {
+					result += child.keySizeBetween(prefix, min, max, mincmp, maxcmp)
+				}
+			}
+		}
+	}
+	return
+}
+
+// dataSizeBetween will return the part of dataSize used by the nodes with keys between min and max, like keySizeBetween.
+func (self *node) dataSizeBetween(prefix, min, max []Nibble, mincmp, maxcmp int) (result int) {
+	prefix = append(prefix, self.segment...)
+	if (min == nil || nComp(prefix, min) > mincmp) && (max == nil || nComp(prefix, max) < maxcmp) {
+		result = self.dataSize
+		for _, child := range self.children {
+			if child != nil {
+				result -= child.dataSize
+			}
+		}
+	}
+	for _, child := range self.children {
+		if child != nil {
+			childKey := make([]Nibble, len(prefix)+len(child.segment))
+			copy(childKey, prefix)
+			copy(childKey[len(prefix):], child.segment)
+			mmi := len(childKey)
+			if mmi > len(min) {
+				mmi = len(min)
+			}
+			mma := len(childKey)
+			if mma > len(max) {
+				mma = len(max)
+			}
+			mires := nComp(childKey[:mmi], min[:mmi])
+			mares := nComp(childKey[:mma], max[:mma])
+			if (min == nil || mires > -1) && (max == nil || mares < 1) {
+				if (min == nil || mires > 0) && (max == nil || mares < 0) {
+					result += child.dataSize
+				} else {
+					result += child.dataSizeBetween(prefix, min, max, mincmp, maxcmp)
+				}
+			}
+		}
+	}
+	return
+}
+
+// keyIndex will return the key at index n among the keys counted in keySize.
+func (self *node) keyIndex(prefix []Nibble, n int) (key []Nibble, existed bool) {
+	prefix = append(prefix, self.segment...)
+	if !self.empty && self.hasKey() {
+		if n == 0 {
+			return prefix, true
+		}
+		n--
+	}
+	for _, child := range self.children {
+		if child != nil {
+			if n < child.keySize {
+				return child.keyIndex(prefix, n)
+			}
+			n -= child.keySize
+		}
+	}
+	return
+}
+
 // eachBetweenIndex will iterate over the tree between index min and max, inclusive.
 // Missing min or max will mean 'from the start' or 'to the end' respectively.
 func (self *node) eachBetweenIndex(prefix []Nibble, count int, min, max *int, use int, f nodeIndexIterator) (cont bool) {
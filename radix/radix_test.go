@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/murmur"
+	"github.com/zond/god/persistence"
 	"math/big"
 	"math/rand"
+	"os"
 	"reflect"
+	"regexp"
 	"runtime"
 	"testing"
 	"time"
@@ -190,6 +193,177 @@ func TestTreeSizeBetween(t *testing.T) {
 	}
 }
 
+func TestTreeRealSizeBetween(t *testing.T) {
+	tree := NewTree()
+	tree.Put([]byte("a"), []byte("1"), 1)
+	for _, subKey := range []string{"x", "y", "z"} {
+		tree.SubPut([]byte("b"), []byte(subKey), []byte("1"), 1)
+	}
+	tree.SubFakeDel([]byte("b"), []byte("y"), 2)
+	tree.SubFakeDel([]byte("b"), []byte("z"), 2)
+	tree.Put([]byte("c"), []byte("1"), 1)
+	tree.FakeDel([]byte("c"), 2)
+	if s := tree.RealSize(); s != 5 {
+		t.Errorf("wanted real size 5 for %v, got %v", tree.Describe(), s)
+	}
+	for _, r := range []struct {
+		min, max string
+		size     int
+	}{{"", "", 5}, {"a", "c", 5}, {"a", "a", 1}, {"b", "b", 3}, {"c", "c", 1}} {
+		var min, max []byte
+		if r.min != "" {
+			min, max = []byte(r.min), []byte(r.max)
+		}
+		if s := tree.RealSizeBetween(min, max, true, true); s != r.size {
+			t.Errorf("wanted real size %v between %v and %v in %v, got %v", r.size, r.min, r.max, tree.Describe(), s)
+		}
+	}
+}
+
+func TestTreeKeySize(t *testing.T) {
+	tree := NewTree()
+	tree.Put([]byte("a"), []byte("1"), 1)
+	tree.Put([]byte("b"), []byte("1"), 1)
+	tree.SubPut([]byte("b"), []byte("x"), []byte("1"), 1)
+	tree.SubPut([]byte("c"), []byte("x"), []byte("1"), 1)
+	tree.SubPut([]byte("c"), []byte("y"), []byte("1"), 1)
+	tree.SubPut([]byte("d"), []byte("x"), []byte("1"), 1)
+	tree.SubFakeDel([]byte("d"), []byte("x"), 2)
+	tree.Put([]byte("e"), []byte("1"), 1)
+	tree.FakeDel([]byte("e"), 2)
+	if s := tree.KeySize(); s != 3 {
+		t.Errorf("wanted 3 keys in %v, got %v", tree.Describe(), s)
+	}
+	for _, r := range []struct {
+		min, max string
+		size     int
+	}{{"a", "e", 3}, {"b", "c", 2}, {"c", "e", 1}, {"d", "e", 0}} {
+		if s := tree.KeySizeBetween([]byte(r.min), []byte(r.max), true, true); s != r.size {
+			t.Errorf("wanted %v keys between %v and %v in %v, got %v", r.size, r.min, r.max, tree.Describe(), s)
+		}
+	}
+	if s := tree.KeySizeBetween([]byte("a"), []byte("c"), false, false); s != 1 {
+		t.Errorf("wanted 1 key between a and c exclusive in %v, got %v", tree.Describe(), s)
+	}
+	for index, wanted := range []string{"a", "b", "c"} {
+		if key, existed := tree.KeyIndex(index); !existed || string(key) != wanted {
+			t.Errorf("wanted %v at index %v in %v, got %s, %v", wanted, index, tree.Describe(), key, existed)
+		}
+	}
+	if key, existed := tree.KeyIndex(3); existed {
+		t.Errorf("wanted no key at index 3 in %v, got %s", tree.Describe(), key)
+	}
+}
+
+func TestTreeDataSizeBetween(t *testing.T) {
+	tree := NewTree()
+	tree.Put([]byte("a"), []byte("1"), 1)
+	tree.Put([]byte("b"), make([]byte, 1000), 1)
+	tree.SubPut([]byte("c"), []byte("x"), make([]byte, 1000), 1)
+	tree.Put([]byte("d"), []byte("1"), 1)
+	if s := tree.DataSizeBetween(nil, nil, true, true); s != tree.DataSize() {
+		t.Errorf("wanted all of %v bytes in %v, got %v", tree.DataSize(), tree.Describe(), s)
+	}
+	below, above := tree.DataSizeBetween(nil, []byte("c"), true, false), tree.DataSizeBetween([]byte("c"), nil, true, true)
+	if below+above != tree.DataSize() {
+		t.Errorf("wanted %v and %v to add up to %v in %v", below, above, tree.DataSize(), tree.Describe())
+	}
+	if s := tree.DataSizeBetween([]byte("b"), []byte("b"), true, true); s < 1000 || s > 1000+2*nodeOverhead {
+		t.Errorf("wanted about 1000 bytes at b in %v, got %v", tree.Describe(), s)
+	}
+	if s := tree.DataSizeBetween([]byte("d"), nil, true, true); s > 2*nodeOverhead {
+		t.Errorf("wanted only a few bytes from d in %v, got %v", tree.Describe(), s)
+	}
+}
+
+func TestTreeVersions(t *testing.T) {
+	tree := NewTree()
+	tree.Put([]byte("old"), []byte("1"), 1)
+	tree.KeepVersions(2)
+	at := func(n int64) int64 {
+		return 1<<40 + n
+	}
+	tree.Put([]byte("a"), []byte("1"), at(10))
+	tree.Put([]byte("b"), []byte("1"), at(10))
+	tree.Put([]byte("a"), []byte("2"), at(20))
+	tree.FakeDel([]byte("b"), at(20))
+	tree.Put([]byte("c"), []byte("1"), at(20))
+	tree.SubPut([]byte("d"), []byte("x"), []byte("1"), at(20))
+	for _, r := range []struct {
+		key     string
+		at      int64
+		value   string
+		existed bool
+	}{
+		{"a", at(5), "", false},
+		{"a", at(10), "1", true},
+		{"a", at(15), "1", true},
+		{"a", at(25), "2", true},
+		{"b", at(15), "1", true},
+		{"b", at(25), "", false},
+		{"c", at(15), "", false},
+		{"c", at(20), "1", true},
+		{"d", at(15), "", false},
+		{"old", at(15), "1", true},
+	} {
+		if value, _, existed, ok := tree.GetAt([]byte(r.key), r.at); !ok || existed != r.existed || string(value) != r.value {
+			t.Errorf("wanted %v, %v under %v at %v, got %s, %v, %v", r.value, r.existed, r.key, r.at, value, existed, ok)
+		}
+	}
+	var found []string
+	if ok := tree.EachBetweenAt(nil, nil, true, true, at(15), func(key, value []byte, timestamp int64) bool {
+		found = append(found, fmt.Sprintf("%s=%s", key, value))
+		return true
+	}); !ok || fmt.Sprint(found) != "[a=1 b=1 old=1]" {
+		t.Errorf("wanted [a=1 b=1 old=1], got %v, %v", found, ok)
+	}
+	found = nil
+	tree.EachBetweenAt([]byte("b"), nil, false, true, at(25), func(key, value []byte, timestamp int64) bool {
+		found = append(found, fmt.Sprintf("%s=%s", key, value))
+		return true
+	})
+	if fmt.Sprint(found) != "[c=1 old=1]" {
+		t.Errorf("wanted [c=1 old=1], got %v", found)
+	}
+	// Only the last 2 versions of a are kept.
+	tree.Put([]byte("a"), []byte("3"), at(30))
+	if _, _, _, ok := tree.GetAt([]byte("a"), at(5)); ok {
+		t.Errorf("wanted the version of a at 5 to be forgotten")
+	}
+	if value, _, _, ok := tree.GetAt([]byte("a"), at(15)); !ok || string(value) != "1" {
+		t.Errorf("wanted 1, got %s, %v", value, ok)
+	}
+	if value, _, _, ok := tree.GetAt([]byte("a"), at(25)); !ok || string(value) != "2" {
+		t.Errorf("wanted 2, got %s, %v", value, ok)
+	}
+	tree.PruneVersions(at(25))
+	if _, _, _, ok := tree.GetAt([]byte("b"), at(15)); ok {
+		t.Errorf("wanted reads before the pruning to fail")
+	}
+	if value, _, existed, ok := tree.GetAt([]byte("a"), at(25)); !ok || !existed || string(value) != "2" {
+		t.Errorf("wanted 2, got %s, %v, %v", value, existed, ok)
+	}
+}
+
+func TestTreeDataSize(t *testing.T) {
+	tree := NewTree()
+	empty := tree.DataSize()
+	tree.Put([]byte("a"), make([]byte, 1000), 1)
+	withValue := tree.DataSize()
+	if withValue < empty+1000 {
+		t.Errorf("wanted at least %v bytes after putting 1000 bytes, got %v", empty+1000, withValue)
+	}
+	tree.SubPut([]byte("b"), []byte("c"), make([]byte, 1000), 1)
+	withSubValue := tree.DataSize()
+	if withSubValue < withValue+1000 {
+		t.Errorf("wanted at least %v bytes after putting 1000 bytes in a sub tree, got %v", withValue+1000, withSubValue)
+	}
+	tree.Put([]byte("a"), nil, 2)
+	if s := tree.DataSize(); s > withSubValue-1000 {
+		t.Errorf("wanted at most %v bytes after replacing the 1000 bytes, got %v", withSubValue-1000, s)
+	}
+}
+
 func TestSubTree(t *testing.T) {
 	tree := NewTree()
 	assertSize(t, tree, 0)
@@ -204,6 +378,535 @@ func TestSubTree(t *testing.T) {
 	}
 }
 
+func countLogged(tree *Tree) (result int) {
+	tree.logger.Stop()
+	tree.logger.Play(func(op persistence.Op) {
+		result++
+	})
+	<-tree.logger.Record()
+	return
+}
+
+func TestSubPutChanged(t *testing.T) {
+	tree := NewTree().Log("subputchangedlogs")
+	defer os.RemoveAll("subputchangedlogs")
+	tree.logger.Clear()
+	key := []byte("h")
+	if changed := tree.SubPutChanged(key, [][]byte{[]byte("a"), []byte("b")}, [][]byte{[]byte("1"), []byte("2")}, 1); changed != 2 {
+		t.Errorf("wanted 2 changed, got %v", changed)
+	}
+	if logged := countLogged(tree); logged != 2 {
+		t.Errorf("wanted 2 logged ops, got %v", logged)
+	}
+	if changed := tree.SubPutChanged(key, [][]byte{[]byte("a"), []byte("b")}, [][]byte{[]byte("1"), []byte("2")}, 2); changed != 0 {
+		t.Errorf("wanted 0 changed, got %v", changed)
+	}
+	if logged := countLogged(tree); logged != 2 {
+		t.Errorf("wanted 2 logged ops, got %v", logged)
+	}
+	if changed := tree.SubPutChanged(key, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, [][]byte{[]byte("1"), []byte("3"), []byte("4")}, 3); changed != 2 {
+		t.Errorf("wanted 2 changed, got %v", changed)
+	}
+	if logged := countLogged(tree); logged != 4 {
+		t.Errorf("wanted 4 logged ops, got %v", logged)
+	}
+	if v, ver, e := tree.SubGet(key, []byte("a")); bytes.Compare(v, []byte("1")) != 0 || ver != 1 || !e {
+		t.Errorf("wrong result, wanted %v, %v, %v got %v, %v, %v", []byte("1"), 1, true, v, ver, e)
+	}
+	if v, ver, e := tree.SubGet(key, []byte("b")); bytes.Compare(v, []byte("3")) != 0 || ver != 3 || !e {
+		t.Errorf("wrong result, wanted %v, %v, %v got %v, %v, %v", []byte("3"), 3, true, v, ver, e)
+	}
+}
+
+func TestFakeDelOlderThan(t *testing.T) {
+	tree := NewTree().Log("fakedelolderthanlogs")
+	defer os.RemoveAll("fakedelolderthanlogs")
+	tree.logger.Clear()
+	tree.Put([]byte("stale"), []byte("1"), 1)
+	tree.Put([]byte("fresh"), []byte("2"), 5)
+	if _, deleted := tree.FakeDelOlderThan([]byte("fresh"), 5, 6); deleted {
+		t.Errorf("wanted fresh to survive")
+	}
+	if old, deleted := tree.FakeDelOlderThan([]byte("stale"), 5, 6); !deleted || string(old) != "1" {
+		t.Errorf("wanted stale to be deleted, got %s, %v", old, deleted)
+	}
+	if _, deleted := tree.FakeDelOlderThan([]byte("missing"), 5, 6); deleted {
+		t.Errorf("wanted nothing to delete under missing")
+	}
+	if logged := countLogged(tree); logged != 3 {
+		t.Errorf("wanted 3 logged ops, got %v", logged)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("fakedelolderthanlogs").Restore()
+	if _, _, e := restored.Get([]byte("stale")); e {
+		t.Errorf("wanted stale to be deleted in %v", restored.Describe())
+	}
+	if v, _, e := restored.Get([]byte("fresh")); !e || string(v) != "2" {
+		t.Errorf("wanted fresh => 2 in %v", restored.Describe())
+	}
+}
+
+func TestPutNewer(t *testing.T) {
+	tree := NewTree().Log("putnewerlogs")
+	defer os.RemoveAll("putnewerlogs")
+	tree.logger.Clear()
+	tree.Put([]byte("a"), []byte("1"), 5)
+	if tree.PutNewer([]byte("a"), []byte("stale"), 4) || tree.PutNewer([]byte("a"), []byte("same"), 5) {
+		t.Errorf("wanted a to keep the newer value")
+	}
+	if !tree.PutNewer([]byte("a"), []byte("2"), 6) || !tree.PutNewer([]byte("b"), []byte("3"), 1) {
+		t.Errorf("wanted the newer values to be put")
+	}
+	tree.FakeDel([]byte("b"), 7)
+	if tree.PutNewer([]byte("b"), []byte("stale"), 6) {
+		t.Errorf("wanted the tombstone of b to be newer")
+	}
+	if logged := countLogged(tree); logged != 4 {
+		t.Errorf("wanted 4 logged ops, got %v", logged)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("putnewerlogs").Restore()
+	if v, ts, e := restored.Get([]byte("a")); !e || string(v) != "2" || ts != 6 {
+		t.Errorf("wanted a => 2 at 6 in %v", restored.Describe())
+	}
+	if _, _, e := restored.Get([]byte("b")); e {
+		t.Errorf("wanted b to be deleted in %v", restored.Describe())
+	}
+}
+
+func TestWatchSubConfigurations(t *testing.T) {
+	tree := NewTree().Log("watchsubconfigurationslogs")
+	defer os.RemoveAll("watchsubconfigurationslogs")
+	tree.logger.Clear()
+	watched := make(map[string]string)
+	tree.WatchSubConfigurations(func(key []byte, conf map[string]string) {
+		watched[string(key)] = conf["c"]
+	})
+	tree.SubAddConfiguration([]byte("a"), 1, "c", "1")
+	tree.SubConfigure([]byte("b"), map[string]string{"c": "2"}, 2)
+	tree.AddConfiguration(3, "c", "3")
+	if expected := map[string]string{"a": "1", "b": "2"}; !reflect.DeepEqual(watched, expected) {
+		t.Errorf("wanted %v, got %v", expected, watched)
+	}
+	tree.logger.Stop()
+	replayed := make(map[string]string)
+	restored := NewTree().Log("watchsubconfigurationslogs")
+	restored.WatchSubConfigurations(func(key []byte, conf map[string]string) {
+		replayed[string(key)] = conf["c"]
+	})
+	restored.Restore()
+	if !reflect.DeepEqual(replayed, watched) {
+		t.Errorf("wanted the replayed configurations %v, got %v", watched, replayed)
+	}
+}
+
+func TestWatchValues(t *testing.T) {
+	watch := func(tree *Tree) map[string]string {
+		watched := make(map[string]string)
+		tree.WatchValues(func(key, oldValue []byte, oldExisted bool, newValue []byte, newExists bool) {
+			if old, found := watched[string(key)]; found != oldExisted || old != string(oldValue) {
+				t.Errorf("wanted %q to have been %q (%v), got %q (%v)", key, old, found, oldValue, oldExisted)
+			}
+			if newExists {
+				watched[string(key)] = string(newValue)
+			} else {
+				delete(watched, string(key))
+			}
+		})
+		return watched
+	}
+	contents := func(tree *Tree) map[string]string {
+		result := make(map[string]string)
+		tree.Each(func(key, value []byte, timestamp int64) bool {
+			result[string(key)] = string(value)
+			return true
+		})
+		return result
+	}
+	tree1 := NewTree()
+	watched1 := watch(tree1)
+	tree1.Put([]byte("a"), []byte("1"), 1)
+	tree1.PutAll([]common.Item{{Key: []byte("a"), Value: []byte("2")}, {Key: []byte("b"), Value: []byte("1")}}, 2)
+	tree1.Modify([]byte("c"), 3, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		return []byte("1"), true, nil
+	})
+	tree1.AddInt64([]byte("d"), 1, 4)
+	tree1.FakeDel([]byte("b"), 5)
+	tree1.FakeDelIfEqual([]byte("c"), []byte("1"), 6)
+	tree1.Apply([]common.Item{{Key: []byte("e"), Value: []byte("1"), Exists: true}, {Key: []byte("a")}}, 7)
+	if expected := contents(tree1); !reflect.DeepEqual(watched1, expected) {
+		t.Errorf("wanted %v, got %v", expected, watched1)
+	}
+	tree2 := NewTree()
+	tree2.Put([]byte("a"), []byte("3"), 1)
+	watched2 := watch(tree2)
+	watched2["a"] = "3"
+	NewSync(tree1, tree2).Run()
+	if expected := contents(tree2); !reflect.DeepEqual(watched2, expected) {
+		t.Errorf("wanted the synced values %v, got %v", expected, watched2)
+	}
+}
+
+func TestModifyLive(t *testing.T) {
+	tree := NewTree()
+	tree.ExpireWith(func(key []byte, timestamp int64, conf map[string]string) bool {
+		return conf["dead"] == "yes"
+	})
+	tree.Put([]byte("a"), []byte("old"), 1)
+	tree.SubAddConfiguration([]byte("a"), 2, "dead", "yes")
+	seen := func(modify func([]byte, int64, Modifier) ([]byte, bool, error)) (value string, existed bool) {
+		modify([]byte("a"), 3, func(oldValue []byte, ex bool) ([]byte, bool, error) {
+			value, existed = string(oldValue), ex
+			return nil, false, nil
+		})
+		return
+	}
+	if value, existed := seen(tree.Modify); !existed || value != "old" {
+		t.Errorf("wanted Modify to see old, got %q, %v", value, existed)
+	}
+	if value, existed := seen(tree.ModifyLive); existed || value != "" {
+		t.Errorf("wanted ModifyLive to see nothing, got %q, %v", value, existed)
+	}
+	if _, put, _ := tree.ModifyLiveIf([]byte("a"), []byte("b"), 3, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		return []byte("1"), true, nil
+	}); put {
+		t.Errorf("wanted ModifyLiveIf to treat a as missing")
+	}
+	if tree.FakeDelLiveIfEqual([]byte("a"), []byte("old"), 4) {
+		t.Errorf("wanted FakeDelLiveIfEqual to leave a alone")
+	}
+	tree.Put([]byte("c"), []byte("not a counter"), 5)
+	tree.SubAddConfiguration([]byte("c"), 6, "dead", "yes")
+	if result, err := tree.AddInt64Live([]byte("c"), 2, 7); err != nil || result != 2 {
+		t.Errorf("wanted AddInt64Live to count from 0, got %v, %v", result, err)
+	}
+}
+
+func TestFakeDelIfEqual(t *testing.T) {
+	tree := NewTree().Log("fakedelifequallogs")
+	defer os.RemoveAll("fakedelifequallogs")
+	tree.logger.Clear()
+	tree.Put([]byte("lock"), []byte("mine"), 1)
+	if tree.FakeDelIfEqual([]byte("lock"), []byte("theirs"), 2) {
+		t.Errorf("wanted lock to survive a different expected value")
+	}
+	if tree.FakeDelIfEqual([]byte("missing"), nil, 2) {
+		t.Errorf("wanted nothing to delete under missing")
+	}
+	if !tree.FakeDelIfEqual([]byte("lock"), []byte("mine"), 3) {
+		t.Errorf("wanted lock to be deleted")
+	}
+	if logged := countLogged(tree); logged != 2 {
+		t.Errorf("wanted 2 logged ops, got %v", logged)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("fakedelifequallogs").Restore()
+	if _, _, e := restored.Get([]byte("lock")); e {
+		t.Errorf("wanted lock to be deleted in %v", restored.Describe())
+	}
+}
+
+func TestFakeDelAll(t *testing.T) {
+	tree := NewTree().Log("fakedelalllogs")
+	defer os.RemoveAll("fakedelalllogs")
+	tree.logger.Clear()
+	tree.Put([]byte("a"), []byte("1"), 1)
+	tree.Put([]byte("b"), []byte("2"), 1)
+	tree.Put([]byte("c"), []byte("3"), 1)
+	deleted := tree.FakeDelAll([][]byte{[]byte("a"), []byte("b"), []byte("missing")}, 2)
+	if len(deleted) != 2 || bytes.Compare(deleted[0].Key, []byte("a")) != 0 || bytes.Compare(deleted[1].Value, []byte("2")) != 0 {
+		t.Errorf("wanted a and b to be deleted, got %+v", deleted)
+	}
+	if again := tree.FakeDelAll([][]byte{[]byte("a"), []byte("b")}, 3); len(again) != 0 {
+		t.Errorf("wanted nothing to be deleted twice, got %+v", again)
+	}
+	if logged := countLogged(tree); logged != 5 {
+		t.Errorf("wanted 5 logged ops, got %v", logged)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("fakedelalllogs").Restore()
+	if _, _, e := restored.Get([]byte("a")); e {
+		t.Errorf("wanted a to be deleted in %v", restored.Describe())
+	}
+	if v, _, e := restored.Get([]byte("c")); !e || string(v) != "3" {
+		t.Errorf("wanted c => 3 in %v", restored.Describe())
+	}
+}
+
+func TestPutAll(t *testing.T) {
+	tree := NewTree().Log("putalllogs")
+	defer os.RemoveAll("putalllogs")
+	tree.logger.Clear()
+	tree.Put([]byte("a"), []byte("old"), 1)
+	old := tree.PutAll([]common.Item{common.Item{Key: []byte("a"), Value: []byte("1")}, common.Item{Key: []byte("b"), Value: []byte("2")}}, 2)
+	if len(old) != 2 || !old[0].Exists || string(old[0].Value) != "old" || old[1].Exists {
+		t.Errorf("wanted a to replace old and b to be new, got %+v", old)
+	}
+	if logged := countLogged(tree); logged != 3 {
+		t.Errorf("wanted 3 logged ops, got %v", logged)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("putalllogs").Restore()
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}} {
+		if v, _, e := restored.Get([]byte(kv[0])); !e || string(v) != kv[1] {
+			t.Errorf("wanted %v => %v in %v", kv[0], kv[1], restored.Describe())
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	tree := NewTree().Log("closelogs")
+	defer os.RemoveAll("closelogs")
+	tree.logger.Clear()
+	for i := 0; i < 100; i++ {
+		tree.Put([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)), 1)
+	}
+	tree.Close()
+	if tree.logger.Recording() {
+		t.Errorf("wanted the logger to be stopped")
+	}
+	tree.Put([]byte("after"), []byte("close"), 1)
+	restored := NewTree().Log("closelogs").Restore()
+	if size := restored.Size(); size != 100 {
+		t.Errorf("wanted the 100 puts before closing to be restored, got %v in %v", size, restored.Describe())
+	}
+}
+
+func TestApply(t *testing.T) {
+	tree := NewTree().Log("applylogs")
+	defer os.RemoveAll("applylogs")
+	tree.logger.Clear()
+	tree.Put([]byte("a"), []byte("old"), 1)
+	tree.Put([]byte("b"), []byte("2"), 1)
+	tree.SubPut([]byte("c"), []byte("d"), []byte("3"), 1)
+	old := tree.Apply([]common.Item{
+		common.Item{Key: []byte("a"), Value: []byte("1"), Exists: true},
+		common.Item{Key: []byte("b")},
+		common.Item{Key: []byte("c"), SubKey: []byte("d")},
+		common.Item{Key: []byte("c"), SubKey: []byte("e"), Value: []byte("4"), Exists: true},
+		common.Item{Key: []byte("missing")},
+	}, 2)
+	if len(old) != 5 || string(old[0].Value) != "old" || !old[1].Exists || !old[2].Exists || old[3].Exists || old[4].Exists {
+		t.Errorf("wanted a, b and c/d to have existed, got %+v", old)
+	}
+	if logged := countLogged(tree); logged != 7 {
+		t.Errorf("wanted 7 logged ops, got %v", logged)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("applylogs").Restore()
+	if v, _, e := restored.Get([]byte("a")); !e || string(v) != "1" {
+		t.Errorf("wanted a => 1 in %v", restored.Describe())
+	}
+	if _, _, e := restored.Get([]byte("b")); e {
+		t.Errorf("wanted b to be deleted in %v", restored.Describe())
+	}
+	if _, _, e := restored.SubGet([]byte("c"), []byte("d")); e {
+		t.Errorf("wanted c/d to be deleted in %v", restored.Describe())
+	}
+	if v, _, e := restored.SubGet([]byte("c"), []byte("e")); !e || string(v) != "4" {
+		t.Errorf("wanted c/e => 4 in %v", restored.Describe())
+	}
+}
+
+func TestBackupRestoreFrom(t *testing.T) {
+	os.RemoveAll("backupcopy")
+	tree := NewTree().Log("backuplogs")
+	defer os.RemoveAll("backuplogs")
+	defer os.RemoveAll("backupcopy")
+	defer os.RemoveAll("restoredlogs")
+	tree.logger.Clear()
+	tree.Put([]byte("a"), []byte("1"), 1)
+	tree.SubPut([]byte("b"), []byte("c"), []byte("2"), 1)
+	if err := tree.Backup("backupcopy"); err != nil {
+		t.Fatal(err)
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("restoredlogs")
+	restored.logger.Clear()
+	restored.Put([]byte("d"), []byte("3"), 1)
+	if err := restored.RestoreFrom("backupcopy", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, e := restored.Get([]byte("a")); !e || string(v) != "1" {
+		t.Errorf("wanted a => 1 in %v", restored.Describe())
+	}
+	if v, _, e := restored.SubGet([]byte("b"), []byte("c")); !e || string(v) != "2" {
+		t.Errorf("wanted b/c => 2 in %v", restored.Describe())
+	}
+	if _, _, e := restored.Get([]byte("d")); e {
+		t.Errorf("wanted no d in %v", restored.Describe())
+	}
+	restored.logger.Stop()
+	reopened := NewTree().Log("restoredlogs").Restore()
+	if v, _, e := reopened.Get([]byte("a")); !e || string(v) != "1" {
+		t.Errorf("wanted the restored a => 1 to be logged, got %v", reopened.Describe())
+	}
+}
+
+func TestSubReplace(t *testing.T) {
+	tree := NewTree().Log("subreplacelogs")
+	defer os.RemoveAll("subreplacelogs")
+	tree.logger.Clear()
+	key := []byte("h")
+	tree.SubPut(key, []byte("a"), []byte("1"), 1)
+	tree.SubPut(key, []byte("b"), []byte("2"), 1)
+	if size := tree.SubReplace(key, [][]byte{[]byte("b"), []byte("c")}, [][]byte{[]byte("3"), []byte("4")}, 2); size != 2 {
+		t.Errorf("wanted size 2, got %v", size)
+	}
+	if _, _, e := tree.SubGet(key, []byte("a")); e {
+		t.Errorf("wanted a to be replaced in %v", tree.Describe())
+	}
+	if v, ver, e := tree.SubGet(key, []byte("b")); bytes.Compare(v, []byte("3")) != 0 || ver != 2 || !e {
+		t.Errorf("wrong result, wanted %v, %v, %v got %v, %v, %v", []byte("3"), 2, true, v, ver, e)
+	}
+	tree.logger.Stop()
+	var ops []persistence.Op
+	tree.logger.Play(func(op persistence.Op) {
+		ops = append(ops, op)
+	})
+	if len(ops) != 5 || !ops[2].Clear || !ops[4].Put {
+		t.Errorf("wanted the replacement to replay as a clear and two puts, got %+v", ops)
+	}
+	restored := NewTree().Log("subreplacelogs").Restore()
+	if !restored.deepEqual(tree) {
+		t.Errorf("%v should equal %v", restored.Describe(), tree.Describe())
+	}
+	if size := tree.SubReplace([]byte("missing"), nil, nil, 3); size != 0 {
+		t.Errorf("wanted size 0, got %v", size)
+	}
+}
+
+func TestAddInt64Concurrent(t *testing.T) {
+	tree := NewTree()
+	key := []byte("ids")
+	results := make(chan int64)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				id, err := tree.AddInt64(key, 1, 1)
+				if err != nil {
+					t.Errorf("%v", err)
+				}
+				results <- id
+			}
+		}()
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < 1000; i++ {
+		id := <-results
+		if seen[id] {
+			t.Errorf("%v was issued twice", id)
+		}
+		seen[id] = true
+	}
+	if v, _, _ := tree.Get(key); common.MustDecodeInt64(v) != 1000 {
+		t.Errorf("wanted 1000, got %v", common.MustDecodeInt64(v))
+	}
+	tree.Put([]byte("notint"), []byte("x"), 1)
+	if _, err := tree.AddInt64([]byte("notint"), 1, 2); err == nil {
+		t.Errorf("wanted an error when adding to a non int value")
+	}
+}
+
+func TestModifyIf(t *testing.T) {
+	tree := NewTree()
+	appender := func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		return append(oldValue, 'x'), true, nil
+	}
+	if _, put, _ := tree.ModifyIf([]byte("cond"), []byte("k"), 1, appender); put {
+		t.Errorf("wanted no modification without the condition key")
+	}
+	tree.SubPut([]byte("cond"), []byte("s"), []byte("v"), 1)
+	if _, put, _ := tree.ModifyIf([]byte("cond"), []byte("k"), 1, appender); put {
+		t.Errorf("wanted no modification with only a sub tree under the condition key")
+	}
+	tree.Put([]byte("cond"), []byte("v"), 1)
+	if v, put, _ := tree.ModifyIf([]byte("cond"), []byte("k"), 2, appender); !put || string(v) != "x" {
+		t.Errorf("wanted x, true, got %s, %v", v, put)
+	}
+}
+func TestModifyReplay(t *testing.T) {
+	os.RemoveAll("modifylogs")
+	defer os.RemoveAll("modifylogs")
+	tree := NewTree().Log("modifylogs")
+	key := []byte("k")
+	tree.Put(key, bytes.Repeat([]byte("x"), 100), 1)
+	pattern := regexp.MustCompile("^(y*)x")
+	done := make(chan bool)
+	for i := 0; i < 100; i++ {
+		go func() {
+			tree.Modify(key, 2, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+				return pattern.ReplaceAll(oldValue, []byte("${1}y")), existed, nil
+			})
+			done <- true
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		<-done
+	}
+	expected := bytes.Repeat([]byte("y"), 100)
+	if v, _, _ := tree.Get(key); bytes.Compare(v, expected) != 0 {
+		t.Errorf("wanted %s, got %s", expected, v)
+	}
+	if _, put, _ := tree.Modify([]byte("missing"), 3, func(oldValue []byte, existed bool) ([]byte, bool, error) {
+		return oldValue, existed, nil
+	}); put {
+		t.Errorf("wanted missing value to be left alone")
+	}
+	tree.logger.Stop()
+	restored := NewTree().Log("modifylogs").Restore()
+	if v, _, _ := restored.Get(key); bytes.Compare(v, expected) != 0 {
+		t.Errorf("wanted %s, got %s", expected, v)
+	}
+	if restored.Size() != 1 {
+		t.Errorf("wanted 1 value in %v", restored.Describe())
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	os.RemoveAll("importlogs")
+	defer os.RemoveAll("importlogs")
+	source := NewTree()
+	for i := 0; i < 10; i++ {
+		source.Put([]byte{byte(i)}, []byte{byte(i)}, 1)
+		source.SubPut([]byte{byte(i)}, []byte("a"), []byte("b"), 1)
+	}
+	var items []common.Item
+	min := []byte{2}
+	for {
+		page := source.ExportBetween(min, []byte{8}, len(items) == 0, false, 3)
+		if len(page) == 0 {
+			break
+		}
+		items = append(items, page...)
+		min = page[len(page)-1].Key
+	}
+	if len(items) != 12 {
+		t.Errorf("wanted 12 items, got %v", items)
+	}
+	tree := NewTree().Log("importlogs")
+	tree.logger.Clear()
+	tree.Put([]byte{3}, []byte("newer"), 2)
+	if imported := tree.Import(items); imported != 11 {
+		t.Errorf("wanted 11 imported, got %v", imported)
+	}
+	if logged := countLogged(tree); logged != 1 {
+		t.Errorf("wanted 1 logged op, got %v", logged)
+	}
+	tree.Snapshot()
+	tree.logger.Stop()
+	restored := NewTree().Log("importlogs").Restore()
+	if !restored.deepEqual(tree) {
+		t.Errorf("%v should equal %v", restored.Describe(), tree.Describe())
+	}
+	if v, _, _ := restored.Get([]byte{3}); string(v) != "newer" {
+		t.Errorf("wanted newer, got %s", v)
+	}
+	if v, _, _ := restored.SubGet([]byte{7}, []byte("a")); string(v) != "b" {
+		t.Errorf("wanted b, got %s", v)
+	}
+}
+
 func TestSyncSubTreeVersions(t *testing.T) {
 	tree1 := NewTree()
 	tree3 := NewTree()
@@ -834,7 +1537,7 @@ func TestTreeNilKey(t *testing.T) {
 	if value, _, existed := tree.Get(nil); value != nil || existed {
 		t.Errorf("should not exist")
 	}
-	if value, existed := tree.Put(nil, nil, 1); value != nil || existed {
+	if value, existed, _ := tree.Put(nil, nil, 1); value != nil || existed {
 		t.Errorf("should not exist")
 	}
 	if value, _, existed := tree.Get(nil); value != nil || !existed {
@@ -864,6 +1567,25 @@ func TestTreeFirstLast(t *testing.T) {
 	}
 }
 
+func TestTreeFirstLastBetween(t *testing.T) {
+	tree := NewTree()
+	tree.SubPut([]byte{1}, []byte("a"), []byte("b"), 1)
+	tree.Put([]byte{2}, []byte{2}, 1)
+	tree.SubPut([]byte{3}, []byte("a"), []byte("b"), 1)
+	if key, value, _, existed := tree.FirstBetween(nil, []byte{3}, true, false); !existed || bytes.Compare(key, []byte{1}) != 0 || value != nil {
+		t.Errorf("%v.FirstBetween(nil, %v) should be %v, nil but was %v, %v, %v", tree.Describe(), []byte{3}, []byte{1}, key, value, existed)
+	}
+	if key, value, _, existed := tree.LastBetween(nil, []byte{3}, true, false); !existed || bytes.Compare(key, []byte{2}) != 0 || bytes.Compare(value, []byte{2}) != 0 {
+		t.Errorf("%v.LastBetween(nil, %v) should be %v, %v but was %v, %v, %v", tree.Describe(), []byte{3}, []byte{2}, []byte{2}, key, value, existed)
+	}
+	if key, _, _, existed := tree.LastBetween([]byte{2}, nil, false, false); !existed || bytes.Compare(key, []byte{3}) != 0 {
+		t.Errorf("%v.LastBetween(%v, nil) should be %v but was %v, %v", tree.Describe(), []byte{2}, []byte{3}, key, existed)
+	}
+	if _, _, _, existed := tree.FirstBetween([]byte{4}, nil, true, false); existed {
+		t.Errorf("%v.FirstBetween(%v, nil) should not exist", tree.Describe(), []byte{4})
+	}
+}
+
 func TestTreeIndex(t *testing.T) {
 	tree := NewTree()
 	for i := 100; i < 200; i++ {
@@ -952,10 +1674,10 @@ func TestTreeBasicOps(t *testing.T) {
 	if !reflect.DeepEqual(tree.ToMap(), comp) {
 		t.Errorf("%v and %v should be equal!", tree.ToMap(), comp)
 	}
-	if old, existed := tree.Put(nil, []byte("nil"), 1); old != nil || existed {
+	if old, existed, _ := tree.Put(nil, []byte("nil"), 1); old != nil || existed {
 		t.Error("should not exist yet")
 	}
-	if old, existed := tree.Put([]byte("nil"), nil, 1); old != nil || existed {
+	if old, existed, _ := tree.Put([]byte("nil"), nil, 1); old != nil || existed {
 		t.Error("should not exist yet")
 	}
 	if value, _, existed := tree.Get(nil); !existed || bytes.Compare(value, []byte("nil")) != 0 {
@@ -9,6 +9,7 @@ import (
 	"github.com/zond/god/persistence"
 	"math/big"
 	"sync/atomic"
+	"time"
 )
 
 // NaiveTimer is a Timer that just provides the current system time.
@@ -28,6 +29,10 @@ type TreeIterator func(key, value []byte, timestamp int64) (cont bool)
 // If they return false, the iteration will end.
 type TreeIndexIterator func(key, value []byte, timestamp int64, index int) (cont bool)
 
+// Modifiers get the old value of a key, and return the new value to put there.
+// If they return put false or an error, nothing will be put.
+type Modifier func(oldValue []byte, existed bool) (newValue []byte, put bool, err error)
+
 func cmps(mininc, maxinc bool) (mincmp, maxcmp int) {
 	if mininc {
 		mincmp = -1
@@ -84,6 +89,16 @@ func newNodeIterator(f TreeIterator) nodeIterator {
 	}
 }
 
+func newEdgeIterator(key, bValue *[]byte, timestamp *int64, existed *bool) nodeIterator {
+	return func(k, b []byte, t *Tree, use int, ts int64) (cont bool) {
+		*key, *timestamp, *existed = k, ts, true
+		if use&byteValue != 0 {
+			*bValue = b
+		}
+		return false
+	}
+}
+
 func newNodeIndexIterator(f TreeIndexIterator) nodeIndexIterator {
 	return func(key, bValue []byte, tValue *Tree, use int, timestamp int64, index int) (cont bool) {
 		return f(key, bValue, timestamp, index)
@@ -106,6 +121,12 @@ type Tree struct {
 	configuration          map[string]string
 	configurationTimestamp int64
 	dataTimestamp          int64
+	keptVersions           int
+	keptSince              int64
+	versions               map[string]*history
+	confWatcher            SubConfigurationWatcher
+	valueWatcher           ValueWatcher
+	expirer                Expirer
 }
 
 func NewTree() *Tree {
@@ -174,6 +195,11 @@ func (self *Tree) mirrorDel(key, value []byte) {
 		self.mirror.Del(newKey)
 	}
 }
+func (self *Tree) changed(key, oldValue []byte, oldExisted bool, newValue []byte, newExists bool) {
+	if self.valueWatcher != nil {
+		self.valueWatcher(key, oldValue, oldExisted, newValue, newExists)
+	}
+}
 func (self *Tree) startMirroring() {
 	self.mirror = NewTreeTimer(self.timer)
 	self.root.each(nil, byteValue, func(key, byteValue []byte, treeValue *Tree, use int, timestamp int64) bool {
@@ -219,51 +245,220 @@ func (self *Tree) AddConfiguration(ts int64, key, value string) bool {
 
 // Log will make this Tree start logging using a new persistence.Logger.
 func (self *Tree) Log(dir string) *Tree {
-	self.logger = persistence.NewLogger(dir)
+	return self.LogTo(persistence.NewLogger(dir))
+}
+
+// LogTo will make this Tree start logging using logger, which must not already be recording.
+func (self *Tree) LogTo(logger *persistence.Logger) *Tree {
+	self.logger = logger
 	<-self.logger.Record()
 	return self
 }
 
+// Close will stop the persistence.Logger of this Tree, if it is recording, once everything queued for it is written, and synced if it syncs its logfiles.
+// Changes made after it are only kept in memory.
+func (self *Tree) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.logger != nil && self.logger.Recording() {
+		self.logger.Stop()
+	}
+}
+
 // Restore will temporarily stop the Logger of this Tree, make it replay all operations
 // to allow us to restore the state logged in that directory, and then start recording again.
+// If the Logger was limited using persistence.Logger#PlayWith, the replayed state is snapshotted to drop the operations that weren't replayed.
 func (self *Tree) Restore() *Tree {
 	self.logger.Stop()
-	self.logger.Play(func(op persistence.Op) {
-		if op.Configuration != nil {
-			if op.Key == nil {
-				self.Configure(op.Configuration, op.Timestamp)
+	self.logger.Play(self.replay)
+	<-self.logger.Record()
+	if self.logger.Limited() {
+		self.Snapshot()
+	}
+	return self
+}
+
+// RestoreFrom will clear this Tree and replay the operations logged in src, as copied there by Backup, up to upTo.
+// The replayed operations are logged like any others, so the restored state is durable when it returns.
+func (self *Tree) RestoreFrom(src string, upTo time.Time) (err error) {
+	logger := persistence.NewLogger(src)
+	if self.logger != nil {
+		logger = self.logger.In(src)
+	}
+	self.Clear(self.timer.ContinuousTime())
+	return logger.PlayUntil(upTo, self.replay)
+}
+
+// Backup will copy the logfiles of this Tree, up to the time of the call, to dst.
+func (self *Tree) Backup(dst string) (err error) {
+	if self.logger == nil || !self.logger.Recording() {
+		return fmt.Errorf("unable to back up a tree that isn't logging")
+	}
+	return self.logger.Backup(dst)
+}
+
+func (self *Tree) replay(op persistence.Op) {
+	if op.Configuration != nil {
+		if op.Key == nil {
+			self.Configure(op.Configuration, op.Timestamp)
+		} else {
+			self.SubConfigure(op.Key, op.Configuration, op.Timestamp)
+		}
+	} else if op.Put {
+		if op.SubKey == nil {
+			self.Put(op.Key, op.Value, op.Timestamp)
+		} else {
+			self.SubPut(op.Key, op.SubKey, op.Value, op.Timestamp)
+		}
+	} else {
+		if op.SubKey == nil {
+			if op.Clear {
+				if op.Timestamp > 0 {
+					self.SubClear(op.Key, op.Timestamp)
+				} else {
+					self.SubKill(op.Key)
+				}
 			} else {
-				self.SubConfigure(op.Key, op.Configuration, op.Timestamp)
+				self.Del(op.Key)
 			}
-		} else if op.Put {
-			if op.SubKey == nil {
-				self.Put(op.Key, op.Value, op.Timestamp)
-			} else {
-				self.SubPut(op.Key, op.SubKey, op.Value, op.Timestamp)
+		} else {
+			self.SubDel(op.Key, op.SubKey)
+		}
+	}
+}
+
+// LogStats returns the Stats of the persistence.Logger of this Tree, if any.
+func (self *Tree) LogStats() (result persistence.Stats) {
+	if self.logger != nil {
+		result = self.logger.Stats()
+	}
+	return
+}
+
+// ExportBetween will return the byte values and sub tree values with keys between min and max as Items, where sub tree values have their sub key in SubKey.
+// If limit is above zero it will stop after the first key that brings the number of Items to at least limit, so that no key is split between calls.
+func (self *Tree) ExportBetween(min, max []byte, mininc, maxinc bool, limit int) (items []common.Item) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue|treeValue, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		if use&byteValue != 0 {
+			items = append(items, common.Item{
+				Key:       key,
+				Value:     bValue,
+				Timestamp: timestamp,
+				Exists:    true,
+			})
+		}
+		if use&treeValue != 0 && tValue != nil {
+			tValue.Each(func(subKey, subValue []byte, subTimestamp int64) bool {
+				items = append(items, common.Item{
+					Key:       key,
+					SubKey:    subKey,
+					Value:     subValue,
+					Timestamp: subTimestamp,
+					Exists:    true,
+				})
+				return true
+			})
+		}
+		return limit < 1 || len(items) < limit
+	})
+	return
+}
+
+// Import will put items, as returned by ExportBetween, in this Tree without logging them. Items that aren't newer than what this Tree already contains are skipped.
+// Since nothing is logged, Snapshot should be called when done importing to make the new state durable.
+func (self *Tree) Import(items []common.Item) (imported int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, item := range items {
+		ripped := Rip(item.Key)
+		_, subTree, timestamp, ex := self.root.get(ripped)
+		if item.SubKey == nil {
+			if ex&byteValue != 0 && timestamp >= item.Timestamp {
+				continue
+			}
+			oldBytes, _, existed := self.put(ripped, item.Value, nil, byteValue, item.Timestamp)
+			if existed&byteValue != 0 {
+				self.mirrorDel(item.Key, oldBytes)
 			}
+			self.mirrorPut(item.Key, item.Value, item.Timestamp)
+			self.changed(item.Key, oldBytes, existed&byteValue != 0, item.Value, true)
 		} else {
-			if op.SubKey == nil {
-				if op.Clear {
-					if op.Timestamp > 0 {
-						self.SubClear(op.Key, op.Timestamp)
-					} else {
-						self.SubKill(op.Key)
-					}
-				} else {
-					self.Del(op.Key)
-				}
+			if ex&treeValue == 0 || subTree == nil {
+				subTree = self.newTreeWith(Rip(item.SubKey), item.Value, item.Timestamp)
+			} else if _, current, present := subTree.GetTimestamp(Rip(item.SubKey)); present && current >= item.Timestamp {
+				continue
 			} else {
-				self.SubDel(op.Key, op.SubKey)
+				subTree.Put(item.SubKey, item.Value, item.Timestamp)
+			}
+			self.put(ripped, nil, subTree, treeValue, timestamp)
+		}
+		imported++
+	}
+	return
+}
+
+// Snapshot will replace the log of this Tree with a snapshot of its current state, written while reads of the Tree go on.
+// If the snapshot could not be written the old log is kept, and ok will be false.
+func (self *Tree) Snapshot() (ok bool) {
+	if self.logger == nil || !self.logger.Recording() {
+		return true
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.logger.Snapshot(self.dumpState)
+}
+func (self *Tree) dumpState(emit func(persistence.Op)) {
+	if self.configurationTimestamp != 0 {
+		conf, ts := self.conf()
+		emit(persistence.Op{
+			Configuration: conf,
+			Timestamp:     ts,
+		})
+	}
+	self.root.each(nil, byteValue|treeValue, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		if use&byteValue != 0 {
+			emit(persistence.Op{
+				Key:       key,
+				Value:     bValue,
+				Timestamp: timestamp,
+				Put:       true,
+			})
+		}
+		if use&treeValue != 0 && tValue != nil {
+			if conf, ts := tValue.Configuration(); ts != 0 {
+				emit(persistence.Op{
+					Key:           key,
+					Configuration: conf,
+					Timestamp:     ts,
+				})
 			}
+			tValue.Each(func(subKey, subValue []byte, subTimestamp int64) bool {
+				emit(persistence.Op{
+					Key:       key,
+					SubKey:    subKey,
+					Value:     subValue,
+					Timestamp: subTimestamp,
+					Put:       true,
+				})
+				return true
+			})
 		}
+		return true
 	})
-	<-self.logger.Record()
-	return self
 }
-func (self *Tree) log(op persistence.Op) {
+func (self *Tree) log(op persistence.Op) (durable bool) {
+	return <-self.queue(op)
+}
+func (self *Tree) queue(op persistence.Op) (durable <-chan bool) {
 	if self.logger != nil && self.logger.Recording() {
-		self.logger.Dump(op)
+		return self.logger.Queue(op)
 	}
+	logged := make(chan bool, 1)
+	logged <- true
+	return logged
 }
 func (self *Tree) newTreeWith(key []Nibble, byteValue []byte, timestamp int64) (result *Tree) {
 	result = NewTreeTimer(self.timer)
@@ -495,6 +690,29 @@ func (self *Tree) RealSizeBetween(min, max []byte, mininc, maxinc bool) int {
 	return self.sizeBetween(min, max, mininc, maxinc, 0)
 }
 
+// KeySizeBetween returns the number of keys between min and max with a byte value or a non empty sub tree, without iterating over them.
+func (self *Tree) KeySizeBetween(min, max []byte, mininc, maxinc bool) int {
+	if self == nil {
+		return 0
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	return self.root.keySizeBetween(nil, Rip(min), Rip(max), mincmp, maxcmp)
+}
+
+// DataSizeBetween returns the approximate number of bytes used by the keys between min and max and their values, including tombstones and sub trees,
+// like DataSize, without iterating over them.
+func (self *Tree) DataSizeBetween(min, max []byte, mininc, maxinc bool) int {
+	if self == nil {
+		return 0
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	return self.root.dataSizeBetween(nil, Rip(min), Rip(max), mincmp, maxcmp)
+}
+
 // MirrorSizeBetween returns the virtual, as in 'not including tombstones and sub trees', size of the mirror Tree between min and max.
 func (self *Tree) MirrorSizeBetween(min, max []byte, mininc, maxinc bool) (i int) {
 	if self == nil || self.mirror == nil {
@@ -516,6 +734,16 @@ func (self *Tree) SizeBetween(min, max []byte, mininc, maxinc bool) int {
 	return self.sizeBetween(min, max, mininc, maxinc, byteValue|treeValue)
 }
 
+// DataSize returns the approximate number of bytes used by the keys and values, including tombstones and sub trees, of this Tree.
+func (self *Tree) DataSize() int {
+	if self == nil {
+		return 0
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.root.dataSize
+}
+
 // RealSize returns the real, as in 'including tombstones and sub trees', size of this Tree.
 func (self *Tree) RealSize() int {
 	if self == nil {
@@ -526,6 +754,30 @@ func (self *Tree) RealSize() int {
 	return self.root.realSize
 }
 
+// KeySize returns the number of keys with a byte value or a non empty sub tree in this Tree, without iterating over them.
+func (self *Tree) KeySize() int {
+	if self == nil {
+		return 0
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.root.keySize
+}
+
+// KeyIndex returns the key at index n, in key order, among the keys with a byte value or a non empty sub tree, without iterating over them.
+func (self *Tree) KeyIndex(n int) (key []byte, existed bool) {
+	if self == nil || n < 0 {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	var ripped []Nibble
+	if ripped, existed = self.root.keyIndex(nil, n); existed {
+		key = Stitch(ripped)
+	}
+	return
+}
+
 // Size returns the virtual, as in 'not including tombstones and sub trees', size of this Tree.
 func (self *Tree) Size() int {
 	if self == nil {
@@ -570,41 +822,407 @@ func (self *Tree) FakeDel(key []byte, timestamp int64) (oldBytes []byte, oldTree
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	var ex int
-	self.root, oldBytes, oldTree, _, ex = self.root.fakeDel(nil, Rip(key), byteValue, timestamp, self.timer.ContinuousTime())
+	var oldTimestamp int64
+	ripped := Rip(key)
+	self.root, oldBytes, oldTree, oldTimestamp, ex = self.root.fakeDel(nil, ripped, byteValue, timestamp, self.timer.ContinuousTime())
+	self.keep(ripped, oldBytes, oldTimestamp, ex, timestamp)
 	existed = ex&byteValue != 0
 	if existed {
 		self.mirrorFakeDel(key, oldBytes, timestamp)
+		self.changed(key, oldBytes, true, nil, false)
+		self.log(persistence.Op{
+			Key: key,
+		})
+	}
+	return
+}
+
+// FakeDelLive will do what FakeDel does, but report an old value that has expired, according to the Expirer set by ExpireWith, as missing.
+func (self *Tree) FakeDelLive(key []byte, timestamp int64) (oldBytes []byte, existed bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	_, subTree, oldTimestamp, ex := self.root.get(ripped)
+	expired := ex&byteValue != 0 && self.expiredValue(key, oldTimestamp, subTree, ex)
+	self.root, oldBytes, _, _, ex = self.root.fakeDel(nil, ripped, byteValue, timestamp, self.timer.ContinuousTime())
+	self.keep(ripped, oldBytes, oldTimestamp, ex, timestamp)
+	if existed = ex&byteValue != 0; existed {
+		self.mirrorFakeDel(key, oldBytes, timestamp)
+		self.changed(key, oldBytes, true, nil, false)
 		self.log(persistence.Op{
 			Key: key,
 		})
 	}
+	if expired {
+		oldBytes, existed = nil, false
+	}
+	return
+}
+
+// FakeDelOlderThan will insert a tombstone at key with timestamp in this Tree if the byte value at key was written with a timestamp before than.
+// Nothing is logged unless the value was deleted.
+func (self *Tree) FakeDelOlderThan(key []byte, than, timestamp int64) (oldBytes []byte, deleted bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	_, _, oldTimestamp, ex := self.root.get(ripped)
+	if ex&byteValue == 0 || oldTimestamp >= than {
+		return
+	}
+	self.root, oldBytes, _, _, _ = self.root.fakeDel(nil, ripped, byteValue, timestamp, self.timer.ContinuousTime())
+	self.keep(ripped, oldBytes, oldTimestamp, ex, timestamp)
+	deleted = true
+	self.mirrorFakeDel(key, oldBytes, timestamp)
+	self.changed(key, oldBytes, true, nil, false)
+	self.log(persistence.Op{
+		Key: key,
+	})
+	return
+}
+
+// FakeDelIfEqual will insert a tombstone at key with timestamp in this Tree if the byte value at key is equal to expected.
+// Nothing is logged unless the value was deleted.
+func (self *Tree) FakeDelIfEqual(key, expected []byte, timestamp int64) (deleted bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.fakeDelIfEqual(key, expected, timestamp, false)
+}
+
+// FakeDelLiveIfEqual will do what FakeDelIfEqual does, but leave a value that has expired, according to the Expirer set by ExpireWith, alone.
+func (self *Tree) FakeDelLiveIfEqual(key, expected []byte, timestamp int64) (deleted bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.fakeDelIfEqual(key, expected, timestamp, true)
+}
+func (self *Tree) fakeDelIfEqual(key, expected []byte, timestamp int64, live bool) (deleted bool) {
+	ripped := Rip(key)
+	oldBytes, subTree, oldTimestamp, ex := self.root.get(ripped)
+	if ex&byteValue == 0 || !bytes.Equal(oldBytes, expected) || (live && self.expiredValue(key, oldTimestamp, subTree, ex)) {
+		return
+	}
+	self.root, _, _, _, _ = self.root.fakeDel(nil, ripped, byteValue, timestamp, self.timer.ContinuousTime())
+	self.keep(ripped, oldBytes, oldTimestamp, ex, timestamp)
+	deleted = true
+	self.mirrorFakeDel(key, oldBytes, timestamp)
+	self.changed(key, oldBytes, true, nil, false)
+	self.log(persistence.Op{
+		Key: key,
+	})
+	return
+}
+
+// FakeDelAll will put delete markers with timestamp under all keys, and return the values it deleted.
+// All deletions are logged as one operation, so a restored Tree will contain either all or none of them.
+func (self *Tree) FakeDelAll(keys [][]byte, timestamp int64) (deleted []common.Item) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var ops []persistence.Op
+	for _, key := range keys {
+		var oldBytes []byte
+		var oldTimestamp int64
+		var ex int
+		ripped := Rip(key)
+		self.root, oldBytes, _, oldTimestamp, ex = self.root.fakeDel(nil, ripped, byteValue, timestamp, self.timer.ContinuousTime())
+		self.keep(ripped, oldBytes, oldTimestamp, ex, timestamp)
+		if ex&byteValue != 0 {
+			self.mirrorFakeDel(key, oldBytes, timestamp)
+			self.changed(key, oldBytes, true, nil, false)
+			deleted = append(deleted, common.Item{
+				Key:       key,
+				Value:     oldBytes,
+				Timestamp: oldTimestamp,
+				Exists:    true,
+			})
+			ops = append(ops, persistence.Op{
+				Key: key,
+			})
+		}
+	}
+	if ops != nil {
+		self.log(persistence.Op{
+			Batch: ops,
+		})
+	}
+	return
+}
+
+// PutAll will put the keys and values of items with timestamp, and return the values they replaced.
+// All puts are logged as one operation, so a restored Tree will contain either all or none of them.
+func (self *Tree) PutAll(items []common.Item, timestamp int64) (old []common.Item) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var ops []persistence.Op
+	old = make([]common.Item, len(items))
+	for index, item := range items {
+		oldBytes, _, ex := self.put(Rip(item.Key), item.Value, nil, byteValue, timestamp)
+		old[index] = common.Item{
+			Key:    item.Key,
+			Value:  oldBytes,
+			Exists: ex&byteValue != 0,
+		}
+		if old[index].Exists {
+			self.mirrorDel(item.Key, oldBytes)
+		}
+		self.mirrorPut(item.Key, item.Value, timestamp)
+		self.changed(item.Key, oldBytes, old[index].Exists, item.Value, true)
+		ops = append(ops, persistence.Op{
+			Key:       item.Key,
+			Value:     item.Value,
+			Timestamp: timestamp,
+			Put:       true,
+		})
+	}
+	if ops != nil {
+		self.log(persistence.Op{
+			Batch: ops,
+		})
+	}
+	return
+}
+
+// Apply will put the items with Exists set, and delete the others, with timestamp, and return the values they replaced.
+// Items with a SubKey are put in, or deleted from, the sub tree defined by their Key.
+// All changes are logged as one operation, so a restored Tree will contain either all or none of them.
+func (self *Tree) Apply(items []common.Item, timestamp int64) (old []common.Item) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.apply(items, timestamp)
+}
+
+// ApplyIf will Apply items with timestamp, if the byte values under the keys of watches were last written with the Timestamps of the watches,
+// or the watches have a zero Timestamp and there are no byte values under their keys. The check and the changes are atomic.
+func (self *Tree) ApplyIf(watches, items []common.Item, timestamp int64) (old []common.Item, applied bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, watch := range watches {
+		_, _, version, ex := self.root.get(Rip(watch.Key))
+		if ex&byteValue == 0 {
+			version = 0
+		}
+		if version != watch.Timestamp {
+			return
+		}
+	}
+	return self.apply(items, timestamp), true
+}
+func (self *Tree) apply(items []common.Item, timestamp int64) (old []common.Item) {
+	var ops []persistence.Op
+	old = make([]common.Item, len(items))
+	for index, item := range items {
+		old[index] = common.Item{
+			Key:    item.Key,
+			SubKey: item.SubKey,
+		}
+		ripped := Rip(item.Key)
+		if item.SubKey == nil {
+			var ex int
+			if item.Exists {
+				old[index].Value, _, ex = self.put(ripped, item.Value, nil, byteValue, timestamp)
+				if old[index].Exists = ex&byteValue != 0; old[index].Exists {
+					self.mirrorDel(item.Key, old[index].Value)
+				}
+				self.mirrorPut(item.Key, item.Value, timestamp)
+				self.changed(item.Key, old[index].Value, old[index].Exists, item.Value, true)
+				ops = append(ops, persistence.Op{
+					Key:       item.Key,
+					Value:     item.Value,
+					Timestamp: timestamp,
+					Put:       true,
+				})
+			} else {
+				var oldTimestamp int64
+				self.root, old[index].Value, _, oldTimestamp, ex = self.root.fakeDel(nil, ripped, byteValue, timestamp, self.timer.ContinuousTime())
+				self.keep(ripped, old[index].Value, oldTimestamp, ex, timestamp)
+				if old[index].Exists = ex&byteValue != 0; old[index].Exists {
+					self.mirrorFakeDel(item.Key, old[index].Value, timestamp)
+					self.changed(item.Key, old[index].Value, true, nil, false)
+					ops = append(ops, persistence.Op{
+						Key: item.Key,
+					})
+				}
+			}
+			continue
+		}
+		_, subTree, subTreeTimestamp, ex := self.root.get(ripped)
+		if ex&treeValue == 0 {
+			subTree = nil
+		}
+		if item.Exists {
+			if subTree == nil {
+				subTree = self.newTreeWith(Rip(item.SubKey), item.Value, timestamp)
+			} else {
+				old[index].Value, old[index].Exists, _ = subTree.Put(item.SubKey, item.Value, timestamp)
+			}
+			self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
+			ops = append(ops, persistence.Op{
+				Key:       item.Key,
+				SubKey:    item.SubKey,
+				Value:     item.Value,
+				Timestamp: timestamp,
+				Put:       true,
+			})
+		} else if subTree != nil {
+			if old[index].Value, _, old[index].Exists = subTree.FakeDel(item.SubKey, timestamp); old[index].Exists {
+				self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
+				ops = append(ops, persistence.Op{
+					Key:    item.Key,
+					SubKey: item.SubKey,
+				})
+			}
+		}
+	}
+	if ops != nil {
+		self.log(persistence.Op{
+			Batch: ops,
+		})
+	}
 	return
 }
-func (self *Tree) put(key []Nibble, byteValue []byte, treeValue *Tree, use int, timestamp int64) (oldBytes []byte, oldTree *Tree, existed int) {
+func (self *Tree) put(key []Nibble, bValue []byte, tValue *Tree, use int, timestamp int64) (oldBytes []byte, oldTree *Tree, existed int) {
 	self.dataTimestamp = timestamp
-	self.root, oldBytes, oldTree, _, existed = self.root.insert(nil, newNode(key, byteValue, treeValue, timestamp, false, use), self.timer.ContinuousTime())
+	var oldTimestamp int64
+	self.root, oldBytes, oldTree, oldTimestamp, existed = self.root.insert(nil, newNode(key, bValue, tValue, timestamp, false, use), self.timer.ContinuousTime())
+	if use&byteValue != 0 {
+		self.keep(key, oldBytes, oldTimestamp, existed, timestamp)
+	}
 	return
 }
 
 // Put will put key and value with timestamp in this Tree.
-func (self *Tree) Put(key []byte, bValue []byte, timestamp int64) (oldBytes []byte, existed bool) {
+// durable will be false if the put could not be written to the log of this Tree, and is only kept in memory.
+func (self *Tree) Put(key []byte, bValue []byte, timestamp int64) (oldBytes []byte, existed, durable bool) {
 	self.lock.Lock()
-	defer self.lock.Unlock()
 	oldBytes, _, ex := self.put(Rip(key), bValue, nil, byteValue, timestamp)
 	existed = ex*byteValue != 0
 	if existed {
 		self.mirrorDel(key, oldBytes)
 	}
 	self.mirrorPut(key, bValue, timestamp)
+	self.changed(key, oldBytes, existed, bValue, true)
+	// The log is ordered by queueing under the lock, but concurrent puts can be written to disk together if we wait for it after unlocking.
+	logged := self.queue(persistence.Op{
+		Key:       key,
+		Value:     bValue,
+		Timestamp: timestamp,
+		Put:       true,
+	})
+	self.lock.Unlock()
+	durable = <-logged
+	return
+}
+
+// PutNewer will put bValue at key with timestamp in this Tree, like Put, unless the byte value or tombstone at key was written with timestamp or later.
+// Nothing is logged unless the value was put.
+func (self *Tree) PutNewer(key []byte, bValue []byte, timestamp int64) (put bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	if _, _, oldTimestamp, _ := self.root.get(ripped); oldTimestamp >= timestamp {
+		return
+	}
+	oldBytes, _, ex := self.put(ripped, bValue, nil, byteValue, timestamp)
+	if ex&byteValue != 0 {
+		self.mirrorDel(key, oldBytes)
+	}
+	self.mirrorPut(key, bValue, timestamp)
+	self.changed(key, oldBytes, ex&byteValue != 0, bValue, true)
 	self.log(persistence.Op{
 		Key:       key,
 		Value:     bValue,
 		Timestamp: timestamp,
 		Put:       true,
 	})
+	return true
+}
+
+// Modify will atomically replace the value at key with what f returns when given the old value, and put it with timestamp in this Tree.
+// If f returns an error, or put is false, nothing will be changed. The new value is what gets logged, so that replay doesn't depend on the previous state.
+func (self *Tree) Modify(key []byte, timestamp int64, f Modifier) (newValue []byte, put bool, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.modify(key, timestamp, false, f)
+}
+
+// ModifyLive will do what Modify does, but give f an old value that has expired, according to the Expirer set by ExpireWith, as missing.
+func (self *Tree) ModifyLive(key []byte, timestamp int64, f Modifier) (newValue []byte, put bool, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.modify(key, timestamp, true, f)
+}
+
+// ModifyIf will do what Modify does, but only if there is a byte value at condKey, checked atomically with the modification.
+func (self *Tree) ModifyIf(condKey, key []byte, timestamp int64, f Modifier) (newValue []byte, put bool, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, _, _, ex := self.root.get(Rip(condKey)); ex&byteValue == 0 {
+		return
+	}
+	return self.modify(key, timestamp, false, f)
+}
+
+// ModifyLiveIf will do what ModifyLive does, but only if there is a byte value at condKey that hasn't expired, checked atomically with the modification.
+func (self *Tree) ModifyLiveIf(condKey, key []byte, timestamp int64, f Modifier) (newValue []byte, put bool, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, subTree, condTimestamp, ex := self.root.get(Rip(condKey)); ex&byteValue == 0 || self.expiredValue(condKey, condTimestamp, subTree, ex) {
+		return
+	}
+	return self.modify(key, timestamp, true, f)
+}
+func (self *Tree) modify(key []byte, timestamp int64, live bool, f Modifier) (newValue []byte, put bool, err error) {
+	ripped := Rip(key)
+	oldBytes, subTree, oldTimestamp, ex := self.root.get(ripped)
+	existed := ex&byteValue != 0
+	given, givenExisted := oldBytes, existed
+	if live && existed && self.expiredValue(key, oldTimestamp, subTree, ex) {
+		given, givenExisted = nil, false
+	}
+	if newValue, put, err = f(given, givenExisted); err != nil || !put {
+		return
+	}
+	self.put(ripped, newValue, nil, byteValue, timestamp)
+	if existed {
+		self.mirrorDel(key, oldBytes)
+	}
+	self.mirrorPut(key, newValue, timestamp)
+	self.changed(key, oldBytes, existed, newValue, true)
+	self.log(persistence.Op{
+		Key:       key,
+		Value:     newValue,
+		Timestamp: timestamp,
+		Put:       true,
+	})
 	return
 }
 
+// AddInt64 will treat the value at key as a common.EncodeInt64 encoded counter, add delta to it and put the result with timestamp in this Tree.
+// A missing value is treated as 0.
+func (self *Tree) AddInt64(key []byte, delta, timestamp int64) (result int64, err error) {
+	_, _, err = self.Modify(key, timestamp, int64Adder(delta, &result))
+	return
+}
+
+// AddInt64Live will do what AddInt64 does, but treat a value that has expired, according to the Expirer set by ExpireWith, as 0.
+func (self *Tree) AddInt64Live(key []byte, delta, timestamp int64) (result int64, err error) {
+	_, _, err = self.ModifyLive(key, timestamp, int64Adder(delta, &result))
+	return
+}
+
+// int64Adder returns a Modifier adding delta to a common.EncodeInt64 encoded counter, and keeping the result in result.
+func int64Adder(delta int64, result *int64) Modifier {
+	return func(oldValue []byte, existed bool) (newValue []byte, put bool, err error) {
+		if existed {
+			if len(oldValue) != 8 {
+				err = fmt.Errorf("%v is not an encoded int64", oldValue)
+				return
+			}
+			*result = common.MustDecodeInt64(oldValue)
+		}
+		*result += delta
+		return common.EncodeInt64(*result), true, nil
+	}
+}
+
 // Get will return the value and timestamp at key.
 func (self *Tree) Get(key []byte) (bValue []byte, timestamp int64, existed bool) {
 	self.lock.RLock()
@@ -790,6 +1408,30 @@ func (self *Tree) Last() (key, byteValue []byte, timestamp int64, existed bool)
 	return
 }
 
+// FirstBetween returns the first key between min and max that has either a byte value or a sub tree, along with its byte value and timestamp.
+func (self *Tree) FirstBetween(min, max []byte, mininc, maxinc bool) (key, value []byte, timestamp int64, existed bool) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue|treeValue, newEdgeIterator(&key, &value, &timestamp, &existed))
+	return
+}
+
+// LastBetween returns the last key between min and max that has either a byte value or a sub tree, along with its byte value and timestamp.
+func (self *Tree) LastBetween(min, max []byte, mininc, maxinc bool) (key, value []byte, timestamp int64, existed bool) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.reverseEachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue|treeValue, newEdgeIterator(&key, &value, &timestamp, &existed))
+	return
+}
+
 // MirrorIndex returns the key, value and timestamp at index in the mirror Tree.
 func (self *Tree) MirrorIndex(n int) (key, byteValue []byte, timestamp int64, existed bool) {
 	if self == nil || self.mirror == nil {
@@ -835,6 +1477,10 @@ func (self *Tree) Clear(timestamp int64) {
 	defer self.lock.Unlock()
 	self.dataTimestamp, self.root = timestamp, nil
 	self.root, _, _, _, _ = self.root.insert(nil, newNode(nil, nil, nil, 0, true, 0), self.timer.ContinuousTime())
+	if self.versions != nil {
+		// The cleared values are gone from the versions as well.
+		self.keptSince, self.versions = self.timer.ContinuousTime(), make(map[string]*history)
+	}
 	self.mirrorClear(timestamp)
 	if self.logger != nil {
 		self.logger.Clear()
@@ -842,7 +1488,12 @@ func (self *Tree) Clear(timestamp int64) {
 }
 func (self *Tree) del(key []Nibble, use int) (oldBytes []byte, existed bool) {
 	var ex int
-	self.root, oldBytes, _, _, ex = self.root.del(nil, key, use, self.timer.ContinuousTime())
+	var oldTimestamp int64
+	now := self.timer.ContinuousTime()
+	self.root, oldBytes, _, oldTimestamp, ex = self.root.del(nil, key, use, now)
+	if use&byteValue != 0 {
+		self.keep(key, oldBytes, oldTimestamp, ex, now)
+	}
 	existed = ex&byteValue != 0
 	return
 }
@@ -854,6 +1505,7 @@ func (self *Tree) Del(key []byte) (oldBytes []byte, existed bool) {
 	oldBytes, existed = self.del(Rip(key), byteValue)
 	if existed {
 		self.mirrorDel(key, oldBytes)
+		self.changed(key, oldBytes, true, nil, false)
 		self.log(persistence.Op{
 			Key: key,
 		})
@@ -1085,7 +1737,7 @@ func (self *Tree) SubPut(key, subKey []byte, byteValue []byte, timestamp int64)
 	if ex&treeValue == 0 || subTree == nil {
 		subTree = self.newTreeWith(Rip(subKey), byteValue, timestamp)
 	} else {
-		oldBytes, existed = subTree.Put(subKey, byteValue, timestamp)
+		oldBytes, existed, _ = subTree.Put(subKey, byteValue, timestamp)
 	}
 	self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
 	self.log(persistence.Op{
@@ -1097,6 +1749,77 @@ func (self *Tree) SubPut(key, subKey []byte, byteValue []byte, timestamp int64)
 	})
 	return
 }
+
+// SubPutChanged will put each of values under the corresponding subKeys in the sub tree defined by key, skipping the ones that already contain the same value.
+// It returns the number of actually changed values, and only logs the changed ones.
+func (self *Tree) SubPutChanged(key []byte, subKeys, values [][]byte, timestamp int64) (changed int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	_, subTree, subTreeTimestamp, ex := self.root.get(ripped)
+	if ex&treeValue == 0 {
+		subTree = nil
+	}
+	for index, subKey := range subKeys {
+		if subTree == nil {
+			subTree = self.newTreeWith(Rip(subKey), values[index], timestamp)
+		} else {
+			if oldBytes, _, existed := subTree.Get(subKey); existed && bytes.Compare(oldBytes, values[index]) == 0 {
+				continue
+			}
+			subTree.Put(subKey, values[index], timestamp)
+		}
+		changed++
+		self.log(persistence.Op{
+			Key:       key,
+			SubKey:    subKey,
+			Value:     values[index],
+			Timestamp: timestamp,
+			Put:       true,
+		})
+	}
+	if changed > 0 {
+		self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
+	}
+	return
+}
+
+// SubReplace will replace the content of the sub tree defined by key with values under the corresponding subKeys, and return the new size of the sub tree.
+// The clear and all the puts are logged as one operation, so a restored Tree will contain either the old or the new sub tree.
+func (self *Tree) SubReplace(key []byte, subKeys, values [][]byte, timestamp int64) (size int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ripped := Rip(key)
+	_, subTree, subTreeTimestamp, ex := self.root.get(ripped)
+	if ex&treeValue == 0 || subTree == nil {
+		if len(subKeys) == 0 {
+			return
+		}
+		subTree = NewTreeTimer(self.timer)
+	}
+	subTree.Clear(timestamp)
+	ops := []persistence.Op{{
+		Key:       key,
+		Clear:     true,
+		Timestamp: timestamp,
+	}}
+	for index, subKey := range subKeys {
+		subTree.Put(subKey, values[index], timestamp)
+		ops = append(ops, persistence.Op{
+			Key:       key,
+			SubKey:    subKey,
+			Value:     values[index],
+			Timestamp: timestamp,
+			Put:       true,
+		})
+	}
+	self.put(ripped, nil, subTree, treeValue, subTreeTimestamp)
+	self.log(persistence.Op{
+		Key:   key,
+		Batch: ops,
+	})
+	return subTree.Size()
+}
 func (self *Tree) SubDel(key, subKey []byte) (oldBytes []byte, existed bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -1183,10 +1906,14 @@ func (self *Tree) GetTimestamp(key []Nibble) (bValue []byte, timestamp int64, pr
 	present = ex&byteValue != 0
 	return
 }
-func (self *Tree) putTimestamp(key []Nibble, bValue []byte, treeValue *Tree, nodeUse, insertUse int, expected, timestamp int64) (result bool, oldBytes []byte) {
+func (self *Tree) putTimestamp(key []Nibble, bValue []byte, treeValue *Tree, nodeUse, insertUse int, expected, timestamp int64) (result bool, oldBytes []byte, ex int) {
 	if _, _, current, _ := self.root.get(key); current == expected {
 		self.dataTimestamp, result = timestamp, true
-		self.root, oldBytes, _, _, _ = self.root.insertHelp(nil, newNode(key, bValue, treeValue, timestamp, false, nodeUse), insertUse, self.timer.ContinuousTime())
+		var oldTimestamp int64
+		self.root, oldBytes, _, oldTimestamp, ex = self.root.insertHelp(nil, newNode(key, bValue, treeValue, timestamp, false, nodeUse), insertUse, self.timer.ContinuousTime())
+		if insertUse&byteValue != 0 {
+			self.keep(key, oldBytes, oldTimestamp, ex, timestamp)
+		}
 	}
 	return
 }
@@ -1198,11 +1925,15 @@ func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expect
 		nodeUse = byteValue
 	}
 	var oldBytes []byte
-	result, oldBytes = self.putTimestamp(key, bValue, nil, nodeUse, byteValue, expected, timestamp)
+	var ex int
+	result, oldBytes, ex = self.putTimestamp(key, bValue, nil, nodeUse, byteValue, expected, timestamp)
 	if result {
 		stitched := Stitch(key)
 		self.mirrorDel(stitched, oldBytes)
 		self.mirrorPut(stitched, bValue, timestamp)
+		if present || ex&byteValue != 0 {
+			self.changed(stitched, oldBytes, ex&byteValue != 0, bValue, present)
+		}
 		self.log(persistence.Op{
 			Key:       Stitch(key),
 			Value:     bValue,
@@ -1212,10 +1943,15 @@ func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expect
 	}
 	return
 }
-func (self *Tree) delTimestamp(key []Nibble, use int, expected int64) (result bool, oldBytes []byte) {
+func (self *Tree) delTimestamp(key []Nibble, use int, expected int64) (result bool, oldBytes []byte, ex int) {
 	if _, _, current, _ := self.root.get(key); current == expected {
 		result = true
-		self.root, oldBytes, _, _, _ = self.root.del(nil, key, use, self.timer.ContinuousTime())
+		now := self.timer.ContinuousTime()
+		var oldTimestamp int64
+		self.root, oldBytes, _, oldTimestamp, ex = self.root.del(nil, key, use, now)
+		if use&byteValue != 0 {
+			self.keep(key, oldBytes, oldTimestamp, ex, now)
+		}
 	}
 	return
 }
@@ -1223,9 +1959,13 @@ func (self *Tree) DelTimestamp(key []Nibble, expected int64) (result bool) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	var oldBytes []byte
-	result, oldBytes = self.delTimestamp(key, byteValue, expected)
+	var ex int
+	result, oldBytes, ex = self.delTimestamp(key, byteValue, expected)
 	if result {
 		self.mirrorDel(Stitch(key), oldBytes)
+		if ex&byteValue != 0 {
+			self.changed(Stitch(key), oldBytes, true, nil, false)
+		}
 		self.log(persistence.Op{
 			Key: Stitch(key),
 		})
@@ -1259,6 +1999,50 @@ func (self *Tree) subConfigure(key []byte, conf map[string]string, timestamp int
 		Configuration: conf,
 		Timestamp:     timestamp,
 	})
+	if self.confWatcher != nil {
+		self.confWatcher(key, conf)
+	}
+}
+
+// SubConfigurationWatcher is a function told about the configurations set for the sub trees of a Tree.
+type SubConfigurationWatcher func(key []byte, conf map[string]string)
+
+// WatchSubConfigurations will make this Tree call f with the key and the new configuration every time the configuration of a sub tree is set,
+// whether by SubConfigure, SubAddConfiguration, a Sync or replaying the log. f is called while this Tree is locked, and must not use it.
+func (self *Tree) WatchSubConfigurations(f SubConfigurationWatcher) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.confWatcher = f
+}
+// Expirer is a function telling whether the byte value under key, written at timestamp, has expired, given the configuration of the sub tree under key.
+type Expirer func(key []byte, timestamp int64, conf map[string]string) bool
+
+// ExpireWith will make the *Live methods of this Tree treat the byte values f says have expired as missing. f is called while this Tree is locked, and must not use it.
+func (self *Tree) ExpireWith(f Expirer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.expirer = f
+}
+func (self *Tree) expiredValue(key []byte, timestamp int64, subTree *Tree, ex int) bool {
+	if self.expirer == nil {
+		return false
+	}
+	conf := make(map[string]string)
+	if ex&treeValue != 0 && subTree != nil {
+		conf, _ = subTree.Configuration()
+	}
+	return self.expirer(key, timestamp, conf)
+}
+
+// ValueWatcher is a function told about the changes to the byte values of a Tree.
+type ValueWatcher func(key, oldValue []byte, oldExisted bool, newValue []byte, newExists bool)
+
+// WatchValues will make this Tree call f with the old and new byte value every time the byte value under a key is put or deleted,
+// whether by a put, a delete, a Sync or an Import. Clear is not reported. f is called while this Tree is locked, and must not use it.
+func (self *Tree) WatchValues(f ValueWatcher) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.valueWatcher = f
 }
 func (self *Tree) SubConfigure(key []byte, conf map[string]string, timestamp int64) {
 	self.lock.Lock()
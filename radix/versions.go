@@ -0,0 +1,171 @@
+package radix
+
+import (
+	"bytes"
+	"sort"
+)
+
+// version is a byte value, or the lack of one if present is false, that was under a key from timestamp until it was replaced at until.
+type version struct {
+	value     []byte
+	present   bool
+	timestamp int64
+	until     int64
+}
+
+func (self version) covers(at int64) bool {
+	return self.timestamp <= at && at < self.until
+}
+
+// history is the replaced versions of the byte value under a key, oldest first.
+// Versions from before forgotten were dropped to keep at most Tree.keptVersions of them.
+type history struct {
+	versions  []version
+	forgotten int64
+}
+
+// KeepVersions will make this Tree remember the last n byte values replaced under each key, so that GetAt and EachBetweenAt
+// can read the byte values of this Tree as they were at a timestamp after now. Versions are kept until PruneVersions forgets them.
+func (self *Tree) KeepVersions(n int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.keptVersions = n
+	if self.versions == nil {
+		self.keptSince, self.versions = self.timer.ContinuousTime(), make(map[string]*history)
+	}
+}
+
+// PruneVersions will forget the versions replaced before timestamp, after which reads before timestamp are no longer possible.
+func (self *Tree) PruneVersions(timestamp int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.versions == nil {
+		return
+	}
+	if timestamp > self.keptSince {
+		self.keptSince = timestamp
+	}
+	for key, h := range self.versions {
+		drop := 0
+		for drop < len(h.versions) && h.versions[drop].until < timestamp {
+			drop++
+		}
+		if drop == len(h.versions) {
+			delete(self.versions, key)
+		} else if drop > 0 {
+			h.versions = append([]version(nil), h.versions[drop:]...)
+		}
+	}
+}
+
+// keep will remember that the byte value under key was oldBytes, if it was present in ex, from oldTimestamp until timestamp, if this Tree keeps versions.
+func (self *Tree) keep(key []Nibble, oldBytes []byte, oldTimestamp int64, ex int, timestamp int64) {
+	if self.keptVersions == 0 {
+		return
+	}
+	v := version{
+		present:   ex&byteValue != 0,
+		timestamp: oldTimestamp,
+		until:     timestamp,
+	}
+	if v.present {
+		v.value = oldBytes
+	}
+	stitched := string(Stitch(key))
+	h, found := self.versions[stitched]
+	if !found {
+		h = &history{}
+		self.versions[stitched] = h
+	}
+	h.versions = append(h.versions, v)
+	if drop := len(h.versions) - self.keptVersions; drop > 0 {
+		h.forgotten = h.versions[drop-1].until
+		h.versions = append([]version(nil), h.versions[drop:]...)
+	}
+}
+
+// getAt will return the byte value under key at timestamp at, and ok false if this Tree no longer knows it.
+func (self *Tree) getAt(key []Nibble, at int64) (value []byte, timestamp int64, existed, ok bool) {
+	bValue, _, current, ex := self.root.get(key)
+	if (ex&byteValue != 0 || current != 0) && current <= at {
+		return bValue, current, ex&byteValue != 0, true
+	}
+	h := self.versions[string(Stitch(key))]
+	if h != nil {
+		for index := len(h.versions) - 1; index >= 0; index-- {
+			if v := h.versions[index]; v.covers(at) {
+				return v.value, v.timestamp, v.present, true
+			}
+		}
+		if at < h.forgotten {
+			return
+		}
+	}
+	ok = at >= self.keptSince
+	return
+}
+
+// GetAt will return the byte value and timestamp at key as they were at timestamp at, which must be after KeepVersions was called,
+// and ok false if this Tree no longer knows them since the versions from then have been forgotten.
+func (self *Tree) GetAt(key []byte, at int64) (bValue []byte, timestamp int64, existed, ok bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if self.versions == nil {
+		return
+	}
+	return self.getAt(Rip(key), at)
+}
+
+// EachBetweenAt will iterate over the byte values between min and max, as they were at timestamp at, like EachBetween.
+// It returns ok false, after the keys before it, if it finds a key whose value at timestamp at this Tree no longer knows.
+// f must not modify this Tree.
+func (self *Tree) EachBetweenAt(min, max []byte, mininc, maxinc bool, at int64, f TreeIterator) (ok bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if self.versions == nil || at < self.keptSince {
+		return
+	}
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	var replaced [][]byte
+	for key := range self.versions {
+		k := []byte(key)
+		if (min == nil || bytes.Compare(k, min) > mincmp) && (max == nil || bytes.Compare(k, max) < maxcmp) {
+			replaced = append(replaced, k)
+		}
+	}
+	sort.Sort(byteSlices(replaced))
+	cont := true
+	visit := func(key []byte) bool {
+		value, timestamp, existed, known := self.getAt(Rip(key), at)
+		if !known {
+			ok, cont = false, false
+		} else if existed {
+			cont = f(key, value, timestamp)
+		}
+		return cont
+	}
+	ok = true
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue, func(key, byteValue []byte, treeValue *Tree, use int, timestamp int64) bool {
+		for len(replaced) > 0 && bytes.Compare(replaced[0], key) < 0 {
+			if !visit(replaced[0]) {
+				return false
+			}
+			replaced = replaced[1:]
+		}
+		if len(replaced) > 0 && bytes.Equal(replaced[0], key) {
+			replaced = replaced[1:]
+		}
+		return visit(key)
+	})
+	for cont && len(replaced) > 0 {
+		visit(replaced[0])
+		replaced = replaced[1:]
+	}
+	return
+}
+
+type byteSlices [][]byte
+
+func (self byteSlices) Len() int           { return len(self) }
+func (self byteSlices) Less(i, j int) bool { return bytes.Compare(self[i], self[j]) < 0 }
+func (self byteSlices) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
@@ -0,0 +1,387 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	typeString      = 0
+	typeList        = 1
+	typeSet         = 2
+	typeZSet        = 3
+	typeHash        = 4
+	typeZSet2       = 5
+	typeHashZipmap  = 9
+	typeListZiplist = 10
+	typeSetIntset   = 11
+	typeZSetZiplist = 12
+	typeHashZiplist = 13
+	typeListQuick   = 14
+	typeHashPack    = 16
+	typeZSetPack    = 17
+	typeListQuick2  = 18
+	typeSetPack     = 20
+)
+
+const (
+	opFunction     = 0xf5
+	opModuleAux    = 0xf7
+	opIdle         = 0xf8
+	opFreq         = 0xf9
+	opAux          = 0xfa
+	opResizeDB     = 0xfb
+	opExpireTimeMS = 0xfc
+	opExpireTime   = 0xfd
+	opSelectDB     = 0xfe
+	opEOF          = 0xff
+)
+
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Entry is a string value read from an RDB dump.
+// ExpireAt is when the value expires in Unix nanoseconds, or 0 if it never expires.
+type Entry struct {
+	DB       int
+	Key      []byte
+	Value    []byte
+	ExpireAt int64
+}
+
+// Reader reads the string values of a Redis RDB dump one by one, skipping values of other types.
+// The checksum at the end of the dump is not verified.
+type Reader struct {
+	reader  *bufio.Reader
+	version int
+	db      int
+	skipped int
+}
+
+// NewReader will return a Reader for the RDB dump in r, after checking its header.
+func NewReader(r io.Reader) (result *Reader, err error) {
+	result = &Reader{
+		reader: bufio.NewReader(r),
+	}
+	header := make([]byte, 9)
+	if _, err = io.ReadFull(result.reader, header); err != nil {
+		return
+	}
+	if string(header[:5]) != "REDIS" {
+		err = fmt.Errorf("%q is not the header of an RDB dump", header)
+		return
+	}
+	if result.version, err = strconv.Atoi(string(header[5:])); err != nil {
+		err = fmt.Errorf("%q is not an RDB version: %v", header[5:], err)
+	}
+	return
+}
+
+// Version returns the RDB version of the dump.
+func (self *Reader) Version() int {
+	return self.version
+}
+
+// Skipped returns the number of values that were not strings, and therefore skipped, so far.
+func (self *Reader) Skipped() int {
+	return self.skipped
+}
+
+// Next will return the next string value in the dump, or io.EOF when there are no more.
+func (self *Reader) Next() (result *Entry, err error) {
+	var expireAt int64
+	for {
+		var op byte
+		if op, err = self.reader.ReadByte(); err != nil {
+			return nil, self.unexpected(err)
+		}
+		switch op {
+		case opEOF:
+			return nil, io.EOF
+		case opSelectDB:
+			var db uint64
+			if db, err = self.length(); err != nil {
+				return
+			}
+			self.db = int(db)
+		case opResizeDB:
+			if _, err = self.length(); err != nil {
+				return
+			}
+			if _, err = self.length(); err != nil {
+				return
+			}
+		case opAux:
+			if err = self.skipStrings(2); err != nil {
+				return
+			}
+		case opExpireTime:
+			var secs uint32
+			if err = binary.Read(self.reader, binary.LittleEndian, &secs); err != nil {
+				return nil, self.unexpected(err)
+			}
+			expireAt = int64(secs) * int64(time.Second)
+		case opExpireTimeMS:
+			var millis uint64
+			if err = binary.Read(self.reader, binary.LittleEndian, &millis); err != nil {
+				return nil, self.unexpected(err)
+			}
+			expireAt = int64(millis) * int64(time.Millisecond)
+		case opIdle:
+			if _, err = self.length(); err != nil {
+				return
+			}
+		case opFreq:
+			if _, err = self.reader.ReadByte(); err != nil {
+				return nil, self.unexpected(err)
+			}
+		case opModuleAux, opFunction:
+			return nil, fmt.Errorf("unable to read RDB opcode %#x", op)
+		default:
+			var key []byte
+			if key, err = self.str(); err != nil {
+				return
+			}
+			if op == typeString {
+				result = &Entry{
+					DB:       self.db,
+					Key:      key,
+					ExpireAt: expireAt,
+				}
+				if result.Value, err = self.str(); err != nil {
+					return nil, err
+				}
+				return
+			}
+			if err = self.skipValue(op); err != nil {
+				return
+			}
+			self.skipped++
+			expireAt = 0
+		}
+	}
+}
+
+func (self *Reader) unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (self *Reader) skipStrings(n uint64) (err error) {
+	for i := uint64(0); i < n; i++ {
+		if _, err = self.str(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// skipValue will read past a value of typ, or return an error if typ is something it can't read past.
+func (self *Reader) skipValue(typ byte) (err error) {
+	var n uint64
+	switch typ {
+	case typeList, typeSet, typeListQuick:
+		if n, err = self.length(); err != nil {
+			return
+		}
+		return self.skipStrings(n)
+	case typeHash:
+		if n, err = self.length(); err != nil {
+			return
+		}
+		return self.skipStrings(n * 2)
+	case typeZSet:
+		if n, err = self.length(); err != nil {
+			return
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err = self.str(); err != nil {
+				return
+			}
+			var scoreLen byte
+			if scoreLen, err = self.reader.ReadByte(); err != nil {
+				return self.unexpected(err)
+			}
+			// 253, 254 and 255 are NaN and the infinities, without any digits following them.
+			if scoreLen < 253 {
+				if _, err = self.reader.Discard(int(scoreLen)); err != nil {
+					return self.unexpected(err)
+				}
+			}
+		}
+	case typeZSet2:
+		if n, err = self.length(); err != nil {
+			return
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err = self.str(); err != nil {
+				return
+			}
+			if _, err = self.reader.Discard(8); err != nil {
+				return self.unexpected(err)
+			}
+		}
+	case typeHashZipmap, typeListZiplist, typeSetIntset, typeZSetZiplist, typeHashZiplist, typeHashPack, typeZSetPack, typeSetPack:
+		_, err = self.str()
+	case typeListQuick2:
+		if n, err = self.length(); err != nil {
+			return
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err = self.length(); err != nil {
+				return
+			}
+			if _, err = self.str(); err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("unable to read past RDB values of type %v", typ)
+	}
+	return
+}
+
+// readLength reads a length, or the kind of special encoding of a string if encoded is true.
+func (self *Reader) readLength() (length uint64, encoded bool, err error) {
+	var first byte
+	if first, err = self.reader.ReadByte(); err != nil {
+		err = self.unexpected(err)
+		return
+	}
+	switch first >> 6 {
+	case 0:
+		length = uint64(first & 0x3f)
+	case 1:
+		var next byte
+		if next, err = self.reader.ReadByte(); err != nil {
+			err = self.unexpected(err)
+			return
+		}
+		length = uint64(first&0x3f)<<8 | uint64(next)
+	case 2:
+		switch first {
+		case 0x80:
+			var l uint32
+			err = binary.Read(self.reader, binary.BigEndian, &l)
+			length = uint64(l)
+		case 0x81:
+			err = binary.Read(self.reader, binary.BigEndian, &length)
+		default:
+			err = fmt.Errorf("unknown RDB length encoding %#x", first)
+		}
+		err = self.unexpected(err)
+	case 3:
+		length, encoded = uint64(first&0x3f), true
+	}
+	return
+}
+
+func (self *Reader) length() (length uint64, err error) {
+	var encoded bool
+	if length, encoded, err = self.readLength(); err == nil && encoded {
+		err = fmt.Errorf("wanted an RDB length, got a string encoding")
+	}
+	return
+}
+
+// str reads a string, which may be an encoded integer or compressed.
+func (self *Reader) str() (result []byte, err error) {
+	length, encoded, err := self.readLength()
+	if err != nil {
+		return
+	}
+	if !encoded {
+		if length > math.MaxInt32 {
+			return nil, fmt.Errorf("RDB string of %v bytes is too long", length)
+		}
+		result = make([]byte, length)
+		_, err = io.ReadFull(self.reader, result)
+		return result, self.unexpected(err)
+	}
+	switch length {
+	case encInt8:
+		var i int8
+		err = binary.Read(self.reader, binary.LittleEndian, &i)
+		result = []byte(strconv.Itoa(int(i)))
+	case encInt16:
+		var i int16
+		err = binary.Read(self.reader, binary.LittleEndian, &i)
+		result = []byte(strconv.Itoa(int(i)))
+	case encInt32:
+		var i int32
+		err = binary.Read(self.reader, binary.LittleEndian, &i)
+		result = []byte(strconv.Itoa(int(i)))
+	case encLZF:
+		var compressedLength, uncompressedLength uint64
+		if compressedLength, err = self.length(); err != nil {
+			return
+		}
+		if uncompressedLength, err = self.length(); err != nil {
+			return
+		}
+		if compressedLength > math.MaxInt32 || uncompressedLength > math.MaxInt32 {
+			return nil, fmt.Errorf("RDB string of %v bytes is too long", uncompressedLength)
+		}
+		compressed := make([]byte, compressedLength)
+		if _, err = io.ReadFull(self.reader, compressed); err != nil {
+			return nil, self.unexpected(err)
+		}
+		return decompress(compressed, int(uncompressedLength))
+	default:
+		err = fmt.Errorf("unknown RDB string encoding %v", length)
+	}
+	return result, self.unexpected(err)
+}
+
+// decompress will decompress the LZF compressed in into a slice of length bytes.
+func decompress(in []byte, length int) (out []byte, err error) {
+	out = make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, fmt.Errorf("LZF literal run past the end of the input")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("LZF back reference past the end of the input")
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, fmt.Errorf("LZF back reference past the end of the input")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("LZF back reference before the start of the output")
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, fmt.Errorf("LZF decompressed %v bytes, wanted %v", len(out), length)
+	}
+	return
+}
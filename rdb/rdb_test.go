@@ -0,0 +1,93 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type dump struct {
+	bytes.Buffer
+}
+
+func (self *dump) str(s string) *dump {
+	self.WriteByte(byte(len(s)))
+	self.WriteString(s)
+	return self
+}
+
+func (self *dump) op(b ...byte) *dump {
+	self.Write(b)
+	return self
+}
+
+func TestReader(t *testing.T) {
+	d := &dump{}
+	d.WriteString("REDIS0009")
+	d.op(opAux).str("redis-ver").str("6.2.6")
+	d.op(opSelectDB, 0).op(opResizeDB, 5, 1)
+	d.op(typeString).str("plain").str("value")
+	// An int16 encoded value.
+	d.op(typeString).str("int").op(0xc1, 0x39, 0x30)
+	// A list, which should be skipped.
+	d.op(typeList).str("list").op(2).str("a").str("b")
+	// aaaaaaaaaa compressed as a literal a followed by a back reference of 9 bytes to it.
+	d.op(typeString).str("lzf").op(0xc3, 5, 10, 0, 'a', 0xe0, 0, 0)
+	d.op(opExpireTimeMS)
+	binary.Write(d, binary.LittleEndian, uint64(1500))
+	d.op(typeString).str("expires").str("soon")
+	d.op(opSelectDB, 3)
+	d.op(typeHash).str("hash").op(1).str("k").str("v")
+	d.op(typeString).str("other").str("db")
+	d.op(opEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+	r, err := NewReader(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != 9 {
+		t.Errorf("wanted version 9, got %v", r.Version())
+	}
+	var found []Entry
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, *entry)
+	}
+	expected := []Entry{
+		Entry{Key: []byte("plain"), Value: []byte("value")},
+		Entry{Key: []byte("int"), Value: []byte("12345")},
+		Entry{Key: []byte("lzf"), Value: []byte("aaaaaaaaaa")},
+		Entry{Key: []byte("expires"), Value: []byte("soon"), ExpireAt: int64(time.Millisecond * 1500)},
+		Entry{DB: 3, Key: []byte("other"), Value: []byte("db")},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("wanted %v, got %v", expected, found)
+	}
+	if r.Skipped() != 2 {
+		t.Errorf("wanted 2 skipped values, got %v", r.Skipped())
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	d := &dump{}
+	d.WriteString("REDIS0009")
+	d.op(typeString).str("key").op(5).WriteString("val")
+	r, err := NewReader(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("wanted %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+	if _, err := NewReader(bytes.NewBufferString("RODIS0009")); err == nil {
+		t.Errorf("wanted an error for a bad header")
+	}
+}